@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"strconv"
@@ -18,11 +19,37 @@ import (
 type dumpRequestArray []*upd.DumpControlRequest
 type addresChangeRequestArray []*upd.InterfaceAddressChangeRequest
 type portForwardRequestArray []*upd.PortForwardingChangeRequest
+type timeoutsRequestArray []*upd.SessionTimeoutsChangeRequest
+type blockOwnerRequestArray []*upd.PortBlockOwnerRequest
+type limitsRequestArray []*upd.SessionLimitsChangeRequest
+type staticMappingRequestArray []*upd.StaticMappingChangeRequest
+type sessionsRequestArray []*upd.SessionsRequest
+type dropCountersRequestArray []*upd.DropCountersRequest
+type historyRequestArray []*upd.SessionHistoryRequest
 
 var (
 	dumpRequests         dumpRequestArray
 	addresChangeRequests addresChangeRequestArray
 	portForwardRequests  portForwardRequestArray
+	timeoutsRequests     timeoutsRequestArray
+	blockOwnerRequests   blockOwnerRequestArray
+	limitsRequests       limitsRequestArray
+	mappingRequests      staticMappingRequestArray
+	listRequests         sessionsRequestArray
+	deleteRequests       sessionsRequestArray
+	dropCountersRequests dropCountersRequestArray
+	historyRequests      historyRequestArray
+
+	protocolNumbers = map[string]uint32{
+		"icmp":    1,
+		"tcp":     6,
+		"udp":     17,
+		"gre":     47,
+		"esp":     50,
+		"icmp6":   58,
+		"sctp":    132,
+		"udplite": 136,
+	}
 )
 
 func (dra *dumpRequestArray) String() string {
@@ -140,10 +167,22 @@ func (pfra *portForwardRequestArray) Set(value string) error {
 		return fmt.Errorf("Bad protocol specified \"%s\"", parts[0])
 	}
 
-	sport, err := strconv.ParseUint(parts[3], 10, 16)
+	// Source port may be a range of ports
+	sports := strings.Split(parts[3], "-")
+	if len(sports) > 2 {
+		return fmt.Errorf("Bad source port range specified \"%s\"", parts[3])
+	}
+	sport, err := strconv.ParseUint(sports[0], 10, 16)
 	if err != nil {
 		return err
 	}
+	lastsport := sport
+	if len(sports) == 2 {
+		lastsport, err = strconv.ParseUint(sports[1], 10, 16)
+		if err != nil {
+			return err
+		}
+	}
 
 	ip := net.ParseIP(parts[4])
 	if ip == nil {
@@ -167,21 +206,409 @@ func (pfra *portForwardRequestArray) Set(value string) error {
 			TargetAddress: &upd.IPAddress{
 				Address: ip,
 			},
-			TargetPortNumber: uint32(tport),
-			Protocol:         upd.Protocol(proto),
+			TargetPortNumber:     uint32(tport),
+			Protocol:             upd.Protocol(proto),
+			LastSourcePortNumber: uint32(lastsport),
 		},
 	})
 	return nil
 }
 
+func (tra *timeoutsRequestArray) String() string {
+	res := ""
+	for _, r := range *tra {
+		res += r.String() + "\n"
+	}
+	return res
+}
+
+func (tra *timeoutsRequestArray) Set(value string) error {
+	req := upd.SessionTimeoutsChangeRequest{}
+	fields := map[string]*uint32{
+		"tcp-established": &req.TcpEstablishedSeconds,
+		"tcp-transitory":  &req.TcpTransitorySeconds,
+		"udp":             &req.UdpSeconds,
+		"icmp":            &req.IcmpSeconds,
+		"tcp-syn-sent":    &req.TcpSynSentSeconds,
+		"tcp-syn-recv":    &req.TcpSynRecvSeconds,
+		"tcp-fin-wait":    &req.TcpFinWaitSeconds,
+		"tcp-close-wait":  &req.TcpCloseWaitSeconds,
+		"tcp-last-ack":    &req.TcpLastAckSeconds,
+		"tcp-time-wait":   &req.TcpTimeWaitSeconds,
+		"tcp-close":       &req.TcpCloseSeconds,
+	}
+
+	for _, part := range strings.Split(value, ",") {
+		nv := strings.Split(part, "=")
+		if len(nv) != 2 {
+			return fmt.Errorf("Bad session timeout specification \"%s\"", part)
+		}
+		field, ok := fields[nv[0]]
+		if !ok {
+			return fmt.Errorf("Bad session timeout name \"%s\"", nv[0])
+		}
+		d, err := time.ParseDuration(nv[1])
+		if err != nil {
+			return err
+		}
+		if d < time.Second {
+			return fmt.Errorf("Session timeout \"%s\" should be at least one second", nv[0])
+		}
+		*field = uint32(d / time.Second)
+	}
+
+	*tra = append(*tra, &req)
+	return nil
+}
+
+func (bora *blockOwnerRequestArray) String() string {
+	res := ""
+	for _, r := range *bora {
+		res += r.String() + "\n"
+	}
+	return res
+}
+
+func (bora *blockOwnerRequestArray) Set(value string) error {
+	parts := strings.Split(value, ",")
+	if len(parts) != 3 {
+		return fmt.Errorf("Bad port block owner specification \"%s\"", value)
+	}
+	index, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(parts[1]).To4()
+	if ip == nil {
+		return fmt.Errorf("Bad IPv4 address specified \"%s\"", parts[1])
+	}
+
+	port, err := strconv.ParseUint(parts[2], 10, 16)
+	if err != nil {
+		return err
+	}
+
+	*bora = append(*bora, &upd.PortBlockOwnerRequest{
+		InterfaceId: uint32(index),
+		Address: &upd.IPAddress{
+			Address: ip,
+		},
+		PortNumber: uint32(port),
+	})
+	return nil
+}
+
+func (lra *limitsRequestArray) String() string {
+	res := ""
+	for _, r := range *lra {
+		res += r.String() + "\n"
+	}
+	return res
+}
+
+func (lra *limitsRequestArray) Set(value string) error {
+	parts := strings.SplitN(value, ",", 2)
+	index, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return err
+	}
+	req := upd.SessionLimitsChangeRequest{
+		InterfaceId: uint32(index),
+	}
+	if len(parts) == 1 {
+		*lra = append(*lra, &req)
+		return nil
+	}
+
+	fields := map[string]*uint32{
+		"total": &req.Total,
+		"tcp":   &req.Tcp,
+		"udp":   &req.Udp,
+		"icmp":  &req.Icmp,
+	}
+	for _, part := range strings.Split(parts[1], ",") {
+		nv := strings.Split(part, "=")
+		if len(nv) != 2 {
+			return fmt.Errorf("Bad session limit specification \"%s\"", part)
+		}
+		field, ok := fields[nv[0]]
+		if !ok {
+			return fmt.Errorf("Bad session limit name \"%s\"", nv[0])
+		}
+		limit, err := strconv.ParseUint(nv[1], 10, 32)
+		if err != nil {
+			return err
+		}
+		*field = uint32(limit)
+	}
+
+	*lra = append(*lra, &req)
+	return nil
+}
+
+func (smra *staticMappingRequestArray) String() string {
+	res := ""
+	for _, r := range *smra {
+		res += r.String() + "\n"
+	}
+	return res
+}
+
+func (smra *staticMappingRequestArray) Set(value string) error {
+	parts := strings.Split(value, ",")
+	if len(parts) < 3 || len(parts) > 4 {
+		return fmt.Errorf("Bad static mapping specification \"%s\"", value)
+	}
+
+	enable, ok := map[string]bool{
+		"+": true,
+		"-": false,
+	}[parts[0]]
+	if !ok {
+		return fmt.Errorf("Bad static mapping enable sign string \"%s\"", parts[0])
+	}
+	if enable != (len(parts) == 4) {
+		return fmt.Errorf("Private address should be specified only when static mapping is added \"%s\"", value)
+	}
+
+	index, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return err
+	}
+
+	var addrs []*upd.IPAddress
+	for _, part := range parts[2:] {
+		ip := net.ParseIP(part)
+		if ip == nil {
+			return fmt.Errorf("Bad IP address specified \"%s\"", part)
+		}
+		ip4 := ip.To4()
+		if ip4 != nil {
+			ip = ip4
+		}
+		addrs = append(addrs, &upd.IPAddress{
+			Address: ip,
+		})
+	}
+
+	req := upd.StaticMappingChangeRequest{
+		EnableMapping: enable,
+		InterfaceId:   uint32(index),
+		PublicAddress: addrs[0],
+	}
+	if enable {
+		req.PrivateAddress = addrs[1]
+	}
+	*smra = append(*smra, &req)
+	return nil
+}
+
+func (sra *sessionsRequestArray) String() string {
+	res := ""
+	for _, r := range *sra {
+		res += r.String() + "\n"
+	}
+	return res
+}
+
+func (sra *sessionsRequestArray) Set(value string) error {
+	req := upd.SessionsRequest{}
+	if value == "all" {
+		*sra = append(*sra, &req)
+		return nil
+	}
+
+	for _, part := range strings.Split(value, ",") {
+		nv := strings.Split(part, "=")
+		if len(nv) != 2 {
+			return fmt.Errorf("Bad session selection specification \"%s\"", part)
+		}
+		switch nv[0] {
+		case "index":
+			index, err := strconv.ParseUint(nv[1], 10, 32)
+			if err != nil {
+				return err
+			}
+			req.MatchInterface = true
+			req.InterfaceId = uint32(index)
+		case "protocol":
+			proto, err := parseProtocol(nv[1])
+			if err != nil {
+				return err
+			}
+			req.Protocol = proto
+		case "private", "public":
+			ip := net.ParseIP(nv[1])
+			if ip == nil {
+				return fmt.Errorf("Bad IP address specified \"%s\"", nv[1])
+			}
+			ip4 := ip.To4()
+			if ip4 != nil {
+				ip = ip4
+			}
+			addr := &upd.IPAddress{
+				Address: ip,
+			}
+			if nv[0] == "private" {
+				req.PrivateAddress = addr
+			} else {
+				req.PublicAddress = addr
+			}
+		default:
+			return fmt.Errorf("Bad session selection name \"%s\"", nv[0])
+		}
+	}
+
+	*sra = append(*sra, &req)
+	return nil
+}
+
+func (dcra *dropCountersRequestArray) String() string {
+	res := ""
+	for _, r := range *dcra {
+		res += r.String() + "\n"
+	}
+	return res
+}
+
+func (dcra *dropCountersRequestArray) Set(value string) error {
+	index, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return err
+	}
+	*dcra = append(*dcra, &upd.DropCountersRequest{
+		InterfaceId: uint32(index),
+	})
+	return nil
+}
+
+func (hra *historyRequestArray) String() string {
+	res := ""
+	for _, r := range *hra {
+		res += r.String() + "\n"
+	}
+	return res
+}
+
+func (hra *historyRequestArray) Set(value string) error {
+	parts := strings.Split(value, ",")
+	if len(parts) != 3 && len(parts) != 4 {
+		return fmt.Errorf("Bad session history specification \"%s\"", value)
+	}
+
+	ip := net.ParseIP(parts[0])
+	if ip == nil {
+		return fmt.Errorf("Bad IP address specified \"%s\"", parts[0])
+	}
+	ip4 := ip.To4()
+	if ip4 != nil {
+		ip = ip4
+	}
+
+	port, err := strconv.ParseUint(parts[1], 10, 16)
+	if err != nil {
+		return err
+	}
+
+	t, err := time.Parse(time.RFC3339, parts[2])
+	if err != nil {
+		return err
+	}
+
+	req := upd.SessionHistoryRequest{
+		PublicAddress: &upd.IPAddress{
+			Address: ip,
+		},
+		PublicPort:   uint32(port),
+		TimeUnixNano: t.UnixNano(),
+	}
+	if len(parts) == 4 {
+		req.Protocol, err = parseProtocol(parts[3])
+		if err != nil {
+			return err
+		}
+	}
+	*hra = append(*hra, &req)
+	return nil
+}
+
+// Returns protocol number given by name or number.
+func parseProtocol(value string) (uint32, error) {
+	proto, ok := protocolNumbers[strings.ToLower(value)]
+	if ok {
+		return proto, nil
+	}
+	number, err := strconv.ParseUint(value, 10, 8)
+	if err != nil || number == 0 {
+		return 0, fmt.Errorf("Bad protocol specified \"%s\"", value)
+	}
+	return uint32(number), nil
+}
+
+// Returns protocol name or number if protocol is unknown.
+func formatProtocol(protocol uint32) string {
+	for name, number := range protocolNumbers {
+		if number == protocol {
+			return strings.ToUpper(name)
+		}
+	}
+	return strconv.Itoa(int(protocol))
+}
+
+// Returns endpoint in a form of address:port, IPv6 addresses are
+// enclosed in brackets.
+func formatEndpoint(addr *upd.IPAddress, port uint32) string {
+	return net.JoinHostPort(net.IP(addr.GetAddress()).String(), strconv.Itoa(int(port)))
+}
+
+func formatSession(s *upd.Session) string {
+	res := fmt.Sprintf("interface %d %s %s -> %s", s.GetInterfaceId(), formatProtocol(s.GetProtocol()),
+		formatEndpoint(s.GetPrivateAddress(), s.GetPrivatePort()), formatEndpoint(s.GetPublicAddress(), s.GetPublicPort()))
+	if s.GetRemoteAddress() != nil {
+		res += ", remote " + formatEndpoint(s.GetRemoteAddress(), s.GetRemotePort())
+	}
+	if s.GetStatic() {
+		res += ", static"
+	} else {
+		res += ", age " + (time.Duration(s.GetAgeSeconds()) * time.Second).String()
+	}
+	res += ", last used " + time.Unix(0, s.GetLastUsedUnixNano()).Format(time.RFC3339)
+	if s.GetTcpState() != "" {
+		res += ", TCP state " + s.GetTcpState()
+	}
+	return res
+}
+
+func formatHistoryRecord(r *upd.SessionHistoryRecord) string {
+	res := fmt.Sprintf("interface %d %s %s -> %s", r.GetInterfaceId(), formatProtocol(r.GetProtocol()),
+		formatEndpoint(r.GetPrivateAddress(), r.GetPrivatePort()), formatEndpoint(r.GetPublicAddress(), r.GetPublicPort()))
+	if r.GetRemoteAddress() != nil {
+		res += ", remote " + formatEndpoint(r.GetRemoteAddress(), r.GetRemotePort())
+	}
+	res += ", started " + time.Unix(0, r.GetStartUnixNano()).Format(time.RFC3339)
+	if r.GetEndUnixNano() != 0 {
+		res += ", ended " + time.Unix(0, r.GetEndUnixNano()).Format(time.RFC3339)
+	} else {
+		res += ", active"
+	}
+	return res
+}
+
 func main() {
 	flag.Usage = func() {
-		fmt.Printf(`Usage: client [-a server:port] [-d {+|-}{d|t|k}] [-s index:subnet] [-p {+|-},{TCP|UDP|TCP6|UDP6},port number,target IP address,target port]
+		fmt.Printf(`Usage: client [-a server:port] [-d {+|-}{d|t|k}] [-s index:subnet] [-p {+|-},index,{TCP|UDP|TCP6|UDP6},port number[-last port number],target IP address,target port] [-t name=duration,...] [-o index,IP address,port] [-l index,name=number,...] [-m {+|-},index,public IP address[,private IP address]] [-L all|name=value,...] [-D all|name=value,...] [-c index] [-H public IP address,port,time[,protocol]]
 
 Client sends GRPS requests to NAT server controlling packets trace dump,
-ports subnet adresses and forwarded ports. Multiple requests of the same
-type are allowed and are processed in the following order: all dump, all
-subnet, all port forwarding requests.
+ports subnet adresses, forwarded ports, session timeouts, session limits
+and static mappings, finding owners of port blocks, listing and
+deleting translation sessions, getting dropped packets counters and
+finding private hosts in translation history.
+Multiple requests of the same type are allowed and are processed in the
+following order: all dump, all subnet, all port forwarding, all session
+timeouts, all session limits, all static mapping, all port block owner,
+all session list, all session delete, all drop counters, all
+translation history requests.
 
 `)
 		flag.PrintDefaults()
@@ -199,11 +626,50 @@ number. Subnet is given in form of port IP address and prefix bits.`)
 	flag.Var(&portForwardRequests, "p", `Control TCP and UDP port forwarding in a form of
 +/-,index,protocol,source port,target IP address,target port, e.g.
 +,1,TCP,2222,192.168.5.7,22 or -,0,TCP,22,0.0.0.0,0 or
-+,1,TCP6,2222,fd14::3,22 or -,0,TCP6,22,::,0. If target
-address is zero, it means that port is forwarded to corresponding
-network port KNI interface. Port forwarding to a non-zero
-target address (not to a KNI interface) is possible only for
-public network port.`)
++,1,TCP6,2222,fd14::3,22 or -,0,TCP6,22,::,0. Source port
+may be a range of ports, e.g. +,1,UDP,10000-10999,192.168.5.7,20000.
+The range is forwarded to target port range of the same size which
+starts from target port. If target address is zero, it means
+that port is forwarded to corresponding network port KNI
+interface. Port forwarding to a non-zero target address (not to
+a KNI interface) is possible only for public network port.`)
+	flag.Var(&timeoutsRequests, "t", `Control session timeouts in a form of comma separated
+name=duration list, e.g. tcp-established=2h4m,udp=5m. Possible
+names are tcp-established, tcp-transitory, udp, icmp and
+timeouts of TCP connection states tcp-syn-sent, tcp-syn-recv,
+tcp-fin-wait, tcp-close-wait, tcp-last-ack, tcp-time-wait and
+tcp-close. Timeouts which are not specified are not changed.`)
+	flag.Var(&blockOwnerRequests, "o", `Find subscriber which owns public address and port when port
+blocks are used in a form of index,IP address,port, e.g.
+1,192.168.16.1,5000. Port index is DPDK port number.`)
+	flag.Var(&limitsRequests, "l", `Control maximum numbers of sessions of every private host in a
+form of index followed by comma separated name=number list, e.g.
+0,total=1000,tcp=800 or 1,udp=100. Possible names are total, tcp,
+udp and icmp. Port index is DPDK port number of any port in a pair.
+Limits which are not specified or are zero are removed.`)
+	flag.Var(&mappingRequests, "m", `Control static one-to-one mappings of public addresses to
+private addresses in a form of +,index,public IP address,private
+IP address or -,index,public IP address, e.g.
++,1,192.168.16.200,192.168.14.10 or -,1,192.168.16.200. Port
+index is DPDK port number of any port in a pair.`)
+	flag.Var(&listRequests, "L", `List translation sessions selected by comma separated
+name=value list or all sessions, e.g. index=1,protocol=TCP or
+private=192.168.14.2 or all. Possible names are index, protocol,
+private and public. Port index is DPDK port number of any port in
+a pair. Protocol is a name (ICMP, TCP, UDP, GRE, ESP, ICMP6, SCTP,
+UDPLite) or a number. Private and public are session addresses.`)
+	flag.Var(&deleteRequests, "D", `Delete translation sessions selected in the same way as for
+listing, e.g. private=192.168.14.2,protocol=UDP. Static sessions
+of forwarded ports are not deleted.`)
+	flag.Var(&dropCountersRequests, "c", `Get numbers of dropped packets of port pair by traffic
+direction and drop reason, e.g. 1. Port index is DPDK port number of
+any port in a pair.`)
+	flag.Var(&historyRequests, "H", `Find translation sessions which used public address and port
+at given time in a form of public IP address,port,time[,protocol],
+e.g. 192.168.16.1,5000,2019-06-01T12:00:00Z,TCP. Time is given in
+RFC 3339 format. Protocol is a name or a number, all protocols are
+matched if it is not specified. Translation history should be
+enabled in NAT configuration.`)
 	flag.Parse()
 
 	// Set up a connection to the server.
@@ -240,4 +706,80 @@ public network port.`)
 		}
 		log.Printf("update successful: \"%s\"", reply.String())
 	}
+
+	for _, r := range timeoutsRequests {
+		reply, err := c.ChangeSessionTimeouts(ctx, r)
+		if err != nil {
+			log.Fatalf("could not update: %v", err)
+		}
+		log.Printf("update successful: \"%s\"", reply.String())
+	}
+
+	for _, r := range limitsRequests {
+		reply, err := c.ChangeSessionLimits(ctx, r)
+		if err != nil {
+			log.Fatalf("could not update: %v", err)
+		}
+		log.Printf("update successful: \"%s\"", reply.String())
+	}
+
+	for _, r := range mappingRequests {
+		reply, err := c.ChangeStaticMapping(ctx, r)
+		if err != nil {
+			log.Fatalf("could not update: %v", err)
+		}
+		log.Printf("update successful: \"%s\"", reply.String())
+	}
+
+	for _, r := range blockOwnerRequests {
+		reply, err := c.FindPortBlockOwner(ctx, r)
+		if err != nil {
+			log.Fatalf("could not find port block owner: %v", err)
+		}
+		log.Printf("request successful: \"%s\"", reply.String())
+	}
+
+	for _, r := range listRequests {
+		stream, err := c.ListSessions(ctx, r)
+		if err != nil {
+			log.Fatalf("could not list sessions: %v", err)
+		}
+		for {
+			s, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				log.Fatalf("could not list sessions: %v", err)
+			}
+			fmt.Println(formatSession(s))
+		}
+	}
+
+	for _, r := range deleteRequests {
+		reply, err := c.DeleteSessions(ctx, r)
+		if err != nil {
+			log.Fatalf("could not delete sessions: %v", err)
+		}
+		log.Printf("request successful: \"%s\"", reply.String())
+	}
+
+	for _, r := range dropCountersRequests {
+		reply, err := c.GetDropCounters(ctx, r)
+		if err != nil {
+			log.Fatalf("could not get drop counters: %v", err)
+		}
+		for _, dc := range reply.GetCounters() {
+			fmt.Printf("%s %s %d\n", dc.GetDirection(), dc.GetReason(), dc.GetPackets())
+		}
+	}
+	for _, r := range historyRequests {
+		reply, err := c.FindSessionHistory(ctx, r)
+		if err != nil {
+			log.Fatalf("could not find session history: %v", err)
+		}
+		for _, hr := range reply.GetRecords() {
+			fmt.Println(formatHistoryRecord(hr))
+		}
+	}
 }
@@ -55,6 +55,7 @@ e.g. "-dump d" or "-dump dtk":
     d means to trace dropped packets,
     t means to trace translated (normally sent) packets,
     k means to trace packets that were sent to KNI interface.`)
+	flag.BoolVar(&nat.DropReasonComments, "drop-reasons", false, "Dump dropped packets in pcapng format with drop reasons in packet comments.")
 	schedulerInterval := flag.Uint("scheduler-interval", 500, "Set scheduler interval in ms. Lower values allow faster reaction to changing traffic but increase scheduling overhead.")
 	sendCPUCoresPerPort := flag.Int("send-threads", 1, "Number of CPU cores to be occupied by Send routines.")
 	tXQueuesNumberPerPort := flag.Int("tx-queues", 4, "Number of transmit queues to use on network card.")
@@ -103,6 +104,18 @@ e.g. "-dump d" or "-dump dtk":
 	// Start GRPC server
 	flow.CheckFatal(nat.StartGRPCServer())
 
+	// Start metrics HTTP server
+	flow.CheckFatal(nat.StartMetricsServer())
+
+	// Start export of NAT events to IPFIX collector
+	flow.CheckFatal(nat.StartIPFIXExporter())
+
+	// Start writing translation log to syslog or local files
+	flow.CheckFatal(nat.StartTranslationLog())
+
+	// Start writing translation history for reverse lookups
+	flow.CheckFatal(nat.StartHistoryStore())
+
 	// Perform all network initialization so that DHCP client could
 	// start sending packets
 	flow.CheckFatal(flow.SystemInitPortsAndMemory())
@@ -112,6 +125,9 @@ e.g. "-dump d" or "-dump dtk":
 		nat.StartDHCPClient()
 	}
 
+	// Start background expiry of idle sessions
+	nat.StartSessionReaper()
+
 	// Start flow scheduler
 	go func() {
 		flow.CheckFatal(flow.SystemStartScheduler())
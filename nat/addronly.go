@@ -0,0 +1,307 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"encoding/binary"
+
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/types"
+)
+
+const (
+	greNumber     = 47
+	espNumber     = 50
+	sctpNumber    = 132
+	udpLiteNumber = 136
+
+	greVersionMask     = 0x0007
+	greKeyPresent      = 0x2000
+	greEnhancedVersion = 1
+	greCallIDOffset    = 6
+	espSPIOffset       = 0
+
+	// Maximum number of inbound lookup keys of address-only
+	// session, so that remote host can't fill translation table by
+	// sending packets with new identifiers
+	maxAddrOnlyKeys = 4
+)
+
+// Checks whether protocol has no ports, so that its packets are
+// translated by changing addresses only.
+func isAddrOnlyProtocol(protocol uint8) bool {
+	return protocol == greNumber || protocol == espNumber
+}
+
+// Returns session identifier of inbound GRE or ESP packet. Original
+// GRE (RFC 2784) has no identifier, so zero is used for it.
+func getAddrOnlyID(pkt *packet.Packet, ipv6 bool, protocol uint8) (uint32, bool) {
+	if protocol == espNumber {
+		data := getL4Data(pkt, ipv6, espSPIOffset+4)
+		if data == nil {
+			return 0, false
+		}
+		return binary.BigEndian.Uint32(data[espSPIOffset:]), true
+	}
+	data := getL4Data(pkt, ipv6, 4)
+	if data == nil {
+		return 0, false
+	}
+	flags := binary.BigEndian.Uint16(data)
+	if flags&greVersionMask != greEnhancedVersion || flags&greKeyPresent == 0 {
+		return 0, true
+	}
+	if len(data) < greCallIDOffset+2 {
+		return 0, false
+	}
+	return uint32(binary.BigEndian.Uint16(data[greCallIDOffset:])), true
+}
+
+// Returns inbound lookup key of address-only session with given
+// identifier.
+func makeAddrOnlyKey(pktIPv4 *packet.IPv4Hdr, pktIPv6 *packet.IPv6Hdr, id uint32) sessionKey {
+	if pktIPv6 != nil {
+		return sessionKey{
+			addr6:       pktIPv6.DstAddr,
+			remoteAddr6: pktIPv6.SrcAddr,
+			id:          id,
+			kind:        sessionKeyAddrOnly6,
+		}
+	}
+	return sessionKey{
+		addr:       packet.SwapBytesIPv4Addr(pktIPv4.DstAddr),
+		remoteAddr: packet.SwapBytesIPv4Addr(pktIPv4.SrcAddr),
+		id:         id,
+		kind:       sessionKeyAddrOnly,
+	}
+}
+
+// Returns pending key of public address and remote address.
+func makePendingKey(ipv6 bool, v4addr, remoteAddr types.IPv4Address, v6addr, remoteAddr6 types.IPv6Address) sessionKey {
+	if ipv6 {
+		return sessionKey{
+			addr6:       v6addr,
+			remoteAddr6: remoteAddr6,
+			kind:        sessionKeyPending6,
+		}
+	}
+	return sessionKey{
+		addr:       v4addr,
+		remoteAddr: remoteAddr,
+		kind:       sessionKeyPending,
+	}
+}
+
+// Adds inbound lookup key of address-only session. Returns false if
+// session already has maximum number of inbound keys. Should be
+// executed under port lock of session public address.
+func (pme *portMapEntry) addAddrOnlyKey(table *sessionTable, key sessionKey) bool {
+	extra := pme.getExtra(true)
+	for i := range extra.addrOnlyKeys {
+		if extra.addrOnlyKeys[i] == key {
+			return table.Store(key, pme)
+		}
+	}
+	if len(extra.addrOnlyKeys) >= maxAddrOnlyKeys {
+		return false
+	}
+	extra.addrOnlyKeys = append(extra.addrOnlyKeys, key)
+	return table.Store(key, pme)
+}
+
+// Deletes inbound lookup keys which still point to address-only
+// session. Pending keys may point to another session already. Should
+// be executed under port lock of session public address.
+func (pme *portMapEntry) deleteAddrOnlyKeys(table *sessionTable) {
+	extra := pme.getExtra(false)
+	if extra == nil {
+		return
+	}
+	for _, key := range extra.addrOnlyKeys {
+		table.CompareAndDelete(key, pme)
+	}
+	extra.addrOnlyKeys = nil
+}
+
+// Translates GRE or ESP packet received from public network. Session
+// is found by public address, remote address and identifier of
+// packet. First inbound packet with unknown identifier is bound to
+// session which sent packets to remote address last.
+func (pp *portPair) translateAddrOnlyPub2Pri(pkt *packet.Packet, pktVLAN *packet.VLANHdr, pktIPv4 *packet.IPv4Hdr, pktIPv6 *packet.IPv6Hdr, protocol uint8) uint {
+	port := &pp.PublicPort
+	ipv6 := pktIPv6 != nil
+
+	var poolIndex int
+	var inPool, addressAcquired bool
+	if ipv6 {
+		poolIndex, inPool = port.getPoolIndex(true, 0, pktIPv6.DstAddr)
+		addressAcquired = port.Subnet6.addressAcquired
+	} else {
+		poolIndex, inPool = port.getPoolIndex(false, packet.SwapBytesIPv4Addr(pktIPv4.DstAddr), zeroIPv6Addr)
+		addressAcquired = port.Subnet.addressAcquired
+	}
+	port.learnSourceMAC(pkt, pktIPv4, pktIPv6)
+
+	table := port.translationTable[protocol]
+	var pme *portMapEntry
+	found := false
+	if id, ok := getAddrOnlyID(pkt, ipv6, protocol); ok && inPool {
+		key := makeAddrOnlyKey(pktIPv4, pktIPv6, id)
+		pme, found = table.Load(key)
+		if !found {
+			pending := makePendingKey(ipv6, key.addr, key.remoteAddr, key.addr6, key.remoteAddr6)
+			if pme, found = table.Load(pending); found {
+				mutex := port.portLock(ipv6, poolIndex, protocol)
+				mutex.Lock()
+				if v, active := table.Load(pme.pub); !active || v != pme || !pme.addAddrOnlyKey(table, key) {
+					found = false
+				}
+				mutex.Unlock()
+			}
+		}
+	}
+	if !found {
+		// Packets which don't belong to any session are directed
+		// to KNI interface if it is present
+		dir := dropResult(dropNoSession)
+		if port.KNIName != "" && addressAcquired {
+			dir = DirKNI
+		}
+		port.dumpPacket(pkt, dir)
+		return dir
+	}
+
+	_, _, handle, _ := getAddrFromTuple(pme.pub)
+	if pme.expired(protocol) {
+		pp.deleteConnection(ipv6, protocol, poolIndex, int(handle))
+		return port.dropPacket(pkt, dropSessionExpired)
+	}
+	pme.touch()
+
+	v4addr, v6addr, _, _ := getAddrFromTuple(pme.priv)
+	var mac types.MACAddress
+	if ipv6 {
+		mac, found = port.opposite.getMACForIPv6(v6addr)
+	} else {
+		mac, found = port.opposite.getMACForIPv4(v4addr)
+	}
+	if !found {
+		return port.dropPacket(pkt, dropNeighborUnresolved)
+	}
+
+	// Do packet translation
+	pkt.Ether.DAddr = mac
+	pkt.Ether.SAddr = port.opposite.SrcMACAddress
+	if pktVLAN != nil {
+		pktVLAN.SetVLANTagIdentifier(port.opposite.Vlan)
+	}
+	// GRE and ESP have no checksums which cover addresses
+	newFirstFragment(pktIPv4, pktIPv6, protocol, nil, nil, nil).setAddr(false, v4addr, v6addr)
+
+	port.opposite.dumpPacket(pkt, DirSEND)
+	return DirSEND
+}
+
+// Translates GRE or ESP packet sent by private host. Every pair of
+// private host and remote address has its own session which gets a
+// handle from port numbers of public address.
+func (pp *portPair) translateAddrOnlyPri2Pub(pkt *packet.Packet, pktVLAN *packet.VLANHdr, pktIPv4 *packet.IPv4Hdr, pktIPv6 *packet.IPv6Hdr, protocol uint8) uint {
+	port := &pp.PrivatePort
+	ipv6 := pktIPv6 != nil
+
+	var privEntry sessionKey
+	var addressAcquired, publicAddressAcquired, packetSentToUs bool
+	if ipv6 {
+		privEntry = makeTuple(true, 0, pktIPv6.SrcAddr, 0)
+		privEntry.remoteAddr6 = pktIPv6.DstAddr
+		addressAcquired = port.Subnet6.addressAcquired
+		publicAddressAcquired = port.opposite.Subnet6.addressAcquired
+		packetSentToUs = port.Subnet6.Addr == pktIPv6.DstAddr || port.Subnet6.llAddr == pktIPv6.DstAddr
+	} else {
+		privEntry = makeTuple(false, packet.SwapBytesIPv4Addr(pktIPv4.SrcAddr), zeroIPv6Addr, 0)
+		privEntry.remoteAddr = packet.SwapBytesIPv4Addr(pktIPv4.DstAddr)
+		addressAcquired = port.Subnet.addressAcquired
+		publicAddressAcquired = port.opposite.Subnet.addressAcquired
+		packetSentToUs = port.Subnet.Addr == packet.SwapBytesIPv4Addr(pktIPv4.DstAddr)
+	}
+
+	// If traffic is directed at private interface IP and KNI is
+	// present, this traffic is directed to KNI
+	if port.KNIName != "" && addressAcquired && packetSentToUs {
+		port.dumpPacket(pkt, DirKNI)
+		return DirKNI
+	}
+	if !addressAcquired || !publicAddressAcquired {
+		return port.dropPacket(pkt, dropNoAddress)
+	}
+	port.learnSourceMAC(pkt, pktIPv4, pktIPv6)
+
+	var v4addr types.IPv4Address
+	var v6addr types.IPv6Address
+	var handle uint16
+	var poolIndex int
+	pme, found := port.translationTable[protocol].Load(privEntry)
+	if found {
+		var inPool bool
+		v4addr, v6addr, handle, _ = getAddrFromTuple(pme.pub)
+		poolIndex, inPool = pp.PublicPort.getPoolIndex(ipv6, v4addr, v6addr)
+		if !inPool {
+			// Public address was changed since this session was
+			// established
+			return port.dropPacket(pkt, dropAddressChanged)
+		}
+		pme.touch()
+	} else {
+		var err error
+		v4addr, v6addr, handle, poolIndex, err = pp.allocateNewEgressConnection(ipv6, protocol, privEntry)
+		if err != nil {
+			if err != errSessionLimit {
+				println("Warning! Failed to allocate new connection", err.Error())
+			}
+			return port.dropPacket(pkt, allocationDropReason(err))
+		}
+		pme = &pp.PublicPort.getPortmap(ipv6, poolIndex, protocol)[handle]
+	}
+
+	// Inbound packets with unknown identifiers from remote address
+	// belong to this session now
+	var pending sessionKey
+	if ipv6 {
+		pending = makePendingKey(true, 0, 0, v6addr, pktIPv6.DstAddr)
+	} else {
+		pending = makePendingKey(false, v4addr, packet.SwapBytesIPv4Addr(pktIPv4.DstAddr), zeroIPv6Addr, zeroIPv6Addr)
+	}
+	pubTable := pp.PublicPort.translationTable[protocol]
+	if v, found := pubTable.Load(pending); !found || v != pme {
+		// Session may be deleted by another handler meanwhile
+		mutex := pp.PublicPort.portLock(ipv6, poolIndex, protocol)
+		mutex.Lock()
+		if v, active := pubTable.Load(pme.pub); active && v == pme {
+			pme.addAddrOnlyKey(pubTable, pending)
+		}
+		mutex.Unlock()
+	}
+
+	var mac types.MACAddress
+	if ipv6 {
+		mac, found = port.opposite.getMACForIPv6(pktIPv6.DstAddr)
+	} else {
+		mac, found = port.opposite.getMACForIPv4(packet.SwapBytesIPv4Addr(pktIPv4.DstAddr))
+	}
+	if !found {
+		return port.dropPacket(pkt, dropNeighborUnresolved)
+	}
+
+	// Do packet translation
+	pkt.Ether.DAddr = mac
+	pkt.Ether.SAddr = port.opposite.SrcMACAddress
+	if pktVLAN != nil {
+		pktVLAN.SetVLANTagIdentifier(port.opposite.Vlan)
+	}
+	newFirstFragment(pktIPv4, pktIPv6, protocol, nil, nil, nil).setAddr(true, v4addr, v6addr)
+
+	port.opposite.dumpPacket(pkt, DirSEND)
+	return DirSEND
+}
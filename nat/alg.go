@@ -0,0 +1,214 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/types"
+)
+
+// Sequence numbers adjustment of TCP connection which payload length
+// was changed by ALG. Like in Linux conntrack, segments which start
+// after position of the last change are shifted by offsetAfter,
+// earlier segments are shifted by offsetBefore.
+type tcpSeqAdjust struct {
+	pos          uint32
+	offsetBefore int32
+	offsetAfter  int32
+}
+
+// Remembers that payload length of segment which starts at seq was
+// changed by diff bytes. Retransmitted segments don't change offsets
+// again.
+func (adj *tcpSeqAdjust) record(seq uint32, diff int) {
+	if adj.offsetBefore == adj.offsetAfter || int32(seq-adj.pos) > 0 {
+		adj.pos = seq
+		adj.offsetBefore = adj.offsetAfter
+		adj.offsetAfter += int32(diff)
+	}
+}
+
+// Returns offset of sequence number of segment sent by side which
+// payload is changed.
+func (adj *tcpSeqAdjust) seqOffset(seq uint32) int32 {
+	if int32(seq-adj.pos) > 0 {
+		return adj.offsetAfter
+	}
+	return adj.offsetBefore
+}
+
+// Returns offset of acknowledgement number of segment sent by
+// opposite side.
+func (adj *tcpSeqAdjust) ackOffset(ack uint32) int32 {
+	if int32(ack-uint32(adj.offsetBefore)-adj.pos) > 0 {
+		return adj.offsetAfter
+	}
+	return adj.offsetBefore
+}
+
+// State of TCP connection which payload is changed by ALG. Payload
+// may be changed in both directions, so sequence numbers are adjusted
+// for each of them.
+type algConnection struct {
+	mutex    sync.Mutex
+	lastused time.Time
+	adjust   [2]tcpSeqAdjust
+	// Last rewritten segment. Its retransmissions are rewritten in
+	// the same way without creating new mappings.
+	rewriteSeq   uint32
+	rewrite      []byte
+	rewriteValid bool
+}
+
+// Adjusts sequence and acknowledgement numbers of segment sent in
+// direction dir. Should be called under connection lock after
+// segment payload is changed.
+func (conn *algConnection) adjustSegment(pktTCP *packet.TCPHdr, seq uint32, dir trafficDirection) {
+	pktTCP.SentSeq = packet.SwapBytesUint32(seq + uint32(conn.adjust[dir].seqOffset(seq)))
+	if pktTCP.TCPFlags&types.TCPFlagAck != 0 {
+		ack := packet.SwapBytesUint32(pktTCP.RecvAck)
+		pktTCP.RecvAck = packet.SwapBytesUint32(ack - uint32(conn.adjust[dir.opposite()].ackOffset(ack)))
+	}
+}
+
+// Returns state of ALG connection with remote endpoint. New state is
+// created if create is true. States of connections which were not
+// used for longer than established TCP connection timeout are
+// forgotten because static port entries are never reset.
+func (pp *portPair) getALGConnection(pme *portMapEntry, remote sessionKey, create bool) *algConnection {
+	extra := pme.getExtra(create)
+	if extra == nil {
+		return nil
+	}
+	p := (*unsafe.Pointer)(unsafe.Pointer(&extra.alg))
+	conns := (*sync.Map)(atomic.LoadPointer(p))
+	if conns == nil {
+		if !create {
+			return nil
+		}
+		// Handlers may create states map concurrently, only one of
+		// them is kept
+		atomic.CompareAndSwapPointer(p, nil, unsafe.Pointer(new(sync.Map)))
+		conns = (*sync.Map)(atomic.LoadPointer(p))
+	}
+	if v, found := conns.Load(remote); found {
+		return v.(*algConnection)
+	}
+	if !create {
+		return nil
+	}
+
+	timeout := Natconfig.getTimeouts().TCPEstablished
+	conns.Range(func(k, v interface{}) bool {
+		conn := v.(*algConnection)
+		conn.mutex.Lock()
+		if time.Since(conn.lastused) > timeout {
+			conns.Delete(k)
+		}
+		conn.mutex.Unlock()
+		return true
+	})
+	v, _ := conns.LoadOrStore(remote, &algConnection{
+		lastused: time.Now(),
+	})
+	return v.(*algConnection)
+}
+
+// Returns remote endpoint of ALG connection. It is destination of
+// packets sent from private network and source of packets received
+// from public network.
+func makeALGRemote(pktIPv4 *packet.IPv4Hdr, pktIPv6 *packet.IPv6Hdr, port uint16, dir trafficDirection) sessionKey {
+	if pktIPv6 != nil {
+		addr := pktIPv6.DstAddr
+		if dir == pub2pri {
+			addr = pktIPv6.SrcAddr
+		}
+		return makeTuple(true, 0, addr, port)
+	}
+	addr := pktIPv4.DstAddr
+	if dir == pub2pri {
+		addr = pktIPv4.SrcAddr
+	}
+	return makeTuple(false, packet.SwapBytesIPv4Addr(addr), zeroIPv6Addr, port)
+}
+
+// Returns TCP payload of packet.
+func getTCPPayload(pkt *packet.Packet, ipv6 bool, pktTCP *packet.TCPHdr) []byte {
+	hdrLen := int(pktTCP.DataOff>>4) << 2
+	data := getL4Data(pkt, ipv6, hdrLen)
+	if data == nil {
+		return nil
+	}
+	return data[hdrLen:]
+}
+
+// Returns UDP payload of packet.
+func getUDPPayload(pkt *packet.Packet, ipv6 bool) []byte {
+	data := getL4Data(pkt, ipv6, types.UDPLen)
+	if data == nil {
+		return nil
+	}
+	return data[types.UDPLen:]
+}
+
+// Replaces bytes from start to end of L4 payload with data. Packet
+// length and length fields of IP and UDP headers are changed
+// accordingly. Checksums are not updated.
+func replacePayload(pkt *packet.Packet, pktIPv4 *packet.IPv4Hdr, pktIPv6 *packet.IPv6Hdr, pktUDP *packet.UDPHdr,
+	payload []byte, start, end int, data []byte) bool {
+	// Only packets of one segment can be resized
+	if len(payload) == 0 || pkt.GetPacketLen() != pkt.GetPacketSegmentLen() {
+		return false
+	}
+	offset := uint(uintptr(unsafe.Pointer(&payload[0])) - uintptr(unsafe.Pointer(pkt.Ether)))
+	diff := len(data) - (end - start)
+	if diff > 0 {
+		if !pkt.EncapsulateTail(offset+uint(end), uint(diff)) {
+			return false
+		}
+	} else if diff < 0 {
+		if !pkt.DecapsulateTail(offset+uint(start+len(data)), uint(-diff)) {
+			return false
+		}
+	}
+	pkt.PacketBytesChange(offset+uint(start), data)
+	if pktIPv6 != nil {
+		pktIPv6.PayloadLen = packet.SwapBytesUint16(uint16(int(packet.SwapBytesUint16(pktIPv6.PayloadLen)) + diff))
+	} else {
+		pktIPv4.TotalLength = packet.SwapBytesUint16(uint16(int(packet.SwapBytesUint16(pktIPv4.TotalLength)) + diff))
+	}
+	if pktUDP != nil {
+		pktUDP.DgramLen = packet.SwapBytesUint16(uint16(int(packet.SwapBytesUint16(pktUDP.DgramLen)) + diff))
+	}
+	return true
+}
+
+// Creates mapping of connection which is expected to be opened from
+// public network to private endpoint. Existing mapping of private
+// endpoint is used if there is one. Expected connection may come from
+// any port of remote host, so inbound filtering is not applied to it.
+// Returns public address and port, pool index and whether mapping
+// was created.
+func (pp *portPair) expectConnection(ipv6 bool, protocol uint8, poolIndex int, privEntry sessionKey) (types.IPv4Address, types.IPv6Address, uint16, int, bool, error) {
+	if pme, found := pp.PrivatePort.translationTable[protocol].Load(privEntry); found {
+		v4addr, v6addr, port, zeroAddr := getAddrFromTuple(pme.pub)
+		if zeroAddr {
+			return 0, zeroIPv6Addr, 0, 0, false, errors.New("Private endpoint is forwarded to KNI interface")
+		}
+		index, _ := pp.PublicPort.getPoolIndex(ipv6, v4addr, v6addr)
+		return v4addr, v6addr, port, index, false, nil
+	}
+	v4addr, v6addr, port, index, err := pp.allocateConnection(ipv6, protocol, privEntry, poolIndex, false)
+	if err != nil {
+		return 0, zeroIPv6Addr, 0, 0, false, err
+	}
+	return v4addr, v6addr, port, index, true, nil
+}
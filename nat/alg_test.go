@@ -0,0 +1,82 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"testing"
+)
+
+func TestTCPSeqAdjust(t *testing.T) {
+	type change struct {
+		seq  uint32
+		diff int
+	}
+	type check struct {
+		// Sequence or acknowledgement number and its expected
+		// offset
+		num    uint32
+		offset int32
+		ack    bool
+	}
+	tests := []struct {
+		name    string
+		changes []change
+		checks  []check
+	}{
+		{"NoChanges", nil, []check{
+			{1000, 0, false},
+			{1000, 0, true},
+		}},
+		{"Grown", []change{{1000, 5}}, []check{
+			{999, 0, false},
+			{1000, 0, false},
+			{1001, 5, false},
+			{1000, 0, true},
+			{1001, 5, true},
+		}},
+		{"Shrunk", []change{{1000, -3}}, []check{
+			{1000, 0, false},
+			{2000, -3, false},
+			{1001, -3, true},
+		}},
+		{"Retransmitted", []change{{1000, 5}, {1000, 5}}, []check{
+			{1000, 0, false},
+			{1001, 5, false},
+		}},
+		{"Twice", []change{{1000, 5}, {2000, -2}}, []check{
+			{1500, 5, false},
+			{2000, 5, false},
+			{2001, 3, false},
+			{2005, 5, true},
+			{2006, 3, true},
+		}},
+		{"EarlierRetransmitted", []change{{1000, 5}, {2000, 4}, {1000, 5}}, []check{
+			{2000, 5, false},
+			{2001, 9, false},
+		}},
+		{"Wraparound", []change{{0xfffffff0, 4}}, []check{
+			{0xffffffe0, 0, false},
+			{0x10, 4, false},
+			{0x10, 4, true},
+		}},
+	}
+	for _, tt := range tests {
+		var adj tcpSeqAdjust
+		for _, c := range tt.changes {
+			adj.record(c.seq, c.diff)
+		}
+		for _, c := range tt.checks {
+			var offset int32
+			if c.ack {
+				offset = adj.ackOffset(c.num)
+			} else {
+				offset = adj.seqOffset(c.num)
+			}
+			if offset != c.offset {
+				t.Errorf("%s: offset of %d (ack %t) is %d, expected %d", tt.name, c.num, c.ack, offset, c.offset)
+			}
+		}
+	}
+}
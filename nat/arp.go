@@ -21,26 +21,31 @@ func (port *ipPort) handleARP(pkt *packet.Packet) uint {
 		if port.KNIName != "" {
 			return DirKNI
 		}
-		return DirDROP
+		return dropResult(dropHandledLocally)
 	}
 
+	// Addresses from address pool and static mappings are not known
+	// to KNI interface, so requests for them are always answered here
+	targetAddr := packet.SwapBytesIPv4Addr(types.ArrayToIPv4(arp.TPA))
+	poolAddr := port.AddressPool.contains(targetAddr) || port.isStaticPublicAddr(targetAddr)
+
 	// If there is a KNI interface, direct all ARP traffic to it
-	if port.KNIName != "" {
+	if port.KNIName != "" && !poolAddr {
 		return DirKNI
 	}
 
 	// Check that someone is asking about MAC of my IP address and HW
 	// address is blank in request
-	if types.BytesToIPv4(arp.TPA[0], arp.TPA[1], arp.TPA[2], arp.TPA[3]) != packet.SwapBytesIPv4Addr(port.Subnet.Addr) {
+	if targetAddr != port.Subnet.Addr && !poolAddr {
 		println("Warning! Got an ARP packet with target IPv4 address", types.IPv4ArrayToString(arp.TPA),
 			"different from IPv4 address on interface. Should be", port.Subnet.Addr.String(),
 			". ARP request ignored.")
-		return DirDROP
+		return dropResult(dropNotForUs)
 	}
 	if arp.THA != (types.MACAddress{}) {
 		println("Warning! Got an ARP packet with non-zero MAC address", arp.THA.String(),
 			". ARP request ignored.")
-		return DirDROP
+		return dropResult(dropMalformed)
 	}
 
 	// Prepare an answer to this request
@@ -58,7 +63,7 @@ func (port *ipPort) handleARP(pkt *packet.Packet) uint {
 	port.dumpPacket(answerPacket, DirSEND)
 	answerPacket.SendPacket(port.Index)
 
-	return DirDROP
+	return dropResult(dropHandledLocally)
 }
 
 func (port *ipPort) getMACForIPv4(ip types.IPv4Address) (types.MACAddress, bool) {
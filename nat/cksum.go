@@ -5,11 +5,21 @@
 package nat
 
 import (
+	"encoding/binary"
+	"hash/crc32"
+	"unsafe"
+
 	"github.com/intel-go/nff-go/packet"
 	"github.com/intel-go/nff-go/types"
-	"unsafe"
 )
 
+const (
+	sctpHdrLen         = 12
+	sctpChecksumOffset = 8
+)
+
+var sctpCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
 func setIPv4UDPChecksum(pkt *packet.Packet, calculateChecksum, hWTXChecksum bool) {
 	if calculateChecksum {
 		l3 := pkt.GetIPv4NoCheck()
@@ -114,3 +124,177 @@ func setIPv6ICMPChecksum(pkt *packet.Packet, calculateChecksum, hWTXChecksum boo
 			unsafe.Pointer(uintptr(unsafe.Pointer(l4))+types.ICMPLen)))
 	}
 }
+
+// Incrementally updates checksum after a 16-bit word of checksummed
+// data changes from old to new value according to RFC 1624. Values
+// are used in the same byte order as they are stored in packet.
+func updateChecksum16(cksum, old, new uint16) uint16 {
+	sum := uint32(^cksum) + uint32(^old) + uint32(new)
+	sum = (sum & 0xffff) + (sum >> 16)
+	sum = (sum & 0xffff) + (sum >> 16)
+	return ^uint16(sum)
+}
+
+// Incrementally updates checksum after IPv4 address changes. Addresses
+// are in network byte order.
+func updateChecksumIPv4Addr(cksum uint16, old, new types.IPv4Address) uint16 {
+	cksum = updateChecksum16(cksum, uint16(old), uint16(new))
+	return updateChecksum16(cksum, uint16(old>>16), uint16(new>>16))
+}
+
+// Incrementally updates checksum after IPv6 address changes.
+func updateChecksumIPv6Addr(cksum uint16, old, new types.IPv6Address) uint16 {
+	for i := 0; i < types.IPv6AddrLen; i += 2 {
+		cksum = updateChecksum16(cksum,
+			uint16(old[i])|uint16(old[i+1])<<8,
+			uint16(new[i])|uint16(new[i+1])<<8)
+	}
+	return cksum
+}
+
+// Sets L4 checksum of a packet which header starts with ports like
+// UDP header. UDP-Lite and SCTP have their own checksums.
+func setUDPLikeChecksum(pkt *packet.Packet, ipv6 bool, protocol uint8) {
+	switch protocol {
+	case sctpNumber:
+		setSCTPChecksum(pkt, ipv6, !NoCalculateChecksum, !NoHWTXChecksum)
+	case udpLiteNumber:
+		setUDPLiteChecksum(pkt, ipv6, !NoCalculateChecksum, !NoHWTXChecksum)
+	default:
+		if ipv6 {
+			setIPv6UDPChecksum(pkt, !NoCalculateChecksum, !NoHWTXChecksum)
+		} else {
+			setIPv4UDPChecksum(pkt, !NoCalculateChecksum, !NoHWTXChecksum)
+		}
+	}
+}
+
+// Sets IPv4 header checksum of packets which L4 checksum cannot be
+// offloaded to hardware.
+func setIPv4HdrChecksum(pkt *packet.Packet, hWTXChecksum bool) {
+	l3 := pkt.GetIPv4NoCheck()
+	if hWTXChecksum {
+		l3.HdrChecksum = 0
+		l2len := uint32(types.EtherLen)
+		if pkt.Ether.EtherType == types.SwapVLANNumber {
+			l2len += types.VLANLen
+		}
+		pkt.SetTXIPv4OLFlags(l2len, types.IPv4MinLen)
+	} else {
+		l3.HdrChecksum = packet.SwapBytesUint16(packet.CalculateIPv4Checksum(l3))
+	}
+}
+
+// Returns L4 data of a packet according to length of its IP
+// payload. Returns nil if packet is shorter than minimum length.
+func getL4Data(pkt *packet.Packet, ipv6 bool, minLen int) []byte {
+	var end uintptr
+	if ipv6 {
+		l3 := pkt.GetIPv6NoCheck()
+		end = uintptr(unsafe.Pointer(l3)) + types.IPv6Len + uintptr(packet.SwapBytesUint16(l3.PayloadLen))
+	} else {
+		l3 := pkt.GetIPv4NoCheck()
+		end = uintptr(unsafe.Pointer(l3)) + uintptr(packet.SwapBytesUint16(l3.TotalLength))
+	}
+	segEnd := uintptr(unsafe.Pointer(pkt.Ether)) + uintptr(pkt.GetPacketSegmentLen())
+	if end > segEnd {
+		end = segEnd
+	}
+	start := uintptr(pkt.L4)
+	if end < start+uintptr(minLen) {
+		return nil
+	}
+	length := int(end - start)
+	return (*[types.MaxLength]byte)(pkt.L4)[:length:length]
+}
+
+// Calculates CRC32c checksum of SCTP packet (RFC 4960 appendix
+// B). Checksum doesn't cover IP addresses but IPv4 header checksum
+// has to be updated too.
+func setSCTPChecksum(pkt *packet.Packet, ipv6 bool, calculateChecksum, hWTXChecksum bool) {
+	if !calculateChecksum {
+		return
+	}
+	if !ipv6 {
+		setIPv4HdrChecksum(pkt, hWTXChecksum)
+	}
+	data := getL4Data(pkt, ipv6, sctpHdrLen)
+	if data == nil {
+		return
+	}
+	cksum := data[sctpChecksumOffset : sctpChecksumOffset+4]
+	binary.LittleEndian.PutUint32(cksum, 0)
+	binary.LittleEndian.PutUint32(cksum, crc32.Checksum(data, sctpCRCTable))
+}
+
+// Returns sum of 16-bit big endian words of data. Odd last byte is
+// padded with zero.
+func sumBytes(data []byte) uint32 {
+	var sum uint32
+	n := len(data) &^ 1
+	for i := 0; i < n; i += 2 {
+		sum += uint32(data[i])<<8 | uint32(data[i+1])
+	}
+	if n != len(data) {
+		sum += uint32(data[n]) << 8
+	}
+	return sum
+}
+
+// Checks that UDP-Lite checksum coverage is either zero or covers at
+// least the header and doesn't exceed packet length. Packets with
+// illegal coverage must be discarded (RFC 3828 section 3.1). Length
+// of fragmented packet is not known, so only minimum coverage is
+// checked for it.
+func checkUDPLiteCoverage(pkt *packet.Packet, ipv6, fragmented bool) bool {
+	data := getL4Data(pkt, ipv6, types.UDPLen)
+	if data == nil {
+		return false
+	}
+	coverage := int(packet.SwapBytesUint16((*packet.UDPHdr)(pkt.L4).DgramLen))
+	if coverage == 0 {
+		return true
+	}
+	return coverage >= types.UDPLen && (fragmented || coverage <= len(data))
+}
+
+// Calculates UDP-Lite checksum (RFC 3828). Checksum covers pseudo
+// header and number of first bytes of packet given in its checksum
+// coverage field, zero coverage means the whole packet. UDP-Lite
+// checksum is not offloaded to hardware.
+func setUDPLiteChecksum(pkt *packet.Packet, ipv6 bool, calculateChecksum, hWTXChecksum bool) {
+	if !calculateChecksum {
+		return
+	}
+	data := getL4Data(pkt, ipv6, types.UDPLen)
+	if data == nil {
+		return
+	}
+	l4 := (*packet.UDPHdr)(pkt.L4)
+	coverage := int(packet.SwapBytesUint16(l4.DgramLen))
+	if coverage == 0 || coverage > len(data) {
+		coverage = len(data)
+	}
+
+	sum := uint32(udpLiteNumber) + uint32(len(data))
+	if ipv6 {
+		l3 := pkt.GetIPv6NoCheck()
+		sum += sumBytes(l3.SrcAddr[:]) + sumBytes(l3.DstAddr[:])
+	} else {
+		setIPv4HdrChecksum(pkt, hWTXChecksum)
+		l3 := pkt.GetIPv4NoCheck()
+		src := packet.SwapBytesIPv4Addr(l3.SrcAddr)
+		dst := packet.SwapBytesIPv4Addr(l3.DstAddr)
+		sum += uint32(src>>16) + uint32(src&0xffff) + uint32(dst>>16) + uint32(dst&0xffff)
+	}
+	l4.DgramCksum = 0
+	sum += sumBytes(data[:coverage])
+	sum = (sum & 0xffff) + (sum >> 16)
+	sum = (sum & 0xffff) + (sum >> 16)
+	cksum := ^uint16(sum)
+	// Zero checksum is not allowed in UDP-Lite
+	if cksum == 0 {
+		cksum = 0xffff
+	}
+	l4.DgramCksum = packet.SwapBytesUint16(cksum)
+}
@@ -11,7 +11,9 @@ import (
 	"net"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/intel-go/nff-go/flow"
@@ -21,27 +23,54 @@ import (
 	upd "github.com/intel-go/nff-go-nat/updatecfg"
 )
 
-type terminationDirection uint8
+type trafficDirection uint8
 type interfaceType int
+type natBehavior uint8
+type portAllocation uint8
+type ipv6Translation uint8
 
 const (
-	pri2pub terminationDirection = 0x0f
-	pub2pri terminationDirection = 0xf0
+	pri2pub trafficDirection = 0
+	pub2pri trafficDirection = 1
 
 	iPUBLIC  interfaceType = 0
 	iPRIVATE interfaceType = 1
 
+	// Mapping and filtering behavior according to RFC 4787
+	endpointIndependent     natBehavior = 0
+	addressDependent        natBehavior = 1
+	addressAndPortDependent natBehavior = 2
+
+	// Public port allocation algorithms according to RFC 6056
+	allocSequential       portAllocation = 0
+	allocRandom           portAllocation = 1
+	allocRandomPreserving portAllocation = 2
+
+	// IPv6 translation modes, stateful NAPT or stateless prefix
+	// translation according to RFC 6296
+	ipv6NAPT ipv6Translation = 0
+	ipv6NPT  ipv6Translation = 1
+
 	DirDROP = uint(upd.TraceType_DUMP_DROP)
 	DirSEND = uint(upd.TraceType_DUMP_TRANSLATE)
 	DirKNI  = uint(upd.TraceType_DUMP_KNI)
-
-	connectionTimeout time.Duration = 1 * time.Minute
-	portReuseTimeout  time.Duration = 1 * time.Second
+	// Packets which are sent back to the port they came from
+	dirHairpin = DirKNI + 1
+
+	// Default session timeouts according to RFC 5382 REQ-5, RFC
+	// 4787 REQ-5 and RFC 5508 REQ-1
+	defaultTCPEstablishedTimeout time.Duration = 2*time.Hour + 4*time.Minute
+	defaultTCPTransitoryTimeout  time.Duration = 4 * time.Minute
+	defaultUDPTimeout            time.Duration = 5 * time.Minute
+	defaultICMPTimeout           time.Duration = 60 * time.Second
+	// Default timeouts of closed TCP connections, same as in Linux
+	// conntrack
+	defaultTCPTimeWaitTimeout time.Duration = 2 * time.Minute
+	defaultTCPCloseTimeout    time.Duration = 10 * time.Second
 )
 
 var (
-	zeroIPv6Addr             = types.IPv6Address{}
-	portReuseSetLastusedTime = time.Duration(portReuseTimeout - connectionTimeout)
+	zeroIPv6Addr = types.IPv6Address{}
 )
 
 type hostPort struct {
@@ -56,8 +85,12 @@ type protocolId struct {
 	ipv6 bool
 }
 
+// Forwarded port or range of ports from Port to LastPort. Range is
+// mapped to destination range of the same size which starts from
+// destination port.
 type forwardedPort struct {
 	Port        uint16     `json:"port"`
+	LastPort    uint16     `json:"last-port"`
 	Destination hostPort   `json:"destination"`
 	Protocol    protocolId `json:"protocol"`
 }
@@ -96,6 +129,186 @@ func (out *protocolId) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+var natBehaviorLookup map[string]natBehavior = map[string]natBehavior{
+	"endpoint-independent":       endpointIndependent,
+	"address-dependent":          addressDependent,
+	"address-and-port-dependent": addressAndPortDependent,
+}
+
+func (out *natBehavior) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	result, ok := natBehaviorLookup[s]
+	if !ok {
+		return errors.New("Bad mapping or filtering behavior: " + s)
+	}
+
+	*out = result
+	return nil
+}
+
+func (behavior natBehavior) String() string {
+	for name, b := range natBehaviorLookup {
+		if b == behavior {
+			return name
+		}
+	}
+	return "unknown(" + strconv.Itoa(int(behavior)) + ")"
+}
+
+var portAllocationLookup map[string]portAllocation = map[string]portAllocation{
+	"sequential":        allocSequential,
+	"random":            allocRandom,
+	"random-preserving": allocRandomPreserving,
+}
+
+func (out *portAllocation) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	result, ok := portAllocationLookup[s]
+	if !ok {
+		return errors.New("Bad port allocation algorithm: " + s)
+	}
+
+	*out = result
+	return nil
+}
+
+func (alloc portAllocation) String() string {
+	for name, a := range portAllocationLookup {
+		if a == alloc {
+			return name
+		}
+	}
+	return "unknown(" + strconv.Itoa(int(alloc)) + ")"
+}
+
+var ipv6TranslationLookup map[string]ipv6Translation = map[string]ipv6Translation{
+	"napt":  ipv6NAPT,
+	"nptv6": ipv6NPT,
+}
+
+func (out *ipv6Translation) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	result, ok := ipv6TranslationLookup[s]
+	if !ok {
+		return errors.New("Bad IPv6 translation mode: " + s)
+	}
+
+	*out = result
+	return nil
+}
+
+func (mode ipv6Translation) String() string {
+	for name, m := range ipv6TranslationLookup {
+		if m == mode {
+			return name
+		}
+	}
+	return "unknown(" + strconv.Itoa(int(mode)) + ")"
+}
+
+// Idle timeouts of translation sessions
+type sessionTimeouts struct {
+	TCPEstablished time.Duration
+	TCPTransitory  time.Duration
+	// Timeouts of TCP connection states other than established. Zero
+	// value means that transitory timeout is used in this state.
+	TCPStates [tcpStateCount]time.Duration
+	UDP       time.Duration
+	ICMP      time.Duration
+}
+
+// Returns idle timeout of TCP connection in given state.
+func (t *sessionTimeouts) tcpTimeout(state tcpState) time.Duration {
+	if state == tcpEstablished {
+		return t.TCPEstablished
+	}
+	if state < tcpStateCount && t.TCPStates[state] != 0 {
+		return t.TCPStates[state]
+	}
+	return t.TCPTransitory
+}
+
+// Returns name of TCP state timeout, e.g. tcp-syn-sent.
+func tcpStateTimeoutName(state tcpState) string {
+	return "tcp-" + strings.Replace(strings.ToLower(state.String()), "_", "-", -1)
+}
+
+// Returns session timeouts which are in use.
+func (c *Config) getTimeouts() *sessionTimeouts {
+	return c.timeouts.Load().(*sessionTimeouts)
+}
+
+// Applies function to a copy of session timeouts which are in use
+// and replaces them with it. Returns new timeouts.
+func (c *Config) changeTimeouts(change func(t *sessionTimeouts)) *sessionTimeouts {
+	c.timeoutsMutex.Lock()
+	defer c.timeoutsMutex.Unlock()
+	t := *c.getTimeouts()
+	change(&t)
+	c.timeouts.Store(&t)
+	return &t
+}
+
+func (t *sessionTimeouts) String() string {
+	res := fmt.Sprintf("TCP established: %v, TCP transitory: %v", t.TCPEstablished, t.TCPTransitory)
+	for state := range t.TCPStates {
+		if t.TCPStates[state] != 0 {
+			res += fmt.Sprintf(", TCP %v: %v", tcpState(state), t.TCPStates[state])
+		}
+	}
+	return res + fmt.Sprintf(", UDP: %v, ICMP: %v", t.UDP, t.ICMP)
+}
+
+// UnmarshalJSON parses session timeouts in a form of Go duration
+// strings, e.g. "2h4m" or "30s". Timeouts which are not specified
+// keep their default values. Timeouts of TCP states other than
+// established are named after states, e.g. "tcp-time-wait".
+func (out *sessionTimeouts) UnmarshalJSON(b []byte) error {
+	var m map[string]string
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+
+	fields := map[string]*time.Duration{
+		"tcp-established": &out.TCPEstablished,
+		"tcp-transitory":  &out.TCPTransitory,
+		"udp":             &out.UDP,
+		"icmp":            &out.ICMP,
+	}
+	for state := tcpSynSent; state < tcpStateCount; state++ {
+		if state != tcpEstablished {
+			fields[tcpStateTimeoutName(state)] = &out.TCPStates[state]
+		}
+	}
+	for name, value := range m {
+		field, ok := fields[name]
+		if !ok {
+			return errors.New("Bad session timeout name: " + name)
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		if d <= 0 {
+			return fmt.Errorf("Session timeout %s should be positive while it is %v", name, d)
+		}
+		*field = d
+	}
+	return nil
+}
+
 type ipv4Subnet struct {
 	Addr            types.IPv4Address
 	Mask            types.IPv4Address
@@ -105,8 +318,9 @@ type ipv4Subnet struct {
 }
 
 func (fp *forwardedPort) String() string {
-	return fmt.Sprintf("Port:%d, Destination IPv4: %v, Destination IPv6: %v, Protocol: %d",
+	return fmt.Sprintf("Port:%d-%d, Destination IPv4: %v, Destination IPv6: %v, Protocol: %d",
 		fp.Port,
+		fp.Port+uint16(fp.count()-1),
 		fp.Destination.Addr4.String(),
 		fp.Destination.Addr6.String(),
 		fp.Protocol)
@@ -170,11 +384,88 @@ func (subnet *ipv6Subnet) checkAddrWithingSubnet(addr types.IPv6Address) bool {
 	return subnet.andMask(addr) == subnet.andMask(subnet.Addr)
 }
 
+// Additional public addresses which are used for translation
+// together with public port own address.
+type addressPool struct {
+	addrs  []types.IPv4Address
+	addrs6 []types.IPv6Address
+	// Lookup from address to its position in addrs and addrs6
+	index  map[types.IPv4Address]int
+	index6 map[types.IPv6Address]int
+	// Solicited node multicast addresses of addrs6
+	multicast6 map[types.IPv6Address]bool
+}
+
+func (pool *addressPool) String() string {
+	str := ""
+	for _, a := range pool.addrs {
+		str += StringIPv4Int(uint32(a)) + " "
+	}
+	for _, a := range pool.addrs6 {
+		str += a.String() + " "
+	}
+	return str
+}
+
+func (pool *addressPool) contains(addr types.IPv4Address) bool {
+	_, ok := pool.index[addr]
+	return ok
+}
+
+func (pool *addressPool) contains6(addr types.IPv6Address) bool {
+	_, ok := pool.index6[addr]
+	return ok
+}
+
+// Public address used for translation with all ports allocated on
+// it.
+type poolAddress struct {
+	// Map of allocated IP ports for every protocol
+	portmap [][]portMapEntry
+	// Ports which are free for dynamic allocation for every protocol
+	free []portSet
+	// Locks of port map and free ports of every protocol. Ports are
+	// allocated and released only under these locks, so handlers
+	// which use different public addresses or protocols don't wait
+	// for each other.
+	mutex []sync.Mutex
+}
+
 type portMapEntry struct {
-	lastused             time.Time
-	finCount             uint8
-	terminationDirection terminationDirection
-	static               bool
+	// Lookup keys of public and private sides of session
+	pub, priv sessionKey
+	created   time.Time
+	// Time of the last translated packet in Unix nanoseconds. It is
+	// updated by packet handlers and read by session reaper, so it
+	// is accessed atomically.
+	lastused int64
+	// TCP connection state and direction of the first FIN segment
+	// packed by packTCPStatus, so that they are changed together
+	tcpStatus uint32
+	// Last acknowledgement numbers seen in every direction and
+	// whether they were seen. They are used to validate RST
+	// segments. All TCP fields are accessed atomically because
+	// handlers of both directions track the same connection.
+	tcpAck     [2]uint32
+	tcpAckSeen [2]uint32
+	static     bool
+	// State which only some sessions need. It is allocated
+	// separately to keep port maps small and is accessed atomically
+	// because it may be added by handlers of both directions.
+	extra *sessionExtra
+}
+
+type sessionExtra struct {
+	// Remote endpoints which private host has sent packets to. It is
+	// allocated only when filtering behavior is not endpoint
+	// independent.
+	remotes *sync.Map
+	// TCP connections which payload is changed by application
+	// level gateways, indexed by remote endpoint
+	alg *sync.Map
+	// Inbound lookup keys of address-only session which point to
+	// it in public port translation table
+	addrOnlyKeys []sessionKey
 }
 
 // Type describing a network port
@@ -183,21 +474,34 @@ type ipPort struct {
 	Subnet        ipv4Subnet       `json:"subnet"`
 	Subnet6       ipv6Subnet       `json:"subnet6"`
 	Vlan          uint16           `json:"vlan-tag"`
+	MTU           uint16           `json:"mtu"`
 	KNIName       string           `json:"kni-name"`
 	ForwardPorts  []forwardedPort  `json:"forward-ports"`
 	DstMACAddress types.MACAddress `json:"dst-mac"`
+	AddressPool   addressPool      `json:"address-pool"`
+	PortBlocks    portBlocks       `json:"port-blocks"`
 	staticArpMode bool
 	SrcMACAddress types.MACAddress
 	Type          interfaceType
 	// Pointer to an opposite port in a pair
 	opposite *ipPort
-	// Map of allocated IP ports on public interface
-	portmap  [][]portMapEntry
-	portmap6 [][]portMapEntry
+	// Addresses used for translation on public interface. First
+	// element corresponds to port own address, others correspond to
+	// address pool entries.
+	pool  []poolAddress
+	pool6 []poolAddress
 	// Main lookup table which contains entries for packets coming at this port
-	translationTable []*sync.Map
+	translationTable []*sessionTable
 	// ARP lookup table
 	arpTable sync.Map
+	// Static one-to-one mappings. Keys are addresses on this port
+	// side, values are addresses on opposite port side.
+	staticMappings sync.Map
+	// Solicited-node multicast addresses of IPv6 static mappings
+	// public addresses
+	staticMulticast6 sync.Map
+	// Translations of fragmented packets
+	fragments fragmentCache
 	// Debug dump stuff
 	fdump    [DirKNI + 1]*os.File
 	dumpsync [DirKNI + 1]sync.Mutex
@@ -207,23 +511,82 @@ type ipPort struct {
 type portPair struct {
 	PrivatePort ipPort `json:"private-port"`
 	PublicPort  ipPort `json:"public-port"`
-	// Synchronization point for lookup table modifications
+	// Which remote endpoints share one public address and port
+	MappingBehavior natBehavior `json:"mapping-behavior"`
+	// Which remote endpoints may send packets to a mapping
+	FilteringBehavior natBehavior `json:"filtering-behavior"`
+	// How public ports are chosen for new mappings
+	PortAllocation portAllocation `json:"port-allocation"`
+	// Maximum numbers of sessions of every private host
+	SessionLimits sessionLimits `json:"session-limits"`
+	// Prefix of IPv6 addresses which are translated to IPv4 public
+	// network
+	NAT64Prefix nat64Prefix `json:"nat64-prefix"`
+	// Whether IPv6 traffic is translated with NAPT or with stateless
+	// prefix translation between private and public subnet6
+	IPv6Translation ipv6Translation `json:"ipv6-translation"`
+	// Public addresses which are translated to private addresses
+	// for all protocols in both directions
+	StaticMappings []staticMapping `json:"static-mappings"`
+	// Whether FTP control connections are translated by FTP
+	// application level gateway
+	FTPALG bool `json:"ftp-alg"`
+	// Whether SIP signalling is translated by SIP application level
+	// gateway
+	SIPALG bool `json:"sip-alg"`
+	// Media stream mappings of SIP dialogs indexed by Call-ID
+	sipDialogs sync.Map
+	// Current numbers of sessions of private hosts
+	sessions sessionCounters
+	// Number of expired sessions deleted by background reaper
+	reclaimedSessions uint64
+	// Packet counters of running handler instances and totals of
+	// stopped ones
+	counters        []*handlerCounters
+	retiredCounters handlerCounters
+	countersMutex   sync.Mutex
+	// Number of sessions which were not created because of lack of
+	// free ports or lookup table space
+	allocFailures uint64
+	// Synchronization point for configuration changes
 	mutex sync.Mutex
-	// Port that was allocated last
-	lastport int
 }
 
 // Config for NAT.
 type Config struct {
-	HostName             string     `json:"host-name"`
-	PortPairs            []portPair `json:"port-pairs"`
+	HostName             string          `json:"host-name"`
+	PortPairs            []portPair      `json:"port-pairs"`
+	Timeouts             sessionTimeouts `json:"session-timeouts"`
 	setKniIP             bool
 	bringUpKniInterfaces bool
+	// Maximum number of entries of every translation table
+	SessionTableSize int `json:"session-table-size"`
+	// How often expired sessions are deleted in background
+	ReapInterval reapInterval `json:"session-reap-interval"`
+	// Address of HTTP server which exports metrics, empty address
+	// disables it
+	MetricsAddress string `json:"metrics-address"`
+	// Address of IPFIX collector which receives NAT events, empty
+	// address disables export
+	IPFIXCollector string `json:"ipfix-collector"`
+	// IPFIX observation domain ID of NAT
+	IPFIXDomainID uint32 `json:"ipfix-domain-id"`
+	// Syslog server and local files which receive translation log
+	TranslationLog translationLogConfig `json:"translation-log"`
+	// Local store of finished sessions for reverse lookups
+	History historyConfig `json:"history"`
+	// Session timeouts which are in use. They are replaced as a
+	// whole when changed at run time, so that packet handlers don't
+	// see partially updated ones.
+	timeouts      atomic.Value
+	timeoutsMutex sync.Mutex
 }
 
 // Type used to pass handler index to translation functions.
 type pairIndex struct {
 	index int
+	// Counters of handler instance which has this context
+	counters *handlerCounters
 }
 
 var (
@@ -240,15 +603,22 @@ var (
 
 	// Debug variables
 	DumpEnabled [DirKNI + 1]bool
+	// DropReasonComments is a flag whether dropped packets are dumped
+	// in pcapng format with their drop reasons in packet comments.
+	DropReasonComments bool
 )
 
 func (pi pairIndex) Copy() interface{} {
 	return pairIndex{
-		index: pi.index,
+		index:    pi.index,
+		counters: Natconfig.PortPairs[pi.index].newHandlerCounters(),
 	}
 }
 
 func (pi pairIndex) Delete() {
+	if pi.counters != nil {
+		Natconfig.PortPairs[pi.index].releaseHandlerCounters(pi.counters)
+	}
 }
 
 // Returns IPv4 address in little endian format. Needs swap before
@@ -334,6 +704,84 @@ func (out *ipv6Subnet) UnmarshalJSON(b []byte) error {
 	return errors.New("Failed to parse address " + s)
 }
 
+// UnmarshalJSON parses a list of public addresses. Every element may
+// be either a single IPv4 or IPv6 address or a subnet in CIDR form in
+// which case all addresses of the subnet are added to the pool.
+func (out *addressPool) UnmarshalJSON(b []byte) error {
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+
+	out.index = make(map[types.IPv4Address]int)
+	out.index6 = make(map[types.IPv6Address]int)
+	out.multicast6 = make(map[types.IPv6Address]bool)
+	for _, s := range list {
+		var ip net.IP
+		var ipnet *net.IPNet
+		var err error
+		if ip, ipnet, err = net.ParseCIDR(s); err != nil {
+			ip = net.ParseIP(s)
+			if ip == nil {
+				return errors.New("Failed to parse address pool entry " + s)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				bits = 8 * net.IPv4len
+			}
+			ipnet = &net.IPNet{
+				IP:   ip,
+				Mask: net.CIDRMask(bits, bits),
+			}
+		}
+
+		ones, bits := ipnet.Mask.Size()
+		if bits-ones > maxPoolSubnetBits {
+			return fmt.Errorf("Address pool subnet %s is too large, at most %d addresses are allowed in one entry", s, 1<<maxPoolSubnetBits)
+		}
+
+		if ip4 := ipnet.IP.To4(); ip4 != nil {
+			first, err := convertIPv4(ip4)
+			if err != nil {
+				return err
+			}
+			for i := 0; i < 1<<uint(bits-ones); i++ {
+				addr := first + types.IPv4Address(i)
+				if _, ok := out.index[addr]; ok {
+					return errors.New("Duplicate address " + StringIPv4Int(uint32(addr)) + " in address pool")
+				}
+				out.index[addr] = len(out.addrs)
+				out.addrs = append(out.addrs, addr)
+			}
+		} else {
+			var first types.IPv6Address
+			copy(first[:], ipnet.IP.To16())
+			for i := 0; i < 1<<uint(bits-ones); i++ {
+				addr := first
+				carry := i
+				for j := types.IPv6AddrLen - 1; j >= 0 && carry != 0; j-- {
+					carry += int(addr[j])
+					addr[j] = uint8(carry)
+					carry >>= 8
+				}
+				if _, ok := out.index6[addr]; ok {
+					return errors.New("Duplicate address " + addr.String() + " in address pool")
+				}
+				out.index6[addr] = len(out.addrs6)
+				out.addrs6 = append(out.addrs6, addr)
+
+				var multicast types.IPv6Address
+				packet.CalculateIPv6MulticastAddrForDstIP(&multicast, addr)
+				out.multicast6[multicast] = true
+			}
+		}
+		if len(out.addrs) > maxPoolAddresses || len(out.addrs6) > maxPoolAddresses {
+			return fmt.Errorf("Address pool may contain at most %d addresses of every IP version", maxPoolAddresses)
+		}
+	}
+	return nil
+}
+
 // UnmarshalJSON parses ipv4 host:port string. Port may be omitted and
 // is set to zero in this case.
 func (out *hostPort) UnmarshalJSON(b []byte) error {
@@ -382,10 +830,48 @@ func ReadConfig(fileName string, setKniIP, bringUpKniInterfaces bool) error {
 	}
 	decoder := json.NewDecoder(file)
 
-	err = decoder.Decode(&Natconfig)
+	Natconfig = &Config{
+		Timeouts: sessionTimeouts{
+			TCPEstablished: defaultTCPEstablishedTimeout,
+			TCPTransitory:  defaultTCPTransitoryTimeout,
+			UDP:            defaultUDPTimeout,
+			ICMP:           defaultICMPTimeout,
+		},
+		SessionTableSize: defaultSessionTableSize,
+		ReapInterval:     defaultReapInterval,
+		History: historyConfig{
+			Retention: defaultHistoryRetention,
+		},
+	}
+	Natconfig.Timeouts.TCPStates[tcpTimeWait] = defaultTCPTimeWaitTimeout
+	Natconfig.Timeouts.TCPStates[tcpClose] = defaultTCPCloseTimeout
+	err = decoder.Decode(Natconfig)
 	if err != nil {
 		return err
 	}
+	timeouts := Natconfig.Timeouts
+	Natconfig.timeouts.Store(&timeouts)
+	fmt.Println("Using session timeouts", timeouts.String())
+	if Natconfig.SessionTableSize <= 0 {
+		return fmt.Errorf("Bad session table size %d", Natconfig.SessionTableSize)
+	}
+	fmt.Println("Using session table size", Natconfig.SessionTableSize)
+	if Natconfig.ReapInterval != 0 {
+		fmt.Println("Using session reap interval", time.Duration(Natconfig.ReapInterval))
+	}
+	if Natconfig.IPFIXCollector != "" {
+		fmt.Println("Using IPFIX collector", Natconfig.IPFIXCollector, "with observation domain ID", Natconfig.IPFIXDomainID)
+	}
+	if Natconfig.TranslationLog.enabled() {
+		if err := Natconfig.TranslationLog.check(); err != nil {
+			return err
+		}
+		fmt.Println("Using translation log", Natconfig.TranslationLog.String())
+	}
+	if Natconfig.History.Directory != "" {
+		fmt.Println("Using translation history directory", Natconfig.History.Directory,
+			"with retention", time.Duration(Natconfig.History.Retention))
+	}
 
 	if setKniIP {
 		Natconfig.setKniIP = true
@@ -416,6 +902,40 @@ func ReadConfig(fileName string, setKniIP, bringUpKniInterfaces bool) error {
 				" has zero vlan tag. Transition between VLAN-enabled and VLAN-disabled networks is not supported yet.")
 		}
 
+		if pp.MappingBehavior != endpointIndependent || pp.FilteringBehavior != endpointIndependent {
+			fmt.Printf("Using %s mapping and %s filtering for port pair %d\n",
+				pp.MappingBehavior, pp.FilteringBehavior, i)
+		}
+		if pp.PortAllocation != allocSequential {
+			fmt.Printf("Using %s port allocation for port pair %d\n", pp.PortAllocation, i)
+		}
+		if pp.NAT64Prefix.enabled() {
+			if pp.PublicPort.PortBlocks.enabled() {
+				return fmt.Errorf("NAT64 prefix cannot be used together with port blocks for port pair %d", i)
+			}
+			fmt.Printf("Using NAT64 prefix %s for port pair %d\n", pp.NAT64Prefix.String(), i)
+		}
+		if pp.IPv6Translation == ipv6NPT {
+			if pp.PrivatePort.Subnet6.addressAcquired && pp.PublicPort.Subnet6.addressAcquired &&
+				pp.PrivatePort.Subnet6.Mask != pp.PublicPort.Subnet6.Mask {
+				return fmt.Errorf("NPTv6 requires private and public IPv6 subnets of port pair %d to have equal prefix lengths", i)
+			}
+			fmt.Printf("Using NPTv6 prefix translation for port pair %d\n", i)
+		}
+		if pp.SessionLimits.enabled() {
+			fmt.Printf("Using session limits %s for port pair %d\n", pp.SessionLimits.String(), i)
+		}
+		if pp.FTPALG {
+			fmt.Printf("Using FTP ALG for port pair %d\n", i)
+		}
+		if pp.SIPALG {
+			fmt.Printf("Using SIP ALG for port pair %d\n", i)
+		}
+
+		if (pp.PrivatePort.MTU != 0 && pp.PrivatePort.MTU < ipv6MinMTU) || (pp.PublicPort.MTU != 0 && pp.PublicPort.MTU < ipv6MinMTU) {
+			return fmt.Errorf("MTU of port pair %d ports should not be less than %d", i, ipv6MinMTU)
+		}
+
 		if (pp.PrivatePort.Vlan != 0 && pp.PrivatePort.KNIName != "") || (pp.PrivatePort.Vlan != 0 && pp.PrivatePort.KNIName != "") {
 			return fmt.Errorf("Using VLANs together with KNI is not supported yet.")
 		}
@@ -440,6 +960,16 @@ func ReadConfig(fileName string, setKniIP, bringUpKniInterfaces bool) error {
 					return err
 				}
 			}
+			if port.Type == iPRIVATE && (len(port.AddressPool.addrs) != 0 || len(port.AddressPool.addrs6) != 0) {
+				return fmt.Errorf("Address pool may be specified only for public port while it is set for private port %d", port.Index)
+			}
+			if port.AddressPool.contains(port.Subnet.Addr) || port.AddressPool.contains6(port.Subnet6.Addr) {
+				return fmt.Errorf("Address pool of port %d should not contain port own address", port.Index)
+			}
+			if err := port.PortBlocks.check(port); err != nil {
+				return err
+			}
+
 			if port.DstMACAddress != (types.MACAddress{}) {
 				port.staticArpMode = true
 				fmt.Printf("Activating static ARP mode for port %d, using %s MAC address\n",
@@ -447,15 +977,38 @@ func ReadConfig(fileName string, setKniIP, bringUpKniInterfaces bool) error {
 			}
 			port = &pp.PublicPort
 		}
+
+		for smi := range pp.StaticMappings {
+			sm := &pp.StaticMappings[smi]
+			if err := pp.enableStaticMapping(sm); err != nil {
+				return err
+			}
+			fmt.Printf("Using static mapping %s for port pair %d\n", sm.String(), i)
+		}
 	}
 
 	return nil
 }
 
+// Returns number of forwarded ports
+func (fp *forwardedPort) count() int {
+	if fp.LastPort <= fp.Port {
+		return 1
+	}
+	return int(fp.LastPort) - int(fp.Port) + 1
+}
+
 func (port *ipPort) checkPortForwarding(fp *forwardedPort) error {
 	if fp.Destination.ipv6 != fp.Protocol.ipv6 {
 		return fmt.Errorf("Port forwarding protocol should be TCP or UDP for IPv4 addresses and TCP6 or UDP6 for IPv6 addresses")
 	}
+	// Zero last port means that single port is forwarded
+	if fp.LastPort == 0 {
+		fp.LastPort = fp.Port
+	}
+	if fp.LastPort < fp.Port {
+		return fmt.Errorf("Last forwarded port %d should not be less than first forwarded port %d", fp.LastPort, fp.Port)
+	}
 
 	var isAddrZero bool
 	if fp.Destination.ipv6 {
@@ -500,6 +1053,9 @@ func (port *ipPort) checkPortForwarding(fp *forwardedPort) error {
 		if fp.Destination.Port == 0 {
 			fp.Destination.Port = fp.Port
 		}
+		if int(fp.Destination.Port)+fp.count()-1 > int(^uint16(0)) {
+			return fmt.Errorf("Destination port range starting from %d is too small for %d forwarded ports", fp.Destination.Port, fp.count())
+		}
 	}
 	return nil
 }
@@ -510,6 +1066,14 @@ func (pp *portPair) initLocalMACs() {
 	pp.PrivatePort.SrcMACAddress = flow.GetPortMACAddress(pp.PrivatePort.Index)
 }
 
+// Returns MTU of port, which is Ethernet MTU unless it is configured.
+func (port *ipPort) getMTU() uint {
+	if port.MTU == 0 {
+		return defaultMTU
+	}
+	return uint(port.MTU)
+}
+
 func (port *ipPort) initIPv6LLAddresses() {
 	packet.CalculateIPv6LinkLocalAddrForMAC(&port.Subnet6.llAddr, port.SrcMACAddress)
 	println("Configured link local address", port.Subnet6.llAddr.String(), "for port", port.Index)
@@ -522,20 +1086,45 @@ func (port *ipPort) initIPv6LLAddresses() {
 }
 
 func (port *ipPort) allocatePublicPortPortMap() {
-	port.portmap = make([][]portMapEntry, 256)
-	port.portmap[types.ICMPNumber] = make([]portMapEntry, portEnd)
-	port.portmap[types.TCPNumber] = make([]portMapEntry, portEnd)
-	port.portmap[types.UDPNumber] = make([]portMapEntry, portEnd)
-	port.portmap6 = make([][]portMapEntry, 256)
-	port.portmap6[types.TCPNumber] = make([]portMapEntry, portEnd)
-	port.portmap6[types.UDPNumber] = make([]portMapEntry, portEnd)
-	port.portmap6[types.ICMPv6Number] = make([]portMapEntry, portEnd)
+	port.pool = make([]poolAddress, len(port.AddressPool.addrs)+1)
+	for i := range port.pool {
+		pa := &port.pool[i]
+		pa.portmap = make([][]portMapEntry, 256)
+		pa.portmap[types.ICMPNumber] = make([]portMapEntry, portEnd)
+		pa.portmap[types.TCPNumber] = make([]portMapEntry, portEnd)
+		pa.portmap[types.UDPNumber] = make([]portMapEntry, portEnd)
+		pa.free = make([]portSet, 256)
+		pa.free[types.ICMPNumber] = newPortSet()
+		pa.free[types.TCPNumber] = newPortSet()
+		pa.free[types.UDPNumber] = newPortSet()
+		pa.mutex = make([]sync.Mutex, 256)
+	}
+	port.pool6 = make([]poolAddress, len(port.AddressPool.addrs6)+1)
+	for i := range port.pool6 {
+		pa := &port.pool6[i]
+		pa.portmap = make([][]portMapEntry, 256)
+		pa.portmap[types.TCPNumber] = make([]portMapEntry, portEnd)
+		pa.portmap[types.UDPNumber] = make([]portMapEntry, portEnd)
+		pa.portmap[types.ICMPv6Number] = make([]portMapEntry, portEnd)
+		pa.free = make([]portSet, 256)
+		pa.free[types.TCPNumber] = newPortSet()
+		pa.free[types.UDPNumber] = newPortSet()
+		pa.free[types.ICMPv6Number] = newPortSet()
+		pa.mutex = make([]sync.Mutex, 256)
+	}
+	if len(port.AddressPool.addrs) != 0 || len(port.AddressPool.addrs6) != 0 {
+		fmt.Println("Using address pool", port.AddressPool.String(), "on port", port.Index)
+	}
+	if port.PortBlocks.enabled() {
+		port.PortBlocks.init(len(port.pool))
+		fmt.Println("Using port blocks", port.PortBlocks.String(), "on port", port.Index)
+	}
 }
 
 func (port *ipPort) allocateLookupMap() {
-	port.translationTable = make([]*sync.Map, 256)
+	port.translationTable = make([]*sessionTable, 256)
 	for i := range port.translationTable {
-		port.translationTable[i] = new(sync.Map)
+		port.translationTable[i] = newSessionTable(Natconfig.SessionTableSize)
 	}
 }
 
@@ -546,57 +1135,60 @@ func (port *ipPort) initPortPortForwardingEntries() {
 	}
 }
 
+// Adds lookup entries for every forwarded port. Port map and free
+// ports set of public port are looked up once for the whole range.
 func (port *ipPort) enableStaticPortForward(fp *forwardedPort) {
-	if fp.Protocol.ipv6 {
-		keyEntry := Tuple6{
-			addr: port.Subnet6.Addr,
-			port: fp.Port,
-		}
-		valEntry := Tuple6{
-			addr: fp.Destination.Addr6,
-			port: fp.Destination.Port,
-		}
-		port.translationTable[fp.Protocol.id].Store(keyEntry, valEntry)
-		if fp.Destination.Addr6 != zeroIPv6Addr {
-			port.opposite.translationTable[fp.Protocol.id].Store(valEntry, keyEntry)
-		}
-		if port.Type == iPUBLIC {
-			port.getPortmap(fp.Protocol.ipv6, fp.Protocol.id)[fp.Port] = portMapEntry{
-				lastused:             time.Now(),
-				finCount:             0,
-				terminationDirection: 0,
-				static:               true,
-			}
+	ipv6 := fp.Protocol.ipv6
+	protocol := fp.Protocol.id
+	var portmap []portMapEntry
+	var free *portSet
+	if port.Type == iPUBLIC {
+		portmap, free = port.getPoolAddress(ipv6, 0).protocolPorts(protocol)
+	}
+	now := time.Now()
+
+	for i := 0; i < fp.count(); i++ {
+		pubPort := fp.Port + uint16(i)
+		var keyEntry, valEntry sessionKey
+		var zeroAddr bool
+		if ipv6 {
+			keyEntry = makeTuple(true, 0, port.Subnet6.Addr, pubPort)
+			valEntry = makeTuple(true, 0, fp.Destination.Addr6, fp.Destination.Port+uint16(i))
+			zeroAddr = fp.Destination.Addr6 == zeroIPv6Addr
+		} else {
+			keyEntry = makeTuple(false, port.Subnet.Addr, zeroIPv6Addr, pubPort)
+			valEntry = makeTuple(false, fp.Destination.Addr4, zeroIPv6Addr, fp.Destination.Port+uint16(i))
+			zeroAddr = fp.Destination.Addr4 == 0
 		}
-	} else {
-		keyEntry := Tuple{
-			addr: port.Subnet.Addr,
-			port: fp.Port,
-		}
-		valEntry := Tuple{
-			addr: fp.Destination.Addr4,
-			port: fp.Destination.Port,
-		}
-		port.translationTable[fp.Protocol.id].Store(keyEntry, valEntry)
-		if fp.Destination.Addr4 != 0 {
-			port.opposite.translationTable[fp.Protocol.id].Store(valEntry, keyEntry)
-		}
-		if port.Type == iPUBLIC {
-			port.getPortmap(fp.Protocol.ipv6, fp.Protocol.id)[fp.Port] = portMapEntry{
-				lastused:             time.Now(),
-				finCount:             0,
-				terminationDirection: 0,
-				static:               true,
-			}
+		// Forwarded ports of private port have no port map, so
+		// their entries are allocated separately
+		pme := &portMapEntry{}
+		if portmap != nil {
+			pme = &portmap[pubPort]
+			free.remove(int(pubPort))
+		}
+		*pme = portMapEntry{
+			pub:      keyEntry,
+			priv:     valEntry,
+			created:  now,
+			lastused: now.UnixNano(),
+			static:   true,
+		}
+		if port.Type != iPUBLIC {
+			pme.pub, pme.priv = valEntry, keyEntry
+		}
+		port.translationTable[protocol].Store(keyEntry, pme)
+		if !zeroAddr {
+			port.opposite.translationTable[protocol].Store(valEntry, pme)
 		}
 	}
 }
 
-func (port *ipPort) getPortmap(ipv6 bool, protocol uint8) []portMapEntry {
+func (port *ipPort) getPortmap(ipv6 bool, index int, protocol uint8) []portMapEntry {
 	if ipv6 {
-		return port.portmap6[protocol]
+		return port.pool6[index].portmap[protocol]
 	} else {
-		return port.portmap[protocol]
+		return port.pool[index].portmap[protocol]
 	}
 }
 
@@ -612,7 +1204,6 @@ func InitFlows() {
 		pp.PrivatePort.allocateLookupMap()
 		pp.PublicPort.allocateLookupMap()
 		pp.PublicPort.allocatePublicPortPortMap()
-		pp.lastport = portStart
 		pp.PrivatePort.initPortPortForwardingEntries()
 		pp.PublicPort.initPortPortForwardingEntries()
 
@@ -623,7 +1214,7 @@ func InitFlows() {
 		var fromPubKNI, fromPrivKNI, toPub, toPriv *flow.Flow
 		var pubKNI, privKNI *flow.Kni
 		var outsPub = uint(2)
-		var outsPriv = uint(2)
+		var outsPriv = uint(dirHairpin + 1)
 
 		// Initialize public to private flow
 		publicToPrivate, err := flow.SetReceiver(pp.PublicPort.Index)
@@ -643,12 +1234,11 @@ func InitFlows() {
 			fromPubKNI = flow.SetReceiverKNI(pubKNI)
 		}
 
-		// Initialize private to public flow
+		// Initialize private to public flow. Private side always
+		// has KNI output because hairpinned packets output follows
+		// it.
 		privateToPublic, err := flow.SetReceiver(pp.PrivatePort.Index)
 		flow.CheckFatal(err)
-		if pp.PrivatePort.KNIName != "" {
-			outsPriv = 3
-		}
 		privTranslationOut, err := flow.SetSplitter(privateToPublic, PrivateToPublicTranslation, outsPriv, context)
 		flow.CheckFatal(err)
 		flow.CheckFatal(flow.SetStopper(privTranslationOut[DirDROP]))
@@ -659,6 +1249,8 @@ func InitFlows() {
 			flow.CheckFatal(err)
 			flow.CheckFatal(flow.SetSenderKNI(privTranslationOut[DirKNI], privKNI))
 			fromPrivKNI = flow.SetReceiverKNI(privKNI)
+		} else {
+			flow.CheckFatal(flow.SetStopper(privTranslationOut[DirKNI]))
 		}
 
 		// Merge traffic coming from public KNI with translated
@@ -671,12 +1263,14 @@ func InitFlows() {
 		}
 
 		// Merge traffic coming from private KNI with translated
-		// traffic from public side
+		// traffic from public side and hairpinned traffic from
+		// private side
 		if fromPrivKNI != nil {
-			toPriv, err = flow.SetMerger(fromPrivKNI, pubTranslationOut[DirSEND])
+			toPriv, err = flow.SetMerger(fromPrivKNI, pubTranslationOut[DirSEND], privTranslationOut[dirHairpin])
 			flow.CheckFatal(err)
 		} else {
-			toPriv = pubTranslationOut[DirSEND]
+			toPriv, err = flow.SetMerger(pubTranslationOut[DirSEND], privTranslationOut[dirHairpin])
+			flow.CheckFatal(err)
 		}
 
 		// Set senders to output packets
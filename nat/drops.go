@@ -0,0 +1,184 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"sync/atomic"
+
+	"github.com/intel-go/nff-go/packet"
+)
+
+// Reason why packet was dropped.
+type dropReason uint8
+
+const (
+	dropUnknown dropReason = iota
+	// Packet headers are truncated or inconsistent
+	dropMalformed
+	// Protocol is not supported for translation
+	dropUnsupportedProtocol
+	// No translation session or mapping for inbound packet
+	dropNoSession
+	// Packet from remote endpoint is not allowed by filtering
+	// behavior of mapping
+	dropFiltered
+	// Translation session has expired
+	dropSessionExpired
+	// Session was created for previous port address
+	dropAddressChanged
+	// Port has no IPv4 or IPv6 address yet
+	dropNoAddress
+	// MAC address of next hop is unknown, neighbor resolution is
+	// started instead
+	dropNeighborUnresolved
+	// No free public ports to create a session
+	dropPortsExhausted
+	// Private host has reached its session limit
+	dropSessionLimit
+	// Fragment which cannot be translated
+	dropFragment
+	// Fragment which is kept until the first fragment of its packet
+	// comes
+	dropFragmentBuffered
+	// Packet is not addressed to NAT or its subnets
+	dropNotForUs
+	// Packet was consumed by NAT itself, e.g. DHCP reply or
+	// answered ARP, Neighbor Discovery or ICMP echo request
+	dropHandledLocally
+	// Packet cannot be translated to another address family
+	dropTranslationFailed
+	// Packet is larger than MTU of outgoing port and cannot be
+	// fragmented, ICMP error is sent to its source instead
+	dropPacketTooBig
+	dropReasonCount
+)
+
+// Names of drop reasons in metric labels, replies and dump comments
+var dropReasonNames = [dropReasonCount]string{
+	dropUnknown:             "unknown",
+	dropMalformed:           "malformed",
+	dropUnsupportedProtocol: "unsupported-protocol",
+	dropNoSession:           "no-session",
+	dropFiltered:            "filtered",
+	dropSessionExpired:      "session-expired",
+	dropAddressChanged:      "address-changed",
+	dropNoAddress:           "no-address",
+	dropNeighborUnresolved:  "neighbor-unresolved",
+	dropPortsExhausted:      "ports-exhausted",
+	dropSessionLimit:        "session-limit",
+	dropFragment:            "fragment",
+	dropFragmentBuffered:    "fragment-buffered",
+	dropNotForUs:            "not-for-us",
+	dropHandledLocally:      "handled-locally",
+	dropTranslationFailed:   "translation-failed",
+	dropPacketTooBig:        "packet-too-big",
+}
+
+func (r dropReason) String() string {
+	if r < dropReasonCount {
+		return dropReasonNames[r]
+	}
+	return dropReasonNames[dropUnknown]
+}
+
+const (
+	// Handlers return drop reason in upper bits of DirDROP result,
+	// so that it reaches per handler counters. Splitters get only
+	// lower bits.
+	dropReasonShift = 8
+	dirMask         = 1<<dropReasonShift - 1
+)
+
+// Returns handler result for packet dropped because of reason.
+func dropResult(reason dropReason) uint {
+	return DirDROP | uint(reason)<<dropReasonShift
+}
+
+// Returns direction and drop reason of handler result.
+func splitResult(res uint) (uint, dropReason) {
+	return res & dirMask, dropReason(res >> dropReasonShift)
+}
+
+// Returns drop reason for packet which session could not be created
+// because of allocation error err.
+func allocationDropReason(err error) dropReason {
+	if err == errSessionLimit {
+		return dropSessionLimit
+	}
+	return dropPortsExhausted
+}
+
+// Dumps packet dropped because of reason and returns corresponding
+// handler result.
+func (port *ipPort) dropPacket(pkt *packet.Packet, reason dropReason) uint {
+	res := dropResult(reason)
+	port.dumpPacket(pkt, res)
+	return res
+}
+
+// Packet counters of one handler instance. Every instance updates
+// only its own counters, so atomic operations are not contended.
+// Counters are padded to cache line size to avoid false sharing.
+type handlerCounters struct {
+	packets [2][dirHairpin + 1]uint64
+	drops   [2][dropReasonCount]uint64
+	_       [64]byte
+}
+
+// Counts packet handled in traffic direction dir with result res and
+// returns direction for splitter.
+func (hc *handlerCounters) count(dir trafficDirection, res uint) uint {
+	out, reason := splitResult(res)
+	atomic.AddUint64(&hc.packets[dir][out], 1)
+	if out == DirDROP {
+		atomic.AddUint64(&hc.drops[dir][reason], 1)
+	}
+	return out
+}
+
+// Adds counters of hc to sum.
+func (hc *handlerCounters) addTo(sum *handlerCounters) {
+	for dir := range hc.packets {
+		for i := range hc.packets[dir] {
+			sum.packets[dir][i] += atomic.LoadUint64(&hc.packets[dir][i])
+		}
+		for i := range hc.drops[dir] {
+			sum.drops[dir][i] += atomic.LoadUint64(&hc.drops[dir][i])
+		}
+	}
+}
+
+// Creates counters for a new handler instance of port pair.
+func (pp *portPair) newHandlerCounters() *handlerCounters {
+	hc := new(handlerCounters)
+	pp.countersMutex.Lock()
+	pp.counters = append(pp.counters, hc)
+	pp.countersMutex.Unlock()
+	return hc
+}
+
+// Keeps counters of stopped handler instance in port pair totals.
+func (pp *portPair) releaseHandlerCounters(hc *handlerCounters) {
+	pp.countersMutex.Lock()
+	for i := range pp.counters {
+		if pp.counters[i] == hc {
+			hc.addTo(&pp.retiredCounters)
+			pp.counters = append(pp.counters[:i], pp.counters[i+1:]...)
+			break
+		}
+	}
+	pp.countersMutex.Unlock()
+}
+
+// Returns sum of counters of all handler instances of port pair.
+func (pp *portPair) getCounters() handlerCounters {
+	pp.countersMutex.Lock()
+	sum := pp.retiredCounters
+	for _, hc := range pp.counters {
+		hc.addTo(&sum)
+	}
+	pp.countersMutex.Unlock()
+	return sum
+}
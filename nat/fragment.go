@@ -0,0 +1,343 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/types"
+)
+
+const (
+	ipv6FragmentHeaderNumber = 44
+	ipv6FragmentHeaderLen    = 8
+
+	// Time during which translation of first fragment is applied to
+	// other fragments of the same packet
+	fragmentTimeout = 30 * time.Second
+	// Maximum number of fragmented packets which are tracked on
+	// every network port at the same time
+	maxFragmentEntries = 4096
+	// Maximum total size of fragments which are kept on every
+	// network port until first fragment of their packet arrives
+	maxPendingFragmentsSize = 1 << 20
+)
+
+type ipv6FragmentHdr struct {
+	NextHeader      uint8
+	Reserved        uint8
+	FragOffsetFlags uint16
+	Identification  uint32
+}
+
+// Fragments of the same packet have the same key
+type fragmentKey struct {
+	src4, dst4 types.IPv4Address
+	src6, dst6 types.IPv6Address
+	id         uint32
+	protocol   uint8
+}
+
+type fragmentInfo struct {
+	key   fragmentKey
+	first bool
+	last  bool
+}
+
+// Translation of a fragmented packet which is done for its first
+// fragment
+type fragmentTranslation struct {
+	// Fragments are translated to another IP version by NAT64
+	nat64      bool
+	dir        uint
+	out        *ipPort
+	srcMAC     types.MACAddress
+	dstMAC     types.MACAddress
+	src4, dst4 types.IPv4Address
+	src6, dst6 types.IPv6Address
+}
+
+type fragmentEntry struct {
+	created     time.Time
+	translated  bool
+	translation fragmentTranslation
+	// Copies of fragments which came before first fragment
+	pending [][]byte
+}
+
+// Entry of fragment cache in order of creation
+type fragmentRef struct {
+	key   fragmentKey
+	entry *fragmentEntry
+}
+
+type fragmentCache struct {
+	mutex   sync.Mutex
+	entries map[fragmentKey]*fragmentEntry
+	// Entries from the oldest to the newest. Entries which were
+	// already removed from map are skipped.
+	order       []fragmentRef
+	pendingSize int
+}
+
+// Checks whether packet is a fragment. IPv6 Fragment header is
+// recognized only when it immediately follows IPv6 header.
+func parseFragment(pktIPv4 *packet.IPv4Hdr, pktIPv6 *packet.IPv6Hdr) (fragmentInfo, bool) {
+	if pktIPv4 != nil {
+		fo := packet.SwapBytesUint16(pktIPv4.FragmentOffset)
+		if fo&0x3fff == 0 {
+			return fragmentInfo{}, false
+		}
+		return fragmentInfo{
+			key: fragmentKey{
+				src4:     pktIPv4.SrcAddr,
+				dst4:     pktIPv4.DstAddr,
+				id:       uint32(packet.SwapBytesUint16(pktIPv4.PacketID)),
+				protocol: pktIPv4.NextProtoID,
+			},
+			first: fo&0x1fff == 0,
+			last:  fo&0x2000 == 0,
+		}, true
+	}
+
+	if pktIPv6.Proto != ipv6FragmentHeaderNumber {
+		return fragmentInfo{}, false
+	}
+	hdr := (*ipv6FragmentHdr)(unsafe.Pointer(uintptr(unsafe.Pointer(pktIPv6)) + types.IPv6Len))
+	fo := packet.SwapBytesUint16(hdr.FragOffsetFlags)
+	return fragmentInfo{
+		key: fragmentKey{
+			src6:     pktIPv6.SrcAddr,
+			dst6:     pktIPv6.DstAddr,
+			id:       packet.SwapBytesUint32(hdr.Identification),
+			protocol: hdr.NextHeader,
+		},
+		first: fo>>3 == 0,
+		last:  fo&1 == 0,
+	}, true
+}
+
+// Returns partial packet view of first fragment so that its addresses
+// and ports can be changed. Packets of protocols other than TCP, UDP,
+// UDP-Lite, SCTP and ICMP have no ports and L4 checksum in this view.
+func newFirstFragment(pktIPv4 *packet.IPv4Hdr, pktIPv6 *packet.IPv6Hdr, protocol uint8,
+	pktTCP *packet.TCPHdr, pktUDP *packet.UDPHdr, pktICMP *packet.ICMPHdr) *partialPacket {
+	part := partialPacket{
+		ipv4:     pktIPv4,
+		ipv6:     pktIPv6,
+		protocol: protocol,
+	}
+	if pktTCP != nil {
+		part.srcPort = &pktTCP.SrcPort
+		part.dstPort = &pktTCP.DstPort
+		part.cksum = &pktTCP.Cksum
+	} else if pktUDP != nil {
+		part.srcPort = &pktUDP.SrcPort
+		part.dstPort = &pktUDP.DstPort
+		// SCTP checksum doesn't cover IP addresses and cannot be
+		// updated incrementally
+		if protocol != sctpNumber {
+			part.cksum = &pktUDP.DgramCksum
+		}
+	} else if pktICMP != nil {
+		part.srcPort = &pktICMP.Identifier
+		part.dstPort = &pktICMP.Identifier
+		part.cksum = &pktICMP.Cksum
+	}
+	return &part
+}
+
+// Returns fragment entry which is not expired. Should be called under
+// cache lock.
+func (fc *fragmentCache) get(key fragmentKey) *fragmentEntry {
+	entry, found := fc.entries[key]
+	if !found {
+		return nil
+	}
+	if time.Since(entry.created) > fragmentTimeout {
+		fc.remove(key, entry)
+		return nil
+	}
+	return entry
+}
+
+// Removes expired entries. Then removes the oldest entries until
+// there is room for a new entry and size bytes of pending
+// fragments. Should be called under cache lock.
+func (fc *fragmentCache) evict(size int) {
+	for len(fc.order) > 0 {
+		oldest := fc.order[0]
+		if fc.entries[oldest.key] == oldest.entry {
+			if time.Since(oldest.entry.created) <= fragmentTimeout &&
+				len(fc.entries) < maxFragmentEntries && fc.pendingSize+size <= maxPendingFragmentsSize {
+				break
+			}
+			fc.remove(oldest.key, oldest.entry)
+		}
+		fc.order[0] = fragmentRef{}
+		fc.order = fc.order[1:]
+	}
+}
+
+// Creates new fragment entry evicting the oldest entries if cache is
+// full. Should be called under cache lock.
+func (fc *fragmentCache) create(key fragmentKey) *fragmentEntry {
+	if fc.entries == nil {
+		fc.entries = make(map[fragmentKey]*fragmentEntry)
+	}
+	fc.evict(0)
+	entry := &fragmentEntry{
+		created: time.Now(),
+	}
+	fc.entries[key] = entry
+	fc.order = append(fc.order, fragmentRef{key, entry})
+	return entry
+}
+
+func (fc *fragmentCache) remove(key fragmentKey, entry *fragmentEntry) {
+	for _, p := range entry.pending {
+		fc.pendingSize -= len(p)
+	}
+	delete(fc.entries, key)
+}
+
+// Translates fragment which is not first in its packet. If first
+// fragment was already translated, the same translation is applied
+// to this one. Otherwise a copy of fragment is kept until first
+// fragment arrives.
+func (port *ipPort) translateFragment(pkt *packet.Packet, frag *fragmentInfo, pktVLAN *packet.VLANHdr, pktIPv4 *packet.IPv4Hdr, pktIPv6 *packet.IPv6Hdr) uint {
+	fc := &port.fragments
+	fc.mutex.Lock()
+	entry := fc.get(frag.key)
+	if entry == nil || !entry.translated {
+		size := int(pkt.GetPacketLen())
+		if size > maxPendingFragmentsSize {
+			fc.mutex.Unlock()
+			return port.dropPacket(pkt, dropFragment)
+		}
+		// The oldest fragments are dropped to make room for new ones
+		fc.evict(size)
+		entry = fc.get(frag.key)
+		if entry == nil {
+			entry = fc.create(frag.key)
+		}
+		entry.pending = append(entry.pending, append([]byte(nil), pkt.GetRawPacketBytes()...))
+		fc.pendingSize += size
+		fc.mutex.Unlock()
+		return dropResult(dropFragmentBuffered)
+	}
+	translation := entry.translation
+	fc.mutex.Unlock()
+
+	if translation.dir == DirSEND || translation.dir == dirHairpin {
+		if !translation.apply(pkt, pktVLAN, pktIPv4, pktIPv6) {
+			return port.dropPacket(pkt, dropTranslationFailed)
+		}
+		translation.out.dumpPacket(pkt, DirSEND)
+	} else {
+		port.dumpPacket(pkt, translation.dir)
+	}
+	return translation.dir
+}
+
+// Remembers translation of first fragment and sends fragments of the
+// same packet which came before it. Headers pktIPv4 and pktIPv6 are
+// the ones parsed before translation.
+func (port *ipPort) saveFragmentTranslation(pkt *packet.Packet, frag *fragmentInfo, dir uint, pktIPv4 *packet.IPv4Hdr, pktIPv6 *packet.IPv6Hdr) {
+	translation := fragmentTranslation{
+		dir:    dir,
+		out:    port.opposite,
+		srcMAC: pkt.Ether.SAddr,
+		dstMAC: pkt.Ether.DAddr,
+	}
+	if dir == dirHairpin {
+		translation.out = port
+	}
+	// NAT64 replaces IP header of translated packet
+	out4, out6 := pktIPv4, pktIPv6
+	if dir == DirSEND {
+		pkt.ParseL3CheckVLAN()
+		out4, out6 = pkt.GetIPv4CheckVLAN(), pkt.GetIPv6CheckVLAN()
+	}
+	translation.nat64 = (out4 != nil) != (pktIPv4 != nil)
+	if out4 != nil {
+		translation.src4 = out4.SrcAddr
+		translation.dst4 = out4.DstAddr
+	} else {
+		translation.src6 = out6.SrcAddr
+		translation.dst6 = out6.DstAddr
+	}
+
+	fc := &port.fragments
+	fc.mutex.Lock()
+	entry := fc.get(frag.key)
+	if entry == nil {
+		// Packet with IPv6 Fragment header may be not fragmented
+		if frag.last {
+			fc.mutex.Unlock()
+			return
+		}
+		entry = fc.create(frag.key)
+	}
+	entry.translated = true
+	entry.translation = translation
+	pending := entry.pending
+	for _, p := range pending {
+		fc.pendingSize -= len(p)
+	}
+	entry.pending = nil
+	fc.mutex.Unlock()
+
+	if dir != DirSEND && dir != dirHairpin {
+		return
+	}
+	for _, p := range pending {
+		fragment, err := packet.NewPacket()
+		if err != nil {
+			return
+		}
+		packet.GeneratePacketFromByte(fragment, p)
+		pktVLAN := fragment.ParseL3CheckVLAN()
+		if !translation.apply(fragment, pktVLAN, fragment.GetIPv4CheckVLAN(), fragment.GetIPv6CheckVLAN()) {
+			port.dropPacket(fragment, dropTranslationFailed)
+			continue
+		}
+		translation.out.dumpPacket(fragment, DirSEND)
+		fragment.SendPacket(translation.out.Index)
+	}
+}
+
+// Applies translation of first fragment to another fragment of the
+// same packet. Fragments other than first don't have L4 header, so
+// only L2 and L3 headers are changed. NAT64 fragments get IP header of
+// another version, IPv6 fragments which exceed minimum IPv6 MTU are
+// fragmented further. Returns false if fragment cannot be translated.
+func (t *fragmentTranslation) apply(pkt *packet.Packet, pktVLAN *packet.VLANHdr, pktIPv4 *packet.IPv4Hdr, pktIPv6 *packet.IPv6Hdr) bool {
+	pkt.Ether.SAddr = t.srcMAC
+	pkt.Ether.DAddr = t.dstMAC
+	if pktVLAN != nil {
+		pktVLAN.SetVLANTagIdentifier(t.out.Vlan)
+	}
+	if t.nat64 {
+		if pktIPv6 != nil {
+			return convertIPv6ToIPv4(pkt, pktVLAN, packet.SwapBytesIPv4Addr(t.src4), packet.SwapBytesIPv4Addr(t.dst4))
+		}
+		return convertIPv4ToIPv6(pkt, pktVLAN, t.src6, t.dst6) &&
+			fragmentIPv6(pkt, pktVLAN, ipv6MinMTU, 0, t.out)
+	}
+	if pktIPv4 != nil {
+		pktIPv4.HdrChecksum = updateChecksumIPv4Addr(pktIPv4.HdrChecksum, pktIPv4.SrcAddr, t.src4)
+		pktIPv4.HdrChecksum = updateChecksumIPv4Addr(pktIPv4.HdrChecksum, pktIPv4.DstAddr, t.dst4)
+		pktIPv4.SrcAddr = t.src4
+		pktIPv4.DstAddr = t.dst4
+	} else {
+		pktIPv6.SrcAddr = t.src6
+		pktIPv6.DstAddr = t.dst6
+	}
+	return true
+}
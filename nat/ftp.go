@@ -0,0 +1,256 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/types"
+)
+
+const ftpControlPort = 21
+
+// FTP commands and replies which carry address of data connection
+const (
+	ftpPORT = iota
+	ftpEPRT
+	ftpPASV
+	ftpEPSV
+)
+
+// Address of data connection found in FTP control connection
+// payload. Bytes from start to end of payload are replaced when it
+// is translated.
+type ftpDataAddr struct {
+	kind       int
+	delim      byte
+	v4addr     types.IPv4Address
+	v6addr     types.IPv6Address
+	port       uint16
+	start, end int
+}
+
+func hasPrefixFold(data []byte, prefix string) bool {
+	return len(data) >= len(prefix) && bytes.EqualFold(data[:len(prefix)], []byte(prefix))
+}
+
+// Parses decimal number of at most five digits. Returns its value
+// and number of parsed bytes.
+func parseFTPDecimal(data []byte) (int, int) {
+	v, i := 0, 0
+	for i < len(data) && i < 5 && data[i] >= '0' && data[i] <= '9' {
+		v = v*10 + int(data[i]-'0')
+		i++
+	}
+	return v, i
+}
+
+// Parses address and port in a form of h1,h2,h3,h4,p1,p2 which is
+// used by PORT command and reply to PASV command (RFC 959).
+func parseFTPHostPort(data []byte, da *ftpDataAddr) bool {
+	var b [6]int
+	i := 0
+	for n := range b {
+		if n > 0 {
+			if i >= len(data) || data[i] != ',' {
+				return false
+			}
+			i++
+		}
+		v, l := parseFTPDecimal(data[i:])
+		if l == 0 || v > 255 {
+			return false
+		}
+		b[n] = v
+		i += l
+	}
+	da.v4addr = types.IPv4Address(b[0]<<24 | b[1]<<16 | b[2]<<8 | b[3])
+	da.port = uint16(b[4]<<8 | b[5])
+	da.end = da.start + i
+	return true
+}
+
+// Parses argument of EPRT command in a form of |proto|addr|port|
+// where any printable character may be used as delimiter (RFC 2428).
+func parseFTPExtHostPort(data []byte, da *ftpDataAddr, ipv6 bool) bool {
+	if len(data) == 0 || data[0] < 33 || data[0] > 126 {
+		return false
+	}
+	da.delim = data[0]
+	fields := bytes.SplitN(data[1:], []byte{da.delim}, 4)
+	if len(fields) != 4 {
+		return false
+	}
+	proto, l := parseFTPDecimal(fields[0])
+	if l == 0 || l != len(fields[0]) || (proto == 2) != ipv6 || (proto != 1 && proto != 2) {
+		return false
+	}
+	ip := net.ParseIP(string(fields[1]))
+	if ip == nil {
+		return false
+	}
+	if ipv6 {
+		copy(da.v6addr[:], ip.To16())
+	} else {
+		ip4 := ip.To4()
+		if ip4 == nil {
+			return false
+		}
+		da.v4addr = types.IPv4Address(ip4[0])<<24 | types.IPv4Address(ip4[1])<<16 |
+			types.IPv4Address(ip4[2])<<8 | types.IPv4Address(ip4[3])
+	}
+	port, l := parseFTPDecimal(fields[2])
+	if l == 0 || l != len(fields[2]) || port > 65535 {
+		return false
+	}
+	da.port = uint16(port)
+	da.end = da.start + 4 + len(fields[0]) + len(fields[1]) + len(fields[2])
+	return true
+}
+
+// Finds address of data connection in FTP control connection
+// payload. Clients send PORT and EPRT commands, servers send replies
+// to PASV and EPSV commands. Command or reply is expected to be in
+// one segment.
+func parseFTPPayload(payload []byte, client, ipv6 bool) (ftpDataAddr, bool) {
+	da := ftpDataAddr{}
+	if client {
+		switch {
+		case !ipv6 && hasPrefixFold(payload, "PORT "):
+			da.kind = ftpPORT
+			da.start = 5
+			return da, parseFTPHostPort(payload[da.start:], &da)
+		case hasPrefixFold(payload, "EPRT "):
+			da.kind = ftpEPRT
+			da.start = 5
+			return da, parseFTPExtHostPort(payload[da.start:], &da, ipv6)
+		}
+		return da, false
+	}
+
+	switch {
+	case !ipv6 && bytes.HasPrefix(payload, []byte("227 ")):
+		// Reply text is not standardized, address is the first
+		// number in it
+		da.kind = ftpPASV
+		da.start = bytes.IndexAny(payload[4:], "0123456789")
+		if da.start < 0 {
+			return da, false
+		}
+		da.start += 4
+		return da, parseFTPHostPort(payload[da.start:], &da)
+	case bytes.HasPrefix(payload, []byte("229 ")):
+		// Only port is present in a form of (|||port|)
+		open := bytes.IndexByte(payload, '(')
+		if open < 0 || open+4 > len(payload) {
+			return da, false
+		}
+		da.kind = ftpEPSV
+		da.delim = payload[open+1]
+		if payload[open+2] != da.delim || payload[open+3] != da.delim {
+			return da, false
+		}
+		da.start = open + 4
+		port, l := parseFTPDecimal(payload[da.start:])
+		if l == 0 || port > 65535 || da.start+l >= len(payload) || payload[da.start+l] != da.delim {
+			return da, false
+		}
+		da.port = uint16(port)
+		da.end = da.start + l
+		return da, true
+	}
+	return da, false
+}
+
+// Returns replacement of data connection address found in payload
+// with given public address and port.
+func (da *ftpDataAddr) format(v4addr types.IPv4Address, v6addr types.IPv6Address, port uint16) []byte {
+	switch da.kind {
+	case ftpPORT, ftpPASV:
+		return []byte(fmt.Sprintf("%d,%d,%d,%d,%d,%d", byte(v4addr>>24), byte(v4addr>>16), byte(v4addr>>8), byte(v4addr),
+			port>>8, port&0xff))
+	case ftpEPRT:
+		if v4addr != 0 {
+			return []byte(fmt.Sprintf("%c1%c%s%c%d%c", da.delim, da.delim, StringIPv4Int(uint32(v4addr)), da.delim, port, da.delim))
+		}
+		return []byte(fmt.Sprintf("%c2%c%s%c%d%c", da.delim, da.delim, net.IP(v6addr[:]).String(), da.delim, port, da.delim))
+	default:
+		return []byte(strconv.Itoa(int(port)))
+	}
+}
+
+// Translates payload of FTP control connection segment sent from
+// private network. Data connection addresses in PORT and EPRT
+// commands of private clients and in passive mode replies of private
+// servers are replaced with public address and port of a new mapping.
+// Checksums are calculated later by caller.
+func (pp *portPair) translateFTPPri2Pub(pkt *packet.Packet, pktIPv4 *packet.IPv4Hdr, pktIPv6 *packet.IPv6Hdr, pktTCP *packet.TCPHdr,
+	pme *portMapEntry, poolIndex int, client bool) {
+	ipv6 := pktIPv6 != nil
+	var srcAddr4 types.IPv4Address
+	var srcAddr6 types.IPv6Address
+	if ipv6 {
+		srcAddr6 = pktIPv6.SrcAddr
+	} else {
+		srcAddr4 = packet.SwapBytesIPv4Addr(pktIPv4.SrcAddr)
+	}
+	remote := makeALGRemote(pktIPv4, pktIPv6, packet.SwapBytesUint16(pktTCP.DstPort), pri2pub)
+	conn := pp.getALGConnection(pme, remote, true)
+	seq := packet.SwapBytesUint32(pktTCP.SentSeq)
+
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.lastused = time.Now()
+
+	payload := getTCPPayload(pkt, ipv6, pktTCP)
+	if da, ok := parseFTPPayload(payload, client, ipv6); ok {
+		// Data connection is expected to use address of the host
+		// which sends command or reply, EPSV reply has port only
+		if da.kind == ftpEPSV {
+			da.v4addr, da.v6addr = srcAddr4, srcAddr6
+		}
+		privData := makeTuple(ipv6, da.v4addr, da.v6addr, da.port)
+		if da.v4addr == srcAddr4 && da.v6addr == srcAddr6 {
+			if !conn.rewriteValid || conn.rewriteSeq != seq {
+				conn.rewriteValid = false
+				v4addr, v6addr, port, _, _, err := pp.expectConnection(ipv6, types.TCPNumber, poolIndex, privData)
+				if err == nil {
+					conn.rewrite = da.format(v4addr, v6addr, port)
+					conn.rewriteSeq = seq
+					conn.rewriteValid = true
+				} else if err != errSessionLimit {
+					println("Warning! Failed to allocate FTP data connection", err.Error())
+				}
+			}
+			if conn.rewriteValid && replacePayload(pkt, pktIPv4, pktIPv6, nil, payload, da.start, da.end, conn.rewrite) {
+				if diff := len(conn.rewrite) - (da.end - da.start); diff != 0 {
+					conn.adjust[pri2pub].record(seq, diff)
+				}
+			}
+		}
+	}
+	conn.adjustSegment(pktTCP, seq, pri2pub)
+}
+
+// Adjusts acknowledgement number of FTP control connection segment
+// received from public network according to changes of payload sent
+// from private network.
+func (pp *portPair) translateFTPPub2Pri(pktIPv4 *packet.IPv4Hdr, pktIPv6 *packet.IPv6Hdr, pktTCP *packet.TCPHdr, pme *portMapEntry) {
+	remote := makeALGRemote(pktIPv4, pktIPv6, packet.SwapBytesUint16(pktTCP.SrcPort), pub2pri)
+	conn := pp.getALGConnection(pme, remote, false)
+	if conn == nil {
+		return
+	}
+
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.lastused = time.Now()
+	conn.adjustSegment(pktTCP, packet.SwapBytesUint32(pktTCP.SentSeq), pub2pri)
+}
@@ -0,0 +1,89 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"testing"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+func TestParseFTPPayload(t *testing.T) {
+	const pubPort = 40000
+	pubAddr4 := types.IPv4Address(0xc0000201)
+	pubAddr6 := testIPv6Address("2001:db8::1")
+	tests := []struct {
+		name    string
+		payload string
+		client  bool
+		ipv6    bool
+		ok      bool
+		// Private data connection endpoint found in payload
+		v4addr types.IPv4Address
+		v6addr string
+		port   uint16
+		// Payload with public data connection endpoint
+		result string
+	}{
+		{"PORT", "PORT 10,0,0,1,4,1\r\n", true, false, true, 0x0a000001, "", 1025,
+			"PORT 192,0,2,1,156,64\r\n"},
+		{"PORTLowerCase", "port 10,0,0,1,4,1\r\n", true, false, true, 0x0a000001, "", 1025,
+			"port 192,0,2,1,156,64\r\n"},
+		{"EPRT", "EPRT |1|10.0.0.1|1025|\r\n", true, false, true, 0x0a000001, "", 1025,
+			"EPRT |1|192.0.2.1|40000|\r\n"},
+		{"EPRTOtherDelimiter", "EPRT !1!10.0.0.1!1025!\r\n", true, false, true, 0x0a000001, "", 1025,
+			"EPRT !1!192.0.2.1!40000!\r\n"},
+		{"EPRTIPv6", "EPRT |2|fd00::1|1025|\r\n", true, true, true, 0, "fd00::1", 1025,
+			"EPRT |2|2001:db8::1|40000|\r\n"},
+		{"PASV", "227 Entering Passive Mode (10,0,0,1,4,1).\r\n", false, false, true, 0x0a000001, "", 1025,
+			"227 Entering Passive Mode (192,0,2,1,156,64).\r\n"},
+		{"PASVNoParentheses", "227 =10,0,0,1,4,1\r\n", false, false, true, 0x0a000001, "", 1025,
+			"227 =192,0,2,1,156,64\r\n"},
+		{"EPSV", "229 Entering Extended Passive Mode (|||1025|)\r\n", false, false, true, 0, "", 1025,
+			"229 Entering Extended Passive Mode (|||40000|)\r\n"},
+		{"EPSVIPv6", "229 Entering Extended Passive Mode (|||1025|)\r\n", false, true, true, 0, "", 1025,
+			"229 Entering Extended Passive Mode (|||40000|)\r\n"},
+		{"PORTFromServer", "PORT 10,0,0,1,4,1\r\n", false, false, false, 0, "", 0, ""},
+		{"PASVFromClient", "227 Entering Passive Mode (10,0,0,1,4,1).\r\n", true, false, false, 0, "", 0, ""},
+		{"PORTIPv6", "PORT 10,0,0,1,4,1\r\n", true, true, false, 0, "", 0, ""},
+		{"PORTTooFewNumbers", "PORT 10,0,0,1,4\r\n", true, false, false, 0, "", 0, ""},
+		{"PORTNumberTooLarge", "PORT 10,0,0,256,4,1\r\n", true, false, false, 0, "", 0, ""},
+		{"EPRTWrongFamily", "EPRT |1|10.0.0.1|1025|\r\n", true, true, false, 0, "", 0, ""},
+		{"EPRTBadAddress", "EPRT |1|10.0.0|1025|\r\n", true, false, false, 0, "", 0, ""},
+		{"EPRTPortTooLarge", "EPRT |1|10.0.0.1|65536|\r\n", true, false, false, 0, "", 0, ""},
+		{"EPSVNoDelimiters", "229 Entering Extended Passive Mode (1025)\r\n", false, false, false, 0, "", 0, ""},
+		{"EPSVUnterminated", "229 Entering Extended Passive Mode (|||1025)\r\n", false, false, false, 0, "", 0, ""},
+		{"OtherCommand", "RETR file\r\n", true, false, false, 0, "", 0, ""},
+	}
+	for _, tt := range tests {
+		payload := []byte(tt.payload)
+		da, ok := parseFTPPayload(payload, tt.client, tt.ipv6)
+		if ok != tt.ok {
+			t.Errorf("%s: parsed %t, expected %t", tt.name, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		var v6addr types.IPv6Address
+		if tt.v6addr != "" {
+			v6addr = testIPv6Address(tt.v6addr)
+		}
+		if da.v4addr != tt.v4addr || da.v6addr != v6addr || da.port != tt.port {
+			t.Errorf("%s: parsed %s %v port %d, expected %s %s port %d", tt.name,
+				StringIPv4Int(uint32(da.v4addr)), da.v6addr, da.port, StringIPv4Int(uint32(tt.v4addr)), tt.v6addr, tt.port)
+		}
+		var data []byte
+		if tt.ipv6 {
+			data = da.format(0, pubAddr6, pubPort)
+		} else {
+			data = da.format(pubAddr4, zeroIPv6Addr, pubPort)
+		}
+		result := string(payload[:da.start]) + string(data) + string(payload[da.end:])
+		if result != tt.result {
+			t.Errorf("%s: payload rewritten to %q, expected %q", tt.name, result, tt.result)
+		}
+	}
+}
@@ -5,8 +5,11 @@
 package nat
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
@@ -117,18 +120,290 @@ func (s *server) ChangePortForwarding(ctx context.Context, in *upd.PortForwardin
 		return nil, err
 	}
 
-	pp.mutex.Lock()
+	// Public port forwarding changes port map of port own address,
+	// private port has only lookup table entries
+	mutex := &pp.mutex
 	if port.Type == iPUBLIC {
-		pp.deleteOldConnection(fp.Protocol.ipv6, fp.Protocol.id, int(fp.Port))
-	} else {
-		port.deletePortForwardingEntry(fp.Protocol.ipv6, fp.Protocol.id, int(fp.Port))
+		mutex = port.portLock(fp.Protocol.ipv6, 0, fp.Protocol.id)
+	}
+	mutex.Lock()
+	for p := int(fp.Port); p <= int(fp.LastPort); p++ {
+		if port.Type == iPUBLIC {
+			pp.deleteOldConnection(fp.Protocol.ipv6, fp.Protocol.id, 0, p)
+		} else {
+			port.deletePortForwardingEntry(fp.Protocol.ipv6, fp.Protocol.id, p)
+		}
 	}
 	if in.GetEnableForwarding() {
 		port.enableStaticPortForward(fp)
 	}
-	pp.mutex.Unlock()
+	mutex.Unlock()
 
 	return &upd.Reply{
 		Msg: "Success",
 	}, nil
 }
+
+func (s *server) ChangeSessionTimeouts(ctx context.Context, in *upd.SessionTimeoutsChangeRequest) (*upd.Reply, error) {
+	tcpStates := map[tcpState]uint32{
+		tcpSynSent:   in.GetTcpSynSentSeconds(),
+		tcpSynRecv:   in.GetTcpSynRecvSeconds(),
+		tcpFinWait:   in.GetTcpFinWaitSeconds(),
+		tcpCloseWait: in.GetTcpCloseWaitSeconds(),
+		tcpLastAck:   in.GetTcpLastAckSeconds(),
+		tcpTimeWait:  in.GetTcpTimeWaitSeconds(),
+		tcpClose:     in.GetTcpCloseSeconds(),
+	}
+	// Zero values mean that corresponding timeout is not changed.
+	// Timeouts in use are replaced with changed copy.
+	t := Natconfig.changeTimeouts(func(t *sessionTimeouts) {
+		if in.GetTcpEstablishedSeconds() != 0 {
+			t.TCPEstablished = time.Duration(in.GetTcpEstablishedSeconds()) * time.Second
+		}
+		if in.GetTcpTransitorySeconds() != 0 {
+			t.TCPTransitory = time.Duration(in.GetTcpTransitorySeconds()) * time.Second
+		}
+		for state, seconds := range tcpStates {
+			if seconds != 0 {
+				t.TCPStates[state] = time.Duration(seconds) * time.Second
+			}
+		}
+		if in.GetUdpSeconds() != 0 {
+			t.UDP = time.Duration(in.GetUdpSeconds()) * time.Second
+		}
+		if in.GetIcmpSeconds() != 0 {
+			t.ICMP = time.Duration(in.GetIcmpSeconds()) * time.Second
+		}
+	})
+
+	return &upd.Reply{
+		Msg: "Successfully set session timeouts to " + t.String(),
+	}, nil
+}
+
+func (s *server) FindPortBlockOwner(ctx context.Context, in *upd.PortBlockOwnerRequest) (*upd.Reply, error) {
+	portId := in.GetInterfaceId()
+	port, _ := Natconfig.getPortAndPairByID(portId)
+	if port == nil {
+		return nil, fmt.Errorf("Interface with ID %d not found", portId)
+	}
+	addr, err := convertIPv4(in.GetAddress().GetAddress())
+	if err != nil {
+		return nil, err
+	}
+
+	subscriber, start, end, err := port.portBlockOwner(addr, int(in.GetPortNumber()))
+	if err != nil {
+		return nil, err
+	}
+
+	return &upd.Reply{
+		Msg: fmt.Sprintf("Port %s:%d belongs to subscriber %s, port block %d-%d",
+			StringIPv4Int(uint32(addr)), in.GetPortNumber(), StringIPv4Int(uint32(subscriber)), start, end-1),
+	}, nil
+}
+
+func (s *server) ChangeSessionLimits(ctx context.Context, in *upd.SessionLimitsChangeRequest) (*upd.Reply, error) {
+	portId := in.GetInterfaceId()
+	port, pp := Natconfig.getPortAndPairByID(portId)
+	if port == nil {
+		return nil, fmt.Errorf("Interface with ID %d not found", portId)
+	}
+
+	// Zero values mean that corresponding number of sessions is not
+	// limited. Existing sessions are kept when limits are reduced.
+	pp.sessions.lockAll()
+	pp.SessionLimits = sessionLimits{
+		Total: in.GetTotal(),
+		TCP:   in.GetTcp(),
+		UDP:   in.GetUdp(),
+		ICMP:  in.GetIcmp(),
+	}
+	str := pp.SessionLimits.String()
+	hosts := pp.sessions.hostsCount()
+	pp.sessions.unlockAll()
+	drops := atomic.LoadUint64(&pp.sessions.drops)
+
+	return &upd.Reply{
+		Msg: fmt.Sprintf("Successfully set session limits of interface %d to %s, %d private hosts have sessions, %d packets were dropped because of session limits",
+			portId, str, hosts, drops),
+	}, nil
+}
+
+func (s *server) ChangeStaticMapping(ctx context.Context, in *upd.StaticMappingChangeRequest) (*upd.Reply, error) {
+	portId := in.GetInterfaceId()
+	port, pp := Natconfig.getPortAndPairByID(portId)
+	if port == nil {
+		return nil, fmt.Errorf("Interface with ID %d not found", portId)
+	}
+	public, err := convertHostAddr(in.GetPublicAddress().GetAddress())
+	if err != nil {
+		return nil, err
+	}
+
+	// Private address is not needed to remove mapping
+	sm := staticMapping{
+		Public: public,
+	}
+	pp.mutex.Lock()
+	if in.GetEnableMapping() {
+		sm.Private, err = convertHostAddr(in.GetPrivateAddress().GetAddress())
+		if err == nil {
+			err = pp.enableStaticMapping(&sm)
+		}
+		if err == nil {
+			pp.StaticMappings = append(pp.StaticMappings, sm)
+		}
+	} else {
+		sm.Private, err = pp.disableStaticMapping(public)
+		if err == nil {
+			for i := range pp.StaticMappings {
+				if pp.StaticMappings[i].Public == public {
+					pp.StaticMappings = append(pp.StaticMappings[:i], pp.StaticMappings[i+1:]...)
+					break
+				}
+			}
+		}
+	}
+	pp.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	action := "removed"
+	if in.GetEnableMapping() {
+		action = "added"
+	}
+	return &upd.Reply{
+		Msg: fmt.Sprintf("Successfully %s static mapping %s of interface %d", action, sm.String(), portId),
+	}, nil
+}
+
+// Returns port pairs and filter of sessions selected by request.
+func getSessionsSelection(in *upd.SessionsRequest) ([]*portPair, *sessionFilter, error) {
+	var pairs []*portPair
+	if in.GetMatchInterface() {
+		portId := in.GetInterfaceId()
+		port, pp := Natconfig.getPortAndPairByID(portId)
+		if port == nil {
+			return nil, nil, fmt.Errorf("Interface with ID %d not found", portId)
+		}
+		pairs = append(pairs, pp)
+	} else {
+		for i := range Natconfig.PortPairs {
+			pairs = append(pairs, &Natconfig.PortPairs[i])
+		}
+	}
+
+	if in.GetProtocol() > 255 {
+		return nil, nil, fmt.Errorf("Bad protocol number %d", in.GetProtocol())
+	}
+	filter := &sessionFilter{
+		protocol: uint8(in.GetProtocol()),
+	}
+	if addr := in.GetPrivateAddress().GetAddress(); addr != nil {
+		priv, err := convertHostAddr(addr)
+		if err != nil {
+			return nil, nil, err
+		}
+		filter.priv = &priv
+	}
+	if addr := in.GetPublicAddress().GetAddress(); addr != nil {
+		pub, err := convertHostAddr(addr)
+		if err != nil {
+			return nil, nil, err
+		}
+		filter.pub = &pub
+	}
+	return pairs, filter, nil
+}
+
+func (s *server) ListSessions(in *upd.SessionsRequest, stream upd.Updater_ListSessionsServer) error {
+	pairs, filter, err := getSessionsSelection(in)
+	if err != nil {
+		return err
+	}
+
+	for _, pp := range pairs {
+		pp.forEachSession(filter, func(s *sessionInfo) bool {
+			err = stream.Send(s.toProto(uint32(pp.PublicPort.Index)))
+			return err == nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *server) DeleteSessions(ctx context.Context, in *upd.SessionsRequest) (*upd.Reply, error) {
+	pairs, filter, err := getSessionsSelection(in)
+	if err != nil {
+		return nil, err
+	}
+
+	deleted := 0
+	for _, pp := range pairs {
+		deleted += pp.deleteSessions(filter)
+	}
+	return &upd.Reply{
+		Msg: fmt.Sprintf("Successfully deleted %d sessions", deleted),
+	}, nil
+}
+
+func (s *server) GetDropCounters(ctx context.Context, in *upd.DropCountersRequest) (*upd.DropCountersReply, error) {
+	portId := in.GetInterfaceId()
+	port, pp := Natconfig.getPortAndPairByID(portId)
+	if port == nil {
+		return nil, fmt.Errorf("Interface with ID %d not found", portId)
+	}
+
+	counters := pp.getCounters()
+	reply := &upd.DropCountersReply{}
+	for _, dir := range []trafficDirection{pri2pub, pub2pri} {
+		for reason, count := range counters.drops[dir] {
+			if count == 0 {
+				continue
+			}
+			reply.Counters = append(reply.Counters, &upd.DropCounter{
+				Direction: dir.String(),
+				Reason:    dropReason(reason).String(),
+				Packets:   count,
+			})
+		}
+	}
+	return reply, nil
+}
+
+func (s *server) FindSessionHistory(ctx context.Context, in *upd.SessionHistoryRequest) (*upd.SessionHistoryReply, error) {
+	if history == nil {
+		return nil, errors.New("Translation history is not enabled")
+	}
+	if in.GetProtocol() > 255 {
+		return nil, fmt.Errorf("Bad protocol number %d", in.GetProtocol())
+	}
+	if in.GetPublicPort() > 65535 {
+		return nil, fmt.Errorf("Bad port number %d", in.GetPublicPort())
+	}
+	pub, err := convertHostAddr(in.GetPublicAddress().GetAddress())
+	if err != nil {
+		return nil, err
+	}
+	port := uint16(in.GetPublicPort())
+	protocol := uint8(in.GetProtocol())
+	t := time.Unix(0, in.GetTimeUnixNano())
+
+	records, err := history.find(pub, port, protocol, t)
+	if err != nil {
+		return nil, err
+	}
+	for i := range Natconfig.PortPairs {
+		records = append(records, Natconfig.PortPairs[i].findActiveSessions(pub, port, protocol, t, i)...)
+	}
+
+	reply := &upd.SessionHistoryReply{}
+	for i := range records {
+		reply.Records = append(reply.Records, records[i].toProto())
+	}
+	return reply, nil
+}
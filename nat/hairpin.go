@@ -0,0 +1,123 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/types"
+)
+
+// Checks whether packet which came from private network is directed
+// to one of public addresses of this NAT and has an active
+// translation entry. Such packets are translated back to private
+// network (hairpinning, RFC 4787 REQ-9 and RFC 5382 REQ-8). Packet
+// source is already translated to public address and port of sending
+// host, so receiving host sees it as if it came from public
+// network. Returns false if packet is not a subject of hairpinning.
+func (pp *portPair) hairpinTranslation(pkt *packet.Packet, ipv6 bool, protocol uint8,
+	srcAddr4 types.IPv4Address, srcAddr6 types.IPv6Address, srcPort, dstPort uint16,
+	pktTCP *packet.TCPHdr, pktUDP *packet.UDPHdr, fragmented bool) (uint, bool) {
+	// Only protocols with ports are looped back. ICMP messages
+	// directed to public addresses are not expected to come from
+	// private network.
+	if pktTCP == nil && pktUDP == nil {
+		return dropResult(dropUnsupportedProtocol), false
+	}
+
+	pub := &pp.PublicPort
+	priv := &pp.PrivatePort
+	var pub2priKey sessionKey
+	var inPool bool
+	if ipv6 {
+		dstAddr := pkt.GetIPv6NoCheck().DstAddr
+		pub2priKey = makeTuple(true, 0, dstAddr, dstPort)
+		_, inPool = pub.getPoolIndex(true, 0, dstAddr)
+	} else {
+		dstAddr := packet.SwapBytesIPv4Addr(pkt.GetIPv4NoCheck().DstAddr)
+		pub2priKey = makeTuple(false, dstAddr, zeroIPv6Addr, dstPort)
+		_, inPool = pub.getPoolIndex(false, dstAddr, zeroIPv6Addr)
+	}
+	if !inPool {
+		return dropResult(dropNotForUs), false
+	}
+
+	pme, found := pub.translationTable[protocol].Load(pub2priKey)
+	if !found {
+		return dropResult(dropNoSession), false
+	}
+	// Hairpinning between IPv4 hosts and NAT64 sessions is not
+	// supported
+	if pme.priv.isNAT64() {
+		return priv.dropPacket(pkt, dropTranslationFailed), true
+	}
+	v4addr, v6addr, newPort, zeroAddr := getAddrFromTuple(pme.priv)
+	if zeroAddr {
+		// Port is forwarded to KNI interface on public port, let
+		// it be sent to public network as usual
+		return dropResult(dropNotForUs), false
+	}
+
+	// Check that translation entry is active and accepts packets
+	// from sending host public address and port
+	if !pme.static {
+		if pme.expired(protocol) {
+			return dropResult(dropSessionExpired), false
+		}
+		remoteKey := makeRemoteKey(pp.FilteringBehavior, ipv6, protocol, srcAddr4, srcAddr6, srcPort)
+		if !pme.remoteAllowed(remoteKey) {
+			return priv.dropPacket(pkt, dropFiltered), true
+		}
+		pme.touch()
+	}
+
+	// Find corresponding MAC address
+	var mac types.MACAddress
+	if ipv6 {
+		mac, found = priv.getMACForIPv6(v6addr)
+	} else {
+		mac, found = priv.getMACForIPv4(v4addr)
+	}
+	if !found {
+		return priv.dropPacket(pkt, dropNeighborUnresolved), true
+	}
+
+	// Do packet translation. Packet leaves the same port where it
+	// came from, so VLAN tag stays the same.
+	pkt.Ether.DAddr = mac
+	pkt.Ether.SAddr = priv.SrcMACAddress
+	if fragmented {
+		// L4 checksum covers other fragments too
+		var pktIPv4 *packet.IPv4Hdr
+		var pktIPv6 *packet.IPv6Hdr
+		if ipv6 {
+			pktIPv6 = pkt.GetIPv6NoCheck()
+		} else {
+			pktIPv4 = pkt.GetIPv4NoCheck()
+		}
+		part := newFirstFragment(pktIPv4, pktIPv6, protocol, pktTCP, pktUDP, nil)
+		part.setAddrPort(true, srcAddr4, srcAddr6, srcPort)
+		part.setAddrPort(false, v4addr, v6addr, newPort)
+		priv.dumpPacket(pkt, DirSEND)
+		return dirHairpin, true
+	}
+	if ipv6 {
+		pktIPv6 := pkt.GetIPv6NoCheck()
+		pktIPv6.SrcAddr = srcAddr6
+		pktIPv6.DstAddr = v6addr
+	} else {
+		pktIPv4 := pkt.GetIPv4NoCheck()
+		pktIPv4.SrcAddr = packet.SwapBytesIPv4Addr(srcAddr4)
+		pktIPv4.DstAddr = packet.SwapBytesIPv4Addr(v4addr)
+	}
+	if pktTCP != nil {
+		pktTCP.DstPort = packet.SwapBytesUint16(newPort)
+	} else {
+		pktUDP.DstPort = packet.SwapBytesUint16(newPort)
+	}
+	setPacketSrcPort(pkt, ipv6, protocol, srcPort, pktTCP, pktUDP, nil)
+
+	priv.dumpPacket(pkt, DirSEND)
+	return dirHairpin, true
+}
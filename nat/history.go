@@ -0,0 +1,567 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/types"
+
+	upd "github.com/intel-go/nff-go-nat/updatecfg"
+)
+
+// Translation history keeps records of finished sessions in segment
+// files which cover fixed periods of time. Records of current segment
+// are appended to its file as sessions end. When period is over,
+// segment records are sorted by public address, port and start time,
+// so that lookups in it use binary search. Segments are sorted in
+// background, so records are written while segment is sorted. If NAT
+// is restarted during a period, sorted segments of the period get
+// sequence numbers.
+const (
+	defaultHistoryRetention = historyRetention(7 * 24 * time.Hour)
+	historySegmentDuration  = 10 * time.Minute
+	historyTimeFormat       = "20060102T150405"
+	historyOpenSuffix       = ".open"
+	historySortedSuffix     = ".seg"
+	// Number of records which may wait to be written. Records which
+	// don't fit are lost.
+	historyQueueSize     = 8192
+	historyFlushInterval = time.Second
+	// Number of records which are read at once from segment which
+	// is not sorted yet
+	historyRecordsRead = 4096
+
+	// Record layout. Lookup key is public address, port and IP
+	// version, records with the same key are ordered by start time.
+	historyRecordSize = 80
+	historyKeySize    = 19
+	historyPubPort    = 16
+	historyPubIPv6    = 18
+	historyProtocol   = 19
+	historyStart      = 20
+	historyEnd        = 28
+	historyPrivAddr   = 36
+	historyPrivPort   = 52
+	historyRemotePort = 54
+	historyRemoteAddr = 56
+	historyPair       = 72
+	historyPrivIPv6   = 76
+	historyRemoteIPv6 = 77
+)
+
+// How long records of finished sessions are kept.
+type historyRetention time.Duration
+
+// UnmarshalJSON parses retention period in a form of Go duration
+// string, e.g. "168h".
+func (out *historyRetention) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	if d < historySegmentDuration {
+		return fmt.Errorf("Translation history retention should be at least %v while it is %v", historySegmentDuration, d)
+	}
+	*out = historyRetention(d)
+	return nil
+}
+
+// Local store of translation history. Empty directory disables it.
+type historyConfig struct {
+	Directory string           `json:"directory"`
+	Retention historyRetention `json:"retention"`
+}
+
+// Session which used public address and port. End time is zero for
+// sessions which are still active.
+type historyRecord struct {
+	pair                          int
+	protocol                      uint8
+	pub, priv, remote             hostAddr
+	pubPort, privPort, remotePort uint16
+	start, end                    time.Time
+}
+
+func putHistoryAddr(b []byte, a hostAddr) {
+	copy(b[:types.IPv6AddrLen], a.bytes())
+}
+
+func getHistoryAddr(b []byte, ipv6 bool) hostAddr {
+	if ipv6 {
+		a := hostAddr{ipv6: true}
+		copy(a.Addr6[:], b)
+		return a
+	}
+	return hostAddr{Addr4: types.IPv4Address(binary.BigEndian.Uint32(b))}
+}
+
+func boolByte(v bool) byte {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// Returns lookup key of public address and port.
+func historyKey(pub hostAddr, port uint16) []byte {
+	var b [historyKeySize]byte
+	putHistoryAddr(b[:], pub)
+	binary.BigEndian.PutUint16(b[historyPubPort:], port)
+	b[historyPubIPv6] = boolByte(pub.ipv6)
+	return b[:]
+}
+
+func (r *historyRecord) encode(b []byte) {
+	copy(b, historyKey(r.pub, r.pubPort))
+	b[historyProtocol] = r.protocol
+	binary.BigEndian.PutUint64(b[historyStart:], uint64(r.start.UnixNano()))
+	binary.BigEndian.PutUint64(b[historyEnd:], uint64(r.end.UnixNano()))
+	putHistoryAddr(b[historyPrivAddr:], r.priv)
+	binary.BigEndian.PutUint16(b[historyPrivPort:], r.privPort)
+	binary.BigEndian.PutUint16(b[historyRemotePort:], r.remotePort)
+	putHistoryAddr(b[historyRemoteAddr:], r.remote)
+	binary.BigEndian.PutUint32(b[historyPair:], uint32(r.pair))
+	b[historyPrivIPv6] = boolByte(r.priv.ipv6)
+	b[historyRemoteIPv6] = boolByte(r.remote.ipv6)
+}
+
+func decodeHistoryRecord(b []byte) historyRecord {
+	return historyRecord{
+		pair:       int(binary.BigEndian.Uint32(b[historyPair:])),
+		protocol:   b[historyProtocol],
+		pub:        getHistoryAddr(b, b[historyPubIPv6] != 0),
+		priv:       getHistoryAddr(b[historyPrivAddr:], b[historyPrivIPv6] != 0),
+		remote:     getHistoryAddr(b[historyRemoteAddr:], b[historyRemoteIPv6] != 0),
+		pubPort:    binary.BigEndian.Uint16(b[historyPubPort:]),
+		privPort:   binary.BigEndian.Uint16(b[historyPrivPort:]),
+		remotePort: binary.BigEndian.Uint16(b[historyRemotePort:]),
+		start:      time.Unix(0, int64(binary.BigEndian.Uint64(b[historyStart:]))),
+		end:        time.Unix(0, int64(binary.BigEndian.Uint64(b[historyEnd:]))),
+	}
+}
+
+// Checks whether encoded record was active at time t and has given
+// protocol. Zero protocol matches any protocol.
+func historyRecordMatches(b []byte, protocol uint8, t int64) bool {
+	return (protocol == 0 || b[historyProtocol] == protocol) &&
+		int64(binary.BigEndian.Uint64(b[historyStart:])) <= t &&
+		int64(binary.BigEndian.Uint64(b[historyEnd:])) >= t
+}
+
+type historyStore struct {
+	dir       string
+	retention time.Duration
+	records   chan historyRecord
+	// Number of records which were lost because queue was full
+	lost uint64
+
+	// Protects segment files from being changed while they are
+	// searched
+	mutex       sync.Mutex
+	active      *os.File
+	writer      *bufio.Writer
+	activeStart time.Time
+}
+
+var history *historyStore
+
+// StartHistoryStore starts writing translation history if it is
+// configured. Segments left unsorted by previous run are sorted.
+func StartHistoryStore() error {
+	c := &Natconfig.History
+	if c.Directory == "" {
+		return nil
+	}
+	if err := os.MkdirAll(c.Directory, 0755); err != nil {
+		return err
+	}
+	h := &historyStore{
+		dir:       c.Directory,
+		retention: time.Duration(c.Retention),
+		records:   make(chan historyRecord, historyQueueSize),
+	}
+	names, err := filepath.Glob(filepath.Join(h.dir, "*"+historyOpenSuffix))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := h.sortSegment(name); err != nil {
+			return err
+		}
+	}
+	h.removeExpired()
+	if err := h.openSegment(time.Now()); err != nil {
+		return err
+	}
+	history = h
+	go h.run()
+	return nil
+}
+
+// Queues record of finished session. Record is lost if queue is full,
+// so that packet handlers are never blocked.
+func recordHistory(r *historyRecord) {
+	if history == nil {
+		return
+	}
+	select {
+	case history.records <- *r:
+	default:
+		atomic.AddUint64(&history.lost, 1)
+	}
+}
+
+// Returns number of history records which were lost because queue
+// was full.
+func lostHistoryRecords() uint64 {
+	if history == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&history.lost)
+}
+
+func (h *historyStore) run() {
+	ticker := time.NewTicker(historyFlushInterval)
+	var b [historyRecordSize]byte
+	for {
+		select {
+		case r := <-h.records:
+			r.encode(b[:])
+			h.mutex.Lock()
+			h.rotate(time.Now())
+			if h.writer != nil {
+				h.writer.Write(b[:])
+			}
+			h.mutex.Unlock()
+		case now := <-ticker.C:
+			h.mutex.Lock()
+			h.rotate(now)
+			if h.writer != nil {
+				if err := h.writer.Flush(); err != nil {
+					common.LogWarning(common.Debug, "Failed to write translation history:", err)
+				}
+			}
+			h.mutex.Unlock()
+		}
+	}
+}
+
+func (h *historyStore) segmentName(start time.Time) string {
+	return filepath.Join(h.dir, start.UTC().Format(historyTimeFormat))
+}
+
+// Returns start time of segment file or false if name is not a
+// segment name. Sequence number of segment is ignored.
+func historySegmentStart(name, suffix string) (time.Time, bool) {
+	base := filepath.Base(name)
+	if !strings.HasSuffix(base, suffix) {
+		return time.Time{}, false
+	}
+	base = strings.TrimSuffix(base, suffix)
+	if i := strings.IndexByte(base, '-'); i >= 0 {
+		base = base[:i]
+	}
+	t, err := time.Parse(historyTimeFormat, base)
+	return t, err == nil
+}
+
+// Starts segment which covers time now. Should be called under store
+// lock.
+func (h *historyStore) openSegment(now time.Time) error {
+	h.activeStart = now.Truncate(historySegmentDuration)
+	f, err := os.OpenFile(h.segmentName(h.activeStart)+historyOpenSuffix, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	h.active = f
+	h.writer = bufio.NewWriter(f)
+	return nil
+}
+
+// Finishes active segment if its period is over and starts a new one.
+// Finished segment is sorted in background. Should be called under
+// store lock.
+func (h *historyStore) rotate(now time.Time) {
+	if now.Before(h.activeStart.Add(historySegmentDuration)) {
+		return
+	}
+	if h.active != nil {
+		h.writer.Flush()
+		h.active.Close()
+		h.active = nil
+		h.writer = nil
+		name := h.segmentName(h.activeStart) + historyOpenSuffix
+		go func() {
+			if err := h.sortSegment(name); err != nil {
+				common.LogWarning(common.Debug, "Failed to sort translation history segment:", err)
+			}
+		}()
+	}
+	h.removeExpired()
+	if err := h.openSegment(now); err != nil {
+		common.LogWarning(common.Debug, "Failed to open translation history segment:", err)
+	}
+}
+
+// Sorts records of finished segment and replaces it with sorted
+// segment file. Records are sorted without store lock, which is
+// taken only to replace segment file, so that lookups see either
+// finished or sorted segment.
+func (h *historyStore) sortSegment(name string) error {
+	base := strings.TrimSuffix(name, historyOpenSuffix)
+	tmp := base + historySortedSuffix + ".tmp"
+	if err := sortHistorySegment(name, tmp); err != nil {
+		return err
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	sorted, err := sortedSegmentName(base)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, sorted); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+// Returns name of sorted segment which doesn't exist yet. Segment of
+// a period which already has sorted segment gets a sequence number.
+func sortedSegmentName(base string) (string, error) {
+	name := base + historySortedSuffix
+	for seq := 1; ; seq++ {
+		_, err := os.Stat(name)
+		if os.IsNotExist(err) {
+			return name, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		name = fmt.Sprintf("%s-%d%s", base, seq, historySortedSuffix)
+	}
+}
+
+// Writes records of finished segment sorted to another file.
+// Incomplete record at the end of file is discarded.
+func sortHistorySegment(name, out string) error {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	n := len(data) / historyRecordSize
+	records := make([][]byte, n)
+	for i := range records {
+		records[i] = data[i*historyRecordSize : (i+1)*historyRecordSize]
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if c := bytes.Compare(records[i][:historyKeySize], records[j][:historyKeySize]); c != 0 {
+			return c < 0
+		}
+		return bytes.Compare(records[i][historyStart:historyEnd], records[j][historyStart:historyEnd]) < 0
+	})
+	sorted := make([]byte, 0, n*historyRecordSize)
+	for _, r := range records {
+		sorted = append(sorted, r...)
+	}
+
+	return ioutil.WriteFile(out, sorted, 0644)
+}
+
+// Removes sorted segments which records are older than retention
+// period.
+func (h *historyStore) removeExpired() {
+	names, err := filepath.Glob(filepath.Join(h.dir, "*"+historySortedSuffix))
+	if err != nil {
+		return
+	}
+	for _, name := range names {
+		start, ok := historySegmentStart(name, historySortedSuffix)
+		if ok && time.Since(start.Add(historySegmentDuration)) > h.retention {
+			if err := os.Remove(name); err != nil {
+				common.LogWarning(common.Debug, "Failed to remove translation history segment:", err)
+			}
+		}
+	}
+}
+
+// Finds finished sessions which used public address and port at time
+// t. Zero protocol matches any protocol.
+func (h *historyStore) find(pub hostAddr, port uint16, protocol uint8, t time.Time) ([]historyRecord, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.writer != nil {
+		if err := h.writer.Flush(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Active segment and finished segments which are being sorted
+	// are searched sequentially
+	key := historyKey(pub, port)
+	var res []historyRecord
+	for _, suffix := range []string{historySortedSuffix, historyOpenSuffix} {
+		names, err := filepath.Glob(filepath.Join(h.dir, "*"+suffix))
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			// Records are written after their sessions end, so
+			// earlier segments contain sessions which ended before t
+			start, ok := historySegmentStart(name, suffix)
+			if !ok || !start.Add(historySegmentDuration).After(t) {
+				continue
+			}
+			var found []historyRecord
+			if suffix == historySortedSuffix {
+				found, err = findInSortedSegment(name, key, protocol, t.UnixNano())
+			} else {
+				found, err = findInOpenSegment(name, key, protocol, t.UnixNano())
+			}
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, found...)
+		}
+	}
+	return res, nil
+}
+
+// Finds matching records in sorted segment with binary search.
+func findInSortedSegment(name string, key []byte, protocol uint8, t int64) ([]historyRecord, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var b [historyRecordSize]byte
+	var readErr error
+	n := int(info.Size() / historyRecordSize)
+	first := sort.Search(n, func(i int) bool {
+		if _, err := f.ReadAt(b[:], int64(i)*historyRecordSize); err != nil {
+			readErr = err
+			return true
+		}
+		return bytes.Compare(b[:historyKeySize], key) >= 0
+	})
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	var res []historyRecord
+	for i := first; i < n; i++ {
+		if _, err := f.ReadAt(b[:], int64(i)*historyRecordSize); err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(b[:historyKeySize], key) {
+			break
+		}
+		// Records with the same key are ordered by start time
+		if int64(binary.BigEndian.Uint64(b[historyStart:])) > t {
+			break
+		}
+		if historyRecordMatches(b[:], protocol, t) {
+			res = append(res, decodeHistoryRecord(b[:]))
+		}
+	}
+	return res, nil
+}
+
+// Finds matching records in segment which is not sorted yet.
+func findInOpenSegment(name string, key []byte, protocol uint8, t int64) ([]historyRecord, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var res []historyRecord
+	buf := make([]byte, historyRecordsRead*historyRecordSize)
+	for {
+		n, err := io.ReadFull(f, buf)
+		for off := 0; off+historyRecordSize <= n; off += historyRecordSize {
+			b := buf[off : off+historyRecordSize]
+			if bytes.Equal(b[:historyKeySize], key) && historyRecordMatches(b, protocol, t) {
+				res = append(res, decodeHistoryRecord(b))
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return res, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Finds active sessions of port pair which have used public address
+// and port since time t or earlier.
+func (pp *portPair) findActiveSessions(pub hostAddr, port uint16, protocol uint8, t time.Time, pair int) []historyRecord {
+	var res []historyRecord
+	pp.forEachSession(&sessionFilter{protocol: protocol, pub: &pub}, func(s *sessionInfo) bool {
+		if s.port != port || s.pme.created.IsZero() || s.pme.created.After(t) {
+			return true
+		}
+		r := historyRecord{
+			pair:     pair,
+			protocol: s.protocol,
+			start:    s.pme.created,
+		}
+		r.pub, r.pubPort, _, _ = getTupleEndpoints(s.pub)
+		r.priv, r.privPort, r.remote, r.remotePort = getTupleEndpoints(s.priv)
+		res = append(res, r)
+		return true
+	})
+	return res
+}
+
+// Returns record for session history replies.
+func (r *historyRecord) toProto() *upd.SessionHistoryRecord {
+	res := &upd.SessionHistoryRecord{
+		Protocol:       uint32(r.protocol),
+		PrivateAddress: &upd.IPAddress{Address: r.priv.bytes()},
+		PrivatePort:    uint32(r.privPort),
+		PublicAddress:  &upd.IPAddress{Address: r.pub.bytes()},
+		PublicPort:     uint32(r.pubPort),
+		StartUnixNano:  r.start.UnixNano(),
+	}
+	if r.pair < len(Natconfig.PortPairs) {
+		res.InterfaceId = uint32(Natconfig.PortPairs[r.pair].PublicPort.Index)
+	}
+	if !r.remote.isZero() {
+		res.RemoteAddress = &upd.IPAddress{Address: r.remote.bytes()}
+		res.RemotePort = uint32(r.remotePort)
+	}
+	if !r.end.IsZero() {
+		res.EndUnixNano = r.end.UnixNano()
+	}
+	return res
+}
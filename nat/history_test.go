@@ -0,0 +1,243 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+func testHistoryRecord(pubPort uint16, protocol uint8, start, end time.Time) historyRecord {
+	return historyRecord{
+		pair:       1,
+		protocol:   protocol,
+		pub:        hostAddr{Addr4: 0xc0000201},
+		priv:       hostAddr{Addr4: 0x0a000001},
+		remote:     hostAddr{Addr4: 0xc6336401},
+		pubPort:    pubPort,
+		privPort:   5000,
+		remotePort: 80,
+		start:      start,
+		end:        end,
+	}
+}
+
+func testHistoryRecordsEqual(a, b *historyRecord) bool {
+	return a.pair == b.pair && a.protocol == b.protocol &&
+		a.pub == b.pub && a.priv == b.priv && a.remote == b.remote &&
+		a.pubPort == b.pubPort && a.privPort == b.privPort && a.remotePort == b.remotePort &&
+		a.start.Equal(b.start) && a.end.Equal(b.end)
+}
+
+func TestHistoryRecordEncoding(t *testing.T) {
+	start := time.Date(2019, 3, 1, 12, 0, 0, 123456789, time.UTC)
+	end := start.Add(90 * time.Second)
+	tests := []struct {
+		name   string
+		record historyRecord
+	}{
+		{"IPv4", testHistoryRecord(40000, types.TCPNumber, start, end)},
+		{"IPv6", historyRecord{
+			pair:       0,
+			protocol:   types.UDPNumber,
+			pub:        hostAddr{Addr6: testIPv6Address("2001:db8::1"), ipv6: true},
+			priv:       hostAddr{Addr6: testIPv6Address("fd00::1"), ipv6: true},
+			remote:     hostAddr{Addr6: testIPv6Address("2001:db8:ffff::1"), ipv6: true},
+			pubPort:    65535,
+			privPort:   1,
+			remotePort: 53,
+			start:      start,
+			end:        end,
+		}},
+		{"NAT64", historyRecord{
+			pair:       3,
+			protocol:   types.ICMPNumber,
+			pub:        hostAddr{Addr4: 0xc0000201},
+			priv:       hostAddr{Addr6: testIPv6Address("fd00::1"), ipv6: true},
+			remote:     hostAddr{Addr6: testIPv6Address("64:ff9b::c633:6401"), ipv6: true},
+			pubPort:    1024,
+			privPort:   7,
+			remotePort: 0,
+			start:      start,
+			end:        end,
+		}},
+		{"AddressOnly", historyRecord{
+			pair:     0,
+			protocol: greNumber,
+			pub:      hostAddr{Addr4: 0xc0000201},
+			priv:     hostAddr{Addr4: 0x0a000001},
+			pubPort:  2000,
+			start:    start,
+			end:      end,
+		}},
+	}
+	for _, tt := range tests {
+		var b [historyRecordSize]byte
+		tt.record.encode(b[:])
+		if r := decodeHistoryRecord(b[:]); !testHistoryRecordsEqual(&r, &tt.record) {
+			t.Errorf("%s: decoded %+v, expected %+v", tt.name, r, tt.record)
+		}
+		key := historyKey(tt.record.pub, tt.record.pubPort)
+		if string(b[:historyKeySize]) != string(key) {
+			t.Errorf("%s: record starts with %x, expected key %x", tt.name, b[:historyKeySize], key)
+		}
+	}
+}
+
+func TestHistoryRecordMatches(t *testing.T) {
+	start := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	end := start.Add(time.Minute)
+	var b [historyRecordSize]byte
+	r := testHistoryRecord(40000, types.TCPNumber, start, end)
+	r.encode(b[:])
+	tests := []struct {
+		name     string
+		protocol uint8
+		t        time.Time
+		matches  bool
+	}{
+		{"Start", types.TCPNumber, start, true},
+		{"End", types.TCPNumber, end, true},
+		{"Middle", types.TCPNumber, start.Add(time.Second), true},
+		{"AnyProtocol", 0, start.Add(time.Second), true},
+		{"OtherProtocol", types.UDPNumber, start.Add(time.Second), false},
+		{"BeforeStart", types.TCPNumber, start.Add(-time.Nanosecond), false},
+		{"AfterEnd", types.TCPNumber, end.Add(time.Nanosecond), false},
+	}
+	for _, tt := range tests {
+		if matches := historyRecordMatches(b[:], tt.protocol, tt.t.UnixNano()); matches != tt.matches {
+			t.Errorf("%s: matches is %t, expected %t", tt.name, matches, tt.matches)
+		}
+	}
+}
+
+func TestHistorySegmentStart(t *testing.T) {
+	start := time.Date(2019, 3, 1, 12, 10, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		file   string
+		suffix string
+		ok     bool
+	}{
+		{"Open", "20190301T121000.open", historyOpenSuffix, true},
+		{"Sorted", "20190301T121000.seg", historySortedSuffix, true},
+		{"SortedWithSequence", "20190301T121000-2.seg", historySortedSuffix, true},
+		{"OtherSuffix", "20190301T121000.seg.tmp", historySortedSuffix, false},
+		{"NotTime", "history.seg", historySortedSuffix, false},
+	}
+	for _, tt := range tests {
+		s, ok := historySegmentStart(filepath.Join("/var/lib/nat", tt.file), tt.suffix)
+		if ok != tt.ok {
+			t.Errorf("%s: parsed %t, expected %t", tt.name, ok, tt.ok)
+			continue
+		}
+		if ok && !s.Equal(start) {
+			t.Errorf("%s: segment starts at %v, expected %v", tt.name, s, start)
+		}
+	}
+}
+
+// Writes records to unsorted segment file which starts at time start.
+func testWriteHistorySegment(t *testing.T, h *historyStore, start time.Time, records []historyRecord) string {
+	name := h.segmentName(start) + historyOpenSuffix
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var b [historyRecordSize]byte
+	for i := range records {
+		records[i].encode(b[:])
+		if _, err := f.Write(b[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return name
+}
+
+func TestHistoryFind(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nat-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	h := &historyStore{
+		dir:       dir,
+		retention: time.Duration(defaultHistoryRetention),
+	}
+
+	period := time.Now().Truncate(historySegmentDuration).Add(-historySegmentDuration)
+	at := func(d time.Duration) time.Time {
+		return period.Add(d)
+	}
+	// Two sorted segments of the same period, like ones written
+	// before and after restart, and the active segment
+	first := testWriteHistorySegment(t, h, period, []historyRecord{
+		testHistoryRecord(40001, types.TCPNumber, at(1*time.Minute), at(3*time.Minute)),
+		testHistoryRecord(40000, types.TCPNumber, at(0), at(2*time.Minute)),
+		testHistoryRecord(40000, types.UDPNumber, at(1*time.Minute), at(4*time.Minute)),
+		testHistoryRecord(40000, types.TCPNumber, at(5*time.Minute), at(6*time.Minute)),
+	})
+	if err := h.sortSegment(first); err != nil {
+		t.Fatal(err)
+	}
+	second := testWriteHistorySegment(t, h, period, []historyRecord{
+		testHistoryRecord(40000, types.TCPNumber, at(7*time.Minute), at(8*time.Minute)),
+	})
+	if err := h.sortSegment(second); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"", "-1"} {
+		if _, err := os.Stat(h.segmentName(period) + name + historySortedSuffix); err != nil {
+			t.Errorf("Sorted segment is missing: %v", err)
+		}
+	}
+	testWriteHistorySegment(t, h, period.Add(historySegmentDuration), []historyRecord{
+		testHistoryRecord(40000, types.TCPNumber, at(9*time.Minute), at(11*time.Minute)),
+	})
+
+	pub := hostAddr{Addr4: 0xc0000201}
+	other := hostAddr{Addr4: 0xc0000202}
+	tests := []struct {
+		name     string
+		pub      hostAddr
+		port     uint16
+		protocol uint8
+		t        time.Time
+		// Start times of found sessions
+		starts []time.Time
+	}{
+		{"FirstSession", pub, 40000, types.TCPNumber, at(30 * time.Second), []time.Time{at(0)}},
+		{"AnyProtocol", pub, 40000, 0, at(90 * time.Second), []time.Time{at(0), at(1 * time.Minute)}},
+		{"OtherPort", pub, 40001, types.TCPNumber, at(2 * time.Minute), []time.Time{at(1 * time.Minute)}},
+		{"LaterSessionSameSegment", pub, 40000, types.TCPNumber, at(330 * time.Second), []time.Time{at(5 * time.Minute)}},
+		{"SegmentAfterRestart", pub, 40000, types.TCPNumber, at(450 * time.Second), []time.Time{at(7 * time.Minute)}},
+		{"ActiveSegment", pub, 40000, types.TCPNumber, at(10 * time.Minute), []time.Time{at(9 * time.Minute)}},
+		{"NoSession", pub, 40000, types.TCPNumber, at(270 * time.Second), nil},
+		{"UnknownPort", pub, 40002, 0, at(1 * time.Minute), nil},
+		{"OtherAddress", other, 40000, 0, at(1 * time.Minute), nil},
+	}
+	for _, tt := range tests {
+		found, err := h.find(tt.pub, tt.port, tt.protocol, tt.t)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(found) != len(tt.starts) {
+			t.Errorf("%s: found %d sessions, expected %d", tt.name, len(found), len(tt.starts))
+			continue
+		}
+		for i := range found {
+			if found[i].pub != tt.pub || found[i].pubPort != tt.port || !found[i].start.Equal(tt.starts[i]) {
+				t.Errorf("%s: found session %+v, expected start %v", tt.name, found[i], tt.starts[i])
+			}
+		}
+	}
+}
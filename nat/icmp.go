@@ -5,22 +5,25 @@
 package nat
 
 import (
-	"time"
-
 	"github.com/intel-go/nff-go/common"
 	"github.com/intel-go/nff-go/packet"
 	"github.com/intel-go/nff-go/types"
 )
 
-func (port *ipPort) handleICMP(protocol uint8, pkt *packet.Packet, key interface{}) uint {
+func (port *ipPort) handleICMP(protocol uint8, pkt *packet.Packet, key sessionKey) uint {
 	// Check that received ICMP packet is addressed at this host. If
 	// not, packet should be translated
 	var requestCode uint8
 	var packetSentToUs bool
+	var packetSentToPool bool
 	var packetSentToMulticast bool
 	if protocol == types.ICMPNumber {
-		if packet.SwapBytesIPv4Addr(pkt.GetIPv4NoCheck().DstAddr) == port.Subnet.Addr {
+		dstAddr := packet.SwapBytesIPv4Addr(pkt.GetIPv4NoCheck().DstAddr)
+		if dstAddr == port.Subnet.Addr {
+			packetSentToUs = true
+		} else if port.AddressPool.contains(dstAddr) {
 			packetSentToUs = true
+			packetSentToPool = true
 		}
 		requestCode = types.ICMPTypeEchoRequest
 	} else {
@@ -30,8 +33,13 @@ func (port *ipPort) handleICMP(protocol uint8, pkt *packet.Packet, key interface
 		if ipv6.DstAddr == port.Subnet6.Addr ||
 			ipv6.DstAddr == port.Subnet6.llAddr {
 			packetSentToUs = true
+		} else if port.AddressPool.contains6(ipv6.DstAddr) || port.isStaticPublicAddr6(ipv6.DstAddr) {
+			packetSentToUs = true
+			packetSentToPool = true
 		} else if ipv6.DstAddr == port.Subnet6.multicastAddr ||
-			ipv6.DstAddr == port.Subnet6.llMulticastAddr {
+			ipv6.DstAddr == port.Subnet6.llMulticastAddr ||
+			port.AddressPool.multicast6[ipv6.DstAddr] ||
+			port.isStaticMulticast6(ipv6.DstAddr) {
 			packetSentToMulticast = true
 		}
 		requestCode = types.ICMPv6TypeEchoRequest
@@ -53,14 +61,13 @@ func (port *ipPort) handleICMP(protocol uint8, pkt *packet.Packet, key interface
 	// If there is KNI interface, direct all ICMP traffic which
 	// doesn't have an active translation entry. It may happen only
 	// for public->private translation when all packets are directed
-	// to NAT public interface IP, so port.portmap exists because port
-	// is public.
-	if packetSentToUs && port.KNIName != "" {
-		if key != nil {
-			_, ok := port.translationTable[protocol].Load(key)
-			if !ok || time.Since(port.getPortmap(ipv6, protocol)[packet.SwapBytesUint16(icmp.Identifier)].lastused) > connectionTimeout {
-				return DirKNI
-			}
+	// to NAT public interface IP, so port.pool exists because port
+	// is public. Packets sent to address pool are not directed to
+	// KNI because KNI interface doesn't have these addresses.
+	if packetSentToUs && !packetSentToPool && port.KNIName != "" {
+		pme, ok := port.translationTable[protocol].Load(key)
+		if !ok || pme.expired(protocol) {
+			return DirKNI
 		}
 	}
 
@@ -94,5 +101,5 @@ func (port *ipPort) handleICMP(protocol uint8, pkt *packet.Packet, key interface
 
 	port.dumpPacket(answerPacket, DirSEND)
 	answerPacket.SendPacket(port.Index)
-	return DirDROP
+	return dropResult(dropHandledLocally)
 }
@@ -0,0 +1,372 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"unsafe"
+
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/types"
+)
+
+const (
+	icmpTypeDestUnreachable  uint8 = 3
+	icmpTypeTimeExceeded     uint8 = 11
+	icmpTypeParameterProblem uint8 = 12
+
+	icmpv6TypeDestUnreachable  uint8 = 1
+	icmpv6TypePacketTooBig     uint8 = 2
+	icmpv6TypeTimeExceeded     uint8 = 3
+	icmpv6TypeParameterProblem uint8 = 4
+)
+
+// Checks whether ICMP message is an error message which carries
+// original packet that caused it.
+func isICMPError(protocol uint8, icmp *packet.ICMPHdr) bool {
+	if protocol == types.ICMPNumber {
+		return icmp.Type == icmpTypeDestUnreachable ||
+			icmp.Type == icmpTypeTimeExceeded ||
+			icmp.Type == icmpTypeParameterProblem
+	}
+	return icmp.Type == icmpv6TypeDestUnreachable ||
+		icmp.Type == icmpv6TypePacketTooBig ||
+		icmp.Type == icmpv6TypeTimeExceeded ||
+		icmp.Type == icmpv6TypeParameterProblem
+}
+
+// Headers of a packet which L4 checksum covers data that is not
+// available. It is either original packet embedded into ICMP error
+// message, which is usually truncated so that L4 checksum may be
+// missing, or first fragment of a fragmented packet.
+type partialPacket struct {
+	ipv4     *packet.IPv4Hdr
+	ipv6     *packet.IPv6Hdr
+	protocol uint8
+	srcPort  *uint16
+	dstPort  *uint16
+	cksum    *uint16
+}
+
+// Parses original packet embedded into ICMP error message. Only TCP,
+// UDP, UDP-Lite, SCTP and ICMP echo packets without IPv6 extension
+// headers can be translated.
+func parseICMPEmbedded(pkt *packet.Packet, ipv6 bool) (*partialPacket, bool) {
+	end := uintptr(unsafe.Pointer(pkt.Ether)) + uintptr(pkt.GetPacketSegmentLen())
+	l3 := uintptr(pkt.L4) + types.ICMPLen
+	emb := partialPacket{}
+
+	var l4 uintptr
+	if ipv6 {
+		if l3+types.IPv6Len > end {
+			return nil, false
+		}
+		emb.ipv6 = (*packet.IPv6Hdr)(unsafe.Pointer(l3))
+		if emb.ipv6.VtcFlow&0xf0 != 0x60 {
+			return nil, false
+		}
+		emb.protocol = emb.ipv6.Proto
+		l4 = l3 + types.IPv6Len
+	} else {
+		if l3+types.IPv4MinLen > end {
+			return nil, false
+		}
+		emb.ipv4 = (*packet.IPv4Hdr)(unsafe.Pointer(l3))
+		hdrLen := uintptr(emb.ipv4.VersionIhl&0x0f) << 2
+		if emb.ipv4.VersionIhl>>4 != 4 || hdrLen < types.IPv4MinLen {
+			return nil, false
+		}
+		// Non-first fragments don't have L4 header
+		if packet.SwapBytesUint16(emb.ipv4.FragmentOffset)&0x1fff != 0 {
+			return nil, false
+		}
+		emb.protocol = emb.ipv4.NextProtoID
+		l4 = l3 + hdrLen
+	}
+
+	// At least 8 bytes of original L4 header are always present
+	if l4+8 > end {
+		return nil, false
+	}
+	switch emb.protocol {
+	case types.TCPNumber:
+		tcp := (*packet.TCPHdr)(unsafe.Pointer(l4))
+		emb.srcPort = &tcp.SrcPort
+		emb.dstPort = &tcp.DstPort
+		if l4+unsafe.Offsetof(tcp.Cksum)+2 <= end {
+			emb.cksum = &tcp.Cksum
+		}
+	case types.UDPNumber, udpLiteNumber:
+		udp := (*packet.UDPHdr)(unsafe.Pointer(l4))
+		emb.srcPort = &udp.SrcPort
+		emb.dstPort = &udp.DstPort
+		emb.cksum = &udp.DgramCksum
+	case sctpNumber:
+		// SCTP checksum covers the whole original packet, which is
+		// truncated, so only ports are translated
+		sctp := (*packet.UDPHdr)(unsafe.Pointer(l4))
+		emb.srcPort = &sctp.SrcPort
+		emb.dstPort = &sctp.DstPort
+	case types.ICMPNumber, types.ICMPv6Number:
+		if (emb.protocol == types.ICMPNumber) == ipv6 {
+			return nil, false
+		}
+		icmp := (*packet.ICMPHdr)(unsafe.Pointer(l4))
+		if icmp.Type != types.ICMPTypeEchoRequest && icmp.Type != types.ICMPTypeEchoResponse &&
+			icmp.Type != types.ICMPv6TypeEchoRequest && icmp.Type != types.ICMPv6TypeEchoResponse {
+			return nil, false
+		}
+		// Echo identifier is used as both ports
+		emb.srcPort = &icmp.Identifier
+		emb.dstPort = &icmp.Identifier
+		emb.cksum = &icmp.Cksum
+	default:
+		return nil, false
+	}
+	return &emb, true
+}
+
+// Returns packet source or destination address and port.
+func (part *partialPacket) getAddrPort(src bool) (types.IPv4Address, types.IPv6Address, uint16) {
+	port := part.dstPort
+	if src {
+		port = part.srcPort
+	}
+	if part.ipv6 != nil {
+		addr := part.ipv6.DstAddr
+		if src {
+			addr = part.ipv6.SrcAddr
+		}
+		return 0, addr, packet.SwapBytesUint16(*port)
+	}
+	addr := part.ipv4.DstAddr
+	if src {
+		addr = part.ipv4.SrcAddr
+	}
+	return packet.SwapBytesIPv4Addr(addr), zeroIPv6Addr, packet.SwapBytesUint16(*port)
+}
+
+// Changes packet source or destination address and port. Checksums
+// are updated incrementally because packet is not complete and cannot
+// be checksummed again.
+func (part *partialPacket) setAddrPort(src bool, v4addr types.IPv4Address, v6addr types.IPv6Address, port uint16) {
+	portField := part.dstPort
+	if src {
+		portField = part.srcPort
+	}
+	newPort := packet.SwapBytesUint16(port)
+	updateL4 := part.updateL4()
+	if updateL4 {
+		*part.cksum = updateChecksum16(*part.cksum, *portField, newPort)
+	}
+	*portField = newPort
+	part.changeAddr(src, v4addr, v6addr, updateL4)
+}
+
+// UDP checksum may be not used in IPv4
+func (part *partialPacket) updateL4() bool {
+	return part.cksum != nil && !(part.protocol == types.UDPNumber && part.ipv4 != nil && *part.cksum == 0)
+}
+
+// Changes packet source or destination address. Checksums are updated
+// incrementally.
+func (part *partialPacket) setAddr(src bool, v4addr types.IPv4Address, v6addr types.IPv6Address) {
+	part.changeAddr(src, v4addr, v6addr, part.updateL4())
+}
+
+func (part *partialPacket) changeAddr(src bool, v4addr types.IPv4Address, v6addr types.IPv6Address, updateL4 bool) {
+	// ICMPv4 checksum doesn't cover pseudo header
+	pseudoHeader := part.protocol != types.ICMPNumber
+
+	if part.ipv6 != nil {
+		addrField := &part.ipv6.DstAddr
+		if src {
+			addrField = &part.ipv6.SrcAddr
+		}
+		if updateL4 && pseudoHeader {
+			*part.cksum = updateChecksumIPv6Addr(*part.cksum, *addrField, v6addr)
+		}
+		*addrField = v6addr
+	} else {
+		addrField := &part.ipv4.DstAddr
+		if src {
+			addrField = &part.ipv4.SrcAddr
+		}
+		newAddr := packet.SwapBytesIPv4Addr(v4addr)
+		part.ipv4.HdrChecksum = updateChecksumIPv4Addr(part.ipv4.HdrChecksum, *addrField, newAddr)
+		if updateL4 && pseudoHeader {
+			*part.cksum = updateChecksumIPv4Addr(*part.cksum, *addrField, newAddr)
+		}
+		*addrField = newAddr
+	}
+
+	// Zero UDP checksum means that there is no checksum, UDP-Lite
+	// doesn't allow it
+	if updateL4 && (part.protocol == types.UDPNumber || part.protocol == udpLiteNumber) && *part.cksum == 0 {
+		*part.cksum = 0xffff
+	}
+}
+
+func setICMPChecksum(pkt *packet.Packet, ipv6 bool) {
+	if ipv6 {
+		setIPv6ICMPChecksum(pkt, !NoCalculateChecksum, !NoHWTXChecksum)
+	} else {
+		setIPv4ICMPChecksum(pkt, !NoCalculateChecksum, !NoHWTXChecksum)
+	}
+}
+
+// Translates ICMP error message which came from public network
+// (RFC 5508). Original packet embedded into it was sent through NAT
+// by a private host, so its source is public address and port of a
+// translation session. Errors don't refresh session idle time.
+func (pp *portPair) translateICMPErrorPub2Pri(pkt *packet.Packet, pktVLAN *packet.VLANHdr, pktIPv4 *packet.IPv4Hdr, pktIPv6 *packet.IPv6Hdr) uint {
+	port := &pp.PublicPort
+	ipv6 := pktIPv6 != nil
+
+	var addressAcquired bool
+	if ipv6 {
+		addressAcquired = port.Subnet6.addressAcquired
+	} else {
+		addressAcquired = port.Subnet.addressAcquired
+	}
+	// Errors which don't belong to translation sessions may be
+	// caused by KNI interface traffic
+	notFoundDir := dropResult(dropNoSession)
+	if port.KNIName != "" && addressAcquired {
+		notFoundDir = DirKNI
+	}
+
+	emb, ok := parseICMPEmbedded(pkt, ipv6)
+	if !ok {
+		port.dumpPacket(pkt, notFoundDir)
+		return notFoundDir
+	}
+
+	embV4addr, embV6addr, embPort := emb.getAddrPort(true)
+	_, inPool := port.getPoolIndex(ipv6, embV4addr, embV6addr)
+	key := makeTuple(ipv6, embV4addr, embV6addr, embPort)
+	pme, found := port.translationTable[emb.protocol].Load(key)
+	if !found || !inPool {
+		port.dumpPacket(pkt, notFoundDir)
+		return notFoundDir
+	}
+
+	if !pme.static && pme.expired(emb.protocol) {
+		return port.dropPacket(pkt, dropSessionExpired)
+	}
+	// Error should be about a packet sent to remote endpoint which
+	// is allowed by filtering behavior. Error sender itself is
+	// usually an intermediate router.
+	if pme.getRemotes() != nil && !pme.static {
+		remoteV4addr, remoteV6addr, remotePort := emb.getAddrPort(false)
+		remoteKey := makeRemoteKey(pp.FilteringBehavior, ipv6, emb.protocol, remoteV4addr, remoteV6addr, remotePort)
+		if !pme.remoteAllowed(remoteKey) {
+			return port.dropPacket(pkt, dropFiltered)
+		}
+	}
+
+	v4addr, v6addr, privPort, zeroAddr := getAddrFromTuple(pme.priv)
+	if pme.priv.isNAT64() {
+		return pp.translateNAT64ICMPErrorPub2Pri(pkt, pktVLAN, pktIPv4, emb, v6addr, privPort)
+	}
+	if zeroAddr {
+		port.dumpPacket(pkt, DirKNI)
+		return DirKNI
+	}
+
+	// Find corresponding MAC address
+	var mac types.MACAddress
+	if ipv6 {
+		mac, found = port.opposite.getMACForIPv6(v6addr)
+	} else {
+		mac, found = port.opposite.getMACForIPv4(v4addr)
+	}
+	if !found {
+		return port.dropPacket(pkt, dropNeighborUnresolved)
+	}
+
+	// Do packet translation
+	pkt.Ether.DAddr = mac
+	pkt.Ether.SAddr = port.opposite.SrcMACAddress
+	if pktVLAN != nil {
+		pktVLAN.SetVLANTagIdentifier(port.opposite.Vlan)
+	}
+	if ipv6 {
+		pktIPv6.DstAddr = v6addr
+	} else {
+		pktIPv4.DstAddr = packet.SwapBytesIPv4Addr(v4addr)
+	}
+	emb.setAddrPort(true, v4addr, v6addr, privPort)
+	setICMPChecksum(pkt, ipv6)
+
+	port.opposite.dumpPacket(pkt, DirSEND)
+	return DirSEND
+}
+
+// Translates ICMP error message which came from private network
+// (RFC 5508). Original packet embedded into it was received from
+// public network, so its destination is private address and port of
+// a translation session.
+func (pp *portPair) translateICMPErrorPri2Pub(pkt *packet.Packet, pktVLAN *packet.VLANHdr, pktIPv4 *packet.IPv4Hdr, pktIPv6 *packet.IPv6Hdr) uint {
+	port := &pp.PrivatePort
+	ipv6 := pktIPv6 != nil
+
+	emb, ok := parseICMPEmbedded(pkt, ipv6)
+	if !ok {
+		return port.dropPacket(pkt, dropMalformed)
+	}
+
+	embV4addr, embV6addr, embPort := emb.getAddrPort(false)
+	key := makeTuple(ipv6, embV4addr, embV6addr, embPort)
+	pme, found := port.translationTable[emb.protocol].Load(key)
+	if !found && pp.MappingBehavior != endpointIndependent {
+		remoteV4addr, remoteV6addr, remotePort := emb.getAddrPort(true)
+		key = addRemoteToKey(key, pp.MappingBehavior, emb.protocol, remoteV4addr, remoteV6addr, remotePort)
+		pme, found = port.translationTable[emb.protocol].Load(key)
+	}
+	if !found {
+		return port.dropPacket(pkt, dropNoSession)
+	}
+
+	v4addr, v6addr, pubPort, zeroAddr := getAddrFromTuple(pme.pub)
+	if zeroAddr {
+		return port.dropPacket(pkt, dropNoSession)
+	}
+	if _, inPool := pp.PublicPort.getPoolIndex(ipv6, v4addr, v6addr); !inPool {
+		return port.dropPacket(pkt, dropAddressChanged)
+	}
+	if !pme.static && pme.expired(emb.protocol) {
+		return port.dropPacket(pkt, dropSessionExpired)
+	}
+
+	// Find corresponding MAC address
+	var mac types.MACAddress
+	if ipv6 {
+		mac, found = port.opposite.getMACForIPv6(pktIPv6.DstAddr)
+	} else {
+		mac, found = port.opposite.getMACForIPv4(packet.SwapBytesIPv4Addr(pktIPv4.DstAddr))
+	}
+	if !found {
+		return port.dropPacket(pkt, dropNeighborUnresolved)
+	}
+
+	// Do packet translation. Error may be sent by a private router
+	// as well as by private host itself, in both cases its source
+	// becomes public address of translation session.
+	pkt.Ether.DAddr = mac
+	pkt.Ether.SAddr = port.opposite.SrcMACAddress
+	if pktVLAN != nil {
+		pktVLAN.SetVLANTagIdentifier(port.opposite.Vlan)
+	}
+	if ipv6 {
+		pktIPv6.SrcAddr = v6addr
+	} else {
+		pktIPv4.SrcAddr = packet.SwapBytesIPv4Addr(v4addr)
+	}
+	emb.setAddrPort(false, v4addr, v6addr, pubPort)
+	setICMPChecksum(pkt, ipv6)
+
+	port.opposite.dumpPacket(pkt, DirSEND)
+	return DirSEND
+}
@@ -0,0 +1,326 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"unsafe"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/types"
+)
+
+var (
+	testPrivateIPv4 = net.IP{10, 0, 0, 1}
+	testPublicIPv4  = net.IP{192, 0, 2, 1}
+	testRemoteIPv4  = net.IP{198, 51, 100, 1}
+	testPrivateIPv6 = net.ParseIP("fd00::1")
+	testPublicIPv6  = net.ParseIP("2001:db8::1")
+	testRemoteIPv6  = net.ParseIP("2001:db8:1::1")
+	testPublicMAC   = net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	testRemoteMAC   = net.HardwareAddr{0x02, 0, 0, 0, 0, 2}
+)
+
+// Serializes layers into packet bytes with correct lengths and
+// checksums.
+func serializeTestLayers(t *testing.T, l ...gopacket.SerializableLayer) []byte {
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{
+		FixLengths:       true,
+		ComputeChecksums: true,
+	}
+	if err := gopacket.SerializeLayers(buf, opts, l...); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// Returns L3 and L4 layers of IP packet with given protocol, addresses
+// and ports. Echo identifier is taken from source port.
+func testIPLayers(ipv6 bool, protocol layers.IPProtocol, src, dst net.IP, srcPort, dstPort uint16) []gopacket.SerializableLayer {
+	var ip gopacket.SerializableLayer
+	var network gopacket.NetworkLayer
+	if ipv6 {
+		ip6 := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: protocol, SrcIP: src, DstIP: dst}
+		ip, network = ip6, ip6
+	} else {
+		ip4 := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: protocol, SrcIP: src, DstIP: dst}
+		ip, network = ip4, ip4
+	}
+	result := []gopacket.SerializableLayer{ip}
+	switch protocol {
+	case layers.IPProtocolTCP:
+		tcp := &layers.TCP{SrcPort: layers.TCPPort(srcPort), DstPort: layers.TCPPort(dstPort), Seq: 1000, ACK: true, Ack: 5000, Window: 1024}
+		tcp.SetNetworkLayerForChecksum(network)
+		result = append(result, tcp)
+	case layers.IPProtocolUDP:
+		udp := &layers.UDP{SrcPort: layers.UDPPort(srcPort), DstPort: layers.UDPPort(dstPort)}
+		udp.SetNetworkLayerForChecksum(network)
+		result = append(result, udp)
+	case layers.IPProtocolICMPv4:
+		result = append(result, &layers.ICMPv4{
+			TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoRequest, 0),
+			Id:       srcPort,
+			Seq:      1,
+		})
+	case layers.IPProtocolICMPv6:
+		icmp := &layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeEchoRequest, 0)}
+		icmp.SetNetworkLayerForChecksum(network)
+		result = append(result, icmp, &layers.ICMPv6Echo{Identifier: srcPort, SeqNumber: 1})
+	}
+	return append(result, gopacket.Payload("embedded payload"))
+}
+
+// Returns ICMP destination unreachable message carrying embedded
+// packet.
+func testICMPError(t *testing.T, ipv6 bool, embedded []byte) []byte {
+	if ipv6 {
+		ip := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolICMPv6, SrcIP: testRemoteIPv6, DstIP: testPublicIPv6}
+		icmp := &layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeDestinationUnreachable, 0)}
+		icmp.SetNetworkLayerForChecksum(ip)
+		eth := &layers.Ethernet{SrcMAC: testRemoteMAC, DstMAC: testPublicMAC, EthernetType: layers.EthernetTypeIPv6}
+		// Four unused bytes precede embedded packet
+		return serializeTestLayers(t, eth, ip, icmp, gopacket.Payload(append(make([]byte, 4), embedded...)))
+	}
+	ip := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolICMPv4, SrcIP: testRemoteIPv4, DstIP: testPublicIPv4}
+	icmp := &layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeDestinationUnreachable, 0)}
+	eth := &layers.Ethernet{SrcMAC: testRemoteMAC, DstMAC: testPublicMAC, EthernetType: layers.EthernetTypeIPv4}
+	return serializeTestLayers(t, eth, ip, icmp, gopacket.Payload(embedded))
+}
+
+// Returns packet with given bytes which has L3 and L4 headers parsed.
+func newTestPacket(t *testing.T, data []byte) *packet.Packet {
+	pkt, err := packet.NewPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !packet.GeneratePacketFromByte(pkt, data) {
+		t.Fatal("cannot generate packet")
+	}
+	pkt.ParseL3()
+	if pkt.GetIPv4() != nil {
+		pkt.ParseL4ForIPv4()
+	} else {
+		pkt.ParseL4ForIPv6()
+	}
+	return pkt
+}
+
+// Returns bytes of packet embedded into ICMP error message.
+func testEmbeddedBytes(pkt *packet.Packet, length int) []byte {
+	start := uintptr(pkt.L4) + types.ICMPLen
+	return (*[types.MaxLength]byte)(unsafe.Pointer(start))[:length:length]
+}
+
+func TestParseICMPEmbedded(t *testing.T) {
+	type embeddedPacket struct {
+		ipv6     bool
+		protocol layers.IPProtocol
+	}
+	tcp4 := embeddedPacket{false, layers.IPProtocolTCP}
+	udp4 := embeddedPacket{false, layers.IPProtocolUDP}
+	icmp4 := embeddedPacket{false, layers.IPProtocolICMPv4}
+	tcp6 := embeddedPacket{true, layers.IPProtocolTCP}
+	udp6 := embeddedPacket{true, layers.IPProtocolUDP}
+	icmp6 := embeddedPacket{true, layers.IPProtocolICMPv6}
+
+	tests := []struct {
+		name     string
+		embedded embeddedPacket
+		// Modifies serialized embedded packet
+		modify func([]byte) []byte
+		ok     bool
+		cksum  bool
+	}{
+		{"TCP", tcp4, nil, true, true},
+		{"UDP", udp4, nil, true, true},
+		{"ICMPEcho", icmp4, nil, true, true},
+		{"TCPv6", tcp6, nil, true, true},
+		{"UDPv6", udp6, nil, true, true},
+		{"ICMPv6Echo", icmp6, nil, true, true},
+		{"TruncatedTCP", tcp4, func(b []byte) []byte { return b[:types.IPv4MinLen+8] }, true, false},
+		{"TruncatedTCPv6", tcp6, func(b []byte) []byte { return b[:types.IPv6Len+8] }, true, false},
+		{"TruncatedL4", udp4, func(b []byte) []byte { return b[:types.IPv4MinLen+4] }, false, false},
+		{"TruncatedL3", udp6, func(b []byte) []byte { return b[:types.IPv6Len-1] }, false, false},
+		{"WrongVersion", udp4, func(b []byte) []byte { b[0] = 0x65; return b }, false, false},
+		{"WrongVersionv6", udp6, func(b []byte) []byte { b[0] = 0x40; return b }, false, false},
+		{"ShortIHL", udp4, func(b []byte) []byte { b[0] = 0x44; return b }, false, false},
+		{"NonFirstFragment", udp4, func(b []byte) []byte { b[7] = 1; return b }, false, false},
+		{"ICMPv6InIPv4", icmp4, func(b []byte) []byte { b[9] = types.ICMPv6Number; return b }, false, false},
+		{"ICMPInIPv6", icmp6, func(b []byte) []byte { b[6] = types.ICMPNumber; return b }, false, false},
+		{"ICMPNotEcho", icmp4, func(b []byte) []byte { b[types.IPv4MinLen] = icmpTypeDestUnreachable; return b }, false, false},
+		{"UnsupportedProtocol", udp4, func(b []byte) []byte { b[9] = 47; return b }, false, false},
+	}
+	for _, tt := range tests {
+		src, dst := testPublicIPv4, testRemoteIPv4
+		if tt.embedded.ipv6 {
+			src, dst = testPublicIPv6, testRemoteIPv6
+		}
+		embedded := serializeTestLayers(t, testIPLayers(tt.embedded.ipv6, tt.embedded.protocol, src, dst, 1024, 80)...)
+		if tt.modify != nil {
+			embedded = tt.modify(embedded)
+		}
+		pkt := newTestPacket(t, testICMPError(t, tt.embedded.ipv6, embedded))
+
+		emb, ok := parseICMPEmbedded(pkt, tt.embedded.ipv6)
+		if ok != tt.ok {
+			t.Errorf("%s: parsed %t, expected %t", tt.name, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if hasCksum := emb.cksum != nil; hasCksum != tt.cksum {
+			t.Errorf("%s: checksum present %t, expected %t", tt.name, hasCksum, tt.cksum)
+		}
+		wantDstPort := uint16(80)
+		if tt.embedded.protocol == layers.IPProtocolICMPv4 || tt.embedded.protocol == layers.IPProtocolICMPv6 {
+			wantDstPort = 1024
+		}
+		srcAddr4, srcAddr6, srcPort := emb.getAddrPort(true)
+		_, _, dstPort := emb.getAddrPort(false)
+		if srcPort != 1024 || dstPort != wantDstPort {
+			t.Errorf("%s: ports are %d and %d, expected 1024 and %d", tt.name, srcPort, dstPort, wantDstPort)
+		}
+		if tt.embedded.ipv6 {
+			if !net.IP(srcAddr6[:]).Equal(testPublicIPv6) {
+				t.Errorf("%s: source address is %v", tt.name, net.IP(srcAddr6[:]))
+			}
+		} else if srcAddr4 != packet.SwapBytesIPv4Addr(types.SliceToIPv4(testPublicIPv4)) {
+			t.Errorf("%s: source address is %v", tt.name, srcAddr4)
+		}
+	}
+}
+
+func TestSetICMPEmbeddedAddrPort(t *testing.T) {
+	tests := []struct {
+		name     string
+		ipv6     bool
+		protocol layers.IPProtocol
+		// Whether source or destination of embedded packet is changed
+		src bool
+		// IPv4 UDP packet without checksum
+		noUDPChecksum bool
+	}{
+		{"TCPSource", false, layers.IPProtocolTCP, true, false},
+		{"TCPDestination", false, layers.IPProtocolTCP, false, false},
+		{"UDPSource", false, layers.IPProtocolUDP, true, false},
+		{"UDPDestination", false, layers.IPProtocolUDP, false, false},
+		{"UDPNoChecksum", false, layers.IPProtocolUDP, true, true},
+		{"ICMPEcho", false, layers.IPProtocolICMPv4, true, false},
+		{"TCPv6Source", true, layers.IPProtocolTCP, true, false},
+		{"TCPv6Destination", true, layers.IPProtocolTCP, false, false},
+		{"UDPv6Source", true, layers.IPProtocolUDP, true, false},
+		{"ICMPv6Echo", true, layers.IPProtocolICMPv6, true, false},
+	}
+	for _, tt := range tests {
+		// Embedded packet was sent by private host to remote one
+		// and its source is translated back to private address
+		// and port. Destination is changed in the same way in the
+		// opposite direction.
+		oldAddr, newAddr, remote := testPublicIPv4, testPrivateIPv4, testRemoteIPv4
+		if tt.ipv6 {
+			oldAddr, newAddr, remote = testPublicIPv6, testPrivateIPv6, testRemoteIPv6
+		}
+		const oldPort, newPort, remotePort = 1024, 5000, 80
+		var original, expected []byte
+		if tt.src {
+			original = serializeTestLayers(t, testIPLayers(tt.ipv6, tt.protocol, oldAddr, remote, oldPort, remotePort)...)
+			expected = serializeTestLayers(t, testIPLayers(tt.ipv6, tt.protocol, newAddr, remote, newPort, remotePort)...)
+		} else {
+			original = serializeTestLayers(t, testIPLayers(tt.ipv6, tt.protocol, remote, oldAddr, remotePort, oldPort)...)
+			expected = serializeTestLayers(t, testIPLayers(tt.ipv6, tt.protocol, remote, newAddr, remotePort, newPort)...)
+		}
+		if tt.noUDPChecksum {
+			cksum := types.IPv4MinLen + 6
+			original[cksum], original[cksum+1] = 0, 0
+			expected[cksum], expected[cksum+1] = 0, 0
+		}
+		pkt := newTestPacket(t, testICMPError(t, tt.ipv6, original))
+		emb, ok := parseICMPEmbedded(pkt, tt.ipv6)
+		if !ok {
+			t.Errorf("%s: cannot parse embedded packet", tt.name)
+			continue
+		}
+
+		var v4addr types.IPv4Address
+		var v6addr types.IPv6Address
+		if tt.ipv6 {
+			copy(v6addr[:], newAddr)
+		} else {
+			v4addr = packet.SwapBytesIPv4Addr(types.SliceToIPv4(newAddr))
+		}
+		emb.setAddrPort(tt.src, v4addr, v6addr, newPort)
+
+		if result := testEmbeddedBytes(pkt, len(expected)); !bytes.Equal(result, expected) {
+			t.Errorf("%s: embedded packet is\n%x\nexpected\n%x", tt.name, result, expected)
+		}
+	}
+}
+
+// Calculates checksum of data in the same byte order in which packet
+// fields are accessed.
+func testChecksum(data []byte) uint16 {
+	var sum uint32
+	for i := 0; i < len(data); i += 2 {
+		sum += uint32(binary.LittleEndian.Uint16(data[i:]))
+	}
+	for sum > 0xffff {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
+
+func TestUpdateChecksum(t *testing.T) {
+	header := []byte{
+		0x45, 0x00, 0x00, 0x54, 0x12, 0x34, 0x40, 0x00,
+		0x40, 0x01, 0x00, 0x00, 0x0a, 0x00, 0x00, 0x01,
+		0xc6, 0x33, 0x64, 0x01, 0xff, 0xff, 0x00, 0x00,
+	}
+	tests := []struct {
+		name   string
+		offset int
+		value  []byte
+	}{
+		{"Field", 2, []byte{0x05, 0xdc}},
+		{"FieldToZero", 4, []byte{0x00, 0x00}},
+		{"FieldFromAllOnes", 20, []byte{0x12, 0x34}},
+		{"FieldToAllOnes", 22, []byte{0xff, 0xff}},
+		{"IPv4Address", 12, []byte{192, 0, 2, 1}},
+		{"IPv4AddressAllOnes", 16, []byte{255, 255, 255, 255}},
+		{"IPv6Address", 4, net.ParseIP("2001:db8::1")},
+		{"IPv6AddressZero", 8, make([]byte, types.IPv6AddrLen)},
+	}
+	for _, tt := range tests {
+		data := append([]byte(nil), header...)
+		cksum := testChecksum(data)
+		old := data[tt.offset : tt.offset+len(tt.value)]
+
+		var updated uint16
+		switch len(tt.value) {
+		case 2:
+			updated = updateChecksum16(cksum, binary.LittleEndian.Uint16(old), binary.LittleEndian.Uint16(tt.value))
+		case 4:
+			updated = updateChecksumIPv4Addr(cksum,
+				types.IPv4Address(binary.LittleEndian.Uint32(old)),
+				types.IPv4Address(binary.LittleEndian.Uint32(tt.value)))
+		default:
+			var oldAddr, newAddr types.IPv6Address
+			copy(oldAddr[:], old)
+			copy(newAddr[:], tt.value)
+			updated = updateChecksumIPv6Addr(cksum, oldAddr, newAddr)
+		}
+
+		copy(old, tt.value)
+		if expected := testChecksum(data); updated != expected {
+			t.Errorf("%s: checksum is %04x, expected %04x", tt.name, updated, expected)
+		}
+	}
+}
@@ -0,0 +1,402 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"encoding/binary"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/types"
+)
+
+// NAT events are exported as IPFIX (RFC 7011) records according to
+// RFC 8158. Events are queued by packet handlers without blocking and
+// are sent to collector in batches by a separate goroutine.
+const (
+	ipfixVersion     = 10
+	ipfixTemplateSet = 2
+	ipfixHeaderSize  = 16
+	ipfixSetHdrSize  = 4
+	// Messages are kept small enough to avoid IP fragmentation
+	ipfixMaxMessageSize = 1400
+	// Number of events which may wait for export. Events which don't
+	// fit are lost.
+	ipfixQueueSize = 8192
+	// How often queued records are sent if message is not full
+	ipfixFlushInterval = time.Second
+	// How often templates are resent because collector may be
+	// restarted and UDP doesn't tell about it
+	ipfixTemplateRefresh = time.Minute
+)
+
+// Values of natEvent information element
+const (
+	natEventNAT44SessionCreate uint8 = 1
+	natEventNAT44SessionDelete uint8 = 2
+	natEventNAT64SessionCreate uint8 = 4
+	natEventNAT64SessionDelete uint8 = 5
+	natEventPortsExhausted     uint8 = 10
+)
+
+// IPFIX information elements used in templates
+const (
+	ieProtocolIdentifier            = 4
+	ieSourceTransportPort           = 7
+	ieSourceIPv4Address             = 8
+	ieDestinationTransportPort      = 11
+	ieDestinationIPv4Address        = 12
+	ieSourceIPv6Address             = 27
+	ieDestinationIPv6Address        = 28
+	iePostNATSourceIPv4Address      = 225
+	iePostNATDestinationIPv4Address = 226
+	iePostNAPTSourceTransportPort   = 227
+	ieNATEvent                      = 230
+	ieObservationTimeMilliseconds   = 323
+)
+
+type ipfixField struct {
+	id, length uint16
+}
+
+type ipfixTemplate struct {
+	id     uint16
+	fields []ipfixField
+}
+
+// Returns length of data record of template.
+func (t *ipfixTemplate) recordLength() int {
+	length := 0
+	for _, f := range t.fields {
+		length += int(f.length)
+	}
+	return length
+}
+
+// Template IDs, indexes in ipfixTemplates are obtained by subtracting
+// the first ID
+const (
+	ipfixTemplateNAT44Session uint16 = 256 + iota
+	ipfixTemplateNAT64Session
+	ipfixTemplatePortsExhausted
+)
+
+var ipfixTemplates = [...]ipfixTemplate{
+	{
+		id: ipfixTemplateNAT44Session,
+		fields: []ipfixField{
+			{ieObservationTimeMilliseconds, 8},
+			{ieNATEvent, 1},
+			{ieProtocolIdentifier, 1},
+			{ieSourceIPv4Address, 4},
+			{ieSourceTransportPort, 2},
+			{iePostNATSourceIPv4Address, 4},
+			{iePostNAPTSourceTransportPort, 2},
+			{ieDestinationIPv4Address, 4},
+			{ieDestinationTransportPort, 2},
+		},
+	},
+	{
+		id: ipfixTemplateNAT64Session,
+		fields: []ipfixField{
+			{ieObservationTimeMilliseconds, 8},
+			{ieNATEvent, 1},
+			{ieProtocolIdentifier, 1},
+			{ieSourceIPv6Address, 16},
+			{ieSourceTransportPort, 2},
+			{iePostNATSourceIPv4Address, 4},
+			{iePostNAPTSourceTransportPort, 2},
+			{ieDestinationIPv6Address, 16},
+			{iePostNATDestinationIPv4Address, 4},
+			{ieDestinationTransportPort, 2},
+		},
+	},
+	{
+		id: ipfixTemplatePortsExhausted,
+		fields: []ipfixField{
+			{ieObservationTimeMilliseconds, 8},
+			{ieNATEvent, 1},
+			{ieProtocolIdentifier, 1},
+			{iePostNATSourceIPv4Address, 4},
+		},
+	},
+}
+
+// NAT event which is exported as a data record of template. IPv4
+// addresses are in host byte order. Remote endpoint is zero when
+// mapping doesn't depend on it.
+type ipfixRecord struct {
+	template    uint16
+	time        time.Time
+	event       uint8
+	protocol    uint8
+	src4        types.IPv4Address
+	src6        types.IPv6Address
+	srcPort     uint16
+	postSrc4    types.IPv4Address
+	postSrcPort uint16
+	dst4        types.IPv4Address
+	dst6        types.IPv6Address
+	dstPort     uint16
+}
+
+func appendIPv4(b []byte, addr types.IPv4Address) []byte {
+	var a [4]byte
+	binary.BigEndian.PutUint32(a[:], uint32(addr))
+	return append(b, a[:]...)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+// Appends value of information element id of record.
+func (r *ipfixRecord) appendField(b []byte, id uint16) []byte {
+	switch id {
+	case ieObservationTimeMilliseconds:
+		var t [8]byte
+		binary.BigEndian.PutUint64(t[:], uint64(r.time.UnixNano()/int64(time.Millisecond)))
+		return append(b, t[:]...)
+	case ieNATEvent:
+		return append(b, r.event)
+	case ieProtocolIdentifier:
+		return append(b, r.protocol)
+	case ieSourceIPv4Address:
+		return appendIPv4(b, r.src4)
+	case ieSourceIPv6Address:
+		return append(b, r.src6[:]...)
+	case ieSourceTransportPort:
+		return appendUint16(b, r.srcPort)
+	case iePostNATSourceIPv4Address:
+		return appendIPv4(b, r.postSrc4)
+	case iePostNAPTSourceTransportPort:
+		return appendUint16(b, r.postSrcPort)
+	case ieDestinationIPv4Address, iePostNATDestinationIPv4Address:
+		return appendIPv4(b, r.dst4)
+	case ieDestinationIPv6Address:
+		return append(b, r.dst6[:]...)
+	case ieDestinationTransportPort:
+		return appendUint16(b, r.dstPort)
+	}
+	return b
+}
+
+// Exports queued NAT events to collector.
+type ipfixExporter struct {
+	conn     net.Conn
+	domainID uint32
+	records  chan ipfixRecord
+	// Number of events which were lost because queue was full
+	lost uint64
+	// Number of data records sent, it is used as message sequence
+	// number
+	sequence uint32
+	// Data sets of current message indexed by template
+	sets          [len(ipfixTemplates)][]byte
+	size          int
+	templatesSent time.Time
+}
+
+var ipfixExport *ipfixExporter
+
+// StartIPFIXExporter starts export of NAT events to IPFIX collector
+// if it is configured.
+func StartIPFIXExporter() error {
+	if Natconfig.IPFIXCollector == "" {
+		return nil
+	}
+	conn, err := net.Dial("udp", Natconfig.IPFIXCollector)
+	if err != nil {
+		return err
+	}
+	ipfixExport = &ipfixExporter{
+		conn:     conn,
+		domainID: Natconfig.IPFIXDomainID,
+		records:  make(chan ipfixRecord, ipfixQueueSize),
+		size:     ipfixHeaderSize,
+	}
+	go ipfixExport.run()
+	return nil
+}
+
+// Queues event for export. Event is lost if queue is full, so that
+// packet handlers are never blocked.
+func logNATEvent(r *ipfixRecord) {
+	if ipfixExport == nil {
+		return
+	}
+	select {
+	case ipfixExport.records <- *r:
+	default:
+		atomic.AddUint64(&ipfixExport.lost, 1)
+	}
+}
+
+// Returns number of NAT events which were lost because export queue
+// was full.
+func lostNATEvents() uint64 {
+	if ipfixExport == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&ipfixExport.lost)
+}
+
+func (e *ipfixExporter) run() {
+	ticker := time.NewTicker(ipfixFlushInterval)
+	for {
+		select {
+		case r := <-e.records:
+			e.add(&r)
+		case <-ticker.C:
+			if e.size > ipfixHeaderSize || time.Since(e.templatesSent) >= ipfixTemplateRefresh {
+				e.flush()
+			}
+		}
+	}
+}
+
+// Adds data record to current message. Message is sent when it is
+// full.
+func (e *ipfixExporter) add(r *ipfixRecord) {
+	index := r.template - ipfixTemplateNAT44Session
+	t := &ipfixTemplates[index]
+	length := t.recordLength()
+	if e.sets[index] != nil && e.size+length > ipfixMaxMessageSize {
+		e.flush()
+	}
+	if e.sets[index] == nil {
+		if e.size+ipfixSetHdrSize+length > ipfixMaxMessageSize {
+			e.flush()
+		}
+		e.sets[index] = make([]byte, ipfixSetHdrSize, ipfixMaxMessageSize)
+		e.size += ipfixSetHdrSize
+	}
+	for _, f := range t.fields {
+		e.sets[index] = r.appendField(e.sets[index], f.id)
+	}
+	e.size += length
+}
+
+func appendIPFIXTemplates(b []byte) []byte {
+	start := len(b)
+	b = append(b, make([]byte, ipfixSetHdrSize)...)
+	for _, t := range ipfixTemplates {
+		b = appendUint16(b, t.id)
+		b = appendUint16(b, uint16(len(t.fields)))
+		for _, f := range t.fields {
+			b = appendUint16(b, f.id)
+			b = appendUint16(b, f.length)
+		}
+	}
+	binary.BigEndian.PutUint16(b[start:], ipfixTemplateSet)
+	binary.BigEndian.PutUint16(b[start+2:], uint16(len(b)-start))
+	return b
+}
+
+// Sends current message with templates if they should be refreshed.
+func (e *ipfixExporter) flush() {
+	now := time.Now()
+	msg := make([]byte, ipfixHeaderSize, ipfixMaxMessageSize)
+	// Templates are sent in a separate message if data records
+	// leave no space for them
+	if now.Sub(e.templatesSent) >= ipfixTemplateRefresh {
+		msg = appendIPFIXTemplates(msg)
+		if len(msg)+e.size-ipfixHeaderSize > ipfixMaxMessageSize {
+			e.send(msg, now, 0)
+			msg = msg[:ipfixHeaderSize]
+		}
+		e.templatesSent = now
+	}
+
+	var records uint32
+	for index := range e.sets {
+		set := e.sets[index]
+		if set == nil {
+			continue
+		}
+		t := &ipfixTemplates[index]
+		binary.BigEndian.PutUint16(set[0:], t.id)
+		binary.BigEndian.PutUint16(set[2:], uint16(len(set)))
+		records += uint32((len(set) - ipfixSetHdrSize) / t.recordLength())
+		msg = append(msg, set...)
+		e.sets[index] = nil
+	}
+	e.size = ipfixHeaderSize
+	if len(msg) > ipfixHeaderSize {
+		e.send(msg, now, records)
+	}
+}
+
+// Fills message header and sends message which contains given number
+// of data records.
+func (e *ipfixExporter) send(msg []byte, now time.Time, records uint32) {
+	binary.BigEndian.PutUint16(msg[0:], ipfixVersion)
+	binary.BigEndian.PutUint16(msg[2:], uint16(len(msg)))
+	binary.BigEndian.PutUint32(msg[4:], uint32(now.Unix()))
+	binary.BigEndian.PutUint32(msg[8:], e.sequence)
+	binary.BigEndian.PutUint32(msg[12:], e.domainID)
+	e.sequence += records
+	if _, err := e.conn.Write(msg); err != nil {
+		common.LogWarning(common.Debug, "Failed to send IPFIX message:", err)
+	}
+}
+
+// Exports creation or deletion of dynamic session with private side
+// key priv and public side key pub. IPv6 to IPv6 sessions are not
+// exported because RFC 8158 defines events only for NAT44 and NAT64.
+func (pp *portPair) exportSessionEvent(create bool, t time.Time, protocol uint8, priv, pub sessionKey) {
+	if ipfixExport == nil || pub.kind != sessionKeyTuple {
+		return
+	}
+	r := ipfixRecord{
+		template:    ipfixTemplateNAT44Session,
+		time:        t,
+		event:       natEventNAT44SessionCreate,
+		protocol:    protocol,
+		postSrc4:    pub.addr,
+		postSrcPort: pub.port,
+	}
+	switch priv.kind {
+	case sessionKeyTuple:
+		r.src4, r.srcPort = priv.addr, priv.port
+		r.dst4, r.dstPort = priv.remoteAddr, priv.remotePort
+	case sessionKeyTuple64:
+		r.template = ipfixTemplateNAT64Session
+		r.event = natEventNAT64SessionCreate
+		r.src6, r.srcPort = priv.addr6, priv.port
+		r.dst6, r.dstPort = priv.remoteAddr6, priv.remotePort
+		if priv.remoteAddr6 != zeroIPv6Addr {
+			r.dst4 = pp.NAT64Prefix.extract(priv.remoteAddr6)
+		}
+	default:
+		return
+	}
+	if isAddrOnlyProtocol(protocol) {
+		// Public port of address-only session is its handle, not a
+		// transport port
+		r.postSrcPort = 0
+	}
+	if !create {
+		// Delete events follow create events of the same kind
+		r.event++
+	}
+	logNATEvent(&r)
+}
+
+// Logs that there are no free ports on public IPv4 address.
+func logPortsExhausted(protocol uint8, addr types.IPv4Address) {
+	if ipfixExport == nil {
+		return
+	}
+	logNATEvent(&ipfixRecord{
+		template: ipfixTemplatePortsExhausted,
+		time:     time.Now(),
+		event:    natEventPortsExhausted,
+		protocol: protocol,
+		postSrc4: addr,
+	})
+}
@@ -0,0 +1,264 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/types"
+)
+
+// Names of protocols in metric labels
+var protocolLabels = map[uint8]string{
+	types.ICMPNumber:   "icmp",
+	types.TCPNumber:    "tcp",
+	types.UDPNumber:    "udp",
+	greNumber:          "gre",
+	espNumber:          "esp",
+	types.ICMPv6Number: "icmp6",
+	sctpNumber:         "sctp",
+	udpLiteNumber:      "udplite",
+}
+
+// Names of ports in metric labels
+var portLabels = map[interfaceType]string{
+	iPUBLIC:  "public",
+	iPRIVATE: "private",
+}
+
+// Names of packet handling outcomes in metric labels
+var packetResultLabels = [DirKNI + 1]string{
+	DirDROP: "drop",
+	DirSEND: "send",
+	DirKNI:  "kni",
+}
+
+func protocolLabel(protocol uint8) string {
+	if name, ok := protocolLabels[protocol]; ok {
+		return name
+	}
+	return strconv.Itoa(int(protocol))
+}
+
+// Writes metrics in Prometheus text exposition format.
+type metricsWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *metricsWriter) family(name, kind, help string) {
+	fmt.Fprintf(&w.buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// Writes sample with labels given as name and value pairs.
+func (w *metricsWriter) sample(name string, value uint64, labels ...string) {
+	w.buf.WriteString(name)
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i == 0 {
+			w.buf.WriteByte('{')
+		} else {
+			w.buf.WriteByte(',')
+		}
+		fmt.Fprintf(&w.buf, "%s=%q", labels[i], labels[i+1])
+		if i+2 >= len(labels) {
+			w.buf.WriteByte('}')
+		}
+	}
+	fmt.Fprintf(&w.buf, " %d\n", value)
+}
+
+func boolMetric(v bool) uint64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// Returns numbers of IPv4 and IPv6 neighbor cache entries of port.
+func (port *ipPort) countNeighbors() (uint64, uint64) {
+	var n4, n6 uint64
+	port.arpTable.Range(func(k, v interface{}) bool {
+		if _, ok := k.(types.IPv6Address); ok {
+			n6++
+		} else {
+			n4++
+		}
+		return true
+	})
+	return n4, n6
+}
+
+func writeMetrics(w *metricsWriter) {
+	counters := make([]handlerCounters, len(Natconfig.PortPairs))
+	for i := range Natconfig.PortPairs {
+		counters[i] = Natconfig.PortPairs[i].getCounters()
+	}
+
+	w.family("nat_packets_total", "counter", "Packets handled by NAT by traffic direction and outcome.")
+	for i := range counters {
+		for _, dir := range []trafficDirection{pri2pub, pub2pri} {
+			var counts [DirKNI + 1]uint64
+			for res, count := range counters[i].packets[dir] {
+				// Hairpinned packets are translated and sent like
+				// others
+				if uint(res) == dirHairpin {
+					counts[DirSEND] += count
+				} else {
+					counts[res] += count
+				}
+			}
+			for res, count := range counts {
+				w.sample("nat_packets_total", count, "port_pair", strconv.Itoa(i), "direction", dir.String(), "result", packetResultLabels[res])
+			}
+		}
+	}
+
+	w.family("nat_drops_total", "counter", "Packets dropped by NAT by traffic direction and drop reason.")
+	for i := range counters {
+		for _, dir := range []trafficDirection{pri2pub, pub2pri} {
+			for reason, count := range counters[i].drops[dir] {
+				if count != 0 {
+					w.sample("nat_drops_total", count, "port_pair", strconv.Itoa(i), "direction", dir.String(), "reason", dropReason(reason).String())
+				}
+			}
+		}
+	}
+
+	w.family("nat_sessions", "gauge", "Sessions in translation tables by protocol.")
+	for i := range Natconfig.PortPairs {
+		pp := &Natconfig.PortPairs[i]
+		counts := make(map[uint8]uint64)
+		pp.forEachSession(&sessionFilter{}, func(s *sessionInfo) bool {
+			counts[s.protocol]++
+			return true
+		})
+		for protocol, count := range counts {
+			w.sample("nat_sessions", count, "port_pair", strconv.Itoa(i), "protocol", protocolLabel(protocol))
+		}
+	}
+
+	w.family("nat_portmap_used_ports", "gauge", "Public ports in use by public address and protocol.")
+	for i := range Natconfig.PortPairs {
+		pp := &Natconfig.PortPairs[i]
+		for _, ipv6 := range []bool{false, true} {
+			pool := pp.PublicPort.pool
+			if ipv6 {
+				pool = pp.PublicPort.pool6
+			}
+			for index := range pool {
+				v4addr, v6addr := pp.PublicPort.getPoolIndexAddr(ipv6, index)
+				addr := hostAddr{Addr4: v4addr, Addr6: v6addr, ipv6: ipv6}
+				pa := &pool[index]
+				for _, protocol := range portmapProtocols(ipv6) {
+					// Port maps of rarely used protocols are
+					// allocated with their first session
+					used := 0
+					pa.mutex[protocol].Lock()
+					if pa.portmap[protocol] != nil {
+						used = numPorts - len(pa.free[protocol].ports)
+					}
+					pa.mutex[protocol].Unlock()
+					w.sample("nat_portmap_used_ports", uint64(used),
+						"port_pair", strconv.Itoa(i), "address", addr.String(), "protocol", protocolLabel(protocol))
+				}
+			}
+		}
+	}
+	w.family("nat_portmap_ports", "gauge", "Public ports available for dynamic allocation on every public address.")
+	w.sample("nat_portmap_ports", numPorts)
+
+	w.family("nat_allocation_failures_total", "counter", "Sessions which were not created because of lack of free ports or lookup table space.")
+	for i := range Natconfig.PortPairs {
+		pp := &Natconfig.PortPairs[i]
+		failures := atomic.LoadUint64(&pp.allocFailures)
+		w.sample("nat_allocation_failures_total", failures, "port_pair", strconv.Itoa(i))
+	}
+
+	w.family("nat_session_limit_drops_total", "counter", "Packets dropped because private host reached its session limit.")
+	for i := range Natconfig.PortPairs {
+		pp := &Natconfig.PortPairs[i]
+		drops := atomic.LoadUint64(&pp.sessions.drops)
+		w.sample("nat_session_limit_drops_total", drops, "port_pair", strconv.Itoa(i))
+	}
+
+	w.family("nat_reclaimed_sessions_total", "counter", "Expired sessions deleted by background reaper.")
+	for i := range Natconfig.PortPairs {
+		w.sample("nat_reclaimed_sessions_total", atomic.LoadUint64(&Natconfig.PortPairs[i].reclaimedSessions), "port_pair", strconv.Itoa(i))
+	}
+
+	w.family("nat_ipfix_lost_events_total", "counter", "NAT events which were not exported to IPFIX collector because export queue was full.")
+	w.sample("nat_ipfix_lost_events_total", lostNATEvents())
+
+	w.family("nat_translation_log_lost_events_total", "counter", "Translation log events which were not written because log queue was full.")
+	w.sample("nat_translation_log_lost_events_total", lostTranslationEvents())
+
+	w.family("nat_history_lost_records_total", "counter", "Finished sessions which were not added to translation history because its queue was full.")
+	w.sample("nat_history_lost_records_total", lostHistoryRecords())
+
+	w.family("nat_neighbor_cache_entries", "gauge", "Entries of ARP and IPv6 neighbor caches.")
+	for i := range Natconfig.PortPairs {
+		pp := &Natconfig.PortPairs[i]
+		for _, port := range []*ipPort{&pp.PrivatePort, &pp.PublicPort} {
+			n4, n6 := port.countNeighbors()
+			w.sample("nat_neighbor_cache_entries", n4, "port_pair", strconv.Itoa(i), "port", portLabels[port.Type], "family", "ipv4")
+			w.sample("nat_neighbor_cache_entries", n6, "port_pair", strconv.Itoa(i), "port", portLabels[port.Type], "family", "ipv6")
+		}
+	}
+
+	w.family("nat_address_acquired", "gauge", "Whether port has its IPv4 or IPv6 address, either static or obtained with DHCP.")
+	for i := range Natconfig.PortPairs {
+		pp := &Natconfig.PortPairs[i]
+		for _, port := range []*ipPort{&pp.PrivatePort, &pp.PublicPort} {
+			w.sample("nat_address_acquired", boolMetric(port.Subnet.addressAcquired), "port_pair", strconv.Itoa(i), "port", portLabels[port.Type], "family", "ipv4")
+			w.sample("nat_address_acquired", boolMetric(port.Subnet6.addressAcquired), "port_pair", strconv.Itoa(i), "port", portLabels[port.Type], "family", "ipv6")
+		}
+	}
+
+	w.family("nat_dhcp_last_message_sent", "gauge", "Type of the last DHCP or DHCPv6 message sent by port.")
+	for i := range Natconfig.PortPairs {
+		pp := &Natconfig.PortPairs[i]
+		for _, port := range []*ipPort{&pp.PrivatePort, &pp.PublicPort} {
+			if t := port.Subnet.ds.lastDHCPPacketTypeSent; t != 0 {
+				w.sample("nat_dhcp_last_message_sent", 1, "port_pair", strconv.Itoa(i), "port", portLabels[port.Type], "family", "ipv4", "type", t.String())
+			}
+			if t := port.Subnet6.ds.lastDHCPv6PacketTypeSent; t != 0 {
+				w.sample("nat_dhcp_last_message_sent", 1, "port_pair", strconv.Itoa(i), "port", portLabels[port.Type], "family", "ipv6", "type", t.String())
+			}
+		}
+	}
+}
+
+func serveMetrics(rw http.ResponseWriter, req *http.Request) {
+	w := metricsWriter{}
+	writeMetrics(&w)
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	rw.Write(w.buf.Bytes())
+}
+
+// StartMetricsServer starts HTTP server which exports NAT metrics at
+// /metrics path if metrics address is configured.
+func StartMetricsServer() error {
+	if Natconfig.MetricsAddress == "" {
+		return nil
+	}
+	lis, err := net.Listen("tcp", Natconfig.MetricsAddress)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", serveMetrics)
+
+	go func() {
+		if err := http.Serve(lis, mux); err != nil {
+			common.LogWarning(common.Initialization, "Error while serving metrics requests:", err)
+		}
+	}()
+	return nil
+}
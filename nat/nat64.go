@@ -0,0 +1,975 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/types"
+)
+
+const (
+	// Difference between IPv6 and IPv4 header lengths
+	nat64HdrDelta = types.IPv6Len - types.IPv4MinLen
+	ipv6MinMTU    = 1280
+	// MTU of ports which don't have it configured
+	defaultMTU = 1500
+	// Maximum length of ICMPv4 error message (RFC 1812 section
+	// 4.3.2.3)
+	icmpv4ErrorMaxLen = 576
+)
+
+// NAT64 prefix which represents IPv4 addresses in private IPv6
+// network (RFC 6052), e.g. 64:ff9b::/96. Zero length means that
+// NAT64 is disabled.
+type nat64Prefix struct {
+	Addr types.IPv6Address
+	Len  int
+}
+
+// UnmarshalJSON parses NAT64 prefix. Only prefix lengths allowed by
+// RFC 6052 are accepted.
+func (out *nat64Prefix) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	subnet := ipv6Subnet{}
+	if err := subnet.UnmarshalJSON(b); err != nil || s == "dhcp" {
+		return errors.New("Bad NAT64 prefix: " + s)
+	}
+	prefix := nat64Prefix{
+		Addr: subnet.andMask(subnet.Addr),
+	}
+	for prefix.Len < 128 && subnet.Mask[prefix.Len>>3]&(0x80>>uint(prefix.Len&7)) != 0 {
+		prefix.Len++
+	}
+	switch prefix.Len {
+	case 32, 40, 48, 56, 64, 96:
+	default:
+		return errors.New("Bad NAT64 prefix length: " + s)
+	}
+	// Bits 64 to 71 of address are reserved
+	if prefix.Len < 96 && prefix.Addr[8] != 0 {
+		return errors.New("Bad NAT64 prefix, bits 64-71 should be zero: " + s)
+	}
+	*out = prefix
+	return nil
+}
+
+func (prefix *nat64Prefix) String() string {
+	return prefix.Addr.String() + "/" + strconv.Itoa(prefix.Len)
+}
+
+func (prefix *nat64Prefix) enabled() bool {
+	return prefix.Len != 0
+}
+
+// Checks whether IPv6 address represents an IPv4 address.
+func (prefix *nat64Prefix) contains(addr types.IPv6Address) bool {
+	if !prefix.enabled() {
+		return false
+	}
+	for i := 0; i < prefix.Len>>3; i++ {
+		if addr[i] != prefix.Addr[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns positions of IPv4 address bytes in IPv6 address. Reserved
+// bits 64 to 71 are skipped.
+func (prefix *nat64Prefix) positions() [types.IPv4AddrLen]int {
+	var pos [types.IPv4AddrLen]int
+	i := prefix.Len >> 3
+	for n := range pos {
+		if i == 8 {
+			i++
+		}
+		pos[n] = i
+		i++
+	}
+	return pos
+}
+
+// Extracts IPv4 address from IPv6 address.
+func (prefix *nat64Prefix) extract(addr types.IPv6Address) types.IPv4Address {
+	var v4addr uint32
+	for _, i := range prefix.positions() {
+		v4addr = v4addr<<8 | uint32(addr[i])
+	}
+	return types.IPv4Address(v4addr)
+}
+
+// Embeds IPv4 address into IPv6 address.
+func (prefix *nat64Prefix) embed(v4addr types.IPv4Address) types.IPv6Address {
+	addr := prefix.Addr
+	for n, i := range prefix.positions() {
+		addr[i] = uint8(v4addr >> uint(24-8*n))
+	}
+	return addr
+}
+
+func getL2Len(pktVLAN *packet.VLANHdr) uint {
+	if pktVLAN != nil {
+		return types.EtherLen + types.VLANLen
+	}
+	return types.EtherLen
+}
+
+func setEtherType(pkt *packet.Packet, pktVLAN *packet.VLANHdr, etherType uint16) {
+	if pktVLAN != nil {
+		pktVLAN.EtherType = packet.SwapBytesUint16(etherType)
+	} else {
+		pkt.Ether.EtherType = packet.SwapBytesUint16(etherType)
+	}
+}
+
+// Last identification of IPv4 packets translated from IPv6 packets
+var nat64PacketID uint32
+
+// Fills IPv4 header translated from IPv6 header (RFC 7915 section
+// 5.1). Fragment gets identification and offset from its Fragment
+// header frag. Other packets get new identification. DF flag is
+// cleared for packets which don't exceed IPv6 minimum MTU, so that
+// IPv4 routers may fragment them, and is set for larger ones. Header
+// checksum is calculated.
+func fillIPv4Hdr(out *packet.IPv4Hdr, in *packet.IPv6Hdr, frag *ipv6FragmentHdr, payloadLen uint16, src, dst types.IPv4Address) {
+	out.VersionIhl = 4<<4 | types.IPv4MinLen>>2
+	out.TypeOfService = uint8(packet.SwapBytesUint32(in.VtcFlow) >> 20)
+	out.TotalLength = packet.SwapBytesUint16(payloadLen + types.IPv4MinLen)
+	proto := in.Proto
+	if frag != nil {
+		fo := packet.SwapBytesUint16(frag.FragOffsetFlags)
+		out.PacketID = packet.SwapBytesUint16(uint16(packet.SwapBytesUint32(frag.Identification)))
+		out.FragmentOffset = packet.SwapBytesUint16(fo>>3 | (fo&1)<<13)
+		proto = frag.NextHeader
+	} else {
+		out.PacketID = packet.SwapBytesUint16(uint16(atomic.AddUint32(&nat64PacketID, 1)))
+		if types.IPv6Len+uint(packet.SwapBytesUint16(in.PayloadLen)) <= ipv6MinMTU {
+			out.FragmentOffset = 0
+		} else {
+			out.FragmentOffset = packet.SwapBytesUint16(0x4000)
+		}
+	}
+	out.TimeToLive = in.HopLimits
+	out.NextProtoID = proto
+	if proto == types.ICMPv6Number {
+		out.NextProtoID = types.ICMPNumber
+	}
+	out.SrcAddr = packet.SwapBytesIPv4Addr(src)
+	out.DstAddr = packet.SwapBytesIPv4Addr(dst)
+	out.HdrChecksum = packet.SwapBytesUint16(packet.CalculateIPv4Checksum(out))
+}
+
+// Fills IPv6 header translated from IPv4 header (RFC 7915 section
+// 4.1). IPv4 options are dropped.
+func fillIPv6Hdr(out *packet.IPv6Hdr, in *packet.IPv4Hdr, payloadLen uint16, src, dst types.IPv6Address) {
+	out.VtcFlow = packet.SwapBytesUint32(6<<28 | uint32(in.TypeOfService)<<20)
+	out.PayloadLen = packet.SwapBytesUint16(payloadLen)
+	out.Proto = in.NextProtoID
+	if in.NextProtoID == types.ICMPNumber {
+		out.Proto = types.ICMPv6Number
+	}
+	out.HopLimits = in.TimeToLive
+	out.SrcAddr = src
+	out.DstAddr = dst
+}
+
+// Replaces IPv6 header of packet with IPv4 header. Fragment header
+// is replaced too and its fields are moved to IPv4 header. L4 header
+// stays at its place, packet start is moved instead.
+func convertIPv6ToIPv4(pkt *packet.Packet, pktVLAN *packet.VLANHdr, src, dst types.IPv4Address) bool {
+	hdr := *pkt.GetIPv6NoCheck()
+	payloadLen := packet.SwapBytesUint16(hdr.PayloadLen)
+	delta := uint(nat64HdrDelta)
+	var frag *ipv6FragmentHdr
+	if hdr.Proto == ipv6FragmentHeaderNumber {
+		if payloadLen < ipv6FragmentHeaderLen {
+			return false
+		}
+		fragHdr := *(*ipv6FragmentHdr)(unsafe.Pointer(uintptr(pkt.L3) + types.IPv6Len))
+		frag = &fragHdr
+		payloadLen -= ipv6FragmentHeaderLen
+		delta += ipv6FragmentHeaderLen
+	}
+	if !pkt.DecapsulateHead(getL2Len(pktVLAN), delta) {
+		return false
+	}
+	pktVLAN = pkt.ParseL3CheckVLAN()
+	setEtherType(pkt, pktVLAN, types.IPV4Number)
+	fillIPv4Hdr(pkt.GetIPv4NoCheck(), &hdr, frag, payloadLen, src, dst)
+	pkt.ParseL4ForIPv4()
+	return true
+}
+
+// Replaces IPv4 header of packet with IPv6 header. Fragment header
+// is added after it if IPv4 packet is a fragment (RFC 7915 section
+// 4.1). L4 header stays at its place, packet start is moved instead.
+func convertIPv4ToIPv6(pkt *packet.Packet, pktVLAN *packet.VLANHdr, src, dst types.IPv6Address) bool {
+	hdr := *pkt.GetIPv4NoCheck()
+	hdrLen := uint(hdr.VersionIhl&0x0f) << 2
+	payloadLen := packet.SwapBytesUint16(hdr.TotalLength) - uint16(hdrLen)
+	fo := packet.SwapBytesUint16(hdr.FragmentOffset)
+	fragmented := fo&0x3fff != 0
+	newLen := uint(types.IPv6Len)
+	if fragmented {
+		newLen += ipv6FragmentHeaderLen
+	}
+	l2len := getL2Len(pktVLAN)
+	if hdrLen < newLen {
+		if !pkt.EncapsulateHead(l2len, newLen-hdrLen) {
+			return false
+		}
+	} else if hdrLen > newLen {
+		if !pkt.DecapsulateHead(l2len, hdrLen-newLen) {
+			return false
+		}
+	}
+	pktVLAN = pkt.ParseL3CheckVLAN()
+	setEtherType(pkt, pktVLAN, types.IPV6Number)
+	if fragmented {
+		var v6hdr packet.IPv6Hdr
+		fillIPv6Hdr(&v6hdr, &hdr, payloadLen, src, dst)
+		data := (*[types.IPv6Len + ipv6FragmentHeaderLen]byte)(pkt.L3)[:]
+		fillIPv6FragmentHdrs(data, &v6hdr, uint(payloadLen), uint(fo&0x1fff)<<3, fo&0x2000 != 0,
+			uint32(packet.SwapBytesUint16(hdr.PacketID)))
+	} else {
+		fillIPv6Hdr(pkt.GetIPv6NoCheck(), &hdr, payloadLen, src, dst)
+	}
+	pkt.ParseL4ForIPv6()
+	return true
+}
+
+// Returns 4 bytes of ICMP header which follow checksum. They contain
+// MTU and pointer fields of error messages.
+func icmpRestOfHeader(icmp *packet.ICMPHdr) *[4]uint8 {
+	return (*[4]uint8)(unsafe.Pointer(&icmp.Identifier))
+}
+
+// Translates type and code of ICMPv6 message to ICMPv4 (RFC 7915
+// section 5.2). Returns false if message cannot be translated.
+func translateICMPv6Type(icmp *packet.ICMPHdr) bool {
+	rest := icmpRestOfHeader(icmp)
+	switch icmp.Type {
+	case types.ICMPv6TypeEchoRequest:
+		icmp.Type = types.ICMPTypeEchoRequest
+		return true
+	case types.ICMPv6TypeEchoResponse:
+		icmp.Type = types.ICMPTypeEchoResponse
+		return true
+	case icmpv6TypeDestUnreachable:
+		switch icmp.Code {
+		case 0, 2, 3:
+			icmp.Code = 1
+		case 1:
+			icmp.Code = 10
+		case 4:
+			icmp.Code = 3
+		default:
+			return false
+		}
+		icmp.Type = icmpTypeDestUnreachable
+		*rest = [4]uint8{}
+	case icmpv6TypePacketTooBig:
+		mtu := uint32(rest[0])<<24 | uint32(rest[1])<<16 | uint32(rest[2])<<8 | uint32(rest[3])
+		if mtu < nat64HdrDelta {
+			return false
+		}
+		mtu -= nat64HdrDelta
+		if mtu > 0xffff {
+			mtu = 0xffff
+		}
+		icmp.Type = icmpTypeDestUnreachable
+		icmp.Code = 4
+		*rest = [4]uint8{0, 0, uint8(mtu >> 8), uint8(mtu)}
+	case icmpv6TypeTimeExceeded:
+		icmp.Type = icmpTypeTimeExceeded
+		*rest = [4]uint8{}
+	case icmpv6TypeParameterProblem:
+		switch icmp.Code {
+		case 0:
+			ptr := uint32(rest[0])<<24 | uint32(rest[1])<<16 | uint32(rest[2])<<8 | uint32(rest[3])
+			var newPtr uint8
+			switch {
+			case ptr == 0 || ptr == 1:
+				newPtr = uint8(ptr)
+			case ptr == 4 || ptr == 5:
+				newPtr = 2
+			case ptr == 6:
+				newPtr = 9
+			case ptr == 7:
+				newPtr = 8
+			case ptr >= 8 && ptr < 24:
+				newPtr = 12
+			case ptr >= 24 && ptr < 40:
+				newPtr = 16
+			default:
+				return false
+			}
+			icmp.Type = icmpTypeParameterProblem
+			*rest = [4]uint8{newPtr, 0, 0, 0}
+		case 1:
+			icmp.Type = icmpTypeDestUnreachable
+			icmp.Code = 2
+			*rest = [4]uint8{}
+		default:
+			return false
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// Translates type and code of ICMPv4 message to ICMPv6 (RFC 7915
+// section 4.2). Returns false if message cannot be translated.
+func translateICMPv4Type(icmp *packet.ICMPHdr) bool {
+	rest := icmpRestOfHeader(icmp)
+	switch icmp.Type {
+	case types.ICMPTypeEchoRequest:
+		icmp.Type = types.ICMPv6TypeEchoRequest
+		return true
+	case types.ICMPTypeEchoResponse:
+		icmp.Type = types.ICMPv6TypeEchoResponse
+		return true
+	case icmpTypeDestUnreachable:
+		icmp.Type = icmpv6TypeDestUnreachable
+		switch icmp.Code {
+		case 0, 1, 5, 6, 7, 8, 11, 12:
+			icmp.Code = 0
+			*rest = [4]uint8{}
+		case 9, 10, 13, 15:
+			icmp.Code = 1
+			*rest = [4]uint8{}
+		case 3:
+			icmp.Code = 4
+			*rest = [4]uint8{}
+		case 2:
+			// Pointer to IPv6 Next Header field
+			icmp.Type = icmpv6TypeParameterProblem
+			icmp.Code = 1
+			*rest = [4]uint8{0, 0, 0, 6}
+		case 4:
+			mtu := uint32(rest[2])<<8 | uint32(rest[3])
+			if mtu == 0 {
+				mtu = ipv6MinMTU
+			} else {
+				mtu += nat64HdrDelta
+			}
+			icmp.Type = icmpv6TypePacketTooBig
+			icmp.Code = 0
+			*rest = [4]uint8{uint8(mtu >> 24), uint8(mtu >> 16), uint8(mtu >> 8), uint8(mtu)}
+		default:
+			return false
+		}
+	case icmpTypeTimeExceeded:
+		icmp.Type = icmpv6TypeTimeExceeded
+		*rest = [4]uint8{}
+	case icmpTypeParameterProblem:
+		if icmp.Code != 0 && icmp.Code != 2 {
+			return false
+		}
+		ptr := rest[0]
+		var newPtr uint8
+		switch {
+		case ptr == 0 || ptr == 1:
+			newPtr = ptr
+		case ptr == 2 || ptr == 3:
+			newPtr = 4
+		case ptr == 8:
+			newPtr = 7
+		case ptr == 9:
+			newPtr = 6
+		case ptr >= 12 && ptr < 16:
+			newPtr = 8
+		case ptr >= 16 && ptr < 20:
+			newPtr = 24
+		default:
+			return false
+		}
+		icmp.Type = icmpv6TypeParameterProblem
+		icmp.Code = 0
+		*rest = [4]uint8{0, 0, 0, newPtr}
+	default:
+		return false
+	}
+	return true
+}
+
+// Adjusts L4 checksum for pseudo header of another IP version. Only
+// address fields differ for TCP and UDP. ICMPv4 checksum doesn't
+// cover pseudo header while ICMPv6 checksum does, so the whole
+// pseudo header is removed or added for ICMP. Addresses are in
+// network byte order.
+func updateChecksumNAT64(cksum uint16, icmp, toIPv4 bool, src4, dst4 types.IPv4Address, src6, dst6 types.IPv6Address, length uint16) uint16 {
+	var addrs4 [2]types.IPv4Address
+	var words6 [2]uint16
+	addrs6 := [2]types.IPv6Address{src6, dst6}
+	if icmp {
+		words6 = [2]uint16{packet.SwapBytesUint16(length), packet.SwapBytesUint16(types.ICMPv6Number)}
+	} else {
+		addrs4 = [2]types.IPv4Address{src4, dst4}
+	}
+	for i := range addrs4 {
+		if toIPv4 {
+			cksum = updateChecksumIPv6Addr(cksum, addrs6[i], zeroIPv6Addr)
+			cksum = updateChecksum16(cksum, words6[i], 0)
+			cksum = updateChecksumIPv4Addr(cksum, 0, addrs4[i])
+		} else {
+			cksum = updateChecksumIPv4Addr(cksum, addrs4[i], 0)
+			cksum = updateChecksumIPv6Addr(cksum, zeroIPv6Addr, addrs6[i])
+			cksum = updateChecksum16(cksum, 0, words6[i])
+		}
+	}
+	return cksum
+}
+
+// Changes port of original packet embedded into ICMP error message
+// and adjusts its L4 checksum for new IP version and addresses.
+// Addresses are in network byte order.
+func (part *partialPacket) setPortNAT64(src bool, port uint16, src4, dst4 types.IPv4Address, src6, dst6 types.IPv6Address, length uint16) {
+	toIPv4 := part.ipv6 != nil
+	updateL4 := part.cksum != nil && !(part.protocol == types.UDPNumber && !toIPv4 && *part.cksum == 0)
+
+	portField := part.dstPort
+	if src {
+		portField = part.srcPort
+	}
+	newPort := packet.SwapBytesUint16(port)
+	if !updateL4 {
+		*portField = newPort
+		return
+	}
+	cksum := updateChecksum16(*part.cksum, *portField, newPort)
+	*portField = newPort
+
+	icmp := part.protocol == types.ICMPNumber || part.protocol == types.ICMPv6Number
+	cksum = updateChecksumNAT64(cksum, icmp, toIPv4, src4, dst4, src6, dst6, length)
+	if icmp {
+		hdr := (*packet.ICMPHdr)(unsafe.Pointer(uintptr(unsafe.Pointer(part.srcPort)) - unsafe.Offsetof(packet.ICMPHdr{}.Identifier)))
+		oldType := hdr.Type
+		if toIPv4 {
+			translateICMPv6Type(hdr)
+		} else {
+			translateICMPv4Type(hdr)
+		}
+		cksum = updateChecksum16(cksum, uint16(oldType), uint16(hdr.Type))
+	}
+	*part.cksum = cksum
+	if part.protocol == types.UDPNumber && cksum == 0 {
+		*part.cksum = 0xffff
+	}
+}
+
+// Replaces L3 part of packet with data. Packet is shortened or
+// extended when necessary.
+func replaceL3(pkt *packet.Packet, pktVLAN *packet.VLANHdr, data []byte, etherType uint16) bool {
+	l2len := getL2Len(pktVLAN)
+	oldLen := pkt.GetPacketLen()
+	newLen := l2len + uint(len(data))
+	if newLen < oldLen {
+		if !pkt.DecapsulateTail(newLen, oldLen-newLen) {
+			return false
+		}
+	} else if newLen > oldLen {
+		if !pkt.EncapsulateTail(oldLen, newLen-oldLen) {
+			return false
+		}
+	}
+	if !pkt.PacketBytesChange(l2len, data) {
+		return false
+	}
+	pktVLAN = pkt.ParseL3CheckVLAN()
+	setEtherType(pkt, pktVLAN, etherType)
+	return true
+}
+
+// Translates ICMPv6 error message to ICMPv4. Outer and embedded IPv6
+// headers are replaced with IPv4 headers. Embedded packet port and
+// checksum should be already translated. Addresses are in host byte
+// order.
+func convertICMPv6ErrorToIPv4(pkt *packet.Packet, pktVLAN *packet.VLANHdr, emb *partialPacket, src, dst, embSrc, embDst types.IPv4Address) bool {
+	outer := pkt.GetIPv6NoCheck()
+	raw := pkt.GetRawPacketBytes()
+	icmpStart := uintptr(pkt.L4) - uintptr(unsafe.Pointer(pkt.Ether))
+	embL4Start := icmpStart + types.ICMPLen + types.IPv6Len
+	// Ethernet padding is not a part of packet
+	if end := int(icmpStart) + int(packet.SwapBytesUint16(outer.PayloadLen)); end < len(raw) {
+		raw = raw[:end]
+	}
+	if int(embL4Start) > len(raw) {
+		return false
+	}
+	payload := raw[embL4Start:]
+
+	data := make([]byte, types.IPv4MinLen+types.ICMPLen+types.IPv4MinLen+len(payload))
+	fillIPv4Hdr((*packet.IPv4Hdr)(unsafe.Pointer(&data[0])), outer, nil, uint16(len(data)-types.IPv4MinLen), src, dst)
+	copy(data[types.IPv4MinLen:], raw[icmpStart:icmpStart+types.ICMPLen])
+	embStart := types.IPv4MinLen + types.ICMPLen
+	fillIPv4Hdr((*packet.IPv4Hdr)(unsafe.Pointer(&data[embStart])), emb.ipv6, nil, packet.SwapBytesUint16(emb.ipv6.PayloadLen), embSrc, embDst)
+	copy(data[embStart+types.IPv4MinLen:], payload)
+
+	if !replaceL3(pkt, pktVLAN, data, types.IPV4Number) {
+		return false
+	}
+	pkt.ParseL4ForIPv4()
+	return true
+}
+
+// Translates ICMPv4 error message to ICMPv6. Outer and embedded IPv4
+// headers are replaced with IPv6 headers. Embedded packet port and
+// checksum should be already translated.
+func convertICMPv4ErrorToIPv6(pkt *packet.Packet, pktVLAN *packet.VLANHdr, emb *partialPacket, src, dst, embSrc, embDst types.IPv6Address) bool {
+	outer := pkt.GetIPv4NoCheck()
+	raw := pkt.GetRawPacketBytes()
+	icmpStart := uintptr(pkt.L4) - uintptr(unsafe.Pointer(pkt.Ether))
+	embL4Start := icmpStart + types.ICMPLen + uintptr(emb.ipv4.VersionIhl&0x0f)<<2
+	// Ethernet padding is not a part of packet
+	l3Start := uintptr(pkt.L3) - uintptr(unsafe.Pointer(pkt.Ether))
+	if end := int(l3Start) + int(packet.SwapBytesUint16(outer.TotalLength)); end < len(raw) {
+		raw = raw[:end]
+	}
+	if int(embL4Start) > len(raw) {
+		return false
+	}
+	payload := raw[embL4Start:]
+	// ICMPv6 error message should not exceed minimum IPv6 MTU (RFC
+	// 4443 section 2.4)
+	if maxLen := ipv6MinMTU - types.IPv6Len - types.ICMPLen - types.IPv6Len; len(payload) > maxLen {
+		payload = payload[:maxLen]
+	}
+
+	data := make([]byte, types.IPv6Len+types.ICMPLen+types.IPv6Len+len(payload))
+	fillIPv6Hdr((*packet.IPv6Hdr)(unsafe.Pointer(&data[0])), outer, uint16(len(data)-types.IPv6Len), src, dst)
+	copy(data[types.IPv6Len:], raw[icmpStart:icmpStart+types.ICMPLen])
+	embStart := types.IPv6Len + types.ICMPLen
+	embHdrLen := uint16(emb.ipv4.VersionIhl&0x0f) << 2
+	fillIPv6Hdr((*packet.IPv6Hdr)(unsafe.Pointer(&data[embStart])), emb.ipv4, packet.SwapBytesUint16(emb.ipv4.TotalLength)-embHdrLen, embSrc, embDst)
+	copy(data[embStart+types.IPv6Len:], payload)
+
+	if !replaceL3(pkt, pktVLAN, data, types.IPV6Number) {
+		return false
+	}
+	pkt.ParseL4ForIPv6()
+	return true
+}
+
+// Sets destination port of IPv6 packet translated from IPv4 and
+// calculates its L4 checksum in software, so that packet can be
+// fragmented afterwards.
+func setNAT64DstPort(pkt *packet.Packet, port uint16, pktTCP *packet.TCPHdr, pktUDP *packet.UDPHdr, pktICMP *packet.ICMPHdr) {
+	if pktTCP != nil {
+		pktTCP.DstPort = packet.SwapBytesUint16(port)
+		setIPv6TCPChecksum(pkt, !NoCalculateChecksum, false)
+	} else if pktUDP != nil {
+		pktUDP.DstPort = packet.SwapBytesUint16(port)
+		setIPv6UDPChecksum(pkt, !NoCalculateChecksum, false)
+	} else {
+		pktICMP.Identifier = packet.SwapBytesUint16(port)
+		setIPv6ICMPChecksum(pkt, !NoCalculateChecksum, false)
+	}
+}
+
+// Fills IPv6 header and Fragment header of fragment which carries
+// payload at offset of original packet with header hdr.
+func fillIPv6FragmentHdrs(data []byte, hdr *packet.IPv6Hdr, payloadLen, offset uint, more bool, id uint32) {
+	out := (*packet.IPv6Hdr)(unsafe.Pointer(&data[0]))
+	*out = *hdr
+	out.PayloadLen = packet.SwapBytesUint16(uint16(ipv6FragmentHeaderLen + payloadLen))
+	out.Proto = ipv6FragmentHeaderNumber
+	frag := (*ipv6FragmentHdr)(unsafe.Pointer(&data[types.IPv6Len]))
+	frag.NextHeader = hdr.Proto
+	frag.Reserved = 0
+	fo := uint16(offset)
+	if more {
+		fo |= 1
+	}
+	frag.FragOffsetFlags = packet.SwapBytesUint16(fo)
+	frag.Identification = packet.SwapBytesUint32(id)
+}
+
+// Splits IPv6 packet which exceeds mtu into fragments (RFC 8200
+// section 4.5). Packet which is already a fragment keeps its
+// identification and offset. Packet itself becomes the first
+// fragment, other fragments are sent to port out. L4 checksum should
+// be already calculated.
+func fragmentIPv6(pkt *packet.Packet, pktVLAN *packet.VLANHdr, mtu uint, id uint32, out *ipPort) bool {
+	l2len := getL2Len(pktVLAN)
+	hdr := *pkt.GetIPv6NoCheck()
+	raw := pkt.GetRawPacketBytes()
+	start := l2len + types.IPv6Len
+	end := start + uint(packet.SwapBytesUint16(hdr.PayloadLen))
+	if end > uint(len(raw)) {
+		return false
+	}
+	if end-l2len <= mtu {
+		return true
+	}
+	var base uint
+	var more bool
+	if hdr.Proto == ipv6FragmentHeaderNumber {
+		if end < start+ipv6FragmentHeaderLen {
+			return false
+		}
+		frag := (*ipv6FragmentHdr)(unsafe.Pointer(&raw[start]))
+		fo := packet.SwapBytesUint16(frag.FragOffsetFlags)
+		base = uint(fo &^ 7)
+		more = fo&1 != 0
+		id = packet.SwapBytesUint32(frag.Identification)
+		hdr.Proto = frag.NextHeader
+		start += ipv6FragmentHeaderLen
+	}
+	l2 := append([]byte(nil), raw[:l2len]...)
+	payload := append([]byte(nil), raw[start:end]...)
+	chunk := (mtu - types.IPv6Len - ipv6FragmentHeaderLen) &^ 7
+	total := uint(len(payload))
+
+	data := make([]byte, types.IPv6Len+ipv6FragmentHeaderLen+chunk)
+	fillIPv6FragmentHdrs(data, &hdr, chunk, base, true, id)
+	copy(data[types.IPv6Len+ipv6FragmentHeaderLen:], payload[:chunk])
+	if !replaceL3(pkt, pktVLAN, data, types.IPV6Number) {
+		return false
+	}
+	pkt.ParseL4ForIPv6()
+
+	for offset := chunk; offset < total; offset += chunk {
+		n := total - offset
+		if n > chunk {
+			n = chunk
+		}
+		data := make([]byte, l2len+types.IPv6Len+ipv6FragmentHeaderLen+n)
+		copy(data, l2)
+		fillIPv6FragmentHdrs(data[l2len:], &hdr, n, base+offset, more || offset+n < total, id)
+		copy(data[l2len+types.IPv6Len+ipv6FragmentHeaderLen:], payload[offset:offset+n])
+
+		fragment, err := packet.NewPacket()
+		if err != nil {
+			return false
+		}
+		packet.GeneratePacketFromByte(fragment, data)
+		out.dumpPacket(fragment, DirSEND)
+		fragment.SendPacket(out.Index)
+	}
+	return true
+}
+
+// Sends ICMPv4 Fragmentation Needed message (RFC 1191) with next hop
+// mtu back to source of IPv4 packet which cannot be translated
+// because it is too big and has DF flag set.
+func (port *ipPort) sendFragmentationNeeded(pkt *packet.Packet, pktVLAN *packet.VLANHdr, pktIPv4 *packet.IPv4Hdr, mtu uint) {
+	l2len := getL2Len(pktVLAN)
+	raw := pkt.GetRawPacketBytes()
+	end := l2len + uint(packet.SwapBytesUint16(pktIPv4.TotalLength))
+	if end > uint(len(raw)) {
+		end = uint(len(raw))
+	}
+	if maxEnd := l2len + icmpv4ErrorMaxLen - types.IPv4MinLen - types.ICMPLen; end > maxEnd {
+		end = maxEnd
+	}
+
+	data := make([]byte, l2len+types.IPv4MinLen+types.ICMPLen+end-l2len)
+	copy(data, raw[:l2len])
+	copy(data[l2len+types.IPv4MinLen+types.ICMPLen:], raw[l2len:end])
+	ether := (*packet.EtherHdr)(unsafe.Pointer(&data[0]))
+	ether.DAddr = pkt.Ether.SAddr
+	ether.SAddr = port.SrcMACAddress
+	ip := (*packet.IPv4Hdr)(unsafe.Pointer(&data[l2len]))
+	ip.VersionIhl = 4<<4 | types.IPv4MinLen>>2
+	ip.TotalLength = packet.SwapBytesUint16(uint16(len(data) - int(l2len)))
+	ip.TimeToLive = 64
+	ip.NextProtoID = types.ICMPNumber
+	ip.SrcAddr = packet.SwapBytesIPv4Addr(port.Subnet.Addr)
+	ip.DstAddr = pktIPv4.SrcAddr
+	icmp := (*packet.ICMPHdr)(unsafe.Pointer(&data[l2len+types.IPv4MinLen]))
+	icmp.Type = icmpTypeDestUnreachable
+	icmp.Code = 4
+	*icmpRestOfHeader(icmp) = [4]uint8{0, 0, uint8(mtu >> 8), uint8(mtu)}
+
+	answerPacket, err := packet.NewPacket()
+	if err != nil {
+		return
+	}
+	packet.GeneratePacketFromByte(answerPacket, data)
+	answerPacket.ParseL3CheckVLAN()
+	answerPacket.ParseL4ForIPv4()
+	setIPv4ICMPChecksum(answerPacket, !NoCalculateChecksum, !NoHWTXChecksum)
+	port.dumpPacket(answerPacket, DirSEND)
+	answerPacket.SendPacket(port.Index)
+}
+
+// Translates packet sent by private IPv6 host to NAT64 prefix into
+// IPv4 packet (RFC 6146). New sessions get public address and port
+// from IPv4 address pool. IPv6 extension headers other than Fragment
+// header are not supported.
+func (pp *portPair) translateNAT64Pri2Pub(pkt *packet.Packet, pktVLAN *packet.VLANHdr, pktIPv6 *packet.IPv6Hdr, protocol uint8,
+	pktTCP *packet.TCPHdr, pktUDP *packet.UDPHdr, pktICMP *packet.ICMPHdr, srcPort, dstPort uint16, fragmented bool) uint {
+	port := &pp.PrivatePort
+
+	// Only TCP, UDP and ICMP packets can be translated to IPv4
+	if protocol != types.TCPNumber && protocol != types.UDPNumber && protocol != types.ICMPv6Number {
+		return port.dropPacket(pkt, dropUnsupportedProtocol)
+	}
+	// ICMPv6 checksum covers length of the whole packet which is
+	// unknown when its first fragment is translated
+	if fragmented && pktICMP != nil {
+		return port.dropPacket(pkt, dropFragment)
+	}
+	if !port.Subnet6.addressAcquired || !port.opposite.Subnet.addressAcquired {
+		return port.dropPacket(pkt, dropNoAddress)
+	}
+	if pktICMP != nil {
+		if isICMPError(protocol, pktICMP) {
+			return pp.translateNAT64ICMPErrorPri2Pub(pkt, pktVLAN, pktIPv6)
+		}
+		if pktICMP.Type != types.ICMPv6TypeEchoRequest && pktICMP.Type != types.ICMPv6TypeEchoResponse {
+			return port.dropPacket(pkt, dropUnsupportedProtocol)
+		}
+		protocol = types.ICMPNumber
+	}
+
+	dstAddr := pp.NAT64Prefix.extract(pktIPv6.DstAddr)
+	key := addRemoteToKey(makeTuple64(pktIPv6.SrcAddr, srcPort), pp.MappingBehavior, protocol, 0, pktIPv6.DstAddr, dstPort)
+
+	var pubAddr types.IPv4Address
+	var pubPort uint16
+	var poolIndex int
+	pme, found := port.translationTable[protocol].Load(key)
+	if found {
+		var inPool bool
+		pubAddr, _, pubPort, _ = getAddrFromTuple(pme.pub)
+		poolIndex, inPool = pp.PublicPort.getPoolIndex(false, pubAddr, zeroIPv6Addr)
+		if !inPool {
+			return port.dropPacket(pkt, dropAddressChanged)
+		}
+	} else {
+		port.arpTable.Store(pktIPv6.SrcAddr, pkt.Ether.SAddr)
+		var err error
+		pubAddr, _, pubPort, poolIndex, err = pp.allocateNewEgressConnection(false, protocol, key)
+		if err != nil {
+			if err != errSessionLimit {
+				println("Warning! Failed to allocate new NAT64 connection", err.Error())
+			}
+			return port.dropPacket(pkt, allocationDropReason(err))
+		}
+	}
+
+	if pme == nil {
+		pme = &pp.PublicPort.getPortmap(false, poolIndex, protocol)[pubPort]
+	}
+	pme.touch()
+	// Remember remote endpoint so that inbound packets from it
+	// are allowed
+	if remotes := pme.getRemotes(); remotes != nil {
+		remoteKey := makeRemoteKey(pp.FilteringBehavior, false, protocol, dstAddr, zeroIPv6Addr, dstPort)
+		if _, known := remotes.Load(remoteKey); !known {
+			remotes.Store(remoteKey, true)
+		}
+	}
+	if pktTCP != nil {
+		pp.trackTCPState(pktTCP, pme, pri2pub)
+	}
+
+	mac, found := port.opposite.getMACForIPv4(dstAddr)
+	if !found {
+		return port.dropPacket(pkt, dropNeighborUnresolved)
+	}
+
+	// Do packet translation
+	pkt.Ether.DAddr = mac
+	pkt.Ether.SAddr = port.opposite.SrcMACAddress
+	if pktVLAN != nil {
+		pktVLAN.SetVLANTagIdentifier(port.opposite.Vlan)
+	}
+	if pktICMP != nil {
+		translateICMPv6Type(pktICMP)
+	}
+	if fragmented {
+		// L4 checksum covers other fragments too
+		newFirstFragment(nil, pktIPv6, protocol, pktTCP, pktUDP, nil).setPortNAT64(true, pubPort,
+			packet.SwapBytesIPv4Addr(pubAddr), packet.SwapBytesIPv4Addr(dstAddr), pktIPv6.SrcAddr, pktIPv6.DstAddr, 0)
+	}
+	if !convertIPv6ToIPv4(pkt, pktVLAN, pubAddr, dstAddr) {
+		return port.dropPacket(pkt, dropTranslationFailed)
+	}
+	if !fragmented {
+		setPacketSrcPort(pkt, false, protocol, pubPort, pktTCP, pktUDP, pktICMP)
+	}
+
+	port.opposite.dumpPacket(pkt, DirSEND)
+	return DirSEND
+}
+
+// Translates IPv4 packet of NAT64 session into IPv6 packet for
+// private host. Session is already checked by caller.
+func (pp *portPair) translateNAT64Pub2Pri(pkt *packet.Packet, pktVLAN *packet.VLANHdr, pktIPv4 *packet.IPv4Hdr,
+	pktTCP *packet.TCPHdr, pktUDP *packet.UDPHdr, pktICMP *packet.ICMPHdr, privAddr types.IPv6Address, privPort uint16, fragmented bool) uint {
+	port := &pp.PublicPort
+	protocol := pktIPv4.NextProtoID
+
+	// ICMPv6 checksum covers length of the whole packet which is
+	// unknown when its first fragment is translated. UDP checksum
+	// which is absent in IPv4 cannot be calculated for fragments.
+	if fragmented && (pktICMP != nil || (pktUDP != nil && pktUDP.DgramCksum == 0)) {
+		return port.dropPacket(pkt, dropFragment)
+	}
+	if pktICMP != nil && !translateICMPv4Type(pktICMP) {
+		return port.dropPacket(pkt, dropTranslationFailed)
+	}
+
+	// Packets with DF flag which don't fit into MTU of private port
+	// after translation are answered with Fragmentation Needed
+	// error. Packets without DF flag which exceed minimum IPv6 MTU
+	// are fragmented to it because path MTU is unknown (RFC 7915
+	// section 4). Fragments are fragmented further in the same way.
+	hdrLen := uint(pktIPv4.VersionIhl&0x0f) << 2
+	size := types.IPv6Len + uint(packet.SwapBytesUint16(pktIPv4.TotalLength)) - hdrLen
+	mtu := port.opposite.getMTU()
+	dontFragment := !fragmented && packet.SwapBytesUint16(pktIPv4.FragmentOffset)&0x4000 != 0
+	if dontFragment && size > mtu {
+		port.sendFragmentationNeeded(pkt, pktVLAN, pktIPv4, mtu-types.IPv6Len+hdrLen)
+		return port.dropPacket(pkt, dropPacketTooBig)
+	}
+	split := !fragmented && !dontFragment && size > ipv6MinMTU
+
+	mac, found := port.opposite.getMACForIPv6(privAddr)
+	if !found {
+		return port.dropPacket(pkt, dropNeighborUnresolved)
+	}
+
+	// Do packet translation
+	pkt.Ether.DAddr = mac
+	pkt.Ether.SAddr = port.opposite.SrcMACAddress
+	if pktVLAN != nil {
+		pktVLAN.SetVLANTagIdentifier(port.opposite.Vlan)
+	}
+	srcAddr := pp.NAT64Prefix.embed(packet.SwapBytesIPv4Addr(pktIPv4.SrcAddr))
+	id := uint32(packet.SwapBytesUint16(pktIPv4.PacketID))
+	if fragmented {
+		// L4 checksum covers other fragments too
+		newFirstFragment(pktIPv4, nil, protocol, pktTCP, pktUDP, nil).setPortNAT64(false, privPort,
+			pktIPv4.SrcAddr, pktIPv4.DstAddr, srcAddr, privAddr, 0)
+	}
+	if !convertIPv4ToIPv6(pkt, pktVLAN, srcAddr, privAddr) {
+		return port.dropPacket(pkt, dropTranslationFailed)
+	}
+	if fragmented {
+		if !fragmentIPv6(pkt, pktVLAN, ipv6MinMTU, 0, port.opposite) {
+			return port.dropPacket(pkt, dropTranslationFailed)
+		}
+	} else if split {
+		setNAT64DstPort(pkt, privPort, pktTCP, pktUDP, pktICMP)
+		if !fragmentIPv6(pkt, pktVLAN, ipv6MinMTU, id, port.opposite) {
+			return port.dropPacket(pkt, dropTranslationFailed)
+		}
+	} else {
+		setPacketDstPort(pkt, true, protocol, privPort, pktTCP, pktUDP, pktICMP)
+	}
+
+	port.opposite.dumpPacket(pkt, DirSEND)
+	return DirSEND
+}
+
+// Translates ICMPv6 error message sent to NAT64 prefix. Original
+// packet embedded into it was received from public network, so its
+// source is in NAT64 prefix and its destination is private address
+// and port of a NAT64 session.
+func (pp *portPair) translateNAT64ICMPErrorPri2Pub(pkt *packet.Packet, pktVLAN *packet.VLANHdr, pktIPv6 *packet.IPv6Hdr) uint {
+	port := &pp.PrivatePort
+
+	emb, ok := parseICMPEmbedded(pkt, true)
+	if !ok || !pp.NAT64Prefix.contains(emb.ipv6.SrcAddr) {
+		return port.dropPacket(pkt, dropTranslationFailed)
+	}
+	protocol := emb.protocol
+	if protocol == types.ICMPv6Number {
+		protocol = types.ICMPNumber
+	}
+
+	_, privAddr, privPort := emb.getAddrPort(false)
+	_, remoteAddr, remotePort := emb.getAddrPort(true)
+	key := addRemoteToKey(makeTuple64(privAddr, privPort), pp.MappingBehavior, protocol, 0, remoteAddr, remotePort)
+	pme, found := port.translationTable[protocol].Load(key)
+	if !found {
+		return port.dropPacket(pkt, dropNoSession)
+	}
+	pubAddr, _, pubPort, _ := getAddrFromTuple(pme.pub)
+	if _, inPool := pp.PublicPort.getPoolIndex(false, pubAddr, zeroIPv6Addr); !inPool {
+		return port.dropPacket(pkt, dropAddressChanged)
+	}
+	if pme.expired(protocol) {
+		return port.dropPacket(pkt, dropSessionExpired)
+	}
+
+	dstAddr := pp.NAT64Prefix.extract(pktIPv6.DstAddr)
+	mac, found := port.opposite.getMACForIPv4(dstAddr)
+	if !found {
+		return port.dropPacket(pkt, dropNeighborUnresolved)
+	}
+	if !translateICMPv6Type((*packet.ICMPHdr)(pkt.L4)) {
+		return port.dropPacket(pkt, dropTranslationFailed)
+	}
+
+	// Do packet translation. Error source becomes public address of
+	// NAT64 session.
+	pkt.Ether.DAddr = mac
+	pkt.Ether.SAddr = port.opposite.SrcMACAddress
+	if pktVLAN != nil {
+		pktVLAN.SetVLANTagIdentifier(port.opposite.Vlan)
+	}
+	remote4 := pp.NAT64Prefix.extract(remoteAddr)
+	emb.setPortNAT64(false, pubPort, packet.SwapBytesIPv4Addr(remote4), packet.SwapBytesIPv4Addr(pubAddr),
+		emb.ipv6.SrcAddr, emb.ipv6.DstAddr, packet.SwapBytesUint16(emb.ipv6.PayloadLen))
+	if !convertICMPv6ErrorToIPv4(pkt, pktVLAN, emb, pubAddr, dstAddr, remote4, pubAddr) {
+		return port.dropPacket(pkt, dropTranslationFailed)
+	}
+	setICMPChecksum(pkt, false)
+
+	port.opposite.dumpPacket(pkt, DirSEND)
+	return DirSEND
+}
+
+// Translates ICMPv4 error message about a packet of NAT64 session.
+// Original packet embedded into it was sent by private host, its
+// source is public address and port of the session. Session is
+// already checked by caller.
+func (pp *portPair) translateNAT64ICMPErrorPub2Pri(pkt *packet.Packet, pktVLAN *packet.VLANHdr, pktIPv4 *packet.IPv4Hdr, emb *partialPacket,
+	privAddr types.IPv6Address, privPort uint16) uint {
+	port := &pp.PublicPort
+
+	mac, found := port.opposite.getMACForIPv6(privAddr)
+	if !found {
+		return port.dropPacket(pkt, dropNeighborUnresolved)
+	}
+	if !translateICMPv4Type((*packet.ICMPHdr)(pkt.L4)) {
+		return port.dropPacket(pkt, dropTranslationFailed)
+	}
+
+	// Do packet translation
+	pkt.Ether.DAddr = mac
+	pkt.Ether.SAddr = port.opposite.SrcMACAddress
+	if pktVLAN != nil {
+		pktVLAN.SetVLANTagIdentifier(port.opposite.Vlan)
+	}
+	srcAddr := pp.NAT64Prefix.embed(packet.SwapBytesIPv4Addr(pktIPv4.SrcAddr))
+	remoteAddr := pp.NAT64Prefix.embed(packet.SwapBytesIPv4Addr(emb.ipv4.DstAddr))
+	embHdrLen := uint16(emb.ipv4.VersionIhl&0x0f) << 2
+	emb.setPortNAT64(true, privPort, emb.ipv4.SrcAddr, emb.ipv4.DstAddr,
+		privAddr, remoteAddr, packet.SwapBytesUint16(emb.ipv4.TotalLength)-embHdrLen)
+	if !convertICMPv4ErrorToIPv6(pkt, pktVLAN, emb, srcAddr, privAddr, privAddr, remoteAddr) {
+		return port.dropPacket(pkt, dropTranslationFailed)
+	}
+	setICMPChecksum(pkt, true)
+
+	port.opposite.dumpPacket(pkt, DirSEND)
+	return DirSEND
+}
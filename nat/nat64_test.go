@@ -0,0 +1,150 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"testing"
+
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/types"
+)
+
+// ICMP message fields changed by NAT64 translation
+type testICMPMessage struct {
+	typ  uint8
+	code uint8
+	// Four bytes after checksum
+	rest [4]uint8
+}
+
+func testTranslateICMPType(t *testing.T, name string, translate func(*packet.ICMPHdr) bool,
+	in, out testICMPMessage, ok bool) {
+	icmp := &packet.ICMPHdr{
+		Type: in.typ,
+		Code: in.code,
+	}
+	*icmpRestOfHeader(icmp) = in.rest
+	if translated := translate(icmp); translated != ok {
+		t.Errorf("%s: translated %t, expected %t", name, translated, ok)
+		return
+	}
+	if !ok {
+		return
+	}
+	result := testICMPMessage{icmp.Type, icmp.Code, *icmpRestOfHeader(icmp)}
+	if result != out {
+		t.Errorf("%s: translated to %+v, expected %+v", name, result, out)
+	}
+}
+
+func TestTranslateICMPv6Type(t *testing.T) {
+	tests := []struct {
+		name string
+		in   testICMPMessage
+		out  testICMPMessage
+		ok   bool
+	}{
+		{"EchoRequest", testICMPMessage{types.ICMPv6TypeEchoRequest, 0, [4]uint8{0, 1, 0, 2}},
+			testICMPMessage{types.ICMPTypeEchoRequest, 0, [4]uint8{0, 1, 0, 2}}, true},
+		{"EchoReply", testICMPMessage{types.ICMPv6TypeEchoResponse, 0, [4]uint8{0, 1, 0, 2}},
+			testICMPMessage{types.ICMPTypeEchoResponse, 0, [4]uint8{0, 1, 0, 2}}, true},
+		{"NoRoute", testICMPMessage{icmpv6TypeDestUnreachable, 0, [4]uint8{}},
+			testICMPMessage{icmpTypeDestUnreachable, 1, [4]uint8{}}, true},
+		{"AdminProhibited", testICMPMessage{icmpv6TypeDestUnreachable, 1, [4]uint8{}},
+			testICMPMessage{icmpTypeDestUnreachable, 10, [4]uint8{}}, true},
+		{"AddressUnreachable", testICMPMessage{icmpv6TypeDestUnreachable, 3, [4]uint8{}},
+			testICMPMessage{icmpTypeDestUnreachable, 1, [4]uint8{}}, true},
+		{"PortUnreachable", testICMPMessage{icmpv6TypeDestUnreachable, 4, [4]uint8{}},
+			testICMPMessage{icmpTypeDestUnreachable, 3, [4]uint8{}}, true},
+		{"UnknownUnreachableCode", testICMPMessage{icmpv6TypeDestUnreachable, 5, [4]uint8{}},
+			testICMPMessage{}, false},
+		{"PacketTooBig", testICMPMessage{icmpv6TypePacketTooBig, 0, [4]uint8{0, 0, 0x05, 0xdc}},
+			testICMPMessage{icmpTypeDestUnreachable, 4, [4]uint8{0, 0, 0x05, 0xc8}}, true},
+		{"PacketTooBigLargeMTU", testICMPMessage{icmpv6TypePacketTooBig, 0, [4]uint8{0, 1, 0, 100}},
+			testICMPMessage{icmpTypeDestUnreachable, 4, [4]uint8{0, 0, 0xff, 0xff}}, true},
+		{"PacketTooBigTinyMTU", testICMPMessage{icmpv6TypePacketTooBig, 0, [4]uint8{0, 0, 0, 10}},
+			testICMPMessage{}, false},
+		{"TimeExceeded", testICMPMessage{icmpv6TypeTimeExceeded, 1, [4]uint8{1, 2, 3, 4}},
+			testICMPMessage{icmpTypeTimeExceeded, 1, [4]uint8{}}, true},
+		{"ParameterProblemTrafficClass", testICMPMessage{icmpv6TypeParameterProblem, 0, [4]uint8{0, 0, 0, 1}},
+			testICMPMessage{icmpTypeParameterProblem, 0, [4]uint8{1, 0, 0, 0}}, true},
+		{"ParameterProblemPayloadLength", testICMPMessage{icmpv6TypeParameterProblem, 0, [4]uint8{0, 0, 0, 4}},
+			testICMPMessage{icmpTypeParameterProblem, 0, [4]uint8{2, 0, 0, 0}}, true},
+		{"ParameterProblemNextHeader", testICMPMessage{icmpv6TypeParameterProblem, 0, [4]uint8{0, 0, 0, 6}},
+			testICMPMessage{icmpTypeParameterProblem, 0, [4]uint8{9, 0, 0, 0}}, true},
+		{"ParameterProblemHopLimit", testICMPMessage{icmpv6TypeParameterProblem, 0, [4]uint8{0, 0, 0, 7}},
+			testICMPMessage{icmpTypeParameterProblem, 0, [4]uint8{8, 0, 0, 0}}, true},
+		{"ParameterProblemSource", testICMPMessage{icmpv6TypeParameterProblem, 0, [4]uint8{0, 0, 0, 23}},
+			testICMPMessage{icmpTypeParameterProblem, 0, [4]uint8{12, 0, 0, 0}}, true},
+		{"ParameterProblemDestination", testICMPMessage{icmpv6TypeParameterProblem, 0, [4]uint8{0, 0, 0, 24}},
+			testICMPMessage{icmpTypeParameterProblem, 0, [4]uint8{16, 0, 0, 0}}, true},
+		{"ParameterProblemFlowLabel", testICMPMessage{icmpv6TypeParameterProblem, 0, [4]uint8{0, 0, 0, 2}},
+			testICMPMessage{}, false},
+		{"ParameterProblemBeyondHeader", testICMPMessage{icmpv6TypeParameterProblem, 0, [4]uint8{0, 0, 0, 40}},
+			testICMPMessage{}, false},
+		{"UnrecognizedNextHeader", testICMPMessage{icmpv6TypeParameterProblem, 1, [4]uint8{0, 0, 0, 40}},
+			testICMPMessage{icmpTypeDestUnreachable, 2, [4]uint8{}}, true},
+		{"UnrecognizedOption", testICMPMessage{icmpv6TypeParameterProblem, 2, [4]uint8{0, 0, 0, 40}},
+			testICMPMessage{}, false},
+		{"NeighborSolicitation", testICMPMessage{135, 0, [4]uint8{}},
+			testICMPMessage{}, false},
+	}
+	for _, tt := range tests {
+		testTranslateICMPType(t, tt.name, translateICMPv6Type, tt.in, tt.out, tt.ok)
+	}
+}
+
+func TestTranslateICMPv4Type(t *testing.T) {
+	tests := []struct {
+		name string
+		in   testICMPMessage
+		out  testICMPMessage
+		ok   bool
+	}{
+		{"EchoRequest", testICMPMessage{types.ICMPTypeEchoRequest, 0, [4]uint8{0, 1, 0, 2}},
+			testICMPMessage{types.ICMPv6TypeEchoRequest, 0, [4]uint8{0, 1, 0, 2}}, true},
+		{"EchoReply", testICMPMessage{types.ICMPTypeEchoResponse, 0, [4]uint8{0, 1, 0, 2}},
+			testICMPMessage{types.ICMPv6TypeEchoResponse, 0, [4]uint8{0, 1, 0, 2}}, true},
+		{"NetUnreachable", testICMPMessage{icmpTypeDestUnreachable, 0, [4]uint8{}},
+			testICMPMessage{icmpv6TypeDestUnreachable, 0, [4]uint8{}}, true},
+		{"HostUnreachable", testICMPMessage{icmpTypeDestUnreachable, 1, [4]uint8{}},
+			testICMPMessage{icmpv6TypeDestUnreachable, 0, [4]uint8{}}, true},
+		{"ProtocolUnreachable", testICMPMessage{icmpTypeDestUnreachable, 2, [4]uint8{}},
+			testICMPMessage{icmpv6TypeParameterProblem, 1, [4]uint8{0, 0, 0, 6}}, true},
+		{"PortUnreachable", testICMPMessage{icmpTypeDestUnreachable, 3, [4]uint8{}},
+			testICMPMessage{icmpv6TypeDestUnreachable, 4, [4]uint8{}}, true},
+		{"FragmentationNeeded", testICMPMessage{icmpTypeDestUnreachable, 4, [4]uint8{0, 0, 0x05, 0xc8}},
+			testICMPMessage{icmpv6TypePacketTooBig, 0, [4]uint8{0, 0, 0x05, 0xdc}}, true},
+		{"FragmentationNeededNoMTU", testICMPMessage{icmpTypeDestUnreachable, 4, [4]uint8{}},
+			testICMPMessage{icmpv6TypePacketTooBig, 0, [4]uint8{0, 0, 0x05, 0x00}}, true},
+		{"SourceRouteFailed", testICMPMessage{icmpTypeDestUnreachable, 5, [4]uint8{}},
+			testICMPMessage{icmpv6TypeDestUnreachable, 0, [4]uint8{}}, true},
+		{"AdminProhibited", testICMPMessage{icmpTypeDestUnreachable, 13, [4]uint8{}},
+			testICMPMessage{icmpv6TypeDestUnreachable, 1, [4]uint8{}}, true},
+		{"PrecedenceViolation", testICMPMessage{icmpTypeDestUnreachable, 14, [4]uint8{}},
+			testICMPMessage{}, false},
+		{"TimeExceeded", testICMPMessage{icmpTypeTimeExceeded, 0, [4]uint8{1, 2, 3, 4}},
+			testICMPMessage{icmpv6TypeTimeExceeded, 0, [4]uint8{}}, true},
+		{"ParameterProblemTotalLength", testICMPMessage{icmpTypeParameterProblem, 0, [4]uint8{2, 0, 0, 0}},
+			testICMPMessage{icmpv6TypeParameterProblem, 0, [4]uint8{0, 0, 0, 4}}, true},
+		{"ParameterProblemTTL", testICMPMessage{icmpTypeParameterProblem, 0, [4]uint8{8, 0, 0, 0}},
+			testICMPMessage{icmpv6TypeParameterProblem, 0, [4]uint8{0, 0, 0, 7}}, true},
+		{"ParameterProblemProtocol", testICMPMessage{icmpTypeParameterProblem, 2, [4]uint8{9, 0, 0, 0}},
+			testICMPMessage{icmpv6TypeParameterProblem, 0, [4]uint8{0, 0, 0, 6}}, true},
+		{"ParameterProblemSource", testICMPMessage{icmpTypeParameterProblem, 0, [4]uint8{15, 0, 0, 0}},
+			testICMPMessage{icmpv6TypeParameterProblem, 0, [4]uint8{0, 0, 0, 8}}, true},
+		{"ParameterProblemDestination", testICMPMessage{icmpTypeParameterProblem, 0, [4]uint8{16, 0, 0, 0}},
+			testICMPMessage{icmpv6TypeParameterProblem, 0, [4]uint8{0, 0, 0, 24}}, true},
+		{"ParameterProblemChecksum", testICMPMessage{icmpTypeParameterProblem, 0, [4]uint8{10, 0, 0, 0}},
+			testICMPMessage{}, false},
+		{"ParameterProblemMissingOption", testICMPMessage{icmpTypeParameterProblem, 1, [4]uint8{}},
+			testICMPMessage{}, false},
+		{"Redirect", testICMPMessage{5, 0, [4]uint8{}},
+			testICMPMessage{}, false},
+	}
+	for _, tt := range tests {
+		testTranslateICMPType(t, tt.name, translateICMPv4Type, tt.in, tt.out, tt.ok)
+	}
+}
@@ -0,0 +1,464 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/types"
+)
+
+const (
+	defaultTranslationLogFileSize = 100
+	defaultTranslationLogFiles    = 10
+	// Number of events which may wait for logging. Events which
+	// don't fit are lost.
+	translationLogQueueSize = 8192
+	// How often buffered lines are written to log file
+	translationLogFlushInterval = time.Second
+	// Minimal interval between attempts to reconnect to syslog
+	// server
+	syslogRedialInterval = 5 * time.Second
+	// Syslog facility local0 and severity informational
+	syslogPriority = 16*8 + 6
+	syslogAppName  = "nff-go-nat"
+	// Structured data ID, private enterprise number is the one
+	// reserved for documentation by RFC 5612
+	syslogSDID = "nat@32473"
+)
+
+// Address of syslog server in a form of URL, e.g. udp://host:514,
+// tcp://host:601 or unix:///dev/log.
+type syslogAddress struct {
+	network, address string
+}
+
+// UnmarshalJSON parses syslog server URL.
+func (out *syslogAddress) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	parts := strings.SplitN(s, "://", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return errors.New("Bad syslog address: " + s)
+	}
+	switch parts[0] {
+	case "udp", "tcp":
+		out.network = parts[0]
+	case "unix":
+		out.network = "unixgram"
+	default:
+		return errors.New("Bad syslog address: " + s)
+	}
+	out.address = parts[1]
+	return nil
+}
+
+func (a syslogAddress) String() string {
+	if a.network == "unixgram" {
+		return "unix://" + a.address
+	}
+	return a.network + "://" + a.address
+}
+
+// Destinations of translation log. Log is written to syslog server,
+// to local files or to both.
+type translationLogConfig struct {
+	Syslog syslogAddress `json:"syslog"`
+	File   string        `json:"file"`
+	// Maximum size of log file in megabytes after which it is
+	// rotated
+	MaxFileSize int `json:"max-file-size"`
+	// Number of rotated log files which are kept
+	MaxFiles int `json:"max-files"`
+}
+
+func (c *translationLogConfig) enabled() bool {
+	return c.Syslog.network != "" || c.File != ""
+}
+
+// Checks configuration and sets default rotation parameters.
+func (c *translationLogConfig) check() error {
+	if c.MaxFileSize < 0 || c.MaxFiles < 0 {
+		return fmt.Errorf("Translation log file size %d and number of files %d should not be negative", c.MaxFileSize, c.MaxFiles)
+	}
+	if c.MaxFileSize == 0 {
+		c.MaxFileSize = defaultTranslationLogFileSize
+	}
+	if c.MaxFiles == 0 {
+		c.MaxFiles = defaultTranslationLogFiles
+	}
+	return nil
+}
+
+func (c *translationLogConfig) String() string {
+	var res []string
+	if c.Syslog.network != "" {
+		res = append(res, "syslog "+c.Syslog.String())
+	}
+	if c.File != "" {
+		res = append(res, fmt.Sprintf("file %s rotated at %d MB, %d files kept", c.File, c.MaxFileSize, c.MaxFiles))
+	}
+	return strings.Join(res, ", ")
+}
+
+type translationEventKind uint8
+
+const (
+	eventSessionCreate translationEventKind = iota
+	eventSessionDelete
+	eventPortBlockAssign
+	eventPortBlockRelease
+)
+
+var translationEventNames = [...]string{
+	eventSessionCreate:    "session-create",
+	eventSessionDelete:    "session-delete",
+	eventPortBlockAssign:  "port-block-assign",
+	eventPortBlockRelease: "port-block-release",
+}
+
+// Translation log event. Port block events have subscriber as private
+// address and ports range on public address as public ports.
+type translationEvent struct {
+	kind     translationEventKind
+	time     time.Time
+	pair     int
+	protocol uint8
+	priv     hostAddr
+	privPort uint16
+	pub      hostAddr
+	pubPort  uint16
+	// Last port of port block
+	pubPortEnd uint16
+	remote     hostAddr
+	remotePort uint16
+	duration   time.Duration
+}
+
+func formatEndpoint(addr hostAddr, port uint16, withPort bool) string {
+	if !withPort {
+		return addr.String()
+	}
+	if addr.ipv6 {
+		return "[" + addr.String() + "]:" + strconv.Itoa(int(port))
+	}
+	return addr.String() + ":" + strconv.Itoa(int(port))
+}
+
+// Returns event fields as name and value pairs.
+func (e *translationEvent) fields() []string {
+	res := []string{"pair", strconv.Itoa(e.pair)}
+	if e.kind == eventPortBlockAssign || e.kind == eventPortBlockRelease {
+		res = append(res,
+			"subscriber", e.priv.String(),
+			"public", fmt.Sprintf("%s:%d-%d", e.pub.String(), e.pubPort, e.pubPortEnd))
+	} else {
+		// Addresses only protocols have session handles instead of
+		// public ports
+		ports := !isAddrOnlyProtocol(e.protocol)
+		remote := "-"
+		if !e.remote.isZero() {
+			remote = formatEndpoint(e.remote, e.remotePort, ports)
+		}
+		res = append(res,
+			"protocol", protocolLabel(e.protocol),
+			"private", formatEndpoint(e.priv, e.privPort, ports),
+			"public", formatEndpoint(e.pub, e.pubPort, ports),
+			"remote", remote)
+	}
+	if e.kind != eventSessionCreate && e.kind != eventPortBlockAssign {
+		res = append(res, "duration", e.duration.String())
+	}
+	return res
+}
+
+// Returns event as a line of local log file.
+func (e *translationEvent) fileLine() string {
+	var b strings.Builder
+	b.WriteString(e.time.UTC().Format(time.RFC3339Nano))
+	b.WriteString(" event=")
+	b.WriteString(translationEventNames[e.kind])
+	f := e.fields()
+	for i := 0; i < len(f); i += 2 {
+		fmt.Fprintf(&b, " %s=%s", f[i], f[i+1])
+	}
+	b.WriteByte('\n')
+	return b.String()
+}
+
+// Escapes structured data parameter value according to RFC 5424.
+var syslogEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`, `]`, `\]`)
+
+// Returns event as RFC 5424 syslog message with event fields in
+// structured data.
+func (e *translationEvent) syslogMessage(hostname string, pid int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %d %s [%s", syslogPriority, e.time.UTC().Format(time.RFC3339Nano),
+		hostname, syslogAppName, pid, translationEventNames[e.kind], syslogSDID)
+	f := e.fields()
+	for i := 0; i < len(f); i += 2 {
+		fmt.Fprintf(&b, ` %s="%s"`, f[i], syslogEscaper.Replace(f[i+1]))
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// Writes translation events to syslog server and local files.
+type translationLogger struct {
+	config   translationLogConfig
+	events   chan translationEvent
+	hostname string
+	pid      int
+	// Number of events which were lost because queue was full
+	lost uint64
+
+	syslog   net.Conn
+	lastDial time.Time
+
+	file     *os.File
+	writer   *bufio.Writer
+	fileSize int64
+}
+
+var translationLog *translationLogger
+
+// StartTranslationLog starts writing translation log if it is
+// configured.
+func StartTranslationLog() error {
+	c := &Natconfig.TranslationLog
+	if !c.enabled() {
+		return nil
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	l := &translationLogger{
+		config:   *c,
+		events:   make(chan translationEvent, translationLogQueueSize),
+		hostname: hostname,
+		pid:      os.Getpid(),
+	}
+	if c.File != "" {
+		if err := l.openFile(); err != nil {
+			return err
+		}
+	}
+	if c.Syslog.network != "" {
+		if err := l.dialSyslog(); err != nil {
+			return err
+		}
+	}
+	translationLog = l
+	go l.run()
+	return nil
+}
+
+// Queues event for logging. Event is lost if queue is full, so that
+// packet handlers are never blocked.
+func logTranslationEvent(e *translationEvent) {
+	if translationLog == nil {
+		return
+	}
+	select {
+	case translationLog.events <- *e:
+	default:
+		atomic.AddUint64(&translationLog.lost, 1)
+	}
+}
+
+// Returns number of translation log events which were lost because
+// queue was full.
+func lostTranslationEvents() uint64 {
+	if translationLog == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&translationLog.lost)
+}
+
+func (l *translationLogger) run() {
+	ticker := time.NewTicker(translationLogFlushInterval)
+	for {
+		select {
+		case e := <-l.events:
+			l.write(&e)
+		case <-ticker.C:
+			if l.writer != nil {
+				if err := l.writer.Flush(); err != nil {
+					common.LogWarning(common.Debug, "Failed to write translation log:", err)
+				}
+			}
+		}
+	}
+}
+
+func (l *translationLogger) write(e *translationEvent) {
+	if l.writer != nil {
+		line := e.fileLine()
+		if l.fileSize+int64(len(line)) > int64(l.config.MaxFileSize)<<20 {
+			l.rotate()
+		}
+		if l.writer != nil {
+			n, _ := l.writer.WriteString(line)
+			l.fileSize += int64(n)
+		}
+	}
+
+	if l.config.Syslog.network != "" {
+		if l.syslog == nil && time.Since(l.lastDial) >= syslogRedialInterval {
+			if err := l.dialSyslog(); err != nil {
+				common.LogWarning(common.Debug, "Failed to connect to syslog server:", err)
+			}
+		}
+		if l.syslog == nil {
+			return
+		}
+		msg := e.syslogMessage(l.hostname, l.pid)
+		// TCP transport uses octet counting framing (RFC 6587)
+		if l.config.Syslog.network == "tcp" {
+			msg = strconv.Itoa(len(msg)) + " " + msg
+		}
+		if _, err := l.syslog.Write([]byte(msg)); err != nil {
+			common.LogWarning(common.Debug, "Failed to send translation log to syslog server:", err)
+			l.syslog.Close()
+			l.syslog = nil
+		}
+	}
+}
+
+func (l *translationLogger) dialSyslog() error {
+	l.lastDial = time.Now()
+	conn, err := net.Dial(l.config.Syslog.network, l.config.Syslog.address)
+	if err != nil {
+		return err
+	}
+	l.syslog = conn
+	return nil
+}
+
+func (l *translationLogger) openFile() error {
+	f, err := os.OpenFile(l.config.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file = f
+	l.writer = bufio.NewWriter(f)
+	l.fileSize = info.Size()
+	return nil
+}
+
+// Renames log file to file.1, previous file.1 to file.2 and so on,
+// and starts a new file. The oldest file is removed.
+func (l *translationLogger) rotate() {
+	l.writer.Flush()
+	l.file.Close()
+	l.file = nil
+	l.writer = nil
+
+	name := l.config.File
+	os.Remove(name + "." + strconv.Itoa(l.config.MaxFiles))
+	for i := l.config.MaxFiles - 1; i > 0; i-- {
+		os.Rename(name+"."+strconv.Itoa(i), name+"."+strconv.Itoa(i+1))
+	}
+	if err := os.Rename(name, name+".1"); err != nil {
+		common.LogWarning(common.Debug, "Failed to rotate translation log:", err)
+	}
+	if err := l.openFile(); err != nil {
+		common.LogWarning(common.Debug, "Failed to open translation log:", err)
+	}
+}
+
+// Returns index of port pair which port belongs to.
+func (port *ipPort) getPairIndex() int {
+	for i := range Natconfig.PortPairs {
+		pp := &Natconfig.PortPairs[i]
+		if port == &pp.PublicPort || port == &pp.PrivatePort {
+			return i
+		}
+	}
+	return -1
+}
+
+// Logs creation or deletion of dynamic session with private side key
+// priv and public side key pub to IPFIX collector and translation
+// log. Deleted sessions are also added to translation history.
+// Session creation time gives duration of deleted session.
+func (pp *portPair) logSessionEvent(create bool, t, created time.Time, protocol uint8, priv, pub sessionKey) {
+	pp.exportSessionEvent(create, t, protocol, priv, pub)
+	if translationLog == nil && (create || history == nil) {
+		return
+	}
+
+	e := translationEvent{
+		kind:     eventSessionCreate,
+		time:     t,
+		pair:     pp.PublicPort.getPairIndex(),
+		protocol: protocol,
+	}
+	if !create {
+		e.kind = eventSessionDelete
+		e.duration = t.Sub(created)
+	}
+	e.pub, e.pubPort, _, _ = getTupleEndpoints(pub)
+	e.priv, e.privPort, e.remote, e.remotePort = getTupleEndpoints(priv)
+	logTranslationEvent(&e)
+	if !create {
+		recordHistory(&historyRecord{
+			pair:       e.pair,
+			protocol:   protocol,
+			pub:        e.pub,
+			priv:       e.priv,
+			remote:     e.remote,
+			pubPort:    e.pubPort,
+			privPort:   e.privPort,
+			remotePort: e.remotePort,
+			start:      created,
+			end:        t,
+		})
+	}
+}
+
+// Logs assignment or release of dynamic port block to translation
+// log.
+func (pb *portBlocks) logBlockEvent(assigned bool, subscriber types.IPv4Address, port *ipPort, block int) {
+	if translationLog == nil {
+		return
+	}
+	t := time.Now()
+	index, start, end := pb.blockRange(block)
+	addr, _ := port.getPoolIndexAddr(false, index)
+	e := translationEvent{
+		kind:       eventPortBlockAssign,
+		time:       t,
+		pair:       port.getPairIndex(),
+		priv:       hostAddr{Addr4: subscriber},
+		pub:        hostAddr{Addr4: addr},
+		pubPort:    uint16(start),
+		pubPortEnd: uint16(end - 1),
+	}
+	if !assigned {
+		e.kind = eventPortBlockRelease
+		e.duration = t.Sub(pb.assignTime[block])
+	}
+	logTranslationEvent(&e)
+}
@@ -10,17 +10,34 @@ import (
 	"github.com/intel-go/nff-go/types"
 )
 
+// Range of ICMPv6 Neighbor Discovery message types
+const (
+	icmpv6TypeRouterSolicitation uint8 = 133
+	icmpv6TypeRedirect           uint8 = 137
+)
+
+// Checks whether packet is an IPv6 Neighbor Discovery message. These
+// messages are never translated.
+func isNDMessage(protocol uint8, icmp *packet.ICMPHdr) bool {
+	return protocol == types.ICMPv6Number && icmp != nil &&
+		icmp.Type >= icmpv6TypeRouterSolicitation && icmp.Type <= icmpv6TypeRedirect
+}
+
 func (port *ipPort) handleIPv6NeighborDiscovery(pkt *packet.Packet) uint {
 	icmp := pkt.GetICMPNoCheck()
 	if icmp.Type == types.ICMPv6NeighborSolicitation {
+		pkt.ParseL7(types.ICMPv6Number)
+		msg := pkt.GetICMPv6NeighborSolicitationMessage()
+		// Addresses from address pool and static mappings are not
+		// known to KNI interface, so solicitations for them are
+		// always answered here
+		poolAddr := port.AddressPool.contains6(msg.TargetAddr) || port.isStaticPublicAddr6(msg.TargetAddr)
 		// If there is KNI interface, forward all of this here
-		if port.KNIName != "" {
+		if port.KNIName != "" && !poolAddr {
 			return DirKNI
 		}
-		pkt.ParseL7(types.ICMPv6Number)
-		msg := pkt.GetICMPv6NeighborSolicitationMessage()
-		if msg.TargetAddr != port.Subnet6.Addr && msg.TargetAddr != port.Subnet6.llAddr {
-			return DirDROP
+		if msg.TargetAddr != port.Subnet6.Addr && msg.TargetAddr != port.Subnet6.llAddr && !poolAddr {
+			return dropResult(dropNotForUs)
 		}
 		option := pkt.GetICMPv6NDSourceLinkLayerAddressOption(packet.ICMPv6NeighborSolicitationMessageSize)
 		if option != nil && option.Type == packet.ICMPv6NDSourceLinkLayerAddress {
@@ -55,7 +72,7 @@ func (port *ipPort) handleIPv6NeighborDiscovery(pkt *packet.Packet) uint {
 		return DirSEND
 	}
 
-	return DirDROP
+	return dropResult(dropHandledLocally)
 }
 
 func (port *ipPort) getMACForIPv6(ip types.IPv6Address) (types.MACAddress, bool) {
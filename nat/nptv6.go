@@ -0,0 +1,190 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"unsafe"
+
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/types"
+)
+
+func getIPv6Word(addr *types.IPv6Address, i int) uint16 {
+	return uint16(addr[2*i])<<8 | uint16(addr[2*i+1])
+}
+
+func setIPv6Word(addr *types.IPv6Address, i int, w uint16) {
+	addr[2*i] = uint8(w >> 8)
+	addr[2*i+1] = uint8(w)
+}
+
+func onesComplementAdd(a, b uint16) uint16 {
+	sum := uint32(a) + uint32(b)
+	return uint16(sum&0xffff + sum>>16)
+}
+
+// Translates address from one prefix to another prefix of the same
+// length so that checksum of address doesn't change (RFC 6296
+// section 3.2). Returns false if address cannot be translated.
+func translatePrefixNPTv6(addr, from, to, mask *types.IPv6Address) bool {
+	var fromSum, toSum uint16
+	for i := 0; i < types.IPv6AddrLen/2; i++ {
+		fromSum = onesComplementAdd(fromSum, getIPv6Word(from, i)&getIPv6Word(mask, i))
+		toSum = onesComplementAdd(toSum, getIPv6Word(to, i)&getIPv6Word(mask, i))
+	}
+
+	// Bits 48-63 are adjusted for prefixes up to /48, otherwise
+	// one of interface identifier words which is not 0xffff is
+	// adjusted (RFC 6296 section 3.4)
+	adj := -1
+	if getIPv6Word(mask, 3) == 0 {
+		adj = 3
+	} else {
+		for i := 4; i < types.IPv6AddrLen/2; i++ {
+			if getIPv6Word(mask, i) == 0 && getIPv6Word(addr, i) != 0xffff {
+				adj = i
+				break
+			}
+		}
+	}
+	if adj < 0 || getIPv6Word(addr, adj) == 0xffff {
+		return false
+	}
+
+	for i := range addr {
+		addr[i] = addr[i]&^mask[i] | to[i]&mask[i]
+	}
+	w := onesComplementAdd(onesComplementAdd(getIPv6Word(addr, adj), fromSum), ^toSum)
+	if w == 0xffff {
+		w = 0
+	}
+	setIPv6Word(addr, adj, w)
+	return true
+}
+
+// Returns IPv6 header of original packet embedded into ICMPv6 error
+// message or nil if it is absent.
+func getICMPv6EmbeddedHdr(pkt *packet.Packet, protocol uint8, pktICMP *packet.ICMPHdr) *packet.IPv6Hdr {
+	if pktICMP == nil || !isICMPError(protocol, pktICMP) {
+		return nil
+	}
+	end := uintptr(unsafe.Pointer(pkt.Ether)) + uintptr(pkt.GetPacketSegmentLen())
+	l3 := uintptr(pkt.L4) + types.ICMPLen
+	if l3+types.IPv6Len > end {
+		return nil
+	}
+	return (*packet.IPv6Hdr)(unsafe.Pointer(l3))
+}
+
+// Checks whether packet is a subject of NPTv6 translation. Packets
+// which are directed to port pair itself, multicast packets, IPv6
+// Neighbor Discovery and NAT64 packets are processed as usual.
+func (pp *portPair) nptv6Applicable(pktIPv6 *packet.IPv6Hdr, protocol uint8, pktICMP *packet.ICMPHdr, port *ipPort, addr types.IPv6Address) bool {
+	if !pp.PrivatePort.Subnet6.addressAcquired || !pp.PublicPort.Subnet6.addressAcquired ||
+		pp.PrivatePort.Subnet6.Mask != pp.PublicPort.Subnet6.Mask {
+		return false
+	}
+	if pktIPv6.DstAddr[0] == 0xff || pp.NAT64Prefix.contains(pktIPv6.DstAddr) {
+		return false
+	}
+	if isNDMessage(protocol, pktICMP) {
+		return false
+	}
+	return addr != port.Subnet6.Addr && port.Subnet6.checkAddrWithingSubnet(addr)
+}
+
+// Translates destination address of IPv6 packet from public prefix
+// to private prefix. Returns false if packet is not a subject of
+// translation.
+func (pp *portPair) translateNPTv6Pub2Pri(pkt *packet.Packet, pktVLAN *packet.VLANHdr, pktIPv6 *packet.IPv6Hdr, protocol uint8, pktICMP *packet.ICMPHdr) (uint, bool) {
+	port := &pp.PublicPort
+	priv := &pp.PrivatePort
+	if !pp.nptv6Applicable(pktIPv6, protocol, pktICMP, port, pktIPv6.DstAddr) {
+		return dropResult(dropNotForUs), false
+	}
+
+	dstAddr := pktIPv6.DstAddr
+	if !translatePrefixNPTv6(&dstAddr, &port.Subnet6.Addr, &priv.Subnet6.Addr, &port.Subnet6.Mask) {
+		return port.dropPacket(pkt, dropTranslationFailed), true
+	}
+	if _, known := port.arpTable.Load(pktIPv6.SrcAddr); !known {
+		port.arpTable.Store(pktIPv6.SrcAddr, pkt.Ether.SAddr)
+	}
+	mac, found := priv.getMACForIPv6(dstAddr)
+	if !found {
+		return port.dropPacket(pkt, dropNeighborUnresolved), true
+	}
+
+	// Do packet translation. Original packet embedded into ICMPv6
+	// error was sent by private host. Checksums don't change.
+	pkt.Ether.DAddr = mac
+	pkt.Ether.SAddr = priv.SrcMACAddress
+	if pktVLAN != nil {
+		pktVLAN.SetVLANTagIdentifier(priv.Vlan)
+	}
+	pktIPv6.DstAddr = dstAddr
+	if emb := getICMPv6EmbeddedHdr(pkt, protocol, pktICMP); emb != nil && port.Subnet6.checkAddrWithingSubnet(emb.SrcAddr) {
+		translatePrefixNPTv6(&emb.SrcAddr, &port.Subnet6.Addr, &priv.Subnet6.Addr, &port.Subnet6.Mask)
+	}
+
+	priv.dumpPacket(pkt, DirSEND)
+	return DirSEND, true
+}
+
+// Translates source address of IPv6 packet from private prefix to
+// public prefix. Packets sent to public prefix are translated back to
+// private network. Returns false if packet is not a subject of
+// translation.
+func (pp *portPair) translateNPTv6Pri2Pub(pkt *packet.Packet, pktVLAN *packet.VLANHdr, pktIPv6 *packet.IPv6Hdr, protocol uint8, pktICMP *packet.ICMPHdr) (uint, bool) {
+	port := &pp.PrivatePort
+	pub := &pp.PublicPort
+	if !pp.nptv6Applicable(pktIPv6, protocol, pktICMP, port, pktIPv6.SrcAddr) || pktIPv6.DstAddr == port.Subnet6.Addr {
+		return dropResult(dropNotForUs), false
+	}
+	if _, known := port.arpTable.Load(pktIPv6.SrcAddr); !known {
+		port.arpTable.Store(pktIPv6.SrcAddr, pkt.Ether.SAddr)
+	}
+
+	// Hairpinning, only destination is translated
+	if pktIPv6.DstAddr != pub.Subnet6.Addr && pub.Subnet6.checkAddrWithingSubnet(pktIPv6.DstAddr) {
+		dstAddr := pktIPv6.DstAddr
+		if !translatePrefixNPTv6(&dstAddr, &pub.Subnet6.Addr, &port.Subnet6.Addr, &pub.Subnet6.Mask) {
+			return port.dropPacket(pkt, dropTranslationFailed), true
+		}
+		mac, found := port.getMACForIPv6(dstAddr)
+		if !found {
+			return port.dropPacket(pkt, dropNeighborUnresolved), true
+		}
+		pkt.Ether.DAddr = mac
+		pkt.Ether.SAddr = port.SrcMACAddress
+		pktIPv6.DstAddr = dstAddr
+		port.dumpPacket(pkt, DirSEND)
+		return dirHairpin, true
+	}
+
+	srcAddr := pktIPv6.SrcAddr
+	if !translatePrefixNPTv6(&srcAddr, &port.Subnet6.Addr, &pub.Subnet6.Addr, &port.Subnet6.Mask) {
+		return port.dropPacket(pkt, dropTranslationFailed), true
+	}
+	mac, found := pub.getMACForIPv6(pktIPv6.DstAddr)
+	if !found {
+		return port.dropPacket(pkt, dropNeighborUnresolved), true
+	}
+
+	// Do packet translation. Original packet embedded into ICMPv6
+	// error was sent to private host. Checksums don't change.
+	pkt.Ether.DAddr = mac
+	pkt.Ether.SAddr = pub.SrcMACAddress
+	if pktVLAN != nil {
+		pktVLAN.SetVLANTagIdentifier(pub.Vlan)
+	}
+	pktIPv6.SrcAddr = srcAddr
+	if emb := getICMPv6EmbeddedHdr(pkt, protocol, pktICMP); emb != nil && port.Subnet6.checkAddrWithingSubnet(emb.DstAddr) {
+		translatePrefixNPTv6(&emb.DstAddr, &port.Subnet6.Addr, &pub.Subnet6.Addr, &port.Subnet6.Mask)
+	}
+
+	pub.dumpPacket(pkt, DirSEND)
+	return DirSEND, true
+}
@@ -0,0 +1,99 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"net"
+	"testing"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+// Returns prefix address and mask of IPv6 subnet.
+func testIPv6Prefix(t *testing.T, cidr string) (types.IPv6Address, types.IPv6Address) {
+	_, subnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var addr, mask types.IPv6Address
+	copy(addr[:], subnet.IP)
+	copy(mask[:], subnet.Mask)
+	return addr, mask
+}
+
+func testIPv6Address(s string) types.IPv6Address {
+	var addr types.IPv6Address
+	copy(addr[:], net.ParseIP(s))
+	return addr
+}
+
+// Returns one's complement sum of address words. Zero and 0xffff
+// represent the same value.
+func testIPv6AddrSum(addr *types.IPv6Address) uint16 {
+	var sum uint16
+	for i := 0; i < types.IPv6AddrLen/2; i++ {
+		sum = onesComplementAdd(sum, getIPv6Word(addr, i))
+	}
+	if sum == 0xffff {
+		sum = 0
+	}
+	return sum
+}
+
+func TestTranslatePrefixNPTv6(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+		addr     string
+		ok       bool
+		// Expected translated address, not checked if empty
+		result string
+	}{
+		// Example from RFC 6296 appendix B
+		{"RFC6296", "fd01:203:405::/48", "2001:db8:1::/48", "fd01:203:405:1::1234", true, "2001:db8:1:d550::1234"},
+		{"Prefix48", "fd00:1:2::/48", "2001:db8:ffff::/48", "fd00:1:2:3:4:5:6:7", true, ""},
+		{"Prefix32", "fd00:1::/32", "2001:db8::/32", "fd00:1:2:3::1", true, ""},
+		{"Prefix56", "fd00:1:2:300::/56", "2001:db8:1:200::/56", "fd00:1:2:3ff::1", true, ""},
+		{"Prefix64", "fd00:1:2:3::/64", "2001:db8:1:2::/64", "fd00:1:2:3:1:2:3:4", true, ""},
+		{"Prefix64SkipsAllOnesWord", "fd00:1:2:3::/64", "2001:db8:1:2::/64", "fd00:1:2:3:ffff:2:3:4", true, ""},
+		{"SamePrefix", "2001:db8::/48", "2001:db8::/48", "2001:db8::1", true, "2001:db8::1"},
+		{"AdjustedWordAllOnes", "fd00:1:2::/48", "2001:db8:1::/48", "fd00:1:2:ffff::1", false, ""},
+		{"NoWordToAdjust", "fd00:1:2:3::/64", "2001:db8:1:2::/64", "fd00:1:2:3:ffff:ffff:ffff:ffff", false, ""},
+	}
+	for _, tt := range tests {
+		from, mask := testIPv6Prefix(t, tt.from)
+		to, _ := testIPv6Prefix(t, tt.to)
+		original := testIPv6Address(tt.addr)
+		addr := original
+		if ok := translatePrefixNPTv6(&addr, &from, &to, &mask); ok != tt.ok {
+			t.Errorf("%s: translated %t, expected %t", tt.name, ok, tt.ok)
+			continue
+		}
+		if !tt.ok {
+			if addr != original {
+				t.Errorf("%s: address changed to %v", tt.name, net.IP(addr[:]))
+			}
+			continue
+		}
+		if tt.result != "" && addr != testIPv6Address(tt.result) {
+			t.Errorf("%s: translated to %v, expected %s", tt.name, net.IP(addr[:]), tt.result)
+		}
+		for i := range addr {
+			if addr[i]&mask[i] != to[i] {
+				t.Errorf("%s: translated address %v doesn't belong to %s", tt.name, net.IP(addr[:]), tt.to)
+				break
+			}
+		}
+		// Translation doesn't change checksum of address, so L4
+		// checksums remain valid
+		if sum, expected := testIPv6AddrSum(&addr), testIPv6AddrSum(&original); sum != expected {
+			t.Errorf("%s: address checksum changed from %04x to %04x", tt.name, expected, sum)
+		}
+		// Reverse translation restores original address
+		if !translatePrefixNPTv6(&addr, &to, &from, &mask) || addr != original {
+			t.Errorf("%s: translated back to %v", tt.name, net.IP(addr[:]))
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+
+	"github.com/intel-go/nff-go/packet"
+)
+
+// Minimal pcapng writer which is used to attach comments to dumped
+// packets. File has one section with one Ethernet interface which
+// timestamps have nanosecond resolution.
+const (
+	pcapngSectionHeaderBlock     = 0x0a0d0d0a
+	pcapngInterfaceDescBlock     = 0x00000001
+	pcapngEnhancedPacketBlock    = 0x00000006
+	pcapngByteOrderMagic         = 0x1a2b3c4d
+	pcapngLinkTypeEthernet       = 1
+	pcapngOptEndOfOpt            = 0
+	pcapngOptComment             = 1
+	pcapngOptIfTsresol           = 9
+	pcapngTsresolNanoseconds     = 9
+	pcapngBlockHeaderTrailerSize = 12
+)
+
+func pcapngPad(n int) int {
+	return (n + 3) &^ 3
+}
+
+// Appends option with value padded to 32 bits.
+func appendPcapngOption(b []byte, code uint16, value []byte) []byte {
+	var hdr [4]byte
+	binary.LittleEndian.PutUint16(hdr[0:], code)
+	binary.LittleEndian.PutUint16(hdr[2:], uint16(len(value)))
+	b = append(b, hdr[:]...)
+	b = append(b, value...)
+	return append(b, make([]byte, pcapngPad(len(value))-len(value))...)
+}
+
+// Writes block of given type with body which length is a multiple
+// of 32 bits.
+func writePcapngBlock(w io.Writer, blockType uint32, body []byte) error {
+	length := uint32(len(body) + pcapngBlockHeaderTrailerSize)
+	b := make([]byte, 8, length)
+	binary.LittleEndian.PutUint32(b[0:], blockType)
+	binary.LittleEndian.PutUint32(b[4:], length)
+	b = append(b, body...)
+	b = append(b, b[4:8]...)
+	_, err := w.Write(b)
+	return err
+}
+
+// Writes section header and interface description blocks which
+// should start the file.
+func writePcapngHeader(w io.Writer) error {
+	shb := make([]byte, 16)
+	binary.LittleEndian.PutUint32(shb[0:], pcapngByteOrderMagic)
+	binary.LittleEndian.PutUint16(shb[4:], 1)
+	binary.LittleEndian.PutUint16(shb[6:], 0)
+	// Section length is not specified
+	binary.LittleEndian.PutUint64(shb[8:], ^uint64(0))
+	if err := writePcapngBlock(w, pcapngSectionHeaderBlock, shb); err != nil {
+		return err
+	}
+
+	idb := make([]byte, 8)
+	binary.LittleEndian.PutUint16(idb[0:], pcapngLinkTypeEthernet)
+	binary.LittleEndian.PutUint32(idb[4:], 0)
+	idb = appendPcapngOption(idb, pcapngOptIfTsresol, []byte{pcapngTsresolNanoseconds})
+	idb = appendPcapngOption(idb, pcapngOptEndOfOpt, nil)
+	return writePcapngBlock(w, pcapngInterfaceDescBlock, idb)
+}
+
+// Writes packet as enhanced packet block. Comment is omitted if it is
+// empty.
+func writePcapngPacket(w io.Writer, pkt *packet.Packet, comment string) error {
+	data := pkt.GetRawPacketBytes()
+	ts := uint64(time.Now().UnixNano())
+
+	body := make([]byte, 20, 20+pcapngPad(len(data))+pcapngPad(len(comment))+8)
+	binary.LittleEndian.PutUint32(body[0:], 0)
+	binary.LittleEndian.PutUint32(body[4:], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(body[8:], uint32(ts))
+	binary.LittleEndian.PutUint32(body[12:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(body[16:], uint32(len(data)))
+	body = append(body, data...)
+	body = append(body, make([]byte, pcapngPad(len(data))-len(data))...)
+	if comment != "" {
+		body = appendPcapngOption(body, pcapngOptComment, []byte(comment))
+		body = appendPcapngOption(body, pcapngOptEndOfOpt, nil)
+	}
+	return writePcapngBlock(w, pcapngEnhancedPacketBlock, body)
+}
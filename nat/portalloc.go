@@ -5,20 +5,43 @@
 package nat
 
 import (
+	crand "crypto/rand"
+	"encoding/binary"
 	"errors"
+	"math/bits"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unsafe"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/types"
 )
 
 const (
-	numPubAddr = 1
+	// Maximum number of addresses in public address pool for every
+	// IP version and maximum size of one pool subnet entry
+	maxPoolAddresses  = 256
+	maxPoolSubnetBits = 8
 
 	portStart = 1024
 	portEnd   = 65500
 	numPorts  = portEnd - portStart
+
+	// Number of port map entries which are checked for expired
+	// connections on every port allocation and maximum number of
+	// them which are checked when there are no free ports
+	portSweepStep = 16
+	maxPortSweep  = 4096
+
+	noPosition = 0xffff
+
+	// Number of random bytes read from secure source at once
+	portRandomBatch = 512
 )
 
-func (dir terminationDirection) String() string {
+func (dir trafficDirection) String() string {
 	if dir == pub2pri {
 		return "pub2pri"
 	} else if dir == pri2pub {
@@ -28,67 +51,437 @@ func (dir terminationDirection) String() string {
 	}
 }
 
+func (dir trafficDirection) opposite() trafficDirection {
+	return dir ^ 1
+}
+
 func (port *ipPort) deletePortForwardingEntry(ipv6 bool, protocol uint8, portNumber int) {
-	key := port.makePortAddrTuple(ipv6, uint16(portNumber))
+	key := port.makePortAddrTuple(ipv6, 0, uint16(portNumber))
 	port.translationTable[protocol].Delete(key)
 }
 
-func (pp *portPair) deleteOldConnection(ipv6 bool, protocol uint8, port int) {
+// Returns lock which protects port map and free ports of protocol
+// on public address with given index.
+func (port *ipPort) portLock(ipv6 bool, index int, protocol uint8) *sync.Mutex {
+	return &port.getPoolAddress(ipv6, index).mutex[protocol]
+}
+
+// Deletes connection which uses public port. Should be executed
+// under port lock of public address and protocol.
+func (pp *portPair) deleteOldConnection(ipv6 bool, protocol uint8, index, port int) {
 	pubTable := pp.PublicPort.translationTable[protocol]
-	pm := pp.getPublicPortPortmap(ipv6, protocol)
+	pm := pp.getPublicPortPortmap(ipv6, index, protocol)
 
-	pub2priKey := pp.PublicPort.makePortAddrTuple(ipv6, uint16(port))
-	pri2pubKey, found := pubTable.Load(pub2priKey)
+	pub2priKey := pp.PublicPort.makePortAddrTuple(ipv6, index, uint16(port))
+	pme, found := pubTable.Load(pub2priKey)
 
 	if found {
-		pp.PrivatePort.translationTable[protocol].Delete(pri2pubKey)
+		pri2pubKey := pme.priv
+		pp.PrivatePort.translationTable[protocol].CompareAndDelete(pri2pubKey, pme)
 		pubTable.Delete(pub2priKey)
+		if isAddrOnlyProtocol(protocol) {
+			pme.deleteAddrOnlyKeys(pubTable)
+		}
+		if !pme.static {
+			pp.releaseSession(protocol, pri2pubKey)
+			pp.logSessionEvent(false, time.Now(), pme.created, protocol, pri2pubKey, pub2priKey)
+		}
+	}
+	ps := &pp.PublicPort.getPoolAddress(ipv6, index).free[protocol]
+	if !ipv6 && !pm[port].static && !ps.contains(port) {
+		pp.PublicPort.PortBlocks.portReleased(index, port, &pp.PublicPort)
 	}
 	pm[port] = portMapEntry{}
+	ps.add(port)
 }
 
-// This function currently is not thread safe and should be executed
-// under a global lock
-func (pp *portPair) allocNewPort(ipv6 bool, protocol uint8) (int, error) {
-	pm := pp.getPublicPortPortmap(ipv6, protocol)
-	for {
-		for p := pp.lastport; p < portEnd; p++ {
-			if !pm[p].static && time.Since(pm[p].lastused) > connectionTimeout {
-				pp.lastport = p
-				pp.deleteOldConnection(ipv6, protocol, p)
-				return p, nil
-			}
+// Deletes connection which uses public port under port lock of
+// public address and protocol.
+func (pp *portPair) deleteConnection(ipv6 bool, protocol uint8, index, port int) {
+	mutex := pp.PublicPort.portLock(ipv6, index, protocol)
+	mutex.Lock()
+	pp.deleteOldConnection(ipv6, protocol, index, port)
+	mutex.Unlock()
+}
+
+// Returns ports of expired connections back to free ports set. At
+// most count port map entries are checked starting from where
+// previous check stopped. Should be executed under port lock of
+// public address and protocol.
+func (pp *portPair) sweepExpiredPorts(ipv6 bool, protocol uint8, index, count int) {
+	pm, ps := pp.PublicPort.getPoolAddress(ipv6, index).protocolPorts(protocol)
+	for n := 0; n < count; n++ {
+		p := ps.sweep
+		ps.sweep++
+		if ps.sweep == portEnd {
+			ps.sweep = portStart
 		}
+		if !ps.contains(p) && !pm[p].static && pm[p].expired(protocol) {
+			pp.deleteOldConnection(ipv6, protocol, index, p)
+		}
+	}
+}
 
-		for p := portStart; p < pp.lastport; p++ {
-			if !pm[p].static && time.Since(pm[p].lastused) > connectionTimeout {
-				pp.lastport = p
-				pp.deleteOldConnection(ipv6, protocol, p)
-				return p, nil
-			}
+// Allocates a free port of protocol on public address. Should be
+// executed under port lock of public address and protocol. Private
+// port is used by port preserving allocation.
+func (pp *portPair) allocNewPort(ipv6 bool, protocol uint8, index int, privPort uint16) (int, error) {
+	pa := pp.PublicPort.getPoolAddress(ipv6, index)
+	_, ps := pa.protocolPorts(protocol)
+
+	// Expired connections are reclaimed a few at a time, so that
+	// free ports set doesn't run out while there are expired ones
+	pp.sweepExpiredPorts(ipv6, protocol, index, portSweepStep)
+	if len(ps.ports) == 0 {
+		pp.sweepExpiredPorts(ipv6, protocol, index, maxPortSweep)
+	}
+
+	var p int
+	var ok bool
+	switch pp.PortAllocation {
+	case allocRandomPreserving:
+		p, ok = int(privPort), ps.contains(int(privPort))
+		if !ok {
+			p, ok = ps.random()
 		}
+	case allocRandom:
+		p, ok = ps.random()
+	default:
+		p, ok = ps.next(ps.last)
+	}
+	if !ok {
 		return 0, errors.New("WARNING! All ports are allocated! Trying again")
 	}
+	ps.last = p
+	ps.remove(p)
+	return p, nil
+}
+
+// Returns idle timeout of a connection which uses this port.
+func (pme *portMapEntry) timeout(protocol uint8) time.Duration {
+	t := Natconfig.getTimeouts()
+	switch protocol {
+	case types.TCPNumber:
+		return t.tcpTimeout(pme.getTCPState())
+	case sctpNumber:
+		// SCTP associations are not tracked, so they are treated
+		// as established TCP connections
+		return t.TCPEstablished
+	case types.UDPNumber, udpLiteNumber, greNumber, espNumber:
+		return t.UDP
+	default:
+		return t.ICMP
+	}
 }
 
-func (pp *portPair) getPublicPortPortmap(ipv6 bool, protocol uint8) []portMapEntry {
+// Returns session description for dumps. TCP connection state is
+// shown only for TCP sessions.
+func (pme *portMapEntry) describe(protocol uint8) string {
+	res := "last used " + pme.lastUsed().Format(time.RFC3339)
+	if pme.static {
+		res += ", static"
+	}
+	if protocol == types.TCPNumber {
+		res += ", TCP state " + pme.getTCPState().String()
+	}
+	return res
+}
+
+// Checks whether there was no transfer on this port for too long
+// time.
+func (pme *portMapEntry) expired(protocol uint8) bool {
+	return time.Since(pme.lastUsed()) > pme.timeout(protocol)
+}
+
+// Records that connection which uses this port has just transferred
+// a packet.
+func (pme *portMapEntry) touch() {
+	atomic.StoreInt64(&pme.lastused, time.Now().UnixNano())
+}
+
+// Returns time when connection which uses this port transferred the
+// last packet.
+func (pme *portMapEntry) lastUsed() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&pme.lastused))
+}
+
+// Returns extra state of session. It is created if create is true
+// and session has none yet.
+func (pme *portMapEntry) getExtra(create bool) *sessionExtra {
+	p := (*unsafe.Pointer)(unsafe.Pointer(&pme.extra))
+	extra := (*sessionExtra)(atomic.LoadPointer(p))
+	if extra == nil && create {
+		// Handlers may create extra state concurrently, only one
+		// of them is kept
+		atomic.CompareAndSwapPointer(p, nil, unsafe.Pointer(new(sessionExtra)))
+		extra = (*sessionExtra)(atomic.LoadPointer(p))
+	}
+	return extra
+}
+
+// Returns remote endpoints which private host has sent packets to or
+// nil if session doesn't filter inbound packets.
+func (pme *portMapEntry) getRemotes() *sync.Map {
+	if extra := pme.getExtra(false); extra != nil {
+		return extra.remotes
+	}
+	return nil
+}
+
+func (pp *portPair) getPublicPortPortmap(ipv6 bool, index int, protocol uint8) []portMapEntry {
+	return pp.PublicPort.getPortmap(ipv6, index, protocol)
+}
+
+// Returns port map and free ports of protocol on public address. Port
+// maps of protocols other than TCP, UDP and ICMP are allocated when
+// protocol gets its first session, so that rarely used protocols
+// don't take memory of every pool address. GRE and ESP sessions use
+// port numbers as session handles. Should be executed under port
+// lock of public address and protocol.
+func (pa *poolAddress) protocolPorts(protocol uint8) ([]portMapEntry, *portSet) {
+	if pa.portmap[protocol] == nil {
+		pa.portmap[protocol] = make([]portMapEntry, portEnd)
+		pa.free[protocol] = newPortSet()
+	}
+	return pa.portmap[protocol], &pa.free[protocol]
+}
+
+// Returns protocols which may have port maps on public addresses.
+func portmapProtocols(ipv6 bool) []uint8 {
+	if ipv6 {
+		return []uint8{types.TCPNumber, types.UDPNumber, types.ICMPv6Number, sctpNumber, udpLiteNumber, greNumber, espNumber}
+	}
+	return []uint8{types.ICMPNumber, types.TCPNumber, types.UDPNumber, sctpNumber, udpLiteNumber, greNumber, espNumber}
+}
+
+func (port *ipPort) getPoolAddress(ipv6 bool, index int) *poolAddress {
 	if ipv6 {
-		return pp.PublicPort.portmap6[protocol]
+		return &port.pool6[index]
+	} else {
+		return &port.pool[index]
+	}
+}
+
+// Returns public address which corresponds to index in address
+// pool. Zero index means port own address.
+func (port *ipPort) getPoolIndexAddr(ipv6 bool, index int) (types.IPv4Address, types.IPv6Address) {
+	if ipv6 {
+		if index == 0 {
+			return 0, port.Subnet6.Addr
+		}
+		return 0, port.AddressPool.addrs6[index-1]
 	} else {
-		return pp.PublicPort.portmap[protocol]
+		if index == 0 {
+			return port.Subnet.Addr, types.IPv6Address{}
+		}
+		return port.AddressPool.addrs[index-1], types.IPv6Address{}
 	}
 }
 
-func (port *ipPort) makePortAddrTuple(ipv6 bool, portNumber uint16) interface{} {
+// Returns index of public address in address pool. Zero index means
+// port own address.
+func (port *ipPort) getPoolIndex(ipv6 bool, v4addr types.IPv4Address, v6addr types.IPv6Address) (int, bool) {
 	if ipv6 {
-		return Tuple6{
-			addr: port.Subnet6.Addr,
-			port: uint16(portNumber),
+		if v6addr == port.Subnet6.Addr {
+			return 0, true
+		}
+		index, ok := port.AddressPool.index6[v6addr]
+		return index + 1, ok
+	} else {
+		if v4addr == port.Subnet.Addr {
+			return 0, true
 		}
+		index, ok := port.AddressPool.index[v4addr]
+		return index + 1, ok
+	}
+}
+
+// Chooses public address for private host. Address is selected by
+// a hash of private address so that all connections of one private
+// host use the same public address ("paired" pooling, RFC 4787
+// REQ-2).
+func (port *ipPort) selectPoolIndex(ipv6 bool, privEntry sessionKey) int {
+	var hash uint32
+	if !privEntry.ipv6() {
+		hash = uint32(privEntry.addr)
 	} else {
-		return Tuple{
-			addr: port.Subnet.Addr,
-			port: uint16(portNumber),
+		// NAT64 sessions have IPv6 private address
+		addr := privEntry.addr6
+		for i := 0; i < types.IPv6AddrLen; i += 4 {
+			hash ^= uint32(addr[i])<<24 | uint32(addr[i+1])<<16 | uint32(addr[i+2])<<8 | uint32(addr[i+3])
+		}
+	}
+	size := len(port.pool)
+	if ipv6 {
+		size = len(port.pool6)
+	}
+	// Multiplicative hashing to mix all address bits
+	hash *= 2654435761
+	return int((uint64(hash) * uint64(size)) >> 32)
+}
+
+func (port *ipPort) makePortAddrTuple(ipv6 bool, index int, portNumber uint16) sessionKey {
+	v4addr, v6addr := port.getPoolIndexAddr(ipv6, index)
+	return makeTuple(ipv6, v4addr, v6addr, portNumber)
+}
+
+// Set of free ports of one protocol on one public address. Ports are
+// kept both in a list, which allows to pick a random free port, and
+// in a bitmap, which allows to find next free port, so that neither
+// of them requires checking every port map entry.
+type portSet struct {
+	ports    []uint16
+	position []uint16
+	bitmap   []uint64
+	// Next port to check for expired connections
+	sweep int
+	// Port that was allocated last
+	last int
+	// Random numbers source for port allocation
+	rng portRandom
+}
+
+func newPortSet() portSet {
+	ps := portSet{
+		ports:    make([]uint16, 0, numPorts),
+		position: make([]uint16, numPorts),
+		bitmap:   make([]uint64, (numPorts+63)/64),
+		sweep:    portStart,
+		last:     portStart,
+	}
+	for i := range ps.position {
+		ps.position[i] = noPosition
+	}
+	for p := portStart; p < portEnd; p++ {
+		ps.add(p)
+	}
+	return ps
+}
+
+func (ps *portSet) contains(port int) bool {
+	i := port - portStart
+	return i >= 0 && i < numPorts && ps.position[i] != noPosition
+}
+
+func (ps *portSet) add(port int) {
+	i := port - portStart
+	if i < 0 || i >= numPorts || ps.position[i] != noPosition {
+		return
+	}
+	ps.position[i] = uint16(len(ps.ports))
+	ps.ports = append(ps.ports, uint16(port))
+	ps.bitmap[i>>6] |= 1 << uint(i&63)
+}
+
+func (ps *portSet) remove(port int) {
+	if !ps.contains(port) {
+		return
+	}
+	i := port - portStart
+	pos := ps.position[i]
+	last := ps.ports[len(ps.ports)-1]
+	ps.ports[pos] = last
+	ps.position[int(last)-portStart] = pos
+	ps.ports = ps.ports[:len(ps.ports)-1]
+	ps.position[i] = noPosition
+	ps.bitmap[i>>6] &^= 1 << uint(i&63)
+}
+
+// Returns first free port in range [start, end) starting from given
+// one. Search wraps around the end of the range.
+func (ps *portSet) nextInRange(from, start, end int) (int, bool) {
+	if p, ok := ps.firstInRange(from, end); ok {
+		return p, true
+	}
+	return ps.firstInRange(start, from)
+}
+
+func (ps *portSet) firstInRange(start, end int) (int, bool) {
+	last := end - portStart
+	for i := start - portStart; i < last; {
+		w := i >> 6
+		word := ps.bitmap[w] >> uint(i&63)
+		if word != 0 {
+			if j := i + bits.TrailingZeros64(word); j < last {
+				return portStart + j, true
+			}
+			return 0, false
+		}
+		i = (w + 1) << 6
+	}
+	return 0, false
+}
+
+// Returns uniformly chosen random free port.
+func (ps *portSet) random() (int, bool) {
+	if len(ps.ports) == 0 {
+		return 0, false
+	}
+	return int(ps.ports[ps.rng.Intn(len(ps.ports))]), true
+}
+
+// Returns first free port starting from given one. Search wraps
+// around the end of ports range.
+func (ps *portSet) next(from int) (int, bool) {
+	if len(ps.ports) == 0 {
+		return 0, false
+	}
+	i := from - portStart
+	if i < 0 || i >= numPorts {
+		i = 0
+	}
+	w := i >> 6
+	word := ps.bitmap[w] &^ (1<<uint(i&63) - 1)
+	for n := 0; n <= len(ps.bitmap); n++ {
+		if word != 0 {
+			return portStart + w<<6 + bits.TrailingZeros64(word), true
+		}
+		w++
+		if w == len(ps.bitmap) {
+			w = 0
+		}
+		word = ps.bitmap[w]
+	}
+	return 0, false
+}
+
+// Random numbers source for port allocation. Numbers are read from
+// cryptographically secure generator in batches, so that allocated
+// ports cannot be predicted from previously observed ones (RFC 6056
+// section 3.3). Zero value is ready to use.
+type portRandom struct {
+	buf []byte
+	pos int
+}
+
+func (r *portRandom) read(n int) []byte {
+	if r.pos+n > len(r.buf) {
+		if r.buf == nil {
+			r.buf = make([]byte, portRandomBatch)
+		}
+		if _, err := crand.Read(r.buf); err != nil {
+			common.LogFatal(common.Debug, err)
+		}
+		r.pos = 0
+	}
+	b := r.buf[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *portRandom) Uint64() uint64 {
+	return binary.LittleEndian.Uint64(r.read(8))
+}
+
+// Returns uniformly distributed random number in [0, n).
+func (r *portRandom) Intn(n int) int {
+	max := uint32(n)
+	// Numbers below threshold would make low results more likely
+	threshold := -max % max
+	for {
+		v := binary.LittleEndian.Uint32(r.read(4))
+		if v >= threshold {
+			return int(v % max)
 		}
 	}
 }
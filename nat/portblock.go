@@ -0,0 +1,386 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+type portBlockMode uint8
+
+const (
+	portBlocksDisabled      portBlockMode = 0
+	portBlocksDeterministic portBlockMode = 1
+	portBlocksDynamic       portBlockMode = 2
+)
+
+var portBlockModeLookup map[string]portBlockMode = map[string]portBlockMode{
+	"disabled":      portBlocksDisabled,
+	"deterministic": portBlocksDeterministic,
+	"dynamic":       portBlocksDynamic,
+}
+
+func (out *portBlockMode) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	result, ok := portBlockModeLookup[s]
+	if !ok {
+		return errors.New("Bad port block mode: " + s)
+	}
+
+	*out = result
+	return nil
+}
+
+func (mode portBlockMode) String() string {
+	for name, m := range portBlockModeLookup {
+		if m == mode {
+			return name
+		}
+	}
+	return "unknown(" + strconv.Itoa(int(mode)) + ")"
+}
+
+// Port block allocation for IPv4 subscribers (RFC 7422). Every
+// subscriber gets whole blocks of ports on one public address and
+// all its connections use ports from these blocks. In deterministic
+// mode block of a subscriber is calculated from its address, so
+// public address and port can be mapped back to a subscriber without
+// any logging. In dynamic mode blocks are assigned on demand and
+// only block assignment and release events are logged.
+type portBlocks struct {
+	Mode      portBlockMode `json:"mode"`
+	BlockSize int           `json:"block-size"`
+	// Subscribers private subnet for deterministic mode
+	Subscribers ipv4Subnet `json:"subscribers"`
+	// Maximum number of blocks of one subscriber in dynamic mode
+	MaxBlocks int `json:"max-blocks"`
+
+	blocksPerAddr int
+	// Dynamic mode state. Block owners are zero for free blocks.
+	owners      []types.IPv4Address
+	assignTime  []time.Time
+	used        []int
+	subscribers map[types.IPv4Address][]int
+	nextBlock   int
+	// Lock of dynamic mode state. It may be taken while port lock
+	// of public address is held, but not vice versa.
+	mutex sync.Mutex
+}
+
+func (pb *portBlocks) enabled() bool {
+	return pb.Mode != portBlocksDisabled
+}
+
+// Checks port block configuration. Number of public addresses
+// includes port own address.
+func (pb *portBlocks) check(port *ipPort) error {
+	if !pb.enabled() {
+		return nil
+	}
+	if port.Type == iPRIVATE {
+		return fmt.Errorf("Port blocks may be specified only for public port while they are set for private port %d", port.Index)
+	}
+	if pb.BlockSize <= 0 || pb.BlockSize > numPorts {
+		return fmt.Errorf("Port block size of port %d should be between 1 and %d", port.Index, numPorts)
+	}
+	if pb.MaxBlocks == 0 {
+		pb.MaxBlocks = 1
+	}
+
+	blocks := (numPorts / pb.BlockSize) * (len(port.AddressPool.addrs) + 1)
+	if pb.Mode == portBlocksDeterministic {
+		if !pb.Subscribers.addressAcquired {
+			return fmt.Errorf("Subscribers subnet should be specified for deterministic port blocks of port %d", port.Index)
+		}
+		if subscribers := int(^pb.Subscribers.Mask) + 1; subscribers > blocks {
+			return fmt.Errorf("Port %d has %d port blocks which is not enough for %d subscribers", port.Index, blocks, subscribers)
+		}
+	}
+	return nil
+}
+
+func (pb *portBlocks) init(addresses int) {
+	pb.blocksPerAddr = numPorts / pb.BlockSize
+	if pb.Mode == portBlocksDynamic {
+		pb.owners = make([]types.IPv4Address, pb.blocksPerAddr*addresses)
+		pb.assignTime = make([]time.Time, pb.blocksPerAddr*addresses)
+		pb.used = make([]int, pb.blocksPerAddr*addresses)
+		pb.subscribers = make(map[types.IPv4Address][]int)
+	}
+}
+
+func (pb *portBlocks) String() string {
+	if pb.Mode == portBlocksDeterministic {
+		return fmt.Sprintf("%s, block size %d, subscribers %s", pb.Mode, pb.BlockSize, pb.Subscribers.String())
+	}
+	return fmt.Sprintf("%s, block size %d, up to %d blocks per subscriber", pb.Mode, pb.BlockSize, pb.MaxBlocks)
+}
+
+// Returns public address index and ports range of a block.
+func (pb *portBlocks) blockRange(block int) (int, int, int) {
+	start := portStart + (block%pb.blocksPerAddr)*pb.BlockSize
+	return block / pb.blocksPerAddr, start, start + pb.BlockSize
+}
+
+// Returns block which contains given port on public address with
+// given index.
+func (pb *portBlocks) blockOf(index, port int) (int, bool) {
+	b := (port - portStart) / pb.BlockSize
+	if port < portStart || b >= pb.blocksPerAddr {
+		return 0, false
+	}
+	return index*pb.blocksPerAddr + b, true
+}
+
+// Returns block of a subscriber in deterministic mode.
+func (pb *portBlocks) deterministicBlock(subscriber types.IPv4Address) (int, bool) {
+	if !pb.Subscribers.checkAddrWithingSubnet(subscriber) {
+		return 0, false
+	}
+	return int(subscriber &^ pb.Subscribers.Mask), true
+}
+
+// Returns subscriber which owns a block and whether block is owned.
+func (pb *portBlocks) blockOwner(block int) (types.IPv4Address, bool) {
+	if pb.Mode == portBlocksDeterministic {
+		subscriber := pb.Subscribers.Addr&pb.Subscribers.Mask | types.IPv4Address(block)
+		return subscriber, pb.Subscribers.checkAddrWithingSubnet(subscriber)
+	}
+	return pb.owners[block], pb.owners[block] != 0
+}
+
+func (pb *portBlocks) logEvent(assigned bool, subscriber types.IPv4Address, port *ipPort, block int) {
+	index, start, end := pb.blockRange(block)
+	addr, _ := port.getPoolIndexAddr(false, index)
+	event := "released from"
+	if assigned {
+		event = "assigned to"
+	}
+	log.Printf("Port block %s:%d-%d %s subscriber %s\n",
+		StringIPv4Int(uint32(addr)), start, end-1, event, StringIPv4Int(uint32(subscriber)))
+	pb.logBlockEvent(assigned, subscriber, port, block)
+}
+
+// Assigns a free block to a subscriber in dynamic mode. Blocks on
+// public address of already assigned blocks are preferred so that
+// all connections of a subscriber use the same public address.
+// Should be executed under block state lock.
+func (pb *portBlocks) assignBlock(subscriber types.IPv4Address, port *ipPort) (int, bool) {
+	first, last := 0, len(pb.owners)
+	if blocks := pb.subscribers[subscriber]; len(blocks) != 0 {
+		index, _, _ := pb.blockRange(blocks[0])
+		first = index * pb.blocksPerAddr
+		last = first + pb.blocksPerAddr
+	} else if pb.nextBlock >= last {
+		pb.nextBlock = 0
+	}
+
+	count := last - first
+	for n := 0; n < count; n++ {
+		block := first + n
+		if first == 0 && last == len(pb.owners) {
+			block = (pb.nextBlock + n) % count
+		}
+		if pb.owners[block] == 0 {
+			pb.owners[block] = subscriber
+			pb.assignTime[block] = time.Now()
+			pb.subscribers[subscriber] = append(pb.subscribers[subscriber], block)
+			pb.nextBlock = block + 1
+			pb.logEvent(true, subscriber, port, block)
+			return block, true
+		}
+	}
+	return 0, false
+}
+
+func (pb *portBlocks) releaseBlock(block int, port *ipPort) {
+	subscriber := pb.owners[block]
+	pb.owners[block] = 0
+	blocks := pb.subscribers[subscriber]
+	for i := range blocks {
+		if blocks[i] == block {
+			blocks = append(blocks[:i], blocks[i+1:]...)
+			break
+		}
+	}
+	if len(blocks) == 0 {
+		delete(pb.subscribers, subscriber)
+	} else {
+		pb.subscribers[subscriber] = blocks
+	}
+	pb.logEvent(false, subscriber, port, block)
+}
+
+// Should be called when port of public address with given index
+// becomes free, under port lock of public address.
+func (pb *portBlocks) portReleased(index, port int, pub *ipPort) {
+	if pb.Mode != portBlocksDynamic {
+		return
+	}
+	pb.mutex.Lock()
+	defer pb.mutex.Unlock()
+	block, ok := pb.blockOf(index, port)
+	if !ok || pb.used[block] == 0 {
+		return
+	}
+	pb.used[block]--
+	if pb.used[block] == 0 && pb.owners[block] != 0 {
+		pb.releaseBlock(block, pub)
+	}
+}
+
+// Allocates a port in one of subscriber blocks. On success port lock
+// of public address and protocol of allocated port is held and
+// should be released by caller.
+func (pp *portPair) allocBlockPort(protocol uint8, subscriber types.IPv4Address, privPort uint16) (int, int, error) {
+	pub := &pp.PublicPort
+	pb := &pub.PortBlocks
+
+	if pb.Mode == portBlocksDeterministic {
+		block, ok := pb.deterministicBlock(subscriber)
+		if !ok {
+			return 0, 0, errors.New("Private address " + StringIPv4Int(uint32(subscriber)) + " doesn't belong to subscribers subnet")
+		}
+		if index, port, ok := pp.allocPortInBlock(protocol, block, privPort); ok {
+			return index, port, nil
+		}
+		return 0, 0, errors.New("WARNING! All ports in subscriber block are allocated!")
+	}
+
+	// Blocks may be released while expired connections are
+	// reclaimed, so a copy of blocks list is used. Block usage is
+	// increased in advance so that block being allocated from is not
+	// released. Block state lock is not held during allocation
+	// because it is taken when ports are released.
+	pb.mutex.Lock()
+	blocks := append([]int(nil), pb.subscribers[subscriber]...)
+	pb.mutex.Unlock()
+	for _, block := range blocks {
+		if !pb.reserveBlock(block, subscriber) {
+			continue
+		}
+		if index, port, ok := pp.allocPortInBlock(protocol, block, privPort); ok {
+			return index, port, nil
+		}
+		pb.unreserveBlock(block)
+	}
+
+	pb.mutex.Lock()
+	if len(pb.subscribers[subscriber]) >= pb.MaxBlocks {
+		pb.mutex.Unlock()
+		return 0, 0, errors.New("WARNING! All ports in subscriber blocks are allocated!")
+	}
+	block, ok := pb.assignBlock(subscriber, pub)
+	if ok {
+		pb.used[block]++
+	}
+	pb.mutex.Unlock()
+	if !ok {
+		return 0, 0, errors.New("WARNING! All port blocks are assigned!")
+	}
+	if index, port, ok := pp.allocPortInBlock(protocol, block, privPort); ok {
+		return index, port, nil
+	}
+	pb.unreserveBlock(block)
+	return 0, 0, errors.New("WARNING! All ports in subscriber blocks are allocated!")
+}
+
+// Increases usage of block if it is still owned by subscriber.
+func (pb *portBlocks) reserveBlock(block int, subscriber types.IPv4Address) bool {
+	pb.mutex.Lock()
+	defer pb.mutex.Unlock()
+	if pb.owners[block] != subscriber {
+		return false
+	}
+	pb.used[block]++
+	return true
+}
+
+func (pb *portBlocks) unreserveBlock(block int) {
+	pb.mutex.Lock()
+	pb.used[block]--
+	pb.mutex.Unlock()
+}
+
+// Allocates a free port in a block according to port allocation
+// algorithm. Expired connections in a block are reclaimed if there is
+// no free port in it. Port lock of public address is left held on
+// success.
+func (pp *portPair) allocPortInBlock(protocol uint8, block int, privPort uint16) (int, int, bool) {
+	pb := &pp.PublicPort.PortBlocks
+	index, start, end := pb.blockRange(block)
+	pa := pp.PublicPort.getPoolAddress(false, index)
+	mutex := &pa.mutex[protocol]
+	mutex.Lock()
+	pm, ps := pa.protocolPorts(protocol)
+
+	pp.sweepExpiredPorts(false, protocol, index, portSweepStep)
+	p, ok := pp.pickPortInRange(ps, start, end, privPort)
+	if !ok {
+		for i := start; i < end; i++ {
+			if !ps.contains(i) && !pm[i].static && pm[i].expired(protocol) {
+				pp.deleteOldConnection(false, protocol, index, i)
+			}
+		}
+		p, ok = pp.pickPortInRange(ps, start, end, privPort)
+		if !ok {
+			mutex.Unlock()
+			return 0, 0, false
+		}
+	}
+	ps.remove(p)
+	return index, p, true
+}
+
+func (pp *portPair) pickPortInRange(ps *portSet, start, end int, privPort uint16) (int, bool) {
+	switch pp.PortAllocation {
+	case allocRandomPreserving:
+		if int(privPort) >= start && int(privPort) < end && ps.contains(int(privPort)) {
+			return int(privPort), true
+		}
+		return ps.nextInRange(start+ps.rng.Intn(end-start), start, end)
+	case allocRandom:
+		return ps.nextInRange(start+ps.rng.Intn(end-start), start, end)
+	default:
+		return ps.nextInRange(start, start, end)
+	}
+}
+
+// Returns subscriber which owns public address and port and range of
+// its port block.
+func (port *ipPort) portBlockOwner(addr types.IPv4Address, portNumber int) (types.IPv4Address, int, int, error) {
+	pb := &port.PortBlocks
+	if !pb.enabled() {
+		return 0, 0, 0, fmt.Errorf("Port blocks are not enabled on port %d", port.Index)
+	}
+	index, inPool := port.getPoolIndex(false, addr, zeroIPv6Addr)
+	if !inPool {
+		return 0, 0, 0, fmt.Errorf("Address %s doesn't belong to port %d", StringIPv4Int(uint32(addr)), port.Index)
+	}
+	block, ok := pb.blockOf(index, portNumber)
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("Port %d doesn't belong to any port block", portNumber)
+	}
+	pb.mutex.Lock()
+	subscriber, owned := pb.blockOwner(block)
+	pb.mutex.Unlock()
+	if !owned {
+		return 0, 0, 0, fmt.Errorf("Port block of %s:%d is not assigned", StringIPv4Int(uint32(addr)), portNumber)
+	}
+	_, start, end := pb.blockRange(block)
+	return subscriber, start, end, nil
+}
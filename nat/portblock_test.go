@@ -0,0 +1,96 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"testing"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+// Returns deterministic port blocks for subscribers subnet 10.0.0.0/22.
+func newTestPortBlocks(blockSize int) *portBlocks {
+	pb := &portBlocks{
+		Mode:      portBlocksDeterministic,
+		BlockSize: blockSize,
+		Subscribers: ipv4Subnet{
+			Addr: 0x0a000000,
+			Mask: 0xfffffc00,
+		},
+	}
+	pb.init(0)
+	return pb
+}
+
+func TestDeterministicPortBlocks(t *testing.T) {
+	tests := []struct {
+		name       string
+		blockSize  int
+		subscriber types.IPv4Address
+		ok         bool
+		// Public address index and ports range of subscriber block
+		index, start, end int
+	}{
+		{"First", 1000, 0x0a000000, true, 0, 1024, 2024},
+		{"LastOnAddress", 1000, 0x0a00003f, true, 0, 64024, 65024},
+		{"FirstOnNextAddress", 1000, 0x0a000040, true, 1, 1024, 2024},
+		{"Last", 1000, 0x0a0003ff, true, 15, 64024, 65024},
+		{"WholeAddress", numPorts, 0x0a000005, true, 5, portStart, portEnd},
+		{"SmallBlocks", 1, 0x0a000123, true, 0, 1024 + 0x123, 1024 + 0x124},
+		{"OutsideSubnet", 1000, 0x0a000400, false, 0, 0, 0},
+		{"OtherSubnet", 1000, 0xc0a80001, false, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		pb := newTestPortBlocks(tt.blockSize)
+		block, ok := pb.deterministicBlock(tt.subscriber)
+		if ok != tt.ok {
+			t.Errorf("%s: block found %t, expected %t", tt.name, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		index, start, end := pb.blockRange(block)
+		if index != tt.index || start != tt.start || end != tt.end {
+			t.Errorf("%s: block is %d:%d-%d, expected %d:%d-%d", tt.name, index, start, end, tt.index, tt.start, tt.end)
+		}
+		// Public address and port are mapped back to the same
+		// block and subscriber
+		for _, port := range []int{start, end - 1} {
+			if b, ok := pb.blockOf(index, port); !ok || b != block {
+				t.Errorf("%s: port %d belongs to block %d (%t), expected %d", tt.name, port, b, ok, block)
+			}
+		}
+		if owner, owned := pb.blockOwner(block); !owned || owner != tt.subscriber {
+			t.Errorf("%s: block owner is %s (%t)", tt.name, StringIPv4Int(uint32(owner)), owned)
+		}
+	}
+}
+
+func TestPortBlockOf(t *testing.T) {
+	tests := []struct {
+		name  string
+		index int
+		port  int
+		ok    bool
+		block int
+	}{
+		{"BelowPortRange", 0, portStart - 1, false, 0},
+		{"First", 0, portStart, true, 0},
+		{"LastPortOfBlock", 0, portStart + 999, true, 0},
+		{"SecondBlock", 0, portStart + 1000, true, 1},
+		{"LastBlock", 0, 65023, true, 63},
+		{"IncompleteBlock", 0, 65024, false, 0},
+		{"AbovePortRange", 0, portEnd, false, 0},
+		{"SecondAddress", 2, portStart, true, 128},
+	}
+	pb := newTestPortBlocks(1000)
+	for _, tt := range tests {
+		block, ok := pb.blockOf(tt.index, tt.port)
+		if ok != tt.ok || block != tt.block {
+			t.Errorf("%s: block is %d (%t), expected %d (%t)", tt.name, block, ok, tt.block, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultReapInterval reapInterval = reapInterval(30 * time.Second)
+	// Number of ports which are checked under one port lock, so
+	// that packet handlers are not blocked for long
+	reapChunk = 1024
+)
+
+// Interval of background session expiry. Zero interval disables it,
+// so that sessions are expired only when their ports are reused.
+type reapInterval time.Duration
+
+// UnmarshalJSON parses interval in a form of Go duration string,
+// e.g. "30s".
+func (out *reapInterval) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	if d < 0 {
+		return fmt.Errorf("Session reap interval should not be negative while it is %v", d)
+	}
+	*out = reapInterval(d)
+	return nil
+}
+
+// StartSessionReaper starts background expiry of idle sessions if it
+// is enabled.
+func StartSessionReaper() {
+	interval := time.Duration(Natconfig.ReapInterval)
+	if interval == 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(interval) {
+			for i := range Natconfig.PortPairs {
+				pp := &Natconfig.PortPairs[i]
+				if n := pp.reapExpiredSessions(); n != 0 {
+					fmt.Printf("Reclaimed %d expired sessions of port pair %d\n", n, i)
+				}
+			}
+		}
+	}()
+}
+
+// Deletes all expired sessions of port pair according to their
+// timeouts and TCP states. Returns number of reclaimed sessions.
+func (pp *portPair) reapExpiredSessions() int {
+	reclaimed := 0
+	for _, ipv6 := range []bool{false, true} {
+		pool := pp.PublicPort.pool
+		if ipv6 {
+			pool = pp.PublicPort.pool6
+		}
+		for index := range pool {
+			pa := &pool[index]
+			for _, protocol := range portmapProtocols(ipv6) {
+				mutex := &pa.mutex[protocol]
+				for start := portStart; start < portEnd; start += reapChunk {
+					end := start + reapChunk
+					if end > portEnd {
+						end = portEnd
+					}
+					mutex.Lock()
+					// Port map may be not allocated yet
+					pm := pa.portmap[protocol]
+					if pm == nil {
+						mutex.Unlock()
+						break
+					}
+					ps := &pa.free[protocol]
+					for p := start; p < end; p++ {
+						if !ps.contains(p) && !pm[p].static && pm[p].expired(protocol) {
+							pp.deleteOldConnection(ipv6, protocol, index, p)
+							reclaimed++
+						}
+					}
+					mutex.Unlock()
+				}
+			}
+		}
+	}
+	pp.sweepSIPDialogs()
+	atomic.AddUint64(&pp.reclaimedSessions, uint64(reclaimed))
+	return reclaimed
+}
@@ -0,0 +1,196 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+const (
+	limitTCP = iota
+	limitUDP
+	limitICMP
+	limitProtocolCount
+)
+
+// Number of independently locked parts of session counters
+const (
+	sessionCounterShardBits = 6
+	sessionCounterShards    = 1 << sessionCounterShardBits
+)
+
+var errSessionLimit = errors.New("Session limit of private host is reached")
+
+// Maximum numbers of concurrent sessions which every private host
+// of a port pair may have. Total limit is applied to sessions of all
+// protocols together. SCTP sessions are counted as TCP ones, UDP-Lite,
+// GRE and ESP sessions are counted as UDP ones. Zero value means that
+// number of sessions is not limited.
+type sessionLimits struct {
+	Total uint32 `json:"total"`
+	TCP   uint32 `json:"tcp"`
+	UDP   uint32 `json:"udp"`
+	ICMP  uint32 `json:"icmp"`
+}
+
+// Private host is identified by its IPv4 or IPv6 address
+type hostKey struct {
+	v4 types.IPv4Address
+	v6 types.IPv6Address
+}
+
+type hostSessions struct {
+	total  uint32
+	counts [limitProtocolCount]uint32
+}
+
+// Numbers of sessions of private hosts. Hosts are spread over
+// shards by their addresses, every shard has its own lock.
+type sessionCounters struct {
+	shards [sessionCounterShards]sessionCounterShard
+	// Number of packets which were dropped because their host
+	// reached its session limit. Should be accessed atomically.
+	drops uint64
+}
+
+type sessionCounterShard struct {
+	mutex sync.Mutex
+	hosts map[hostKey]*hostSessions
+}
+
+func (sl *sessionLimits) String() string {
+	str := func(limit uint32) string {
+		if limit == 0 {
+			return "unlimited"
+		}
+		return fmt.Sprint(limit)
+	}
+	return fmt.Sprintf("total: %s, TCP: %s, UDP: %s, ICMP: %s",
+		str(sl.Total), str(sl.TCP), str(sl.UDP), str(sl.ICMP))
+}
+
+func (sl *sessionLimits) enabled() bool {
+	return sl.Total != 0 || sl.TCP != 0 || sl.UDP != 0 || sl.ICMP != 0
+}
+
+func (sl *sessionLimits) limit(index int) uint32 {
+	switch index {
+	case limitTCP:
+		return sl.TCP
+	case limitUDP:
+		return sl.UDP
+	default:
+		return sl.ICMP
+	}
+}
+
+func limitIndex(protocol uint8) int {
+	switch protocol {
+	case types.TCPNumber, sctpNumber:
+		return limitTCP
+	case types.ICMPNumber, types.ICMPv6Number:
+		return limitICMP
+	default:
+		return limitUDP
+	}
+}
+
+func makeHostKey(privEntry sessionKey) hostKey {
+	return hostKey{
+		v4: privEntry.addr,
+		v6: privEntry.addr6,
+	}
+}
+
+// Returns shard of session counters which keeps host.
+func (sc *sessionCounters) shard(key hostKey) *sessionCounterShard {
+	hash := uint32(key.v4)
+	for i := 0; i < types.IPv6AddrLen; i += 4 {
+		hash ^= uint32(key.v6[i])<<24 | uint32(key.v6[i+1])<<16 | uint32(key.v6[i+2])<<8 | uint32(key.v6[i+3])
+	}
+	// Multiplicative hashing to mix all address bits
+	hash *= 2654435761
+	return &sc.shards[hash>>(32-sessionCounterShardBits)]
+}
+
+// Locks all shards, so that session limits may be changed and
+// counters of all hosts may be read.
+func (sc *sessionCounters) lockAll() {
+	for i := range sc.shards {
+		sc.shards[i].mutex.Lock()
+	}
+}
+
+func (sc *sessionCounters) unlockAll() {
+	for i := range sc.shards {
+		sc.shards[i].mutex.Unlock()
+	}
+}
+
+// Returns number of private hosts which have sessions. Should be
+// executed with all shards locked.
+func (sc *sessionCounters) hostsCount() int {
+	count := 0
+	for i := range sc.shards {
+		count += len(sc.shards[i].hosts)
+	}
+	return count
+}
+
+// Counts new session of private host if it doesn't exceed host
+// limits. Otherwise counts a dropped packet and returns
+// errSessionLimit.
+func (pp *portPair) acquireSession(protocol uint8, privEntry sessionKey) error {
+	key := makeHostKey(privEntry)
+	index := limitIndex(protocol)
+	shard := pp.sessions.shard(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	hs := shard.hosts[key]
+	if hs != nil {
+		limit := pp.SessionLimits.limit(index)
+		if (pp.SessionLimits.Total != 0 && hs.total >= pp.SessionLimits.Total) ||
+			(limit != 0 && hs.counts[index] >= limit) {
+			atomic.AddUint64(&pp.sessions.drops, 1)
+			return errSessionLimit
+		}
+	} else {
+		if shard.hosts == nil {
+			shard.hosts = make(map[hostKey]*hostSessions)
+		}
+		hs = &hostSessions{}
+		shard.hosts[key] = hs
+	}
+	hs.total++
+	hs.counts[index]++
+	return nil
+}
+
+// Forgets session of private host.
+func (pp *portPair) releaseSession(protocol uint8, privEntry sessionKey) {
+	key := makeHostKey(privEntry)
+	shard := pp.sessions.shard(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	hs := shard.hosts[key]
+	if hs == nil {
+		return
+	}
+	index := limitIndex(protocol)
+	if hs.counts[index] > 0 {
+		hs.counts[index]--
+		hs.total--
+	}
+	if hs.total == 0 {
+		delete(shard.hosts, key)
+	}
+}
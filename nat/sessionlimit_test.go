@@ -0,0 +1,113 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"testing"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+// Session of private host which is created or removed
+type testSessionOp struct {
+	release  bool
+	protocol uint8
+	host     uint8
+	// Whether session is refused by limits
+	limited bool
+}
+
+// Returns private side lookup key of private host with given number.
+func testHostEntry(ipv6 bool, host uint8) sessionKey {
+	if ipv6 {
+		return makeTuple(true, 0, types.IPv6Address{0xfd, 15: host}, 5000)
+	}
+	return makeTuple(false, 0x0a000000|types.IPv4Address(host), zeroIPv6Addr, 5000)
+}
+
+func TestSessionLimits(t *testing.T) {
+	const tcp, udp, icmp = types.TCPNumber, types.UDPNumber, types.ICMPNumber
+	tests := []struct {
+		name   string
+		limits sessionLimits
+		ops    []testSessionOp
+	}{
+		{"Unlimited", sessionLimits{}, []testSessionOp{
+			{false, tcp, 1, false},
+			{false, tcp, 1, false},
+			{false, udp, 1, false},
+		}},
+		{"Protocol", sessionLimits{TCP: 2}, []testSessionOp{
+			{false, tcp, 1, false},
+			{false, tcp, 1, false},
+			{false, tcp, 1, true},
+			{false, udp, 1, false},
+			{false, tcp, 2, false},
+		}},
+		{"Total", sessionLimits{Total: 2}, []testSessionOp{
+			{false, tcp, 1, false},
+			{false, udp, 1, false},
+			{false, icmp, 1, true},
+			{false, icmp, 2, false},
+		}},
+		{"TotalAndProtocol", sessionLimits{Total: 3, UDP: 1}, []testSessionOp{
+			{false, udp, 1, false},
+			{false, udp, 1, true},
+			{false, tcp, 1, false},
+			{false, tcp, 1, false},
+			{false, tcp, 1, true},
+		}},
+		{"Release", sessionLimits{ICMP: 1}, []testSessionOp{
+			{false, icmp, 1, false},
+			{false, icmp, 1, true},
+			{true, icmp, 1, false},
+			{false, icmp, 1, false},
+		}},
+		{"ReleaseOtherProtocol", sessionLimits{TCP: 1}, []testSessionOp{
+			{false, tcp, 1, false},
+			{true, udp, 1, false},
+			{false, tcp, 1, true},
+		}},
+		{"ReleaseUnknownHost", sessionLimits{Total: 1}, []testSessionOp{
+			{true, tcp, 1, false},
+			{false, tcp, 1, false},
+			{false, tcp, 1, true},
+		}},
+	}
+	for _, tt := range tests {
+		for _, ipv6 := range []bool{false, true} {
+			pp := &portPair{SessionLimits: tt.limits}
+			var drops uint64
+			for i, op := range tt.ops {
+				entry := testHostEntry(ipv6, op.host)
+				if op.release {
+					pp.releaseSession(op.protocol, entry)
+					continue
+				}
+				err := pp.acquireSession(op.protocol, entry)
+				if limited := err == errSessionLimit; limited != op.limited {
+					t.Errorf("%s (IPv6 %t): session %d limited is %t, expected %t", tt.name, ipv6, i, limited, op.limited)
+				}
+				if op.limited {
+					drops++
+				}
+			}
+			if pp.sessions.drops != drops {
+				t.Errorf("%s (IPv6 %t): %d drops counted, expected %d", tt.name, ipv6, pp.sessions.drops, drops)
+			}
+
+			// Hosts are forgotten when all their sessions are
+			// released
+			for _, op := range tt.ops {
+				if !op.release && !op.limited {
+					pp.releaseSession(op.protocol, testHostEntry(ipv6, op.host))
+				}
+			}
+			if hosts := pp.sessions.hostsCount(); hosts != 0 {
+				t.Errorf("%s (IPv6 %t): %d hosts left after releasing all sessions", tt.name, ipv6, hosts)
+			}
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+
+	"github.com/intel-go/nff-go/types"
+
+	upd "github.com/intel-go/nff-go-nat/updatecfg"
+)
+
+// Translation session found in lookup table of public port. Public
+// entry is IPv4 or IPv6 tuple, private entry may also be NAT64
+// tuple.
+type sessionInfo struct {
+	ipv6      bool
+	protocol  uint8
+	index     int
+	port      uint16
+	pub, priv sessionKey
+	pme       *portMapEntry
+}
+
+// Criteria of sessions selection. Zero protocol and nil addresses
+// match any session.
+type sessionFilter struct {
+	protocol  uint8
+	priv, pub *hostAddr
+}
+
+// Returns address and port of tuple and its remote address and port.
+func getTupleEndpoints(t sessionKey) (hostAddr, uint16, hostAddr, uint16) {
+	if t.ipv6() {
+		return hostAddr{Addr6: t.addr6, ipv6: true}, t.port, hostAddr{Addr6: t.remoteAddr6, ipv6: true}, t.remotePort
+	}
+	return hostAddr{Addr4: t.addr}, t.port, hostAddr{Addr4: t.remoteAddr}, t.remotePort
+}
+
+// Returns address in network byte order.
+func (a hostAddr) bytes() []byte {
+	if a.ipv6 {
+		return append([]byte(nil), a.Addr6[:]...)
+	}
+	res := make([]byte, 4)
+	binary.BigEndian.PutUint32(res, uint32(a.Addr4))
+	return res
+}
+
+func (a hostAddr) isZero() bool {
+	return a.Addr4 == 0 && a.Addr6 == zeroIPv6Addr
+}
+
+func (f *sessionFilter) match(s *sessionInfo) bool {
+	if f.protocol != 0 && f.protocol != s.protocol {
+		return false
+	}
+	if f.pub != nil {
+		if addr, _, _, _ := getTupleEndpoints(s.pub); addr != *f.pub {
+			return false
+		}
+	}
+	if f.priv != nil {
+		if addr, _, _, _ := getTupleEndpoints(s.priv); addr != *f.priv {
+			return false
+		}
+	}
+	return true
+}
+
+// Calls f for every session of port pair which matches filter. Lookup
+// tables are not locked, so sessions which are created or deleted
+// meanwhile may be either visited or not.
+func (pp *portPair) forEachSession(filter *sessionFilter, f func(s *sessionInfo) bool) {
+	for protocol, table := range pp.PublicPort.translationTable {
+		if filter.protocol != 0 && uint8(protocol) != filter.protocol {
+			continue
+		}
+		cont := true
+		table.Range(func(k sessionKey, pme *portMapEntry) bool {
+			// Inbound lookup keys of address-only sessions are
+			// skipped
+			if !k.isTuple() {
+				return true
+			}
+			ipv6 := k.ipv6()
+			v4addr, v6addr, port, _ := getAddrFromTuple(k)
+			index, inPool := pp.PublicPort.getPoolIndex(ipv6, v4addr, v6addr)
+			if !inPool {
+				return true
+			}
+			s := sessionInfo{
+				ipv6:     ipv6,
+				protocol: uint8(protocol),
+				index:    index,
+				port:     port,
+				pub:      k,
+				priv:     pme.priv,
+				pme:      pme,
+			}
+			if filter.match(&s) {
+				cont = f(&s)
+			}
+			return cont
+		})
+		if !cont {
+			return
+		}
+	}
+}
+
+// Returns session description for session list replies.
+func (s *sessionInfo) toProto(interfaceID uint32) *upd.Session {
+	pubAddr, pubPort, _, _ := getTupleEndpoints(s.pub)
+	privAddr, privPort, remoteAddr, remotePort := getTupleEndpoints(s.priv)
+	res := &upd.Session{
+		InterfaceId:      interfaceID,
+		Protocol:         uint32(s.protocol),
+		PrivateAddress:   &upd.IPAddress{Address: privAddr.bytes()},
+		PrivatePort:      uint32(privPort),
+		PublicAddress:    &upd.IPAddress{Address: pubAddr.bytes()},
+		PublicPort:       uint32(pubPort),
+		LastUsedUnixNano: atomic.LoadInt64(&s.pme.lastused),
+		Static:           s.pme.static,
+	}
+	if !remoteAddr.isZero() {
+		res.RemoteAddress = &upd.IPAddress{Address: remoteAddr.bytes()}
+		res.RemotePort = uint32(remotePort)
+	}
+	if !s.pme.created.IsZero() {
+		res.AgeSeconds = uint64(time.Since(s.pme.created) / time.Second)
+	}
+	if s.protocol == types.TCPNumber && !s.pme.static {
+		res.TcpState = s.pme.getTCPState().String()
+	}
+	return res
+}
+
+// Deletes dynamic sessions of port pair which match filter. Returns
+// number of deleted sessions.
+func (pp *portPair) deleteSessions(filter *sessionFilter) int {
+	var sessions []sessionInfo
+	pp.forEachSession(filter, func(s *sessionInfo) bool {
+		if !s.pme.static {
+			sessions = append(sessions, *s)
+		}
+		return true
+	})
+
+	deleted := 0
+	for i := range sessions {
+		s := &sessions[i]
+		mutex := pp.PublicPort.portLock(s.ipv6, s.index, s.protocol)
+		mutex.Lock()
+		// Session may be replaced meanwhile
+		if pme, found := pp.PublicPort.translationTable[s.protocol].Load(s.pub); found && pme.priv == s.priv {
+			pp.deleteOldConnection(s.ipv6, s.protocol, s.index, int(s.port))
+			deleted++
+		}
+		mutex.Unlock()
+	}
+	return deleted
+}
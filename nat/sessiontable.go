@@ -0,0 +1,344 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+const (
+	// Default maximum number of entries of one translation table
+	defaultSessionTableSize = 1 << 20
+
+	sessionTableShardBits = 6
+	sessionTableShards    = 1 << sessionTableShardBits
+	// Shard accepts new entries while at most 3/4 of its slots are
+	// used
+	sessionTableLoadNum = 3
+	sessionTableLoadDen = 4
+)
+
+// Kinds of translation table keys
+const (
+	sessionKeyTuple = iota + 1
+	sessionKeyTuple6
+	sessionKeyTuple64
+	sessionKeyAddrOnly
+	sessionKeyAddrOnly6
+	sessionKeyPending
+	sessionKeyPending6
+)
+
+var errSessionTableFull = errors.New("Session table is full")
+
+// Typed lookup key of translation table. Tuples are pairs of address
+// and port. Remote address and port are set only for private side
+// keys when mapping behavior depends on remote endpoint. IPv4 tuples
+// use addr fields, IPv6 tuples use addr6 fields.
+//
+// NAT64 sessions have separate kind of private side tuples so that
+// they don't collide with native IPv6 sessions of the same private
+// host. Public side tuple of NAT64 sessions is a usual IPv4 tuple.
+//
+// Inbound keys of address-only sessions have identifier which is ESP
+// SPI or enhanced GRE (RFC 2637) Call ID chosen by private host.
+// Pending key has no identifier and points to the session which sent
+// packets to remote address last, it is used to bind identifiers
+// which are not known yet.
+type sessionKey struct {
+	addr, remoteAddr   types.IPv4Address
+	addr6, remoteAddr6 types.IPv6Address
+	port, remotePort   uint16
+	id                 uint32
+	kind               uint8
+}
+
+// Returns IPv4 or IPv6 tuple of address and port.
+func makeTuple(ipv6 bool, v4addr types.IPv4Address, v6addr types.IPv6Address, port uint16) sessionKey {
+	if ipv6 {
+		return sessionKey{
+			addr6: v6addr,
+			port:  port,
+			kind:  sessionKeyTuple6,
+		}
+	}
+	return sessionKey{
+		addr: v4addr,
+		port: port,
+		kind: sessionKeyTuple,
+	}
+}
+
+// Returns private side tuple of NAT64 session.
+func makeTuple64(addr types.IPv6Address, port uint16) sessionKey {
+	return sessionKey{
+		addr6: addr,
+		port:  port,
+		kind:  sessionKeyTuple64,
+	}
+}
+
+// Checks whether key has IPv6 addresses.
+func (k *sessionKey) ipv6() bool {
+	switch k.kind {
+	case sessionKeyTuple6, sessionKeyTuple64, sessionKeyAddrOnly6, sessionKeyPending6:
+		return true
+	}
+	return false
+}
+
+// Checks whether key is an IPv4 or IPv6 tuple which is used by
+// public side of sessions.
+func (k *sessionKey) isTuple() bool {
+	return k.kind == sessionKeyTuple || k.kind == sessionKeyTuple6
+}
+
+func (k *sessionKey) isNAT64() bool {
+	return k.kind == sessionKeyTuple64
+}
+
+func (k *sessionKey) String() string {
+	if !k.ipv6() {
+		str := fmt.Sprintf("addr = %s:%d", StringIPv4Int(uint32(k.addr)), k.port)
+		if k.remoteAddr != 0 {
+			str += fmt.Sprintf(", remote = %s:%d", StringIPv4Int(uint32(k.remoteAddr)), k.remotePort)
+		}
+		return str
+	}
+	str := fmt.Sprintf("addr = [%s]:%d", k.addr6.String(), k.port)
+	if k.remoteAddr6 != zeroIPv6Addr {
+		str += fmt.Sprintf(", remote = [%s]:%d", k.remoteAddr6.String(), k.remotePort)
+	}
+	return str
+}
+
+func mixSessionHash(h, v uint64) uint64 {
+	h ^= v
+	h *= 0x9e3779b97f4a7c15
+	return h ^ h>>29
+}
+
+func (k *sessionKey) hash(seed uint64) uint64 {
+	h := mixSessionHash(seed, uint64(k.addr)|uint64(k.remoteAddr)<<32)
+	h = mixSessionHash(h, binary.LittleEndian.Uint64(k.addr6[:8]))
+	h = mixSessionHash(h, binary.LittleEndian.Uint64(k.addr6[8:]))
+	h = mixSessionHash(h, binary.LittleEndian.Uint64(k.remoteAddr6[:8]))
+	h = mixSessionHash(h, binary.LittleEndian.Uint64(k.remoteAddr6[8:]))
+	return mixSessionHash(h, uint64(k.port)|uint64(k.remotePort)<<16|uint64(k.id)<<32^uint64(k.kind)<<56)
+}
+
+// Entry of translation table. Entries are never changed after they
+// are stored, so readers may use them without locking. Value is port
+// map entry of session which keeps keys of both its sides.
+type sessionTableEntry struct {
+	key   sessionKey
+	hash  uint64
+	value *portMapEntry
+}
+
+// Marks slot of deleted entry, lookups continue past it.
+var sessionTableTombstone = unsafe.Pointer(new(sessionTableEntry))
+
+// Shard of translation table. Slots are allocated when the first
+// entry is stored and are replaced with a new array when deleted
+// entries take too much space. Shards are padded to cache line size
+// to avoid false sharing.
+type sessionTableShard struct {
+	mutex sync.Mutex
+	// Pointer to []unsafe.Pointer which elements are either nil,
+	// tombstone or *sessionTableEntry
+	slots unsafe.Pointer
+	// Numbers of live entries and of used (live or deleted) slots
+	live, used int
+	_          [32]byte
+}
+
+// Translation table with fixed capacity. It uses open addressing
+// with linear probing and is split into shards which have separate
+// locks for modifications. Lookups don't take locks and don't
+// allocate memory.
+type sessionTable struct {
+	shards    [sessionTableShards]sessionTableShard
+	shardSize int
+	maxLoad   int
+	seed      uint64
+}
+
+// Creates translation table which can hold at least size entries.
+func newSessionTable(size int) *sessionTable {
+	slots := (size*sessionTableLoadDen/sessionTableLoadNum + sessionTableShards - 1) / sessionTableShards
+	shardSize := 1
+	for shardSize <= slots {
+		shardSize <<= 1
+	}
+	return &sessionTable{
+		shardSize: shardSize,
+		maxLoad:   shardSize * sessionTableLoadNum / sessionTableLoadDen,
+		seed:      new(portRandom).Uint64(),
+	}
+}
+
+func (t *sessionTable) locate(k *sessionKey) (uint64, *sessionTableShard) {
+	h := k.hash(t.seed)
+	return h, &t.shards[h&(sessionTableShards-1)]
+}
+
+func (shard *sessionTableShard) getSlots() []unsafe.Pointer {
+	p := atomic.LoadPointer(&shard.slots)
+	if p == nil {
+		return nil
+	}
+	return *(*[]unsafe.Pointer)(p)
+}
+
+// Returns index of slot with given key or of empty slot which ends
+// its probe sequence.
+func findSessionSlot(slots []unsafe.Pointer, k *sessionKey, h uint64) (int, *sessionTableEntry) {
+	mask := uint64(len(slots) - 1)
+	i := (h >> sessionTableShardBits) & mask
+	for n := 0; n < len(slots); n++ {
+		p := atomic.LoadPointer(&slots[i])
+		if p == nil {
+			return int(i), nil
+		}
+		if p != sessionTableTombstone {
+			if e := (*sessionTableEntry)(p); e.hash == h && e.key == *k {
+				return int(i), e
+			}
+		}
+		i = (i + 1) & mask
+	}
+	return -1, nil
+}
+
+// Load returns port map entry stored for key.
+func (t *sessionTable) Load(k sessionKey) (*portMapEntry, bool) {
+	h, shard := t.locate(&k)
+	slots := shard.getSlots()
+	if slots == nil {
+		return nil, false
+	}
+	if _, e := findSessionSlot(slots, &k, h); e != nil {
+		return e.value, true
+	}
+	return nil, false
+}
+
+// Store sets port map entry for key. It returns false if table is
+// full.
+func (t *sessionTable) Store(k sessionKey, value *portMapEntry) bool {
+	h, shard := t.locate(&k)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	// Slots are allocated by rebuild because taking address of
+	// local slice here would move it to heap on every call
+	slots := shard.getSlots()
+	if slots == nil {
+		slots = shard.rebuild(t.shardSize)
+	}
+	e := &sessionTableEntry{
+		key:   k,
+		hash:  h,
+		value: value,
+	}
+	i, old := findSessionSlot(slots, &k, h)
+	if old != nil {
+		atomic.StorePointer(&slots[i], unsafe.Pointer(e))
+		return true
+	}
+
+	if shard.live >= t.maxLoad {
+		return false
+	}
+	// Reuse slot of deleted entry from probe sequence if there is
+	// one, otherwise a new slot is taken
+	mask := len(slots) - 1
+	for j := int(h>>sessionTableShardBits) & mask; j != i; j = (j + 1) & mask {
+		if atomic.LoadPointer(&slots[j]) == sessionTableTombstone {
+			atomic.StorePointer(&slots[j], unsafe.Pointer(e))
+			shard.live++
+			return true
+		}
+	}
+	if shard.used >= t.maxLoad {
+		slots = shard.rebuild(len(slots))
+		i, _ = findSessionSlot(slots, &k, h)
+	}
+	atomic.StorePointer(&slots[i], unsafe.Pointer(e))
+	shard.live++
+	shard.used++
+	return true
+}
+
+// Moves live entries to a new slots array to get rid of deleted ones.
+// Lookups which already started use previous array. Should be called
+// under shard lock.
+func (shard *sessionTableShard) rebuild(size int) []unsafe.Pointer {
+	slots := make([]unsafe.Pointer, size)
+	for _, p := range shard.getSlots() {
+		if p == nil || p == sessionTableTombstone {
+			continue
+		}
+		e := (*sessionTableEntry)(p)
+		i, _ := findSessionSlot(slots, &e.key, e.hash)
+		slots[i] = p
+	}
+	atomic.StorePointer(&shard.slots, unsafe.Pointer(&slots))
+	shard.used = shard.live
+	return slots
+}
+
+// Delete removes key from table.
+func (t *sessionTable) Delete(k sessionKey) {
+	t.delete(&k, nil)
+}
+
+// CompareAndDelete removes key from table if it is stored for given
+// port map entry.
+func (t *sessionTable) CompareAndDelete(k sessionKey, old *portMapEntry) {
+	t.delete(&k, old)
+}
+
+func (t *sessionTable) delete(k *sessionKey, old *portMapEntry) {
+	h, shard := t.locate(k)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	slots := shard.getSlots()
+	if slots == nil {
+		return
+	}
+	if i, e := findSessionSlot(slots, k, h); e != nil && (old == nil || e.value == old) {
+		atomic.StorePointer(&slots[i], sessionTableTombstone)
+		shard.live--
+	}
+}
+
+// Range calls f for every entry of table until it returns false.
+// Like with sync.Map, f may modify table and entries which are
+// stored or deleted meanwhile may be either visited or not.
+func (t *sessionTable) Range(f func(key sessionKey, value *portMapEntry) bool) {
+	for s := range t.shards {
+		slots := t.shards[s].getSlots()
+		for i := range slots {
+			p := atomic.LoadPointer(&slots[i])
+			if p == nil || p == sessionTableTombstone {
+				continue
+			}
+			e := (*sessionTableEntry)(p)
+			if !f(e.key, e.value) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+// Number of distinct keys used by benchmarks
+const benchSessions = 1 << 16
+
+var benchKeyKinds = []struct {
+	name string
+	ipv6 bool
+}{
+	{"Tuple", false},
+	{"Tuple6", true},
+}
+
+// Returns IPv4 or IPv6 tuple keys with different addresses and
+// ports and port map entries for them.
+func makeBenchSessions(ipv6 bool) ([]sessionKey, []portMapEntry) {
+	keys := make([]sessionKey, benchSessions)
+	entries := make([]portMapEntry, benchSessions)
+	for i := range keys {
+		addr := types.IPv4Address(0x0a000000 + uint32(i>>4))
+		var addr6 types.IPv6Address
+		addr6[0] = 0xfd
+		addr6[12], addr6[13], addr6[14], addr6[15] = uint8(addr>>24), uint8(addr>>16), uint8(addr>>8), uint8(addr)
+		keys[i] = makeTuple(ipv6, addr, addr6, uint16(portStart+(i&0xf)))
+		entries[i].priv = keys[i]
+	}
+	return keys, entries
+}
+
+func BenchmarkSessionTableLoad(b *testing.B) {
+	for _, kind := range benchKeyKinds {
+		b.Run(kind.name, func(b *testing.B) {
+			keys, entries := makeBenchSessions(kind.ipv6)
+			t := newSessionTable(benchSessions)
+			for i := range keys {
+				t.Store(keys[i], &entries[i])
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, found := t.Load(keys[i%benchSessions]); !found {
+					b.Fatal("key is not found")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSyncMapLoad(b *testing.B) {
+	for _, kind := range benchKeyKinds {
+		b.Run(kind.name, func(b *testing.B) {
+			keys, entries := makeBenchSessions(kind.ipv6)
+			var m sync.Map
+			for i := range keys {
+				m.Store(keys[i], &entries[i])
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, found := m.Load(keys[i%benchSessions]); !found {
+					b.Fatal("key is not found")
+				}
+			}
+		})
+	}
+}
+
+// Keys are stored to empty table first and then replaced, so both
+// insertion and update are measured.
+func BenchmarkSessionTableStore(b *testing.B) {
+	for _, kind := range benchKeyKinds {
+		b.Run(kind.name, func(b *testing.B) {
+			keys, entries := makeBenchSessions(kind.ipv6)
+			t := newSessionTable(benchSessions)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				n := i % benchSessions
+				if !t.Store(keys[n], &entries[n]) {
+					b.Fatal("table is full")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSyncMapStore(b *testing.B) {
+	for _, kind := range benchKeyKinds {
+		b.Run(kind.name, func(b *testing.B) {
+			keys, entries := makeBenchSessions(kind.ipv6)
+			var m sync.Map
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				n := i % benchSessions
+				m.Store(keys[n], &entries[n])
+			}
+		})
+	}
+}
+
+// Table is refilled with timer stopped every time all keys are
+// deleted.
+func BenchmarkSessionTableDelete(b *testing.B) {
+	for _, kind := range benchKeyKinds {
+		b.Run(kind.name, func(b *testing.B) {
+			keys, entries := makeBenchSessions(kind.ipv6)
+			t := newSessionTable(benchSessions)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				n := i % benchSessions
+				if n == 0 {
+					b.StopTimer()
+					for j := range keys {
+						t.Store(keys[j], &entries[j])
+					}
+					b.StartTimer()
+				}
+				t.Delete(keys[n])
+			}
+		})
+	}
+}
+
+func BenchmarkSyncMapDelete(b *testing.B) {
+	for _, kind := range benchKeyKinds {
+		b.Run(kind.name, func(b *testing.B) {
+			keys, entries := makeBenchSessions(kind.ipv6)
+			var m sync.Map
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				n := i % benchSessions
+				if n == 0 {
+					b.StopTimer()
+					for j := range keys {
+						m.Store(keys[j], &entries[j])
+					}
+					b.StartTimer()
+				}
+				m.Delete(keys[n])
+			}
+		})
+	}
+}
@@ -0,0 +1,650 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/types"
+)
+
+const sipPort = 5060
+
+// Compact forms of SIP header names (RFC 3261 section 7.3.3)
+var sipCompactHeaders = map[string]string{
+	"v": "via",
+	"m": "contact",
+	"i": "call-id",
+	"l": "content-length",
+	"c": "content-type",
+	"f": "from",
+	"t": "to",
+}
+
+// Headers which may contain address of SIP endpoint. Empty name
+// stands for request or status line.
+var sipAddressHeaders = map[string]bool{
+	"":        true,
+	"via":     true,
+	"contact": true,
+	"from":    true,
+	"to":      true,
+}
+
+// Address and port of SIP endpoint.
+type sipAddr struct {
+	v4addr types.IPv4Address
+	v6addr types.IPv6Address
+	ipv6   bool
+	port   uint16
+}
+
+// Returns address in a form used in SIP headers, IPv6 addresses are
+// enclosed in brackets.
+func (a *sipAddr) host() string {
+	if a.ipv6 {
+		return "[" + net.IP(a.v6addr[:]).String() + "]"
+	}
+	return StringIPv4Int(uint32(a.v4addr))
+}
+
+// Returns address in a form used in SDP.
+func (a *sipAddr) sdpHost() string {
+	if a.ipv6 {
+		return net.IP(a.v6addr[:]).String()
+	}
+	return StringIPv4Int(uint32(a.v4addr))
+}
+
+func (a *sipAddr) equalIP(ip net.IP) bool {
+	if a.ipv6 {
+		return ip != nil && ip.To4() == nil && bytes.Equal(ip.To16(), a.v6addr[:])
+	}
+	ip4 := ip.To4()
+	return ip4 != nil && types.IPv4Address(ip4[0])<<24|types.IPv4Address(ip4[1])<<16|
+		types.IPv4Address(ip4[2])<<8|types.IPv4Address(ip4[3]) == a.v4addr
+}
+
+// Line of SIP message header. Offsets are relative to beginning of
+// payload.
+type sipLine struct {
+	start, end int
+	name       string
+}
+
+// Parsed SIP message. Method of response is taken from its CSeq
+// header.
+type sipMessage struct {
+	method    string
+	status    int
+	callID    string
+	sdp       bool
+	lines     []sipLine
+	bodyStart int
+	bodyEnd   int
+	// Offsets of Content-Length header value, zero if it is absent
+	lengthStart, lengthEnd int
+}
+
+// Change of payload bytes from start to end.
+type payloadEdit struct {
+	start, end int
+	data       []byte
+}
+
+// Parses SIP message which is expected to be in one packet.
+func parseSIPMessage(payload []byte) (*sipMessage, bool) {
+	hdrEnd := bytes.Index(payload, []byte("\r\n\r\n"))
+	if hdrEnd < 0 {
+		return nil, false
+	}
+	msg := sipMessage{
+		bodyStart: hdrEnd + 4,
+		bodyEnd:   len(payload),
+	}
+
+	for start := 0; start < hdrEnd; {
+		end := bytes.Index(payload[start:hdrEnd+2], []byte("\r\n")) + start
+		line := payload[start:end]
+		if start == 0 {
+			if bytes.HasPrefix(line, []byte("SIP/2.0 ")) {
+				status, err := strconv.Atoi(string(bytes.SplitN(line[8:], []byte(" "), 2)[0]))
+				if err != nil {
+					return nil, false
+				}
+				msg.status = status
+			} else if sp := bytes.IndexByte(line, ' '); sp > 0 && bytes.HasSuffix(line, []byte(" SIP/2.0")) {
+				msg.method = string(line[:sp])
+			} else {
+				return nil, false
+			}
+			msg.lines = append(msg.lines, sipLine{start: start, end: end})
+			start = end + 2
+			continue
+		}
+
+		colon := bytes.IndexByte(line, ':')
+		if colon > 0 {
+			name := strings.ToLower(strings.TrimSpace(string(line[:colon])))
+			if full, ok := sipCompactHeaders[name]; ok {
+				name = full
+			}
+			value := bytes.TrimSpace(line[colon+1:])
+			switch name {
+			case "call-id":
+				msg.callID = string(value)
+			case "cseq":
+				if msg.status != 0 {
+					if fields := bytes.Fields(value); len(fields) == 2 {
+						msg.method = string(fields[1])
+					}
+				}
+			case "content-type":
+				msg.sdp = bytes.HasPrefix(bytes.ToLower(value), []byte("application/sdp"))
+			case "content-length":
+				length, err := strconv.Atoi(string(value))
+				if err == nil && msg.bodyStart+length <= len(payload) {
+					msg.bodyEnd = msg.bodyStart + length
+				}
+				msg.lengthStart = start + colon + 1 + bytes.Index(line[colon+1:], value)
+				msg.lengthEnd = msg.lengthStart + len(value)
+			}
+			msg.lines = append(msg.lines, sipLine{start: start, end: end, name: name})
+		}
+		start = end + 2
+	}
+	return &msg, true
+}
+
+func isHostChar(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '.' || c == '-'
+}
+
+// Replaces occurrences of from endpoint in payload between start
+// and end with to endpoint. Port is replaced only when it is equal
+// to port of from endpoint, absent port means default SIP port.
+func rewriteSIPHost(payload []byte, start, end int, from, to *sipAddr, edits []payloadEdit) []payloadEdit {
+	fromHost := []byte(from.host())
+	toHost := to.host()
+	for i := start; i < end; {
+		j := bytes.Index(payload[i:end], fromHost)
+		if j < 0 {
+			break
+		}
+		j += i
+		k := j + len(fromHost)
+		i = k
+		if (j > start && isHostChar(payload[j-1])) || (k < end && isHostChar(payload[k])) {
+			continue
+		}
+
+		if k < end && payload[k] == ':' {
+			l := k + 1
+			for l < end && payload[l] >= '0' && payload[l] <= '9' {
+				l++
+			}
+			if port, err := strconv.Atoi(string(payload[k+1 : l])); err == nil && port == int(from.port) {
+				edits = append(edits, payloadEdit{
+					start: j,
+					end:   l,
+					data:  []byte(toHost + ":" + strconv.Itoa(int(to.port))),
+				})
+				i = l
+				continue
+			}
+		} else if from.port == sipPort && to.port != sipPort {
+			toHost += ":" + strconv.Itoa(int(to.port))
+		}
+		edits = append(edits, payloadEdit{
+			start: j,
+			end:   k,
+			data:  []byte(toHost),
+		})
+		toHost = to.host()
+	}
+	return edits
+}
+
+// Media stream mapping created for SIP dialog.
+type sipMedia struct {
+	ipv6  bool
+	index int
+	port  uint16
+	priv  sessionKey
+}
+
+// Media stream mappings negotiated in SIP dialog. They are deleted
+// when dialog ends or expire as usual UDP sessions when media stream
+// stops.
+type sipDialog struct {
+	mutex     sync.Mutex
+	confirmed bool
+	// Dialog is deleted from dialogs map. It is changed under
+	// dialog lock, so that media are not added to deleted dialog.
+	deleted bool
+	media   []sipMedia
+}
+
+// Checks whether all media stream mappings of dialog are expired or
+// deleted.
+func (pp *portPair) sipDialogExpired(d *sipDialog) bool {
+	for _, m := range d.media {
+		pubEntry := pp.PublicPort.makePortAddrTuple(m.ipv6, m.index, m.port)
+		pme, found := pp.PublicPort.translationTable[types.UDPNumber].Load(pubEntry)
+		if found && pme.priv == m.priv && !pme.expired(types.UDPNumber) {
+			return false
+		}
+	}
+	return true
+}
+
+// Forgets dialogs which media streams stopped without dialog being
+// ended. It is called by session reaper.
+func (pp *portPair) sweepSIPDialogs() {
+	pp.sipDialogs.Range(func(k, v interface{}) bool {
+		d := v.(*sipDialog)
+		d.mutex.Lock()
+		if !d.deleted && pp.sipDialogExpired(d) {
+			pp.sipDialogs.Delete(k)
+			d.deleted = true
+		}
+		d.mutex.Unlock()
+		return true
+	})
+}
+
+// Remembers media stream mapping created for SIP dialog. Dialog is
+// created again if it was deleted meanwhile.
+func (pp *portPair) addSIPMedia(callID string, m sipMedia) {
+	for {
+		v, found := pp.sipDialogs.Load(callID)
+		if !found {
+			v, _ = pp.sipDialogs.LoadOrStore(callID, &sipDialog{})
+		}
+		d := v.(*sipDialog)
+		d.mutex.Lock()
+		if !d.deleted {
+			d.media = append(d.media, m)
+			d.mutex.Unlock()
+			return
+		}
+		d.mutex.Unlock()
+	}
+}
+
+// Deletes media stream mappings of SIP dialog which is ended.
+func (pp *portPair) endSIPDialog(callID string, d *sipDialog) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.deleted {
+		return
+	}
+	pp.sipDialogs.Delete(callID)
+	d.deleted = true
+	for _, m := range d.media {
+		mutex := pp.PublicPort.portLock(m.ipv6, m.index, types.UDPNumber)
+		mutex.Lock()
+		pubEntry := pp.PublicPort.makePortAddrTuple(m.ipv6, m.index, m.port)
+		if pme, found := pp.PublicPort.translationTable[types.UDPNumber].Load(pubEntry); found && pme.priv == m.priv {
+			pp.deleteOldConnection(m.ipv6, types.UDPNumber, m.index, int(m.port))
+		}
+		mutex.Unlock()
+	}
+	d.media = nil
+}
+
+// Follows SIP dialog state. Dialog ends with BYE or CANCEL request
+// or with failure response to initial INVITE.
+func (pp *portPair) trackSIPDialog(msg *sipMessage) {
+	if msg.callID == "" {
+		return
+	}
+	v, found := pp.sipDialogs.Load(msg.callID)
+	if !found {
+		return
+	}
+	d := v.(*sipDialog)
+	switch {
+	case msg.status == 0 && (msg.method == "BYE" || msg.method == "CANCEL"):
+		pp.endSIPDialog(msg.callID, d)
+	case msg.method == "INVITE" && msg.status >= 200 && msg.status < 300:
+		d.mutex.Lock()
+		d.confirmed = true
+		d.mutex.Unlock()
+	case msg.method == "INVITE" && msg.status >= 300:
+		d.mutex.Lock()
+		confirmed := d.confirmed
+		d.mutex.Unlock()
+		if !confirmed {
+			pp.endSIPDialog(msg.callID, d)
+		}
+	}
+}
+
+// Media description of SDP body. Offsets are relative to beginning
+// of payload.
+type sdpMedia struct {
+	port               uint16
+	portStart, portEnd int
+	lineEnd            int
+	eol                string
+	addrMatched        bool
+	addrSet            bool
+	rtcpPort           uint16
+	rtcpStart, rtcpEnd int
+	rtcpSet            bool
+}
+
+// Returns offsets of space separated fields of line.
+func sdpFields(payload []byte, start, end int) [][2]int {
+	var res [][2]int
+	for i := start; i < end; {
+		for i < end && payload[i] == ' ' {
+			i++
+		}
+		j := i
+		for j < end && payload[j] != ' ' {
+			j++
+		}
+		if j > i {
+			res = append(res, [2]int{i, j})
+		}
+		i = j
+	}
+	return res
+}
+
+// Translates SDP body sent by private endpoint. Connection and
+// origin addresses equal to private address are replaced with public
+// address. Media streams of private address get public mappings, RTCP
+// mapping uses the next port unless it is given explicitly.
+func (pp *portPair) translateSDP(msg *sipMessage, payload []byte, poolIndex int, priv, pub *sipAddr, edits []payloadEdit) []payloadEdit {
+	var media []sdpMedia
+	sessionMatched := false
+	replaceAddr := func(field [2]int) bool {
+		if !priv.equalIP(net.ParseIP(string(payload[field[0]:field[1]]))) {
+			return false
+		}
+		edits = append(edits, payloadEdit{
+			start: field[0],
+			end:   field[1],
+			data:  []byte(pub.sdpHost()),
+		})
+		return true
+	}
+
+	for start := msg.bodyStart; start < msg.bodyEnd; {
+		end := bytes.IndexByte(payload[start:msg.bodyEnd], '\n')
+		next := msg.bodyEnd
+		if end < 0 {
+			end = msg.bodyEnd
+		} else {
+			end += start
+			next = end + 1
+		}
+		eol := "\n"
+		if end > start && payload[end-1] == '\r' {
+			end--
+			eol = "\r\n"
+		}
+		if end-start < 2 || payload[start+1] != '=' {
+			start = next
+			continue
+		}
+		fields := sdpFields(payload, start+2, end)
+		switch payload[start] {
+		case 'c':
+			if len(fields) >= 3 {
+				matched := replaceAddr(fields[2])
+				if len(media) == 0 {
+					sessionMatched = matched
+				} else {
+					media[len(media)-1].addrMatched = matched
+					media[len(media)-1].addrSet = true
+				}
+			}
+		case 'o':
+			if len(fields) >= 6 {
+				replaceAddr(fields[5])
+			}
+		case 'm':
+			if len(fields) >= 2 {
+				m := sdpMedia{
+					portStart: fields[1][0],
+					portEnd:   fields[1][0],
+					lineEnd:   next,
+					eol:       eol,
+				}
+				for m.portEnd < fields[1][1] && payload[m.portEnd] != '/' {
+					m.portEnd++
+				}
+				port, err := strconv.Atoi(string(payload[m.portStart:m.portEnd]))
+				if err != nil || port > 65535 {
+					port = 0
+				}
+				m.port = uint16(port)
+				media = append(media, m)
+			}
+		case 'a':
+			if len(media) != 0 && bytes.HasPrefix(payload[start:end], []byte("a=rtcp:")) {
+				m := &media[len(media)-1]
+				m.rtcpStart = start + 7
+				m.rtcpEnd = m.rtcpStart
+				for m.rtcpEnd < end && payload[m.rtcpEnd] >= '0' && payload[m.rtcpEnd] <= '9' {
+					m.rtcpEnd++
+				}
+				port, err := strconv.Atoi(string(payload[m.rtcpStart:m.rtcpEnd]))
+				if err == nil && port <= 65535 {
+					m.rtcpPort = uint16(port)
+					m.rtcpSet = true
+				}
+				if fields = sdpFields(payload, m.rtcpEnd, end); len(fields) >= 3 {
+					replaceAddr(fields[2])
+				}
+			}
+		}
+		start = next
+	}
+
+	for i := range media {
+		m := &media[i]
+		if m.port == 0 || (m.addrSet && !m.addrMatched) || (!m.addrSet && !sessionMatched) {
+			continue
+		}
+		rtpPort, ok := pp.expectSIPMedia(msg.callID, poolIndex, priv, m.port)
+		if !ok {
+			continue
+		}
+		edits = append(edits, payloadEdit{
+			start: m.portStart,
+			end:   m.portEnd,
+			data:  []byte(strconv.Itoa(int(rtpPort))),
+		})
+
+		if !m.rtcpSet {
+			m.rtcpPort = m.port + 1
+		}
+		rtcpPort, ok := pp.expectSIPMedia(msg.callID, poolIndex, priv, m.rtcpPort)
+		if !ok {
+			continue
+		}
+		if m.rtcpSet {
+			edits = append(edits, payloadEdit{
+				start: m.rtcpStart,
+				end:   m.rtcpEnd,
+				data:  []byte(strconv.Itoa(int(rtcpPort))),
+			})
+		} else if rtcpPort != rtpPort+1 {
+			// RTCP port is given explicitly when public ports are
+			// not consecutive (RFC 3605)
+			edits = append(edits, payloadEdit{
+				start: m.lineEnd,
+				end:   m.lineEnd,
+				data:  []byte("a=rtcp:" + strconv.Itoa(int(rtcpPort)) + m.eol),
+			})
+		}
+	}
+	return edits
+}
+
+// Creates public mapping of private media stream endpoint and
+// remembers it in SIP dialog if it is new. Returns public port.
+func (pp *portPair) expectSIPMedia(callID string, poolIndex int, priv *sipAddr, port uint16) (uint16, bool) {
+	privEntry := makeTuple(priv.ipv6, priv.v4addr, priv.v6addr, port)
+	_, _, pubPort, index, created, err := pp.expectConnection(priv.ipv6, types.UDPNumber, poolIndex, privEntry)
+	if err != nil {
+		if err != errSessionLimit {
+			println("Warning! Failed to allocate SIP media connection", err.Error())
+		}
+		return 0, false
+	}
+	if created {
+		pp.addSIPMedia(callID, sipMedia{
+			ipv6:  priv.ipv6,
+			index: index,
+			port:  pubPort,
+			priv:  privEntry,
+		})
+	}
+	return pubPort, true
+}
+
+// Applies sorted payload edits to packet. Returns change of payload
+// length.
+func applyPayloadEdits(pkt *packet.Packet, pktIPv4 *packet.IPv4Hdr, pktIPv6 *packet.IPv6Hdr, pktUDP *packet.UDPHdr,
+	payload []byte, edits []payloadEdit) (int, bool) {
+	if len(edits) == 0 {
+		return 0, true
+	}
+	start, end := edits[0].start, edits[len(edits)-1].end
+	var data []byte
+	pos := start
+	for _, e := range edits {
+		data = append(data, payload[pos:e.start]...)
+		data = append(data, e.data...)
+		pos = e.end
+	}
+	if !replacePayload(pkt, pktIPv4, pktIPv6, pktUDP, payload, start, end, data) {
+		return 0, false
+	}
+	return len(data) - (end - start), true
+}
+
+// Translates SIP message in packet sent in direction dir between
+// private and public signalling endpoints of a session. Addresses
+// of private endpoint in request line and Via, Contact, From and To
+// headers are replaced with public ones and vice versa. SDP bodies
+// sent from private network get public media stream mappings.
+// Checksums are calculated later by caller.
+func (pp *portPair) translateSIP(pkt *packet.Packet, pktIPv4 *packet.IPv4Hdr, pktIPv6 *packet.IPv6Hdr, pktTCP *packet.TCPHdr, pktUDP *packet.UDPHdr,
+	pme *portMapEntry, poolIndex int, priv, pub *sipAddr, dir trafficDirection) {
+	ipv6 := pktIPv6 != nil
+	var payload []byte
+	var conn *algConnection
+	var seq uint32
+	if pktTCP != nil {
+		remotePort := pktTCP.DstPort
+		if dir == pub2pri {
+			remotePort = pktTCP.SrcPort
+		}
+		conn = pp.getALGConnection(pme, makeALGRemote(pktIPv4, pktIPv6, packet.SwapBytesUint16(remotePort), dir), true)
+		conn.mutex.Lock()
+		defer conn.mutex.Unlock()
+		conn.lastused = time.Now()
+		seq = packet.SwapBytesUint32(pktTCP.SentSeq)
+		payload = getTCPPayload(pkt, ipv6, pktTCP)
+	} else {
+		payload = getUDPPayload(pkt, ipv6)
+	}
+
+	if msg, ok := parseSIPMessage(payload); ok {
+		from, to := priv, pub
+		if dir == pub2pri {
+			from, to = pub, priv
+		}
+		var edits []payloadEdit
+		for _, line := range msg.lines {
+			if sipAddressHeaders[line.name] {
+				edits = rewriteSIPHost(payload, line.start, line.end, from, to, edits)
+			}
+		}
+		if dir == pri2pub && msg.sdp {
+			bodyEdits := pp.translateSDP(msg, payload, poolIndex, priv, pub, nil)
+			diff := 0
+			for _, e := range bodyEdits {
+				diff += len(e.data) - (e.end - e.start)
+			}
+			if diff != 0 && msg.lengthEnd != 0 {
+				edits = append(edits, payloadEdit{
+					start: msg.lengthStart,
+					end:   msg.lengthEnd,
+					data:  []byte(strconv.Itoa(msg.bodyEnd - msg.bodyStart + diff)),
+				})
+			}
+			edits = append(edits, bodyEdits...)
+		}
+		sort.Slice(edits, func(i, j int) bool {
+			return edits[i].start < edits[j].start
+		})
+		if diff, ok := applyPayloadEdits(pkt, pktIPv4, pktIPv6, pktUDP, payload, edits); ok && conn != nil && diff != 0 {
+			conn.adjust[dir].record(seq, diff)
+		}
+		pp.trackSIPDialog(msg)
+	}
+
+	if conn != nil {
+		conn.adjustSegment(pktTCP, seq, dir)
+	}
+}
+
+// Translates SIP message sent from private network. Private and
+// public signalling endpoints are taken from packet and its session.
+func (pp *portPair) translateSIPPri2Pub(pkt *packet.Packet, pktIPv4 *packet.IPv4Hdr, pktIPv6 *packet.IPv6Hdr, pktTCP *packet.TCPHdr, pktUDP *packet.UDPHdr,
+	pme *portMapEntry, poolIndex int, srcPort uint16, pubAddr4 types.IPv4Address, pubAddr6 types.IPv6Address, pubPort uint16) {
+	priv := sipAddr{
+		ipv6: pktIPv6 != nil,
+		port: srcPort,
+	}
+	pub := sipAddr{
+		v4addr: pubAddr4,
+		v6addr: pubAddr6,
+		ipv6:   pktIPv6 != nil,
+		port:   pubPort,
+	}
+	if pktIPv6 != nil {
+		priv.v6addr = pktIPv6.SrcAddr
+	} else {
+		priv.v4addr = packet.SwapBytesIPv4Addr(pktIPv4.SrcAddr)
+	}
+	pp.translateSIP(pkt, pktIPv4, pktIPv6, pktTCP, pktUDP, pme, poolIndex, &priv, &pub, pri2pub)
+}
+
+// Translates SIP message received from public network.
+func (pp *portPair) translateSIPPub2Pri(pkt *packet.Packet, pktIPv4 *packet.IPv4Hdr, pktIPv6 *packet.IPv6Hdr, pktTCP *packet.TCPHdr, pktUDP *packet.UDPHdr,
+	pme *portMapEntry, poolIndex int, dstPort uint16, privAddr4 types.IPv4Address, privAddr6 types.IPv6Address, privPort uint16) {
+	priv := sipAddr{
+		v4addr: privAddr4,
+		v6addr: privAddr6,
+		ipv6:   pktIPv6 != nil,
+		port:   privPort,
+	}
+	pub := sipAddr{
+		ipv6: pktIPv6 != nil,
+		port: dstPort,
+	}
+	if pktIPv6 != nil {
+		pub.v6addr = pktIPv6.DstAddr
+	} else {
+		pub.v4addr = packet.SwapBytesIPv4Addr(pktIPv4.DstAddr)
+	}
+	pp.translateSIP(pkt, pktIPv4, pktIPv6, pktTCP, pktUDP, pme, poolIndex, &priv, &pub, pub2pri)
+}
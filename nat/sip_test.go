@@ -0,0 +1,309 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+// Returns port pair which public port has address 192.0.2.1 and
+// 2001:db8::1 and no address pool.
+func newTestPortPair() *portPair {
+	if Natconfig == nil {
+		Natconfig = &Config{
+			SessionTableSize: 1024,
+		}
+		Natconfig.Timeouts = sessionTimeouts{
+			TCPEstablished: defaultTCPEstablishedTimeout,
+			TCPTransitory:  defaultTCPTransitoryTimeout,
+			UDP:            defaultUDPTimeout,
+			ICMP:           defaultICMPTimeout,
+		}
+		timeouts := Natconfig.Timeouts
+		Natconfig.timeouts.Store(&timeouts)
+	}
+	pp := &portPair{}
+	pp.PrivatePort.Type = iPRIVATE
+	pp.PublicPort.Type = iPUBLIC
+	pp.PrivatePort.opposite = &pp.PublicPort
+	pp.PublicPort.opposite = &pp.PrivatePort
+	pp.PublicPort.Subnet.Addr = 0xc0000201
+	pp.PublicPort.Subnet6.Addr = testIPv6Address("2001:db8::1")
+	pp.PublicPort.allocatePublicPortPortMap()
+	pp.PrivatePort.allocateLookupMap()
+	pp.PublicPort.allocateLookupMap()
+	return pp
+}
+
+// Returns payload with edits applied.
+func testApplyEdits(payload []byte, edits []payloadEdit) string {
+	sort.Slice(edits, func(i, j int) bool {
+		return edits[i].start < edits[j].start
+	})
+	var res []byte
+	pos := 0
+	for _, e := range edits {
+		res = append(res, payload[pos:e.start]...)
+		res = append(res, e.data...)
+		pos = e.end
+	}
+	return string(append(res, payload[pos:]...))
+}
+
+func TestParseSIPMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		ok      bool
+		method  string
+		status  int
+		callID  string
+		sdp     bool
+		// Names of parsed header lines, empty name is request or
+		// status line
+		lines []string
+		body  string
+		// Value of Content-Length header
+		length string
+	}{
+		{"Invite", "INVITE sip:bob@198.51.100.1 SIP/2.0\r\n" +
+			"Via: SIP/2.0/UDP 10.0.0.1:5060\r\n" +
+			"Call-ID: a84b4c76e66710\r\n" +
+			"CSeq: 1 INVITE\r\n" +
+			"Content-Type: application/sdp\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"v=0\r\n",
+			true, "INVITE", 0, "a84b4c76e66710", true,
+			[]string{"", "via", "call-id", "cseq", "content-type", "content-length"}, "v=0\r\n", "5"},
+		{"CompactHeaders", "INVITE sip:bob@198.51.100.1 SIP/2.0\r\n" +
+			"v: SIP/2.0/UDP 10.0.0.1\r\n" +
+			"m: <sip:alice@10.0.0.1>\r\n" +
+			"i: a84b4c76e66710\r\n" +
+			"c: Application/SDP\r\n" +
+			"l: 0\r\n" +
+			"\r\n",
+			true, "INVITE", 0, "a84b4c76e66710", true,
+			[]string{"", "via", "contact", "call-id", "content-type", "content-length"}, "", "0"},
+		{"Response", "SIP/2.0 200 OK\r\n" +
+			"CSeq: 2 BYE\r\n" +
+			"call-id: a84b4c76e66710\r\n" +
+			"\r\n",
+			true, "BYE", 200, "a84b4c76e66710", false,
+			[]string{"", "cseq", "call-id"}, "", ""},
+		{"NoContentLength", "ACK sip:bob@198.51.100.1 SIP/2.0\r\n" +
+			"\r\n" +
+			"body",
+			true, "ACK", 0, "", false,
+			[]string{""}, "body", ""},
+		{"ShortContentLength", "MESSAGE sip:bob@198.51.100.1 SIP/2.0\r\n" +
+			"Content-Length: 2\r\n" +
+			"\r\n" +
+			"body",
+			true, "MESSAGE", 0, "", false,
+			[]string{"", "content-length"}, "bo", "2"},
+		{"ContentLengthBeyondPacket", "MESSAGE sip:bob@198.51.100.1 SIP/2.0\r\n" +
+			"Content-Length:  100 \r\n" +
+			"\r\n" +
+			"body",
+			true, "MESSAGE", 0, "", false,
+			[]string{"", "content-length"}, "body", "100"},
+		{"LineWithoutColon", "BYE sip:bob@198.51.100.1 SIP/2.0\r\n" +
+			"garbage\r\n" +
+			"Call-ID: a84b4c76e66710\r\n" +
+			"\r\n",
+			true, "BYE", 0, "a84b4c76e66710", false,
+			[]string{"", "call-id"}, "", ""},
+		{"Unterminated", "INVITE sip:bob@198.51.100.1 SIP/2.0\r\nCall-ID: a84b4c76e66710\r\n",
+			false, "", 0, "", false, nil, "", ""},
+		{"BadStatus", "SIP/2.0 OK\r\n\r\n",
+			false, "", 0, "", false, nil, "", ""},
+		{"NotSIP", "GET / HTTP/1.1\r\n\r\n",
+			false, "", 0, "", false, nil, "", ""},
+	}
+	for _, tt := range tests {
+		payload := []byte(tt.payload)
+		msg, ok := parseSIPMessage(payload)
+		if ok != tt.ok {
+			t.Errorf("%s: parsed %t, expected %t", tt.name, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if msg.method != tt.method || msg.status != tt.status || msg.callID != tt.callID || msg.sdp != tt.sdp {
+			t.Errorf("%s: parsed method %q, status %d, Call-ID %q, SDP %t", tt.name, msg.method, msg.status, msg.callID, msg.sdp)
+		}
+		var lines []string
+		for _, line := range msg.lines {
+			lines = append(lines, line.name)
+		}
+		if !reflect.DeepEqual(lines, tt.lines) {
+			t.Errorf("%s: parsed lines %q, expected %q", tt.name, lines, tt.lines)
+		}
+		if body := string(payload[msg.bodyStart:msg.bodyEnd]); body != tt.body {
+			t.Errorf("%s: parsed body %q, expected %q", tt.name, body, tt.body)
+		}
+		if length := string(payload[msg.lengthStart:msg.lengthEnd]); length != tt.length {
+			t.Errorf("%s: parsed Content-Length %q, expected %q", tt.name, length, tt.length)
+		}
+	}
+}
+
+func TestRewriteSIPHost(t *testing.T) {
+	priv := sipAddr{v4addr: 0x0a000001, port: sipPort}
+	pub := sipAddr{v4addr: 0xc0000201, port: 40000}
+	pubDefaultPort := sipAddr{v4addr: 0xc0000201, port: sipPort}
+	priv6 := sipAddr{v6addr: testIPv6Address("fd00::1"), ipv6: true, port: sipPort}
+	pub6 := sipAddr{v6addr: testIPv6Address("2001:db8::1"), ipv6: true, port: 40000}
+	tests := []struct {
+		name     string
+		line     string
+		from, to *sipAddr
+		result   string
+	}{
+		{"AddressAndPort", "Via: SIP/2.0/UDP 10.0.0.1:5060;branch=z9hG4bK776", &priv, &pub,
+			"Via: SIP/2.0/UDP 192.0.2.1:40000;branch=z9hG4bK776"},
+		{"DefaultPort", "Contact: <sip:alice@10.0.0.1>", &priv, &pub,
+			"Contact: <sip:alice@192.0.2.1:40000>"},
+		{"DefaultPortKept", "Contact: <sip:alice@10.0.0.1>", &priv, &pubDefaultPort,
+			"Contact: <sip:alice@192.0.2.1>"},
+		{"OtherPort", "Contact: <sip:alice@10.0.0.1:5070>", &priv, &pub,
+			"Contact: <sip:alice@192.0.2.1:5070>"},
+		{"Twice", "From: <sip:alice@10.0.0.1>;tag=1928301774;received=10.0.0.1", &priv, &pub,
+			"From: <sip:alice@192.0.2.1:40000>;tag=1928301774;received=192.0.2.1:40000"},
+		{"LongerAddress", "To: <sip:bob@10.0.0.10>, <sip:bob@110.0.0.1>, <sip:bob@10.0.0.1.example.com>", &priv, &pub,
+			"To: <sip:bob@10.0.0.10>, <sip:bob@110.0.0.1>, <sip:bob@10.0.0.1.example.com>"},
+		{"PublicToPrivate", "INVITE sip:bob@192.0.2.1:40000 SIP/2.0", &pub, &priv,
+			"INVITE sip:bob@10.0.0.1:5060 SIP/2.0"},
+		{"PublicToPrivateNoPort", "To: <sip:bob@192.0.2.1>", &pub, &priv,
+			"To: <sip:bob@10.0.0.1>"},
+		{"IPv6", "Via: SIP/2.0/UDP [fd00::1]:5060;branch=z9hG4bK776", &priv6, &pub6,
+			"Via: SIP/2.0/UDP [2001:db8::1]:40000;branch=z9hG4bK776"},
+		{"IPv6DefaultPort", "Contact: <sip:alice@[fd00::1]>", &priv6, &pub6,
+			"Contact: <sip:alice@[2001:db8::1]:40000>"},
+	}
+	for _, tt := range tests {
+		payload := []byte(tt.line)
+		edits := rewriteSIPHost(payload, 0, len(payload), tt.from, tt.to, nil)
+		if result := testApplyEdits(payload, edits); result != tt.result {
+			t.Errorf("%s: rewritten to %q, expected %q", tt.name, result, tt.result)
+		}
+	}
+}
+
+func TestTranslateSDP(t *testing.T) {
+	priv := sipAddr{v4addr: 0x0a000001, port: sipPort}
+	pub := sipAddr{v4addr: 0xc0000201, port: 40000}
+	priv6 := sipAddr{v6addr: testIPv6Address("fd00::1"), ipv6: true, port: sipPort}
+	pub6 := sipAddr{v6addr: testIPv6Address("2001:db8::1"), ipv6: true, port: 40000}
+	// Public ports are allocated sequentially starting from 1024
+	tests := []struct {
+		name      string
+		sdp       string
+		priv, pub *sipAddr
+		// Private ports which are mapped before translation
+		mapped []uint16
+		result string
+	}{
+		{"SessionConnection", "v=0\r\n" +
+			"o=alice 2890844526 2890844526 IN IP4 10.0.0.1\r\n" +
+			"s=-\r\n" +
+			"c=IN IP4 10.0.0.1\r\n" +
+			"t=0 0\r\n" +
+			"m=audio 49170 RTP/AVP 0\r\n",
+			&priv, &pub, nil,
+			"v=0\r\n" +
+				"o=alice 2890844526 2890844526 IN IP4 192.0.2.1\r\n" +
+				"s=-\r\n" +
+				"c=IN IP4 192.0.2.1\r\n" +
+				"t=0 0\r\n" +
+				"m=audio 1024 RTP/AVP 0\r\n"},
+		{"MediaConnection", "v=0\n" +
+			"m=audio 49170/2 RTP/AVP 0\n" +
+			"c=IN IP4 10.0.0.1\n" +
+			"m=video 51372 RTP/AVP 31\n" +
+			"c=IN IP4 198.51.100.1\n",
+			&priv, &pub, nil,
+			"v=0\n" +
+				"m=audio 1024/2 RTP/AVP 0\n" +
+				"c=IN IP4 192.0.2.1\n" +
+				"m=video 51372 RTP/AVP 31\n" +
+				"c=IN IP4 198.51.100.1\n"},
+		{"ExplicitRTCP", "c=IN IP4 10.0.0.1\r\n" +
+			"m=audio 49170 RTP/AVP 0\r\n" +
+			"a=rtcp:53020 IN IP4 10.0.0.1\r\n",
+			&priv, &pub, nil,
+			"c=IN IP4 192.0.2.1\r\n" +
+				"m=audio 1024 RTP/AVP 0\r\n" +
+				"a=rtcp:1025 IN IP4 192.0.2.1\r\n"},
+		{"NonConsecutiveRTCP", "c=IN IP4 10.0.0.1\r\n" +
+			"m=audio 49170 RTP/AVP 0\r\n" +
+			"a=sendrecv\r\n",
+			&priv, &pub, []uint16{49171},
+			"c=IN IP4 192.0.2.1\r\n" +
+				"m=audio 1025 RTP/AVP 0\r\n" +
+				"a=rtcp:1024\r\n" +
+				"a=sendrecv\r\n"},
+		{"ExistingMapping", "c=IN IP4 10.0.0.1\r\n" +
+			"m=audio 49170 RTP/AVP 0\r\n",
+			&priv, &pub, []uint16{49170, 49171},
+			"c=IN IP4 192.0.2.1\r\n" +
+				"m=audio 1024 RTP/AVP 0\r\n"},
+		{"ForeignConnection", "o=bob 1 1 IN IP4 198.51.100.1\r\n" +
+			"c=IN IP4 198.51.100.1\r\n" +
+			"m=audio 49170 RTP/AVP 0\r\n",
+			&priv, &pub, nil,
+			"o=bob 1 1 IN IP4 198.51.100.1\r\n" +
+				"c=IN IP4 198.51.100.1\r\n" +
+				"m=audio 49170 RTP/AVP 0\r\n"},
+		{"DisabledStream", "c=IN IP4 10.0.0.1\r\n" +
+			"m=audio 0 RTP/AVP 0\r\n",
+			&priv, &pub, nil,
+			"c=IN IP4 192.0.2.1\r\n" +
+				"m=audio 0 RTP/AVP 0\r\n"},
+		{"IPv6", "c=IN IP6 fd00::1\r\n" +
+			"m=audio 49170 RTP/AVP 0\r\n",
+			&priv6, &pub6, nil,
+			"c=IN IP6 2001:db8::1\r\n" +
+				"m=audio 1024 RTP/AVP 0\r\n"},
+	}
+	for _, tt := range tests {
+		pp := newTestPortPair()
+		for _, port := range tt.mapped {
+			privEntry := makeTuple(false, tt.priv.v4addr, zeroIPv6Addr, port)
+			if _, _, _, _, err := pp.allocateConnection(false, types.UDPNumber, privEntry, 0, false); err != nil {
+				t.Fatal(err)
+			}
+		}
+		payload := []byte(tt.sdp)
+		msg := &sipMessage{
+			callID:  "a84b4c76e66710",
+			bodyEnd: len(payload),
+		}
+		edits := pp.translateSDP(msg, payload, 0, tt.priv, tt.pub, nil)
+		if result := testApplyEdits(payload, edits); result != tt.result {
+			t.Errorf("%s: translated to %q, expected %q", tt.name, result, tt.result)
+		}
+
+		// New media mappings are remembered in dialog
+		var media int
+		if v, found := pp.sipDialogs.Load(msg.callID); found {
+			media = len(v.(*sipDialog).media)
+		}
+		mapped := 0
+		pp.PublicPort.translationTable[types.UDPNumber].Range(func(k sessionKey, v *portMapEntry) bool {
+			mapped++
+			return true
+		})
+		if expected := mapped - len(tt.mapped); media != expected {
+			t.Errorf("%s: %d media in dialog, expected %d", tt.name, media, expected)
+		}
+	}
+}
@@ -0,0 +1,386 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/types"
+)
+
+// Single IPv4 or IPv6 address
+type hostAddr struct {
+	Addr4 types.IPv4Address
+	Addr6 types.IPv6Address
+	ipv6  bool
+}
+
+// Static one-to-one mapping of public address to private address. All
+// packets sent to public address are translated to private address
+// regardless of their protocol and ports, and all packets sent by
+// private host get public address as their source.
+type staticMapping struct {
+	Public  hostAddr `json:"public"`
+	Private hostAddr `json:"private"`
+}
+
+// UnmarshalJSON parses IPv4 or IPv6 address.
+func (out *hostAddr) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return errors.New("Bad IP address specified: " + s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		addr, err := convertIPv4(ip4)
+		if err != nil {
+			return err
+		}
+		*out = hostAddr{
+			Addr4: addr,
+		}
+	} else {
+		*out = hostAddr{
+			ipv6: true,
+		}
+		copy(out.Addr6[:], ip.To16())
+	}
+	return nil
+}
+
+func (a hostAddr) String() string {
+	if a.ipv6 {
+		return a.Addr6.String()
+	}
+	return StringIPv4Int(uint32(a.Addr4))
+}
+
+// Returns address as a key of static mappings table
+func (a hostAddr) key() interface{} {
+	if a.ipv6 {
+		return a.Addr6
+	}
+	return a.Addr4
+}
+
+func (sm *staticMapping) String() string {
+	return sm.Public.String() + "<->" + sm.Private.String()
+}
+
+// Checks whether address is a public address of static mapping
+func (port *ipPort) isStaticPublicAddr(addr types.IPv4Address) bool {
+	if port.Type != iPUBLIC {
+		return false
+	}
+	_, found := port.staticMappings.Load(addr)
+	return found
+}
+
+// Checks whether address is a public IPv6 address of static mapping
+func (port *ipPort) isStaticPublicAddr6(addr types.IPv6Address) bool {
+	if port.Type != iPUBLIC {
+		return false
+	}
+	_, found := port.staticMappings.Load(addr)
+	return found
+}
+
+// Checks whether address is a solicited-node multicast address of
+// public IPv6 address of static mapping
+func (port *ipPort) isStaticMulticast6(addr types.IPv6Address) bool {
+	_, found := port.staticMulticast6.Load(addr)
+	return found
+}
+
+// Adds static mapping to lookup tables of port pair after checking
+// that its addresses are not used. Should be executed under port pair
+// lock when translation is running.
+func (pp *portPair) enableStaticMapping(sm *staticMapping) error {
+	pub := &pp.PublicPort
+	priv := &pp.PrivatePort
+	if sm.Public.ipv6 != sm.Private.ipv6 {
+		return fmt.Errorf("Public and private addresses of static mapping %s should have the same IP version", sm.String())
+	}
+	if sm.Public.ipv6 {
+		if sm.Public.Addr6 == pub.Subnet6.Addr || pub.AddressPool.contains6(sm.Public.Addr6) {
+			return fmt.Errorf("Public address of static mapping %s should not be port %d own address or address pool entry", sm.String(), pub.Index)
+		}
+		if priv.Subnet6.addressAcquired && (sm.Private.Addr6 == priv.Subnet6.Addr || !priv.Subnet6.checkAddrWithingSubnet(sm.Private.Addr6)) {
+			return fmt.Errorf("Private address of static mapping %s should be within subnet %s", sm.String(), priv.Subnet6.String())
+		}
+	} else {
+		if sm.Public.Addr4 == pub.Subnet.Addr || pub.AddressPool.contains(sm.Public.Addr4) {
+			return fmt.Errorf("Public address of static mapping %s should not be port %d own address or address pool entry", sm.String(), pub.Index)
+		}
+		if priv.Subnet.addressAcquired && (sm.Private.Addr4 == priv.Subnet.Addr || !priv.Subnet.checkAddrWithingSubnet(sm.Private.Addr4)) {
+			return fmt.Errorf("Private address of static mapping %s should be within subnet %s", sm.String(), priv.Subnet.String())
+		}
+	}
+	if _, used := pub.staticMappings.Load(sm.Public.key()); used {
+		return fmt.Errorf("Public address %s already has static mapping", sm.Public.String())
+	}
+	if _, used := priv.staticMappings.Load(sm.Private.key()); used {
+		return fmt.Errorf("Private address %s already has static mapping", sm.Private.String())
+	}
+
+	pub.staticMappings.Store(sm.Public.key(), sm.Private.key())
+	priv.staticMappings.Store(sm.Private.key(), sm.Public.key())
+	if sm.Public.ipv6 {
+		var multicast types.IPv6Address
+		packet.CalculateIPv6MulticastAddrForDstIP(&multicast, sm.Public.Addr6)
+		pub.staticMulticast6.Store(multicast, true)
+	}
+	return nil
+}
+
+// Removes static mapping of public address from lookup tables of port
+// pair. Should be executed under port pair lock.
+func (pp *portPair) disableStaticMapping(public hostAddr) (hostAddr, error) {
+	pub := &pp.PublicPort
+	priv := &pp.PrivatePort
+	v, found := pub.staticMappings.Load(public.key())
+	if !found {
+		return hostAddr{}, fmt.Errorf("Public address %s has no static mapping", public.String())
+	}
+	pub.staticMappings.Delete(public.key())
+	priv.staticMappings.Delete(v)
+
+	if !public.ipv6 {
+		return hostAddr{
+			Addr4: v.(types.IPv4Address),
+		}, nil
+	}
+	// Solicited-node multicast address may be shared with other
+	// mappings
+	var multicast types.IPv6Address
+	packet.CalculateIPv6MulticastAddrForDstIP(&multicast, public.Addr6)
+	pub.staticMulticast6.Delete(multicast)
+	pub.staticMappings.Range(func(k, _ interface{}) bool {
+		if addr, ok := k.(types.IPv6Address); ok {
+			var m types.IPv6Address
+			packet.CalculateIPv6MulticastAddrForDstIP(&m, addr)
+			if m == multicast {
+				pub.staticMulticast6.Store(multicast, true)
+				return false
+			}
+		}
+		return true
+	})
+	return hostAddr{
+		Addr6: v.(types.IPv6Address),
+		ipv6:  true,
+	}, nil
+}
+
+// Stores MAC address of packet sender in ARP cache if it is not known
+func (port *ipPort) learnSourceMAC(pkt *packet.Packet, pktIPv4 *packet.IPv4Hdr, pktIPv6 *packet.IPv6Hdr) {
+	var src interface{}
+	if pktIPv6 != nil {
+		src = pktIPv6.SrcAddr
+	} else {
+		src = packet.SwapBytesIPv4Addr(pktIPv4.SrcAddr)
+	}
+	if _, known := port.arpTable.Load(src); !known {
+		port.arpTable.Store(src, pkt.Ether.SAddr)
+	}
+}
+
+// Translates addresses of original packet embedded into ICMP error
+// message using static mappings tables. Only embedded packets which
+// can be parsed are translated.
+func translateStaticEmbedded(pkt *packet.Packet, ipv6 bool, srcTable, dstTable *sync.Map) {
+	emb, ok := parseICMPEmbedded(pkt, ipv6)
+	if !ok {
+		return
+	}
+	for _, src := range []bool{true, false} {
+		table := dstTable
+		if src {
+			table = srcTable
+		}
+		if table == nil {
+			continue
+		}
+		v4addr, v6addr, _ := emb.getAddrPort(src)
+		var v interface{}
+		var found bool
+		if ipv6 {
+			v, found = table.Load(v6addr)
+		} else {
+			v, found = table.Load(v4addr)
+		}
+		if !found {
+			continue
+		}
+		if ipv6 {
+			emb.setAddr(src, 0, v.(types.IPv6Address))
+		} else {
+			emb.setAddr(src, v.(types.IPv4Address), zeroIPv6Addr)
+		}
+	}
+}
+
+// Changes source or destination address of packet according to
+// static mapping. ICMP error messages are checksummed again because
+// original packet embedded into them is changed too.
+func setStaticAddr(pkt *packet.Packet, pktIPv4 *packet.IPv4Hdr, pktIPv6 *packet.IPv6Hdr, protocol uint8,
+	pktTCP *packet.TCPHdr, pktUDP *packet.UDPHdr, pktICMP *packet.ICMPHdr, src, icmpError bool, v interface{}) {
+	if icmpError {
+		if pktIPv6 != nil {
+			if src {
+				pktIPv6.SrcAddr = v.(types.IPv6Address)
+			} else {
+				pktIPv6.DstAddr = v.(types.IPv6Address)
+			}
+		} else {
+			if src {
+				pktIPv4.SrcAddr = packet.SwapBytesIPv4Addr(v.(types.IPv4Address))
+			} else {
+				pktIPv4.DstAddr = packet.SwapBytesIPv4Addr(v.(types.IPv4Address))
+			}
+		}
+		setICMPChecksum(pkt, pktIPv6 != nil)
+		return
+	}
+	part := newFirstFragment(pktIPv4, pktIPv6, protocol, pktTCP, pktUDP, pktICMP)
+	if pktIPv6 != nil {
+		part.setAddr(src, 0, v.(types.IPv6Address))
+	} else {
+		part.setAddr(src, v.(types.IPv4Address), zeroIPv6Addr)
+	}
+}
+
+// Translates packet sent to public address of static mapping. Returns
+// false if destination address has no static mapping.
+func (pp *portPair) translateStaticPub2Pri(pkt *packet.Packet, pktVLAN *packet.VLANHdr, pktIPv4 *packet.IPv4Hdr, pktIPv6 *packet.IPv6Hdr, protocol uint8,
+	pktTCP *packet.TCPHdr, pktUDP *packet.UDPHdr, pktICMP *packet.ICMPHdr, fragmented bool) (uint, bool) {
+	port := &pp.PublicPort
+	ipv6 := pktIPv6 != nil
+	var v interface{}
+	var found bool
+	if ipv6 {
+		v, found = port.staticMappings.Load(pktIPv6.DstAddr)
+	} else {
+		v, found = port.staticMappings.Load(packet.SwapBytesIPv4Addr(pktIPv4.DstAddr))
+	}
+	if !found || isNDMessage(protocol, pktICMP) {
+		return dropResult(dropNoSession), false
+	}
+
+	// Find corresponding MAC address
+	port.learnSourceMAC(pkt, pktIPv4, pktIPv6)
+	var mac types.MACAddress
+	if ipv6 {
+		mac, found = port.opposite.getMACForIPv6(v.(types.IPv6Address))
+	} else {
+		mac, found = port.opposite.getMACForIPv4(v.(types.IPv4Address))
+	}
+	if !found {
+		return port.dropPacket(pkt, dropNeighborUnresolved), true
+	}
+
+	// Do packet translation. Original packet embedded into ICMP
+	// error was sent by private host.
+	pkt.Ether.DAddr = mac
+	pkt.Ether.SAddr = port.opposite.SrcMACAddress
+	if pktVLAN != nil {
+		pktVLAN.SetVLANTagIdentifier(port.opposite.Vlan)
+	}
+	icmpError := pktICMP != nil && !fragmented && isICMPError(protocol, pktICMP)
+	if icmpError {
+		translateStaticEmbedded(pkt, ipv6, &port.staticMappings, nil)
+	}
+	setStaticAddr(pkt, pktIPv4, pktIPv6, protocol, pktTCP, pktUDP, pktICMP, false, icmpError, v)
+
+	port.opposite.dumpPacket(pkt, DirSEND)
+	return DirSEND, true
+}
+
+// Translates packet sent by private host which has static
+// mapping. Packets sent to public addresses of other static mappings
+// are translated back to private network. Returns false if source
+// address has no static mapping or packet is directed to port pair
+// itself.
+func (pp *portPair) translateStaticPri2Pub(pkt *packet.Packet, pktVLAN *packet.VLANHdr, pktIPv4 *packet.IPv4Hdr, pktIPv6 *packet.IPv6Hdr, protocol uint8,
+	pktTCP *packet.TCPHdr, pktUDP *packet.UDPHdr, pktICMP *packet.ICMPHdr, fragmented bool) (uint, bool) {
+	port := &pp.PrivatePort
+	ipv6 := pktIPv6 != nil
+	var v, dst interface{}
+	var found, local bool
+	if ipv6 {
+		v, found = port.staticMappings.Load(pktIPv6.SrcAddr)
+		dst = pktIPv6.DstAddr
+		local = pktIPv6.DstAddr == port.Subnet6.Addr ||
+			pktIPv6.DstAddr == port.Subnet6.llAddr ||
+			pktIPv6.DstAddr[0] == 0xff
+	} else {
+		v, found = port.staticMappings.Load(packet.SwapBytesIPv4Addr(pktIPv4.SrcAddr))
+		addr := packet.SwapBytesIPv4Addr(pktIPv4.DstAddr)
+		dst = addr
+		local = addr == port.Subnet.Addr || addr>>28 == 0xe || addr == types.IPv4Address(0xffffffff)
+	}
+	if !found || local || isNDMessage(protocol, pktICMP) {
+		return dropResult(dropNoSession), false
+	}
+	port.learnSourceMAC(pkt, pktIPv4, pktIPv6)
+	icmpError := pktICMP != nil && !fragmented && isICMPError(protocol, pktICMP)
+
+	// Hairpinning between hosts with static mappings
+	if pv, hairpin := port.opposite.staticMappings.Load(dst); hairpin {
+		var mac types.MACAddress
+		if ipv6 {
+			mac, found = port.getMACForIPv6(pv.(types.IPv6Address))
+		} else {
+			mac, found = port.getMACForIPv4(pv.(types.IPv4Address))
+		}
+		if !found {
+			return port.dropPacket(pkt, dropNeighborUnresolved), true
+		}
+		pkt.Ether.DAddr = mac
+		pkt.Ether.SAddr = port.SrcMACAddress
+		if icmpError {
+			translateStaticEmbedded(pkt, ipv6, &port.opposite.staticMappings, &port.staticMappings)
+		}
+		setStaticAddr(pkt, pktIPv4, pktIPv6, protocol, pktTCP, pktUDP, pktICMP, true, icmpError, v)
+		setStaticAddr(pkt, pktIPv4, pktIPv6, protocol, pktTCP, pktUDP, pktICMP, false, icmpError, pv)
+		port.dumpPacket(pkt, DirSEND)
+		return dirHairpin, true
+	}
+
+	// Find corresponding MAC address
+	var mac types.MACAddress
+	if ipv6 {
+		mac, found = port.opposite.getMACForIPv6(pktIPv6.DstAddr)
+	} else {
+		mac, found = port.opposite.getMACForIPv4(packet.SwapBytesIPv4Addr(pktIPv4.DstAddr))
+	}
+	if !found {
+		return port.dropPacket(pkt, dropNeighborUnresolved), true
+	}
+
+	// Do packet translation. Original packet embedded into ICMP
+	// error was sent to private host.
+	pkt.Ether.DAddr = mac
+	pkt.Ether.SAddr = port.opposite.SrcMACAddress
+	if pktVLAN != nil {
+		pktVLAN.SetVLANTagIdentifier(port.opposite.Vlan)
+	}
+	if icmpError {
+		translateStaticEmbedded(pkt, ipv6, nil, &port.staticMappings)
+	}
+	setStaticAddr(pkt, pktIPv4, pktIPv6, protocol, pktTCP, pktUDP, pktICMP, true, icmpError, v)
+
+	port.opposite.dumpPacket(pkt, DirSEND)
+	return DirSEND, true
+}
@@ -0,0 +1,172 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"strconv"
+	"sync/atomic"
+
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/types"
+)
+
+// TCP connection states of a translation session. They follow Linux
+// conntrack TCP states.
+type tcpState uint8
+
+const (
+	tcpNone tcpState = iota
+	tcpSynSent
+	tcpSynRecv
+	tcpEstablished
+	tcpFinWait
+	tcpCloseWait
+	tcpLastAck
+	tcpTimeWait
+	tcpClose
+	tcpStateCount
+
+	// Maximum distance between RST sequence number and last
+	// acknowledgement number seen from the opposite direction which
+	// is considered valid. It covers usual amount of data in flight
+	// without parsing window scale options.
+	maxTCPRSTWindow = 1 << 20
+)
+
+var tcpStateNames = [tcpStateCount]string{
+	tcpNone:        "NONE",
+	tcpSynSent:     "SYN_SENT",
+	tcpSynRecv:     "SYN_RECV",
+	tcpEstablished: "ESTABLISHED",
+	tcpFinWait:     "FIN_WAIT",
+	tcpCloseWait:   "CLOSE_WAIT",
+	tcpLastAck:     "LAST_ACK",
+	tcpTimeWait:    "TIME_WAIT",
+	tcpClose:       "CLOSE",
+}
+
+func (s tcpState) String() string {
+	if s < tcpStateCount {
+		return tcpStateNames[s]
+	}
+	return "unknown(" + strconv.Itoa(int(s)) + ")"
+}
+
+func packTCPStatus(state tcpState, finDirection trafficDirection) uint32 {
+	return uint32(state) | uint32(finDirection)<<8
+}
+
+func unpackTCPStatus(status uint32) (tcpState, trafficDirection) {
+	return tcpState(status), trafficDirection(status >> 8)
+}
+
+// Returns TCP connection state of session.
+func (pme *portMapEntry) getTCPState() tcpState {
+	state, _ := unpackTCPStatus(atomic.LoadUint32(&pme.tcpStatus))
+	return state
+}
+
+// Calculates connection state after a segment with given flags was
+// seen in direction dir in connection state with given direction of
+// the first FIN segment. Dynamic translation sessions are always
+// opened from private network, so pri2pub is the original direction
+// of connection.
+func (pme *portMapEntry) nextTCPState(hdr *packet.TCPHdr, dir trafficDirection, state tcpState, finDirection trafficDirection) tcpState {
+	flags := hdr.TCPFlags
+
+	if flags&types.TCPFlagRst != 0 {
+		if pme.rstAcceptable(hdr, dir) {
+			return tcpClose
+		}
+		return state
+	}
+
+	if flags&types.TCPFlagSyn != 0 {
+		if flags&types.TCPFlagAck == 0 {
+			// New connection or reopening of a closed one
+			if dir == pri2pub && (state == tcpNone || state == tcpTimeWait || state == tcpClose) {
+				return tcpSynSent
+			}
+		} else if dir == pub2pri && state == tcpSynSent {
+			return tcpSynRecv
+		}
+		return state
+	}
+
+	if flags&types.TCPFlagFin != 0 {
+		switch state {
+		case tcpSynRecv, tcpEstablished:
+			return tcpFinWait
+		case tcpFinWait, tcpCloseWait:
+			if dir != finDirection {
+				return tcpLastAck
+			}
+		}
+		return state
+	}
+
+	if flags&types.TCPFlagAck != 0 {
+		switch state {
+		case tcpNone:
+			// Connection which was opened before session was
+			// created is picked up only when remote side answers
+			// it. Unsolicited ACK from private network doesn't
+			// make session established.
+			if dir == pub2pri {
+				return tcpEstablished
+			}
+		case tcpSynRecv:
+			if dir == pri2pub {
+				return tcpEstablished
+			}
+		case tcpFinWait:
+			if dir != finDirection {
+				return tcpCloseWait
+			}
+		case tcpLastAck:
+			if dir == finDirection {
+				return tcpTimeWait
+			}
+		}
+	}
+	return state
+}
+
+// Checks that RST segment sequence number is close to what opposite
+// side of connection has acknowledged last. This prevents stray RST
+// segments from tearing down a session.
+func (pme *portMapEntry) rstAcceptable(hdr *packet.TCPHdr, dir trafficDirection) bool {
+	opposite := dir.opposite()
+	if atomic.LoadUint32(&pme.tcpAckSeen[opposite]) == 0 {
+		return true
+	}
+	seq := packet.SwapBytesUint32(hdr.SentSeq)
+	return seq-atomic.LoadUint32(&pme.tcpAck[opposite]) < maxTCPRSTWindow
+}
+
+// Tracks TCP connection state of a dynamic translation session. New
+// state is calculated from the one it replaces, so state is retried
+// if it was changed meanwhile by handler of another direction.
+func (pp *portPair) trackTCPState(hdr *packet.TCPHdr, pme *portMapEntry, dir trafficDirection) {
+	for {
+		status := atomic.LoadUint32(&pme.tcpStatus)
+		state, finDirection := unpackTCPStatus(status)
+		next := pme.nextTCPState(hdr, dir, state, finDirection)
+		if next == state {
+			break
+		}
+		if next == tcpFinWait && state != tcpFinWait {
+			finDirection = dir
+		}
+		if atomic.CompareAndSwapUint32(&pme.tcpStatus, status, packTCPStatus(next, finDirection)) {
+			break
+		}
+	}
+
+	if hdr.TCPFlags&types.TCPFlagAck != 0 {
+		atomic.StoreUint32(&pme.tcpAck[dir], packet.SwapBytesUint32(hdr.RecvAck))
+		atomic.StoreUint32(&pme.tcpAckSeen[dir], 1)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/intel-go/nff-go/packet"
+	"github.com/intel-go/nff-go/types"
+)
+
+// TCP segment seen by translation session
+type testSegment struct {
+	dir   trafficDirection
+	flags types.TCPFlags
+	seq   uint32
+	ack   uint32
+}
+
+const (
+	testSyn    = types.TCPFlagSyn
+	testSynAck = types.TCPFlagSyn | types.TCPFlagAck
+	testAck    = types.TCPFlagAck
+	testFin    = types.TCPFlagFin | types.TCPFlagAck
+	testRst    = types.TCPFlagRst
+)
+
+// Returns translation session and function which tracks TCP segments
+// seen by it.
+func newTCPTestSession() (*portMapEntry, func(hdr *packet.TCPHdr, dir trafficDirection)) {
+	const port = portStart
+	pp := &portPair{}
+	pp.PublicPort.allocatePublicPortPortMap()
+	pme := &pp.getPublicPortPortmap(false, 0, types.TCPNumber)[port]
+	return pme, func(hdr *packet.TCPHdr, dir trafficDirection) {
+		pp.trackTCPState(hdr, pme, dir)
+	}
+}
+
+func TestTrackTCPState(t *testing.T) {
+	established := []testSegment{
+		{pri2pub, testSyn, 1000, 0},
+		{pub2pri, testSynAck, 5000, 1001},
+		{pri2pub, testAck, 1001, 5001},
+	}
+	tests := []struct {
+		name     string
+		segments []testSegment
+		state    tcpState
+	}{
+		{"SynSent", established[:1], tcpSynSent},
+		{"SynRecv", established[:2], tcpSynRecv},
+		{"Established", established, tcpEstablished},
+		{"InboundSyn", []testSegment{{pub2pri, testSyn, 5000, 0}}, tcpNone},
+		{"PrivateAckNotPickedUp", []testSegment{{pri2pub, testAck, 1000, 5000}}, tcpNone},
+		{"PublicAckPickedUp", []testSegment{{pub2pri, testAck, 5000, 1000}}, tcpEstablished},
+		{"FinWait", append(established[:3:3],
+			testSegment{pri2pub, testFin, 1001, 5001}), tcpFinWait},
+		{"FinAckedSameDirection", append(established[:3:3],
+			testSegment{pri2pub, testFin, 1001, 5001},
+			testSegment{pri2pub, testAck, 1002, 5001}), tcpFinWait},
+		{"CloseWait", append(established[:3:3],
+			testSegment{pri2pub, testFin, 1001, 5001},
+			testSegment{pub2pri, testAck, 5001, 1002}), tcpCloseWait},
+		{"LastAck", append(established[:3:3],
+			testSegment{pri2pub, testFin, 1001, 5001},
+			testSegment{pub2pri, testAck, 5001, 1002},
+			testSegment{pub2pri, testFin, 5001, 1002}), tcpLastAck},
+		{"TimeWait", append(established[:3:3],
+			testSegment{pri2pub, testFin, 1001, 5001},
+			testSegment{pub2pri, testAck, 5001, 1002},
+			testSegment{pub2pri, testFin, 5001, 1002},
+			testSegment{pri2pub, testAck, 1002, 5002}), tcpTimeWait},
+		{"SimultaneousClose", append(established[:3:3],
+			testSegment{pub2pri, testFin, 5001, 1001},
+			testSegment{pri2pub, testFin, 1001, 5002},
+			testSegment{pub2pri, testAck, 5002, 1002}), tcpTimeWait},
+		{"RstInWindow", append(established[:3:3],
+			testSegment{pub2pri, testRst, 5001 + 100, 0}), tcpClose},
+		{"RstOutOfWindow", append(established[:3:3],
+			testSegment{pub2pri, testRst, 5001 + maxTCPRSTWindow, 0}), tcpEstablished},
+		{"RstBeforeAck", []testSegment{
+			{pri2pub, testSyn, 1000, 0},
+			{pub2pri, testRst, 12345, 0}}, tcpClose},
+		{"ReopenAfterRst", append(established[:3:3],
+			testSegment{pri2pub, testRst, 1001, 0},
+			testSegment{pri2pub, testSyn, 3000, 0}), tcpSynSent},
+	}
+	for _, tt := range tests {
+		pme, track := newTCPTestSession()
+		for _, s := range tt.segments {
+			hdr := &packet.TCPHdr{
+				TCPFlags: s.flags,
+				SentSeq:  packet.SwapBytesUint32(s.seq),
+				RecvAck:  packet.SwapBytesUint32(s.ack),
+			}
+			track(hdr, s.dir)
+		}
+		if state := pme.getTCPState(); state != tt.state {
+			t.Errorf("%s: state is %v, expected %v", tt.name, state, tt.state)
+		}
+	}
+}
+
+func TestTCPTimeout(t *testing.T) {
+	timeouts := sessionTimeouts{
+		TCPEstablished: 2 * time.Hour,
+		TCPTransitory:  4 * time.Minute,
+	}
+	timeouts.TCPStates[tcpTimeWait] = 2 * time.Minute
+	tests := []struct {
+		state   tcpState
+		timeout time.Duration
+	}{
+		{tcpEstablished, 2 * time.Hour},
+		{tcpSynSent, 4 * time.Minute},
+		{tcpFinWait, 4 * time.Minute},
+		{tcpTimeWait, 2 * time.Minute},
+		{tcpStateCount, 4 * time.Minute},
+	}
+	for _, tt := range tests {
+		if timeout := timeouts.tcpTimeout(tt.state); timeout != tt.timeout {
+			t.Errorf("%v: timeout is %v, expected %v", tt.state, timeout, tt.timeout)
+		}
+	}
+}
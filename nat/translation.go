@@ -5,6 +5,8 @@
 package nat
 
 import (
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/intel-go/nff-go/flow"
@@ -12,62 +14,128 @@ import (
 	"github.com/intel-go/nff-go/types"
 )
 
-// Tuple is a pair of address and port.
-type Tuple struct {
-	addr types.IPv4Address
-	port uint16
+// Adds remote endpoint to private side lookup key according to
+// mapping behavior. ICMP has no remote port so only remote address is
+// used for it.
+func addRemoteToKey(key sessionKey, behavior natBehavior, protocol uint8, v4addr types.IPv4Address, v6addr types.IPv6Address, port uint16) sessionKey {
+	if behavior == endpointIndependent {
+		return key
+	}
+	if behavior == addressDependent || protocol == types.ICMPNumber || protocol == types.ICMPv6Number {
+		port = 0
+	}
+	if key.ipv6() {
+		key.remoteAddr6 = v6addr
+	} else {
+		key.remoteAddr = v4addr
+	}
+	key.remotePort = port
+	return key
 }
 
-type Tuple6 struct {
-	addr types.IPv6Address
-	port uint16
+// Creates a remote endpoint key which is used to check inbound
+// packets according to filtering behavior.
+func makeRemoteKey(behavior natBehavior, ipv6 bool, protocol uint8, v4addr types.IPv4Address, v6addr types.IPv6Address, port uint16) sessionKey {
+	if behavior == addressDependent || protocol == types.ICMPNumber || protocol == types.ICMPv6Number {
+		port = 0
+	}
+	return makeTuple(ipv6, v4addr, v6addr, port)
 }
 
-func (pp *portPair) allocateNewEgressConnection(ipv6 bool, protocol uint8, privEntry interface{}) (types.IPv4Address, types.IPv6Address, uint16, error) {
-	pp.mutex.Lock()
+func (pp *portPair) allocateNewEgressConnection(ipv6 bool, protocol uint8, privEntry sessionKey) (types.IPv4Address, types.IPv6Address, uint16, int, error) {
+	return pp.allocateConnection(ipv6, protocol, privEntry, -1, pp.FilteringBehavior != endpointIndependent)
+}
 
-	port, err := pp.allocNewPort(ipv6, protocol)
+// Allocates public address and port for private endpoint. Public
+// address is selected from address pool unless its index is given.
+// Port blocks of IPv4 subscribers always define public address. Port
+// is allocated and session is added to lookup tables under port lock
+// of public address and protocol. Session accepts inbound packets
+// only from remote endpoints it has sent packets to if filter is
+// true.
+func (pp *portPair) allocateConnection(ipv6 bool, protocol uint8, privEntry sessionKey, poolIndex int, filter bool) (types.IPv4Address, types.IPv6Address, uint16, int, error) {
+	err := pp.acquireSession(protocol, privEntry)
 	if err != nil {
-		pp.mutex.Unlock()
-		return 0, types.IPv6Address{}, 0, err
+		return 0, types.IPv6Address{}, 0, 0, err
 	}
 
-	var pubEntry interface{}
-	var v4addr types.IPv4Address
-	var v6addr types.IPv6Address
-	if ipv6 {
-		v6addr = pp.PublicPort.Subnet6.Addr
-		pubEntry = Tuple6{
-			addr: v6addr,
-			port: uint16(port),
-		}
+	// Port blocks belong to IPv4 subscribers. NAT64 is not allowed
+	// together with port blocks, so their owners are always known.
+	var index, port int
+	if privEntry.kind == sessionKeyTuple && pp.PublicPort.PortBlocks.enabled() {
+		index, port, err = pp.allocBlockPort(protocol, privEntry.addr, privEntry.port)
 	} else {
-		v4addr = pp.PublicPort.Subnet.Addr
-		pubEntry = Tuple{
-			addr: v4addr,
-			port: uint16(port),
+		index = poolIndex
+		if index < 0 {
+			index = pp.PublicPort.selectPoolIndex(ipv6, privEntry)
+		}
+		mutex := pp.PublicPort.portLock(ipv6, index, protocol)
+		mutex.Lock()
+		port, err = pp.allocNewPort(ipv6, protocol, index, privEntry.port)
+		if err != nil {
+			mutex.Unlock()
+			if !ipv6 {
+				addr, _ := pp.PublicPort.getPoolIndexAddr(false, index)
+				logPortsExhausted(protocol, addr)
+			}
 		}
 	}
+	if err != nil {
+		pp.releaseSession(protocol, privEntry)
+		atomic.AddUint64(&pp.allocFailures, 1)
+		return 0, types.IPv6Address{}, 0, 0, err
+	}
+	// Port lock was taken by allocation
+	defer pp.PublicPort.portLock(ipv6, index, protocol).Unlock()
 
-	pp.getPublicPortPortmap(ipv6, protocol)[port] = portMapEntry{
-		lastused:             time.Now(),
-		finCount:             0,
-		terminationDirection: 0,
-		static:               false,
+	v4addr, v6addr := pp.PublicPort.getPoolIndexAddr(ipv6, index)
+	pubEntry := makeTuple(ipv6, v4addr, v6addr, uint16(port))
+
+	var extra *sessionExtra
+	if filter {
+		extra = &sessionExtra{
+			remotes: new(sync.Map),
+		}
+	}
+	now := time.Now()
+	pme := &pp.getPublicPortPortmap(ipv6, index, protocol)[port]
+	*pme = portMapEntry{
+		pub:      pubEntry,
+		priv:     privEntry,
+		created:  now,
+		lastused: now.UnixNano(),
+		static:   false,
+		extra:    extra,
 	}
 
 	// Add lookup entries for packet translation
-	pp.PublicPort.translationTable[protocol].Store(pubEntry, privEntry)
-	pp.PrivatePort.translationTable[protocol].Store(privEntry, pubEntry)
-
-	pp.mutex.Unlock()
-	return v4addr, v6addr, uint16(port), nil
+	if !pp.PublicPort.translationTable[protocol].Store(pubEntry, pme) {
+		pp.releaseSession(protocol, privEntry)
+		pp.deleteOldConnection(ipv6, protocol, index, port)
+		atomic.AddUint64(&pp.allocFailures, 1)
+		return 0, types.IPv6Address{}, 0, 0, errSessionTableFull
+	}
+	if !pp.PrivatePort.translationTable[protocol].Store(privEntry, pme) {
+		// Session creation was not logged, so port is freed without
+		// logging session deletion
+		pp.PublicPort.translationTable[protocol].Delete(pubEntry)
+		pp.releaseSession(protocol, privEntry)
+		pp.deleteOldConnection(ipv6, protocol, index, port)
+		atomic.AddUint64(&pp.allocFailures, 1)
+		return 0, types.IPv6Address{}, 0, 0, errSessionTableFull
+	}
+	pp.logSessionEvent(true, now, now, protocol, privEntry, pubEntry)
+	return v4addr, v6addr, uint16(port), index, nil
 }
 
 // PublicToPrivateTranslation does ingress translation.
 func PublicToPrivateTranslation(pkt *packet.Packet, ctx flow.UserContext) uint {
 	pi := ctx.(pairIndex)
 	pp := &Natconfig.PortPairs[pi.index]
+	return pi.counters.count(pub2pri, pp.translatePublicPacket(pkt))
+}
+
+func (pp *portPair) translatePublicPacket(pkt *packet.Packet) uint {
 	port := &pp.PublicPort
 
 	port.dumpPacket(pkt, DirSEND)
@@ -78,28 +146,68 @@ func PublicToPrivateTranslation(pkt *packet.Packet, ctx flow.UserContext) uint {
 		return dir
 	}
 
-	protocol, pktTCP, pktUDP, pktICMP, _, DstPort := ParseAllKnownL4(pkt, pktIPv4, pktIPv6)
+	// Fragments other than first are translated in the same way as
+	// first fragment of their packet
+	frag, fragmented := parseFragment(pktIPv4, pktIPv6)
+	if fragmented && !frag.first {
+		return port.translateFragment(pkt, &frag, pktVLAN, pktIPv4, pktIPv6)
+	}
+	dir = pp.publicToPrivateTranslation(pkt, pktVLAN, pktIPv4, pktIPv6, fragmented)
+	if fragmented {
+		port.saveFragmentTranslation(pkt, &frag, dir, pktIPv4, pktIPv6)
+	}
+	return dir
+}
+
+func (pp *portPair) publicToPrivateTranslation(pkt *packet.Packet, pktVLAN *packet.VLANHdr, pktIPv4 *packet.IPv4Hdr, pktIPv6 *packet.IPv6Hdr, fragmented bool) uint {
+	port := &pp.PublicPort
+
+	protocol, pktTCP, pktUDP, pktICMP, SrcPort, DstPort := ParseAllKnownL4(pkt, pktIPv4, pktIPv6)
+	if protocol == udpLiteNumber && !checkUDPLiteCoverage(pkt, pktIPv6 != nil, fragmented) {
+		return port.dropPacket(pkt, dropMalformed)
+	}
+	// NPTv6 translates packets of all protocols without sessions
+	if pktIPv6 != nil && pp.IPv6Translation == ipv6NPT {
+		if dir, handled := pp.translateNPTv6Pub2Pri(pkt, pktVLAN, pktIPv6, protocol, pktICMP); handled {
+			return dir
+		}
+	}
+	// Static mappings translate packets of all protocols
+	if dir, handled := pp.translateStaticPub2Pri(pkt, pktVLAN, pktIPv4, pktIPv6, protocol, pktTCP, pktUDP, pktICMP, fragmented); handled {
+		return dir
+	}
 	if protocol == 0 {
-		// Only TCP, UDP and ICMP are supported now, all other protocols are ignored
-		port.dumpPacket(pkt, DirDROP)
-		return DirDROP
+		// Protocols which are not supported are ignored
+		return port.dropPacket(pkt, dropUnsupportedProtocol)
+	}
+	// SCTP checksum covers the whole packet, so fragmented SCTP
+	// packets cannot be translated
+	if protocol == sctpNumber && fragmented {
+		return port.dropPacket(pkt, dropFragment)
+	}
+	// Protocols without ports are translated by addresses only
+	if isAddrOnlyProtocol(protocol) {
+		return pp.translateAddrOnlyPub2Pri(pkt, pktVLAN, pktIPv4, pktIPv6, protocol)
 	}
 	portNumber := DstPort
 	// Create a lookup key from packet destination address and port
-	var pub2priKey interface{}
+	var pub2priKey sessionKey
+	var poolIndex int
+	var inPool bool
 	if pktIPv4 != nil {
-		pub2priKey = Tuple{
-			addr: packet.SwapBytesIPv4Addr(pktIPv4.DstAddr),
-			port: portNumber,
-		}
+		pub2priKey = makeTuple(false, packet.SwapBytesIPv4Addr(pktIPv4.DstAddr), zeroIPv6Addr, portNumber)
+		poolIndex, inPool = port.getPoolIndex(false, packet.SwapBytesIPv4Addr(pktIPv4.DstAddr), zeroIPv6Addr)
 	} else {
-		pub2priKey = Tuple6{
-			addr: pktIPv6.DstAddr,
-			port: portNumber,
-		}
+		pub2priKey = makeTuple(true, 0, pktIPv6.DstAddr, portNumber)
+		poolIndex, inPool = port.getPoolIndex(true, 0, pktIPv6.DstAddr)
 	}
-	// Check for ICMP traffic first
-	if pktICMP != nil {
+	// Check for ICMP traffic first. Error messages belong to
+	// sessions of packets embedded into them. Fragmented ICMP
+	// messages are not handled locally.
+	if pktICMP != nil && !fragmented && isICMPError(protocol, pktICMP) {
+		return pp.translateICMPErrorPub2Pri(pkt, pktVLAN, pktIPv4, pktIPv6)
+	}
+	if pktICMP != nil && !fragmented {
 		dir := port.handleICMP(protocol, pkt, pub2priKey)
 		if dir != DirSEND {
 			port.dumpPacket(pkt, dir)
@@ -108,7 +216,7 @@ func PublicToPrivateTranslation(pkt *packet.Packet, ctx flow.UserContext) uint {
 	}
 	ipv6 := pktIPv6 != nil
 	// Check for DHCP traffic. We need to get an address if it not set yet
-	if pktUDP != nil {
+	if protocol == types.UDPNumber && !fragmented {
 		var handled bool
 		if ipv6 {
 			handled = port.handleDHCPv6(pkt)
@@ -116,16 +224,15 @@ func PublicToPrivateTranslation(pkt *packet.Packet, ctx flow.UserContext) uint {
 			handled = port.handleDHCP(pkt)
 		}
 		if handled {
-			port.dumpPacket(pkt, DirDROP)
-			return DirDROP
+			return port.dropPacket(pkt, dropHandledLocally)
 		}
 	}
 
 	// Do lookup
-	v, found := port.translationTable[protocol].Load(pub2priKey)
+	pme, found := port.translationTable[protocol].Load(pub2priKey)
 	kniPresent := port.KNIName != ""
 
-	if !found {
+	if !found || !inPool {
 		// Store new local network entry in ARP cache
 		var addressAcquired bool
 		if ipv6 {
@@ -142,34 +249,60 @@ func PublicToPrivateTranslation(pkt *packet.Packet, ctx flow.UserContext) uint {
 		// incoming packet is ignored unless there is a KNI
 		// interface. If KNI is present and its IP address is known,
 		// traffic is directed there.
+		dir := dropResult(dropNoSession)
 		if kniPresent && addressAcquired {
 			dir = DirKNI
-		} else {
-			dir = DirDROP
 		}
 		port.dumpPacket(pkt, dir)
 		return dir
 	}
-	v4addr, v6addr, newPort, zeroAddr := getAddrFromTuple(v, ipv6)
+	v4addr, v6addr, newPort, zeroAddr := getAddrFromTuple(pme.priv)
+
+	// Check inbound filtering. Packets are accepted only from
+	// remote endpoints which private host has sent packets to.
+	if pme.getRemotes() != nil && !pme.static {
+		var remoteKey sessionKey
+		if ipv6 {
+			remoteKey = makeRemoteKey(pp.FilteringBehavior, true, protocol, 0, pktIPv6.SrcAddr, SrcPort)
+		} else {
+			remoteKey = makeRemoteKey(pp.FilteringBehavior, false, protocol, packet.SwapBytesIPv4Addr(pktIPv4.SrcAddr), zeroIPv6Addr, SrcPort)
+		}
+		if !pme.remoteAllowed(remoteKey) {
+			return port.dropPacket(pkt, dropFiltered)
+		}
+	}
 
-	portmap := port.getPortmap(ipv6, protocol)
 	// Check whether connection is too old
-	if portmap[portNumber].static || time.Since(portmap[portNumber].lastused) <= connectionTimeout {
-		portmap[portNumber].lastused = time.Now()
+	if pme.static || !pme.expired(protocol) {
+		pme.touch()
 	} else {
 		// There was no transfer on this port for too long
 		// time. We don't allow it any more
-		pp.mutex.Lock()
-		pp.deleteOldConnection(pktIPv6 != nil, protocol, int(portNumber))
-		pp.mutex.Unlock()
-		port.dumpPacket(pkt, DirDROP)
-		return DirDROP
+		pp.deleteConnection(pktIPv6 != nil, protocol, poolIndex, int(portNumber))
+		return port.dropPacket(pkt, dropSessionExpired)
 	}
 
 	if !zeroAddr {
-		// Check whether TCP connection could be reused
-		if pktTCP != nil && !portmap[portNumber].static {
-			pp.checkTCPTermination(ipv6, pktTCP, int(portNumber), pub2pri)
+		// Track TCP connection state
+		if pktTCP != nil && !pme.static {
+			pp.trackTCPState(pktTCP, pme, pub2pri)
+		}
+
+		// NAT64 sessions translate packets to IPv6
+		if pme.priv.isNAT64() {
+			return pp.translateNAT64Pub2Pri(pkt, pktVLAN, pktIPv4, pktTCP, pktUDP, pktICMP, v6addr, newPort, fragmented)
+		}
+
+		// Acknowledgements of FTP control connection payload
+		// changed by FTP ALG are adjusted
+		if pp.FTPALG && pktTCP != nil && !fragmented && (SrcPort == ftpControlPort || DstPort == ftpControlPort) {
+			pp.translateFTPPub2Pri(pktIPv4, pktIPv6, pktTCP, pme)
+		}
+
+		// SIP ALG translates public signalling addresses back to
+		// private ones
+		if pp.SIPALG && (pktTCP != nil || protocol == types.UDPNumber) && !fragmented && (SrcPort == sipPort || DstPort == sipPort) {
+			pp.translateSIPPub2Pri(pkt, pktIPv4, pktIPv6, pktTCP, pktUDP, pme, poolIndex, DstPort, v4addr, v6addr, newPort)
 		}
 
 		// Find corresponding MAC address
@@ -181,8 +314,7 @@ func PublicToPrivateTranslation(pkt *packet.Packet, ctx flow.UserContext) uint {
 			mac, found = port.opposite.getMACForIPv4(v4addr)
 		}
 		if !found {
-			port.dumpPacket(pkt, DirDROP)
-			return DirDROP
+			return port.dropPacket(pkt, dropNeighborUnresolved)
 		}
 
 		// Do packet translation
@@ -191,12 +323,17 @@ func PublicToPrivateTranslation(pkt *packet.Packet, ctx flow.UserContext) uint {
 		if pktVLAN != nil {
 			pktVLAN.SetVLANTagIdentifier(port.opposite.Vlan)
 		}
-		if ipv6 {
-			pktIPv6.DstAddr = v6addr
+		if fragmented {
+			// L4 checksum covers other fragments too
+			newFirstFragment(pktIPv4, pktIPv6, protocol, pktTCP, pktUDP, pktICMP).setAddrPort(false, v4addr, v6addr, newPort)
 		} else {
-			pktIPv4.DstAddr = packet.SwapBytesIPv4Addr(v4addr)
+			if ipv6 {
+				pktIPv6.DstAddr = v6addr
+			} else {
+				pktIPv4.DstAddr = packet.SwapBytesIPv4Addr(v4addr)
+			}
+			setPacketDstPort(pkt, ipv6, protocol, newPort, pktTCP, pktUDP, pktICMP)
 		}
-		setPacketDstPort(pkt, ipv6, newPort, pktTCP, pktUDP, pktICMP)
 
 		port.opposite.dumpPacket(pkt, DirSEND)
 		return DirSEND
@@ -210,6 +347,10 @@ func PublicToPrivateTranslation(pkt *packet.Packet, ctx flow.UserContext) uint {
 func PrivateToPublicTranslation(pkt *packet.Packet, ctx flow.UserContext) uint {
 	pi := ctx.(pairIndex)
 	pp := &Natconfig.PortPairs[pi.index]
+	return pi.counters.count(pri2pub, pp.translatePrivatePacket(pkt))
+}
+
+func (pp *portPair) translatePrivatePacket(pkt *packet.Packet) uint {
 	port := &pp.PrivatePort
 
 	port.dumpPacket(pkt, DirSEND)
@@ -220,28 +361,64 @@ func PrivateToPublicTranslation(pkt *packet.Packet, ctx flow.UserContext) uint {
 		return dir
 	}
 
-	protocol, pktTCP, pktUDP, pktICMP, SrcPort, _ := ParseAllKnownL4(pkt, pktIPv4, pktIPv6)
+	// Fragments other than first are translated in the same way as
+	// first fragment of their packet
+	frag, fragmented := parseFragment(pktIPv4, pktIPv6)
+	if fragmented && !frag.first {
+		return port.translateFragment(pkt, &frag, pktVLAN, pktIPv4, pktIPv6)
+	}
+	dir = pp.privateToPublicTranslation(pkt, pktVLAN, pktIPv4, pktIPv6, fragmented)
+	if fragmented {
+		port.saveFragmentTranslation(pkt, &frag, dir, pktIPv4, pktIPv6)
+	}
+	return dir
+}
+
+func (pp *portPair) privateToPublicTranslation(pkt *packet.Packet, pktVLAN *packet.VLANHdr, pktIPv4 *packet.IPv4Hdr, pktIPv6 *packet.IPv6Hdr, fragmented bool) uint {
+	port := &pp.PrivatePort
+
+	protocol, pktTCP, pktUDP, pktICMP, SrcPort, DstPort := ParseAllKnownL4(pkt, pktIPv4, pktIPv6)
+	if protocol == udpLiteNumber && !checkUDPLiteCoverage(pkt, pktIPv6 != nil, fragmented) {
+		return port.dropPacket(pkt, dropMalformed)
+	}
+	// NPTv6 translates packets of all protocols without sessions
+	if pktIPv6 != nil && pp.IPv6Translation == ipv6NPT {
+		if dir, handled := pp.translateNPTv6Pri2Pub(pkt, pktVLAN, pktIPv6, protocol, pktICMP); handled {
+			return dir
+		}
+	}
+	// Static mappings translate packets of all protocols
+	if dir, handled := pp.translateStaticPri2Pub(pkt, pktVLAN, pktIPv4, pktIPv6, protocol, pktTCP, pktUDP, pktICMP, fragmented); handled {
+		return dir
+	}
 	if protocol == 0 {
-		// Only TCP, UDP and ICMP are supported now, all other protocols are ignored
-		port.dumpPacket(pkt, DirDROP)
-		return DirDROP
+		// Protocols which are not supported are ignored
+		return port.dropPacket(pkt, dropUnsupportedProtocol)
+	}
+	// SCTP checksum covers the whole packet, so fragmented SCTP
+	// packets cannot be translated
+	if protocol == sctpNumber && fragmented {
+		return port.dropPacket(pkt, dropFragment)
+	}
+	// Packets sent to NAT64 prefix are translated to IPv4
+	if pktIPv6 != nil && pp.NAT64Prefix.contains(pktIPv6.DstAddr) {
+		return pp.translateNAT64Pri2Pub(pkt, pktVLAN, pktIPv6, protocol, pktTCP, pktUDP, pktICMP, SrcPort, DstPort, fragmented)
+	}
+	// Protocols without ports are translated by addresses only
+	if isAddrOnlyProtocol(protocol) {
+		return pp.translateAddrOnlyPri2Pub(pkt, pktVLAN, pktIPv4, pktIPv6, protocol)
 	}
 	portNumber := SrcPort
 	// Create a lookup key from packet source address and port
-	var pri2pubKey interface{}
+	var pri2pubKey sessionKey
 	if pktIPv4 != nil {
-		pri2pubKey = Tuple{
-			addr: packet.SwapBytesIPv4Addr(pktIPv4.SrcAddr),
-			port: portNumber,
-		}
+		pri2pubKey = makeTuple(false, packet.SwapBytesIPv4Addr(pktIPv4.SrcAddr), zeroIPv6Addr, portNumber)
 	} else {
-		pri2pubKey = Tuple6{
-			addr: pktIPv6.SrcAddr,
-			port: portNumber,
-		}
+		pri2pubKey = makeTuple(true, 0, pktIPv6.SrcAddr, portNumber)
 	}
-	// Check for ICMP traffic first
-	if pktICMP != nil {
+	// Check for ICMP traffic first. Fragmented ICMP messages are not
+	// handled locally.
+	if pktICMP != nil && !fragmented {
 		dir := port.handleICMP(protocol, pkt, pri2pubKey)
 		if dir != DirSEND {
 			port.dumpPacket(pkt, dir)
@@ -250,7 +427,7 @@ func PrivateToPublicTranslation(pkt *packet.Packet, ctx flow.UserContext) uint {
 	}
 	ipv6 := pktIPv6 != nil
 	// Check for DHCP traffic. We need to get an address if it not set yet
-	if pktUDP != nil {
+	if protocol == types.UDPNumber && !fragmented {
 		var handled bool
 		if ipv6 {
 			handled = port.handleDHCPv6(pkt)
@@ -258,8 +435,7 @@ func PrivateToPublicTranslation(pkt *packet.Packet, ctx flow.UserContext) uint {
 			handled = port.handleDHCP(pkt)
 		}
 		if handled {
-			port.dumpPacket(pkt, DirDROP)
-			return DirDROP
+			return port.dropPacket(pkt, dropHandledLocally)
 		}
 	}
 
@@ -284,12 +460,28 @@ func PrivateToPublicTranslation(pkt *packet.Packet, ctx flow.UserContext) uint {
 		return DirKNI
 	}
 
-	// Do lookup
-	v, found := port.translationTable[protocol].Load(pri2pubKey)
+	// ICMP error messages belong to sessions of packets embedded
+	// into them
+	if pktICMP != nil && !fragmented && isICMPError(protocol, pktICMP) {
+		return pp.translateICMPErrorPri2Pub(pkt, pktVLAN, pktIPv4, pktIPv6)
+	}
+
+	// Do lookup. Static port forwarding entries are always stored
+	// without remote endpoint, so they are checked first.
+	pme, found := port.translationTable[protocol].Load(pri2pubKey)
+	if !found && pp.MappingBehavior != endpointIndependent {
+		if ipv6 {
+			pri2pubKey = addRemoteToKey(pri2pubKey, pp.MappingBehavior, protocol, 0, pktIPv6.DstAddr, DstPort)
+		} else {
+			pri2pubKey = addRemoteToKey(pri2pubKey, pp.MappingBehavior, protocol, packet.SwapBytesIPv4Addr(pktIPv4.DstAddr), zeroIPv6Addr, DstPort)
+		}
+		pme, found = port.translationTable[protocol].Load(pri2pubKey)
+	}
 
 	var v4addr types.IPv4Address
 	var v6addr types.IPv6Address
 	var newPort uint16
+	var poolIndex int
 	var zeroAddr bool
 
 	if !found {
@@ -306,28 +498,74 @@ func PrivateToPublicTranslation(pkt *packet.Packet, ctx flow.UserContext) uint {
 		if !addressAcquired || !publicAddressAcquired {
 			// No packets are allowed yet because ports address is not
 			// known yet
-			port.dumpPacket(pkt, DirDROP)
-			return DirDROP
+			return port.dropPacket(pkt, dropNoAddress)
 		}
 		var err error
 		// Allocate new connection from private to public network
-		v4addr, v6addr, newPort, err = pp.allocateNewEgressConnection(pktIPv6 != nil, protocol, pri2pubKey)
+		v4addr, v6addr, newPort, poolIndex, err = pp.allocateNewEgressConnection(pktIPv6 != nil, protocol, pri2pubKey)
 
 		if err != nil {
-			println("Warning! Failed to allocate new connection", err)
-			port.dumpPacket(pkt, DirDROP)
-			return DirDROP
+			// Hosts which reach their session limits are expected
+			// to send a lot of packets, so they are not reported
+			if err != errSessionLimit {
+				println("Warning! Failed to allocate new connection", err.Error())
+			}
+			return port.dropPacket(pkt, allocationDropReason(err))
 		}
 		zeroAddr = false
 	} else {
-		v4addr, v6addr, newPort, zeroAddr = getAddrFromTuple(v, ipv6)
-		pp.PublicPort.getPortmap(ipv6, protocol)[newPort].lastused = time.Now()
+		v4addr, v6addr, newPort, zeroAddr = getAddrFromTuple(pme.pub)
+		if !zeroAddr {
+			var inPool bool
+			poolIndex, inPool = pp.PublicPort.getPoolIndex(ipv6, v4addr, v6addr)
+			if !inPool {
+				// Public address was changed since this
+				// connection was established
+				return port.dropPacket(pkt, dropAddressChanged)
+			}
+			pme.touch()
+		}
 	}
 
 	if !zeroAddr {
-		// Check whether TCP connection could be reused
-		if pktTCP != nil && !pp.PublicPort.getPortmap(ipv6, protocol)[newPort].static {
-			pp.checkTCPTermination(ipv6, pktTCP, int(newPort), pri2pub)
+		if pme == nil {
+			pme = &pp.PublicPort.getPortmap(ipv6, poolIndex, protocol)[newPort]
+		}
+		// Remember remote endpoint so that inbound packets from it
+		// are allowed
+		if remotes := pme.getRemotes(); remotes != nil && !pme.static {
+			var remoteKey sessionKey
+			if ipv6 {
+				remoteKey = makeRemoteKey(pp.FilteringBehavior, true, protocol, 0, pktIPv6.DstAddr, DstPort)
+			} else {
+				remoteKey = makeRemoteKey(pp.FilteringBehavior, false, protocol, packet.SwapBytesIPv4Addr(pktIPv4.DstAddr), zeroIPv6Addr, DstPort)
+			}
+			if _, known := remotes.Load(remoteKey); !known {
+				remotes.Store(remoteKey, true)
+			}
+		}
+
+		// Track TCP connection state
+		if pktTCP != nil && !pme.static {
+			pp.trackTCPState(pktTCP, pme, pri2pub)
+		}
+
+		// FTP ALG rewrites addresses of data connections in
+		// control connection payload
+		if pp.FTPALG && pktTCP != nil && !fragmented && (DstPort == ftpControlPort || SrcPort == ftpControlPort) {
+			pp.translateFTPPri2Pub(pkt, pktIPv4, pktIPv6, pktTCP, pme, poolIndex, DstPort == ftpControlPort)
+		}
+
+		// SIP ALG rewrites private signalling addresses and creates
+		// mappings of media streams negotiated in SDP
+		if pp.SIPALG && (pktTCP != nil || protocol == types.UDPNumber) && !fragmented && (DstPort == sipPort || SrcPort == sipPort) {
+			pp.translateSIPPri2Pub(pkt, pktIPv4, pktIPv6, pktTCP, pktUDP, pme, poolIndex, SrcPort, v4addr, v6addr, newPort)
+		}
+
+		// Check whether packet should be sent back to private
+		// network
+		if dir, hairpin := pp.hairpinTranslation(pkt, ipv6, protocol, v4addr, v6addr, newPort, DstPort, pktTCP, pktUDP, fragmented); hairpin {
+			return dir
 		}
 
 		// Find corresponding MAC address
@@ -339,8 +577,7 @@ func PrivateToPublicTranslation(pkt *packet.Packet, ctx flow.UserContext) uint {
 			mac, found = port.opposite.getMACForIPv4(packet.SwapBytesIPv4Addr(pktIPv4.DstAddr))
 		}
 		if !found {
-			port.dumpPacket(pkt, DirDROP)
-			return DirDROP
+			return port.dropPacket(pkt, dropNeighborUnresolved)
 		}
 
 		// Do packet translation
@@ -349,12 +586,17 @@ func PrivateToPublicTranslation(pkt *packet.Packet, ctx flow.UserContext) uint {
 		if pktVLAN != nil {
 			pktVLAN.SetVLANTagIdentifier(port.opposite.Vlan)
 		}
-		if ipv6 {
-			pktIPv6.SrcAddr = v6addr
+		if fragmented {
+			// L4 checksum covers other fragments too
+			newFirstFragment(pktIPv4, pktIPv6, protocol, pktTCP, pktUDP, pktICMP).setAddrPort(true, v4addr, v6addr, newPort)
 		} else {
-			pktIPv4.SrcAddr = packet.SwapBytesIPv4Addr(v4addr)
+			if ipv6 {
+				pktIPv6.SrcAddr = v6addr
+			} else {
+				pktIPv4.SrcAddr = packet.SwapBytesIPv4Addr(v4addr)
+			}
+			setPacketSrcPort(pkt, ipv6, protocol, newPort, pktTCP, pktUDP, pktICMP)
 		}
-		setPacketSrcPort(pkt, ipv6, newPort, pktTCP, pktUDP, pktICMP)
 
 		port.opposite.dumpPacket(pkt, DirSEND)
 		return DirSEND
@@ -364,42 +606,15 @@ func PrivateToPublicTranslation(pkt *packet.Packet, ctx flow.UserContext) uint {
 	}
 }
 
-// Simple check for FIN or RST in TCP
-func (pp *portPair) checkTCPTermination(ipv6 bool, hdr *packet.TCPHdr, port int, dir terminationDirection) {
-	if hdr.TCPFlags&types.TCPFlagFin != 0 {
-		// First check for FIN
-		pp.mutex.Lock()
-
-		pme := &pp.getPublicPortPortmap(ipv6, types.TCPNumber)[port]
-		if pme.finCount == 0 {
-			pme.finCount = 1
-			pme.terminationDirection = dir
-		} else if pme.finCount == 1 && pme.terminationDirection == ^dir {
-			pme.finCount = 2
-		}
-
-		pp.mutex.Unlock()
-	} else if hdr.TCPFlags&types.TCPFlagRst != 0 {
-		// RST means that connection is terminated immediately
-		pp.mutex.Lock()
-		pp.deleteOldConnection(ipv6, types.TCPNumber, port)
-		pp.mutex.Unlock()
-	} else if hdr.TCPFlags&types.TCPFlagAck != 0 {
-		// Check for ACK last so that if there is also FIN,
-		// termination doesn't happen. Last ACK should come without
-		// FIN
-		pp.mutex.Lock()
-
-		pme := &pp.getPublicPortPortmap(ipv6, types.TCPNumber)[port]
-		if pme.finCount == 2 {
-			pp.deleteOldConnection(ipv6, types.TCPNumber, port)
-			// Set some time while port cannot be used before
-			// connection timeout is reached
-			pme.lastused = time.Now().Add(portReuseSetLastusedTime)
-		}
-
-		pp.mutex.Unlock()
+// Checks whether inbound packets from remote endpoint are allowed by
+// filtering behavior.
+func (pme *portMapEntry) remoteAllowed(remoteKey sessionKey) bool {
+	remotes := pme.getRemotes()
+	if remotes == nil {
+		return true
 	}
+	_, allowed := remotes.Load(remoteKey)
+	return allowed
 }
 
 func (port *ipPort) parsePacketAndCheckARP(pkt *packet.Packet) (dir uint, vlanhdr *packet.VLANHdr, ipv4hdr *packet.IPv4Hdr, ipv6hdr *packet.IPv6Hdr) {
@@ -414,20 +629,18 @@ func (port *ipPort) parsePacketAndCheckARP(pkt *packet.Packet) (dir uint, vlanhd
 				port.dumpPacket(pkt, dir)
 				return dir, pktVLAN, nil, nil
 			}
-			port.dumpPacket(pkt, DirDROP)
-			return DirDROP, pktVLAN, nil, nil
+			return port.dropPacket(pkt, dropUnsupportedProtocol), pktVLAN, nil, nil
 		}
 		return DirSEND, pktVLAN, nil, pktIPv6
 	}
 	return DirSEND, pktVLAN, pktIPv4, nil
 }
 
-func getAddrFromTuple(v interface{}, ipv6 bool) (types.IPv4Address, types.IPv6Address, uint16, bool) {
-	if ipv6 {
-		value := v.(Tuple6)
-		return 0, value.addr, value.port, value.addr == types.IPv6Address{}
-	} else {
-		value := v.(Tuple)
-		return value.addr, types.IPv6Address{}, value.port, value.addr == 0
+// Returns address and port of tuple and whether its address is zero.
+// NAT64 sessions have IPv6 private address in IPv4 tables.
+func getAddrFromTuple(k sessionKey) (types.IPv4Address, types.IPv6Address, uint16, bool) {
+	if k.ipv6() {
+		return 0, k.addr6, k.port, k.addr6 == zeroIPv6Addr
 	}
+	return k.addr, zeroIPv6Addr, k.port, k.addr == 0
 }
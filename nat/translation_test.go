@@ -0,0 +1,136 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/intel-go/nff-go/types"
+)
+
+// Remote endpoint used by mapping and filtering tests
+type testRemote struct {
+	addr4 types.IPv4Address
+	addr6 types.IPv6Address
+	port  uint16
+}
+
+var (
+	testRemoteA      = testRemote{addr4: 0xc6336401, addr6: types.IPv6Address{0x20, 0x01, 0x0d, 0xb8, 15: 1}, port: 80}
+	testRemoteAPort2 = testRemote{addr4: 0xc6336401, addr6: types.IPv6Address{0x20, 0x01, 0x0d, 0xb8, 15: 1}, port: 8080}
+	testRemoteB      = testRemote{addr4: 0xc6336402, addr6: types.IPv6Address{0x20, 0x01, 0x0d, 0xb8, 15: 2}, port: 80}
+)
+
+// Returns private side lookup key of private endpoint sending packet
+// to remote endpoint.
+func testMappingKey(behavior natBehavior, ipv6 bool, protocol uint8, r testRemote) sessionKey {
+	var key sessionKey
+	if ipv6 {
+		key = makeTuple(true, 0, types.IPv6Address{0xfd, 15: 1}, 5000)
+	} else {
+		key = makeTuple(false, 0x0a000001, zeroIPv6Addr, 5000)
+	}
+	return addRemoteToKey(key, behavior, protocol, r.addr4, r.addr6, r.port)
+}
+
+func TestMappingBehavior(t *testing.T) {
+	tests := []struct {
+		name     string
+		behavior natBehavior
+		protocol uint8
+		first    testRemote
+		second   testRemote
+		// Whether packets to both remote endpoints use the same
+		// mapping
+		same bool
+	}{
+		{"EndpointIndependent", endpointIndependent, types.UDPNumber, testRemoteA, testRemoteB, true},
+		{"AddressDependentSameAddress", addressDependent, types.UDPNumber, testRemoteA, testRemoteAPort2, true},
+		{"AddressDependentOtherAddress", addressDependent, types.UDPNumber, testRemoteA, testRemoteB, false},
+		{"AddressAndPortDependentSameEndpoint", addressAndPortDependent, types.TCPNumber, testRemoteA, testRemoteA, true},
+		{"AddressAndPortDependentOtherPort", addressAndPortDependent, types.TCPNumber, testRemoteA, testRemoteAPort2, false},
+		{"AddressAndPortDependentOtherAddress", addressAndPortDependent, types.TCPNumber, testRemoteA, testRemoteB, false},
+		{"AddressAndPortDependentICMP", addressAndPortDependent, types.ICMPNumber, testRemoteA, testRemoteAPort2, true},
+	}
+	for _, tt := range tests {
+		for _, ipv6 := range []bool{false, true} {
+			protocol := tt.protocol
+			if ipv6 && protocol == types.ICMPNumber {
+				protocol = types.ICMPv6Number
+			}
+			first := testMappingKey(tt.behavior, ipv6, protocol, tt.first)
+			second := testMappingKey(tt.behavior, ipv6, protocol, tt.second)
+			if same := first == second; same != tt.same {
+				t.Errorf("%s (IPv6 %t): same mapping is %t, expected %t", tt.name, ipv6, same, tt.same)
+			}
+		}
+	}
+}
+
+func TestFilteringBehavior(t *testing.T) {
+	tests := []struct {
+		name     string
+		behavior natBehavior
+		protocol uint8
+		// Remote endpoint which private host sent packet to
+		sent testRemote
+		// Remote endpoint which sends inbound packet
+		received testRemote
+		allowed  bool
+	}{
+		{"AddressDependentSameEndpoint", addressDependent, types.UDPNumber, testRemoteA, testRemoteA, true},
+		{"AddressDependentOtherPort", addressDependent, types.UDPNumber, testRemoteA, testRemoteAPort2, true},
+		{"AddressDependentOtherAddress", addressDependent, types.UDPNumber, testRemoteA, testRemoteB, false},
+		{"AddressAndPortDependentSameEndpoint", addressAndPortDependent, types.UDPNumber, testRemoteA, testRemoteA, true},
+		{"AddressAndPortDependentOtherPort", addressAndPortDependent, types.UDPNumber, testRemoteA, testRemoteAPort2, false},
+		{"AddressAndPortDependentOtherAddress", addressAndPortDependent, types.UDPNumber, testRemoteA, testRemoteB, false},
+		{"AddressAndPortDependentICMP", addressAndPortDependent, types.ICMPNumber, testRemoteA, testRemoteAPort2, true},
+	}
+	for _, tt := range tests {
+		for _, ipv6 := range []bool{false, true} {
+			protocol := tt.protocol
+			if ipv6 && protocol == types.ICMPNumber {
+				protocol = types.ICMPv6Number
+			}
+			sent := makeRemoteKey(tt.behavior, ipv6, protocol, tt.sent.addr4, tt.sent.addr6, tt.sent.port)
+			received := makeRemoteKey(tt.behavior, ipv6, protocol, tt.received.addr4, tt.received.addr6, tt.received.port)
+			if allowed := sent == received; allowed != tt.allowed {
+				t.Errorf("%s (IPv6 %t): inbound packet allowed is %t, expected %t", tt.name, ipv6, allowed, tt.allowed)
+			}
+		}
+	}
+}
+
+func TestNATBehaviorJSON(t *testing.T) {
+	tests := []struct {
+		in       string
+		behavior natBehavior
+		ok       bool
+	}{
+		{`"endpoint-independent"`, endpointIndependent, true},
+		{`"address-dependent"`, addressDependent, true},
+		{`"address-and-port-dependent"`, addressAndPortDependent, true},
+		{`"port-dependent"`, 0, false},
+		{`1`, 0, false},
+	}
+	for _, tt := range tests {
+		var behavior natBehavior
+		err := json.Unmarshal([]byte(tt.in), &behavior)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: error %v, expected success %t", tt.in, err, tt.ok)
+			continue
+		}
+		if !tt.ok {
+			continue
+		}
+		if behavior != tt.behavior {
+			t.Errorf("%s: parsed %d, expected %d", tt.in, behavior, tt.behavior)
+		}
+		if s := `"` + behavior.String() + `"`; s != tt.in {
+			t.Errorf("%s: string is %s", tt.in, s)
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"log"
 	"net"
 	"os"
+	"unsafe"
 
 	"github.com/vishvananda/netlink"
 
@@ -18,15 +19,6 @@ import (
 	upd "github.com/intel-go/nff-go-nat/updatecfg"
 )
 
-func (t *Tuple) String() string {
-	return fmt.Sprintf("addr = %d.%d.%d.%d:%d",
-		(t.addr>>24)&0xff,
-		(t.addr>>16)&0xff,
-		(t.addr>>8)&0xff,
-		t.addr&0xff,
-		t.port)
-}
-
 func StringIPv4Int(addr uint32) string {
 	return fmt.Sprintf("%d.%d.%d.%d",
 		(addr>>24)&0xff,
@@ -49,6 +41,11 @@ func swapAddrIPv6(pkt *packet.Packet) {
 	ipv6.SrcAddr, ipv6.DstAddr = ipv6.DstAddr, ipv6.SrcAddr
 }
 
+// Returns whether dropped packets are dumped with their drop reasons.
+func dropReasonsDumped(dir uint) bool {
+	return dir == DirDROP && DropReasonComments
+}
+
 func (port *ipPort) startTrace(dir uint) *os.File {
 	dumpNameLookup := [DirKNI + 1]string{
 		"drop",
@@ -56,24 +53,43 @@ func (port *ipPort) startTrace(dir uint) *os.File {
 		"kni",
 	}
 
-	fname := fmt.Sprintf("%s-%d-%s.pcap", dumpNameLookup[dir], port.Index, port.SrcMACAddress.String())
+	ext := "pcap"
+	if dropReasonsDumped(dir) {
+		ext = "pcapng"
+	}
+	fname := fmt.Sprintf("%s-%d-%s.%s", dumpNameLookup[dir], port.Index, port.SrcMACAddress.String(), ext)
 
 	file, err := os.Create(fname)
 	if err != nil {
 		log.Fatal(err)
 	}
-	packet.WritePcapGlobalHdr(file)
+	if dropReasonsDumped(dir) {
+		err = writePcapngHeader(file)
+	} else {
+		err = packet.WritePcapGlobalHdr(file)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 	return file
 }
 
-func (port *ipPort) dumpPacket(pkt *packet.Packet, dir uint) {
+// Dumps packet according to handler result res which may contain
+// drop reason.
+func (port *ipPort) dumpPacket(pkt *packet.Packet, res uint) {
+	dir, reason := splitResult(res)
 	if DumpEnabled[dir] {
 		port.dumpsync[dir].Lock()
 		if port.fdump[dir] == nil {
 			port.fdump[dir] = port.startTrace(dir)
 		}
 
-		err := pkt.WritePcapOnePacket(port.fdump[dir])
+		var err error
+		if dropReasonsDumped(dir) {
+			err = writePcapngPacket(port.fdump[dir], pkt, "drop reason: "+reason.String())
+		} else {
+			err = pkt.WritePcapOnePacket(port.fdump[dir])
+		}
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -137,7 +153,8 @@ func convertForwardedPort(p *upd.ForwardedPort) (*forwardedPort, error) {
 	}
 
 	return &forwardedPort{
-		Port: uint16(p.GetSourcePortNumber()),
+		Port:     uint16(p.GetSourcePortNumber()),
+		LastPort: uint16(p.GetLastSourcePortNumber()),
 		Destination: hostPort{
 			Addr4: addr,
 			Addr6: addr6,
@@ -151,7 +168,24 @@ func convertForwardedPort(p *upd.ForwardedPort) (*forwardedPort, error) {
 	}, nil
 }
 
-func setPacketDstPort(pkt *packet.Packet, ipv6 bool, port uint16, pktTCP *packet.TCPHdr, pktUDP *packet.UDPHdr, pktICMP *packet.ICMPHdr) {
+func convertHostAddr(bytes []byte) (hostAddr, error) {
+	if len(bytes) == types.IPv6AddrLen {
+		ret := hostAddr{
+			ipv6: true,
+		}
+		copy(ret.Addr6[:], bytes)
+		return ret, nil
+	}
+	addr, err := convertIPv4(bytes)
+	if err != nil {
+		return hostAddr{}, err
+	}
+	return hostAddr{
+		Addr4: addr,
+	}, nil
+}
+
+func setPacketDstPort(pkt *packet.Packet, ipv6 bool, protocol uint8, port uint16, pktTCP *packet.TCPHdr, pktUDP *packet.UDPHdr, pktICMP *packet.ICMPHdr) {
 	if pktTCP != nil {
 		pktTCP.DstPort = packet.SwapBytesUint16(port)
 		if ipv6 {
@@ -161,11 +195,7 @@ func setPacketDstPort(pkt *packet.Packet, ipv6 bool, port uint16, pktTCP *packet
 		}
 	} else if pktUDP != nil {
 		pktUDP.DstPort = packet.SwapBytesUint16(port)
-		if ipv6 {
-			setIPv6UDPChecksum(pkt, !NoCalculateChecksum, !NoHWTXChecksum)
-		} else {
-			setIPv4UDPChecksum(pkt, !NoCalculateChecksum, !NoHWTXChecksum)
-		}
+		setUDPLikeChecksum(pkt, ipv6, protocol)
 	} else {
 		pktICMP.Identifier = packet.SwapBytesUint16(port)
 		if ipv6 {
@@ -176,7 +206,7 @@ func setPacketDstPort(pkt *packet.Packet, ipv6 bool, port uint16, pktTCP *packet
 	}
 }
 
-func setPacketSrcPort(pkt *packet.Packet, ipv6 bool, port uint16, pktTCP *packet.TCPHdr, pktUDP *packet.UDPHdr, pktICMP *packet.ICMPHdr) {
+func setPacketSrcPort(pkt *packet.Packet, ipv6 bool, protocol uint8, port uint16, pktTCP *packet.TCPHdr, pktUDP *packet.UDPHdr, pktICMP *packet.ICMPHdr) {
 	if pktTCP != nil {
 		pktTCP.SrcPort = packet.SwapBytesUint16(port)
 		if ipv6 {
@@ -186,11 +216,7 @@ func setPacketSrcPort(pkt *packet.Packet, ipv6 bool, port uint16, pktTCP *packet
 		}
 	} else if pktUDP != nil {
 		pktUDP.SrcPort = packet.SwapBytesUint16(port)
-		if ipv6 {
-			setIPv6UDPChecksum(pkt, !NoCalculateChecksum, !NoHWTXChecksum)
-		} else {
-			setIPv4UDPChecksum(pkt, !NoCalculateChecksum, !NoHWTXChecksum)
-		}
+		setUDPLikeChecksum(pkt, ipv6, protocol)
 	} else {
 		pktICMP.Identifier = packet.SwapBytesUint16(port)
 		if ipv6 {
@@ -205,18 +231,31 @@ func ParseAllKnownL4(pkt *packet.Packet, pktIPv4 *packet.IPv4Hdr, pktIPv6 *packe
 	var protocol uint8
 
 	if pktIPv4 != nil {
+		// Fragments other than first don't have L4 header
+		if packet.SwapBytesUint16(pktIPv4.FragmentOffset)&0x1fff != 0 {
+			return 0, nil, nil, nil, 0, 0
+		}
 		protocol = pktIPv4.NextProtoID
 		pkt.ParseL4ForIPv4()
 	} else {
 		protocol = pktIPv6.Proto
 		pkt.ParseL4ForIPv6()
+		if protocol == ipv6FragmentHeaderNumber {
+			hdr := (*ipv6FragmentHdr)(pkt.L4)
+			if packet.SwapBytesUint16(hdr.FragOffsetFlags)>>3 != 0 {
+				return 0, nil, nil, nil, 0, 0
+			}
+			protocol = hdr.NextHeader
+			pkt.L4 = unsafe.Pointer(uintptr(pkt.L4) + ipv6FragmentHeaderLen)
+		}
 	}
 
 	switch protocol {
 	case types.TCPNumber:
 		pktTCP := (*packet.TCPHdr)(pkt.L4)
 		return protocol, pktTCP, nil, nil, packet.SwapBytesUint16(pktTCP.SrcPort), packet.SwapBytesUint16(pktTCP.DstPort)
-	case types.UDPNumber:
+	case types.UDPNumber, udpLiteNumber, sctpNumber:
+		// UDP-Lite and SCTP headers start with ports like UDP header
 		pktUDP := (*packet.UDPHdr)(pkt.L4)
 		return protocol, nil, pktUDP, nil, packet.SwapBytesUint16(pktUDP.SrcPort), packet.SwapBytesUint16(pktUDP.DstPort)
 	case types.ICMPNumber:
@@ -225,6 +264,9 @@ func ParseAllKnownL4(pkt *packet.Packet, pktIPv4 *packet.IPv4Hdr, pktIPv6 *packe
 	case types.ICMPv6Number:
 		pktICMP := (*packet.ICMPHdr)(pkt.L4)
 		return protocol, nil, nil, pktICMP, packet.SwapBytesUint16(pktICMP.Identifier), packet.SwapBytesUint16(pktICMP.Identifier)
+	case greNumber, espNumber:
+		// Protocols without ports are translated by addresses only
+		return protocol, nil, nil, nil, 0, 0
 	default:
 		return 0, nil, nil, nil, 0, 0
 	}
@@ -3,13 +3,12 @@
 
 package updatecfg
 
-import proto "github.com/golang/protobuf/proto"
-import fmt "fmt"
-import math "math"
-
 import (
-	context "golang.org/x/net/context"
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
 	grpc "google.golang.org/grpc"
+	math "math"
 )
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -21,7 +20,7 @@ var _ = math.Inf
 // is compatible with the proto package it is being compiled against.
 // A compilation error at this line likely means your copy of the
 // proto package needs to be updated.
-const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
 
 type TraceType int32
 
@@ -36,6 +35,7 @@ var TraceType_name = map[int32]string{
 	1: "DUMP_TRANSLATE",
 	2: "DUMP_KNI",
 }
+
 var TraceType_value = map[string]int32{
 	"DUMP_DROP":      0,
 	"DUMP_TRANSLATE": 1,
@@ -45,8 +45,9 @@ var TraceType_value = map[string]int32{
 func (x TraceType) String() string {
 	return proto.EnumName(TraceType_name, int32(x))
 }
+
 func (TraceType) EnumDescriptor() ([]byte, []int) {
-	return fileDescriptor_updatecfg_fa7d68b5067fd899, []int{0}
+	return fileDescriptor_156a706a72c56418, []int{0}
 }
 
 type Protocol int32
@@ -68,6 +69,7 @@ var Protocol_name = map[int32]string{
 	65542: "TCP6",
 	65553: "UDP6",
 }
+
 var Protocol_value = map[string]int32{
 	"UNKNOWN":   0,
 	"TCP":       6,
@@ -80,8 +82,9 @@ var Protocol_value = map[string]int32{
 func (x Protocol) String() string {
 	return proto.EnumName(Protocol_name, int32(x))
 }
+
 func (Protocol) EnumDescriptor() ([]byte, []int) {
-	return fileDescriptor_updatecfg_fa7d68b5067fd899, []int{1}
+	return fileDescriptor_156a706a72c56418, []int{1}
 }
 
 type DumpControlRequest struct {
@@ -96,16 +99,17 @@ func (m *DumpControlRequest) Reset()         { *m = DumpControlRequest{} }
 func (m *DumpControlRequest) String() string { return proto.CompactTextString(m) }
 func (*DumpControlRequest) ProtoMessage()    {}
 func (*DumpControlRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_updatecfg_fa7d68b5067fd899, []int{0}
+	return fileDescriptor_156a706a72c56418, []int{0}
 }
+
 func (m *DumpControlRequest) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_DumpControlRequest.Unmarshal(m, b)
 }
 func (m *DumpControlRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
 	return xxx_messageInfo_DumpControlRequest.Marshal(b, m, deterministic)
 }
-func (dst *DumpControlRequest) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_DumpControlRequest.Merge(dst, src)
+func (m *DumpControlRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DumpControlRequest.Merge(m, src)
 }
 func (m *DumpControlRequest) XXX_Size() int {
 	return xxx_messageInfo_DumpControlRequest.Size(m)
@@ -141,16 +145,17 @@ func (m *IPAddress) Reset()         { *m = IPAddress{} }
 func (m *IPAddress) String() string { return proto.CompactTextString(m) }
 func (*IPAddress) ProtoMessage()    {}
 func (*IPAddress) Descriptor() ([]byte, []int) {
-	return fileDescriptor_updatecfg_fa7d68b5067fd899, []int{1}
+	return fileDescriptor_156a706a72c56418, []int{1}
 }
+
 func (m *IPAddress) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_IPAddress.Unmarshal(m, b)
 }
 func (m *IPAddress) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
 	return xxx_messageInfo_IPAddress.Marshal(b, m, deterministic)
 }
-func (dst *IPAddress) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_IPAddress.Merge(dst, src)
+func (m *IPAddress) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_IPAddress.Merge(m, src)
 }
 func (m *IPAddress) XXX_Size() int {
 	return xxx_messageInfo_IPAddress.Size(m)
@@ -180,16 +185,17 @@ func (m *Subnet) Reset()         { *m = Subnet{} }
 func (m *Subnet) String() string { return proto.CompactTextString(m) }
 func (*Subnet) ProtoMessage()    {}
 func (*Subnet) Descriptor() ([]byte, []int) {
-	return fileDescriptor_updatecfg_fa7d68b5067fd899, []int{2}
+	return fileDescriptor_156a706a72c56418, []int{2}
 }
+
 func (m *Subnet) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_Subnet.Unmarshal(m, b)
 }
 func (m *Subnet) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
 	return xxx_messageInfo_Subnet.Marshal(b, m, deterministic)
 }
-func (dst *Subnet) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_Subnet.Merge(dst, src)
+func (m *Subnet) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Subnet.Merge(m, src)
 }
 func (m *Subnet) XXX_Size() int {
 	return xxx_messageInfo_Subnet.Size(m)
@@ -226,16 +232,17 @@ func (m *InterfaceAddressChangeRequest) Reset()         { *m = InterfaceAddressC
 func (m *InterfaceAddressChangeRequest) String() string { return proto.CompactTextString(m) }
 func (*InterfaceAddressChangeRequest) ProtoMessage()    {}
 func (*InterfaceAddressChangeRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_updatecfg_fa7d68b5067fd899, []int{3}
+	return fileDescriptor_156a706a72c56418, []int{3}
 }
+
 func (m *InterfaceAddressChangeRequest) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_InterfaceAddressChangeRequest.Unmarshal(m, b)
 }
 func (m *InterfaceAddressChangeRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
 	return xxx_messageInfo_InterfaceAddressChangeRequest.Marshal(b, m, deterministic)
 }
-func (dst *InterfaceAddressChangeRequest) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_InterfaceAddressChangeRequest.Merge(dst, src)
+func (m *InterfaceAddressChangeRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_InterfaceAddressChangeRequest.Merge(m, src)
 }
 func (m *InterfaceAddressChangeRequest) XXX_Size() int {
 	return xxx_messageInfo_InterfaceAddressChangeRequest.Size(m)
@@ -265,6 +272,7 @@ type ForwardedPort struct {
 	TargetAddress        *IPAddress `protobuf:"bytes,2,opt,name=target_address,json=targetAddress,proto3" json:"target_address,omitempty"`
 	TargetPortNumber     uint32     `protobuf:"varint,3,opt,name=target_port_number,json=targetPortNumber,proto3" json:"target_port_number,omitempty"`
 	Protocol             Protocol   `protobuf:"varint,4,opt,name=protocol,proto3,enum=updatecfg.Protocol" json:"protocol,omitempty"`
+	LastSourcePortNumber uint32     `protobuf:"varint,5,opt,name=last_source_port_number,json=lastSourcePortNumber,proto3" json:"last_source_port_number,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
 	XXX_unrecognized     []byte     `json:"-"`
 	XXX_sizecache        int32      `json:"-"`
@@ -274,16 +282,17 @@ func (m *ForwardedPort) Reset()         { *m = ForwardedPort{} }
 func (m *ForwardedPort) String() string { return proto.CompactTextString(m) }
 func (*ForwardedPort) ProtoMessage()    {}
 func (*ForwardedPort) Descriptor() ([]byte, []int) {
-	return fileDescriptor_updatecfg_fa7d68b5067fd899, []int{4}
+	return fileDescriptor_156a706a72c56418, []int{4}
 }
+
 func (m *ForwardedPort) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_ForwardedPort.Unmarshal(m, b)
 }
 func (m *ForwardedPort) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
 	return xxx_messageInfo_ForwardedPort.Marshal(b, m, deterministic)
 }
-func (dst *ForwardedPort) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_ForwardedPort.Merge(dst, src)
+func (m *ForwardedPort) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ForwardedPort.Merge(m, src)
 }
 func (m *ForwardedPort) XXX_Size() int {
 	return xxx_messageInfo_ForwardedPort.Size(m)
@@ -322,6 +331,13 @@ func (m *ForwardedPort) GetProtocol() Protocol {
 	return Protocol_UNKNOWN
 }
 
+func (m *ForwardedPort) GetLastSourcePortNumber() uint32 {
+	if m != nil {
+		return m.LastSourcePortNumber
+	}
+	return 0
+}
+
 type PortForwardingChangeRequest struct {
 	EnableForwarding     bool           `protobuf:"varint,1,opt,name=enable_forwarding,json=enableForwarding,proto3" json:"enable_forwarding,omitempty"`
 	InterfaceId          uint32         `protobuf:"varint,2,opt,name=interface_id,json=interfaceId,proto3" json:"interface_id,omitempty"`
@@ -335,16 +351,17 @@ func (m *PortForwardingChangeRequest) Reset()         { *m = PortForwardingChang
 func (m *PortForwardingChangeRequest) String() string { return proto.CompactTextString(m) }
 func (*PortForwardingChangeRequest) ProtoMessage()    {}
 func (*PortForwardingChangeRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_updatecfg_fa7d68b5067fd899, []int{5}
+	return fileDescriptor_156a706a72c56418, []int{5}
 }
+
 func (m *PortForwardingChangeRequest) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_PortForwardingChangeRequest.Unmarshal(m, b)
 }
 func (m *PortForwardingChangeRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
 	return xxx_messageInfo_PortForwardingChangeRequest.Marshal(b, m, deterministic)
 }
-func (dst *PortForwardingChangeRequest) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_PortForwardingChangeRequest.Merge(dst, src)
+func (m *PortForwardingChangeRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PortForwardingChangeRequest.Merge(m, src)
 }
 func (m *PortForwardingChangeRequest) XXX_Size() int {
 	return xxx_messageInfo_PortForwardingChangeRequest.Size(m)
@@ -376,235 +393,1448 @@ func (m *PortForwardingChangeRequest) GetPort() *ForwardedPort {
 	return nil
 }
 
-type Reply struct {
-	Msg                  string   `protobuf:"bytes,2,opt,name=msg,proto3" json:"msg,omitempty"`
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
+type SessionTimeoutsChangeRequest struct {
+	TcpEstablishedSeconds uint32   `protobuf:"varint,1,opt,name=tcp_established_seconds,json=tcpEstablishedSeconds,proto3" json:"tcp_established_seconds,omitempty"`
+	TcpTransitorySeconds  uint32   `protobuf:"varint,2,opt,name=tcp_transitory_seconds,json=tcpTransitorySeconds,proto3" json:"tcp_transitory_seconds,omitempty"`
+	UdpSeconds            uint32   `protobuf:"varint,3,opt,name=udp_seconds,json=udpSeconds,proto3" json:"udp_seconds,omitempty"`
+	IcmpSeconds           uint32   `protobuf:"varint,4,opt,name=icmp_seconds,json=icmpSeconds,proto3" json:"icmp_seconds,omitempty"`
+	TcpSynSentSeconds     uint32   `protobuf:"varint,5,opt,name=tcp_syn_sent_seconds,json=tcpSynSentSeconds,proto3" json:"tcp_syn_sent_seconds,omitempty"`
+	TcpSynRecvSeconds     uint32   `protobuf:"varint,6,opt,name=tcp_syn_recv_seconds,json=tcpSynRecvSeconds,proto3" json:"tcp_syn_recv_seconds,omitempty"`
+	TcpFinWaitSeconds     uint32   `protobuf:"varint,7,opt,name=tcp_fin_wait_seconds,json=tcpFinWaitSeconds,proto3" json:"tcp_fin_wait_seconds,omitempty"`
+	TcpCloseWaitSeconds   uint32   `protobuf:"varint,8,opt,name=tcp_close_wait_seconds,json=tcpCloseWaitSeconds,proto3" json:"tcp_close_wait_seconds,omitempty"`
+	TcpLastAckSeconds     uint32   `protobuf:"varint,9,opt,name=tcp_last_ack_seconds,json=tcpLastAckSeconds,proto3" json:"tcp_last_ack_seconds,omitempty"`
+	TcpTimeWaitSeconds    uint32   `protobuf:"varint,10,opt,name=tcp_time_wait_seconds,json=tcpTimeWaitSeconds,proto3" json:"tcp_time_wait_seconds,omitempty"`
+	TcpCloseSeconds       uint32   `protobuf:"varint,11,opt,name=tcp_close_seconds,json=tcpCloseSeconds,proto3" json:"tcp_close_seconds,omitempty"`
+	XXX_NoUnkeyedLiteral  struct{} `json:"-"`
+	XXX_unrecognized      []byte   `json:"-"`
+	XXX_sizecache         int32    `json:"-"`
 }
 
-func (m *Reply) Reset()         { *m = Reply{} }
-func (m *Reply) String() string { return proto.CompactTextString(m) }
-func (*Reply) ProtoMessage()    {}
-func (*Reply) Descriptor() ([]byte, []int) {
-	return fileDescriptor_updatecfg_fa7d68b5067fd899, []int{6}
+func (m *SessionTimeoutsChangeRequest) Reset()         { *m = SessionTimeoutsChangeRequest{} }
+func (m *SessionTimeoutsChangeRequest) String() string { return proto.CompactTextString(m) }
+func (*SessionTimeoutsChangeRequest) ProtoMessage()    {}
+func (*SessionTimeoutsChangeRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_156a706a72c56418, []int{6}
 }
-func (m *Reply) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_Reply.Unmarshal(m, b)
+
+func (m *SessionTimeoutsChangeRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SessionTimeoutsChangeRequest.Unmarshal(m, b)
 }
-func (m *Reply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_Reply.Marshal(b, m, deterministic)
+func (m *SessionTimeoutsChangeRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SessionTimeoutsChangeRequest.Marshal(b, m, deterministic)
 }
-func (dst *Reply) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_Reply.Merge(dst, src)
+func (m *SessionTimeoutsChangeRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SessionTimeoutsChangeRequest.Merge(m, src)
 }
-func (m *Reply) XXX_Size() int {
-	return xxx_messageInfo_Reply.Size(m)
+func (m *SessionTimeoutsChangeRequest) XXX_Size() int {
+	return xxx_messageInfo_SessionTimeoutsChangeRequest.Size(m)
 }
-func (m *Reply) XXX_DiscardUnknown() {
-	xxx_messageInfo_Reply.DiscardUnknown(m)
+func (m *SessionTimeoutsChangeRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_SessionTimeoutsChangeRequest.DiscardUnknown(m)
 }
 
-var xxx_messageInfo_Reply proto.InternalMessageInfo
+var xxx_messageInfo_SessionTimeoutsChangeRequest proto.InternalMessageInfo
 
-func (m *Reply) GetMsg() string {
+func (m *SessionTimeoutsChangeRequest) GetTcpEstablishedSeconds() uint32 {
 	if m != nil {
-		return m.Msg
+		return m.TcpEstablishedSeconds
 	}
-	return ""
+	return 0
 }
 
-func init() {
-	proto.RegisterType((*DumpControlRequest)(nil), "updatecfg.DumpControlRequest")
-	proto.RegisterType((*IPAddress)(nil), "updatecfg.IPAddress")
-	proto.RegisterType((*Subnet)(nil), "updatecfg.Subnet")
-	proto.RegisterType((*InterfaceAddressChangeRequest)(nil), "updatecfg.InterfaceAddressChangeRequest")
-	proto.RegisterType((*ForwardedPort)(nil), "updatecfg.ForwardedPort")
-	proto.RegisterType((*PortForwardingChangeRequest)(nil), "updatecfg.PortForwardingChangeRequest")
-	proto.RegisterType((*Reply)(nil), "updatecfg.Reply")
-	proto.RegisterEnum("updatecfg.TraceType", TraceType_name, TraceType_value)
-	proto.RegisterEnum("updatecfg.Protocol", Protocol_name, Protocol_value)
+func (m *SessionTimeoutsChangeRequest) GetTcpTransitorySeconds() uint32 {
+	if m != nil {
+		return m.TcpTransitorySeconds
+	}
+	return 0
 }
 
-// Reference imports to suppress errors if they are not otherwise used.
-var _ context.Context
-var _ grpc.ClientConn
+func (m *SessionTimeoutsChangeRequest) GetUdpSeconds() uint32 {
+	if m != nil {
+		return m.UdpSeconds
+	}
+	return 0
+}
 
-// This is a compile-time assertion to ensure that this generated file
-// is compatible with the grpc package it is being compiled against.
-const _ = grpc.SupportPackageIsVersion4
+func (m *SessionTimeoutsChangeRequest) GetIcmpSeconds() uint32 {
+	if m != nil {
+		return m.IcmpSeconds
+	}
+	return 0
+}
 
-// UpdaterClient is the client API for Updater service.
-//
-// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
-type UpdaterClient interface {
-	ControlDump(ctx context.Context, in *DumpControlRequest, opts ...grpc.CallOption) (*Reply, error)
-	ChangeInterfaceAddress(ctx context.Context, in *InterfaceAddressChangeRequest, opts ...grpc.CallOption) (*Reply, error)
-	ChangePortForwarding(ctx context.Context, in *PortForwardingChangeRequest, opts ...grpc.CallOption) (*Reply, error)
+func (m *SessionTimeoutsChangeRequest) GetTcpSynSentSeconds() uint32 {
+	if m != nil {
+		return m.TcpSynSentSeconds
+	}
+	return 0
 }
 
-type updaterClient struct {
-	cc *grpc.ClientConn
+func (m *SessionTimeoutsChangeRequest) GetTcpSynRecvSeconds() uint32 {
+	if m != nil {
+		return m.TcpSynRecvSeconds
+	}
+	return 0
 }
 
-func NewUpdaterClient(cc *grpc.ClientConn) UpdaterClient {
-	return &updaterClient{cc}
+func (m *SessionTimeoutsChangeRequest) GetTcpFinWaitSeconds() uint32 {
+	if m != nil {
+		return m.TcpFinWaitSeconds
+	}
+	return 0
 }
 
-func (c *updaterClient) ControlDump(ctx context.Context, in *DumpControlRequest, opts ...grpc.CallOption) (*Reply, error) {
-	out := new(Reply)
-	err := c.cc.Invoke(ctx, "/updatecfg.Updater/ControlDump", in, out, opts...)
-	if err != nil {
-		return nil, err
+func (m *SessionTimeoutsChangeRequest) GetTcpCloseWaitSeconds() uint32 {
+	if m != nil {
+		return m.TcpCloseWaitSeconds
 	}
-	return out, nil
+	return 0
 }
 
-func (c *updaterClient) ChangeInterfaceAddress(ctx context.Context, in *InterfaceAddressChangeRequest, opts ...grpc.CallOption) (*Reply, error) {
-	out := new(Reply)
-	err := c.cc.Invoke(ctx, "/updatecfg.Updater/ChangeInterfaceAddress", in, out, opts...)
-	if err != nil {
-		return nil, err
+func (m *SessionTimeoutsChangeRequest) GetTcpLastAckSeconds() uint32 {
+	if m != nil {
+		return m.TcpLastAckSeconds
 	}
-	return out, nil
+	return 0
 }
 
-func (c *updaterClient) ChangePortForwarding(ctx context.Context, in *PortForwardingChangeRequest, opts ...grpc.CallOption) (*Reply, error) {
-	out := new(Reply)
-	err := c.cc.Invoke(ctx, "/updatecfg.Updater/ChangePortForwarding", in, out, opts...)
-	if err != nil {
-		return nil, err
+func (m *SessionTimeoutsChangeRequest) GetTcpTimeWaitSeconds() uint32 {
+	if m != nil {
+		return m.TcpTimeWaitSeconds
 	}
-	return out, nil
+	return 0
 }
 
-// UpdaterServer is the server API for Updater service.
-type UpdaterServer interface {
-	ControlDump(context.Context, *DumpControlRequest) (*Reply, error)
-	ChangeInterfaceAddress(context.Context, *InterfaceAddressChangeRequest) (*Reply, error)
-	ChangePortForwarding(context.Context, *PortForwardingChangeRequest) (*Reply, error)
+func (m *SessionTimeoutsChangeRequest) GetTcpCloseSeconds() uint32 {
+	if m != nil {
+		return m.TcpCloseSeconds
+	}
+	return 0
 }
 
-func RegisterUpdaterServer(s *grpc.Server, srv UpdaterServer) {
-	s.RegisterService(&_Updater_serviceDesc, srv)
+type PortBlockOwnerRequest struct {
+	InterfaceId          uint32     `protobuf:"varint,1,opt,name=interface_id,json=interfaceId,proto3" json:"interface_id,omitempty"`
+	Address              *IPAddress `protobuf:"bytes,2,opt,name=address,proto3" json:"address,omitempty"`
+	PortNumber           uint32     `protobuf:"varint,3,opt,name=port_number,json=portNumber,proto3" json:"port_number,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
+	XXX_unrecognized     []byte     `json:"-"`
+	XXX_sizecache        int32      `json:"-"`
 }
 
-func _Updater_ControlDump_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(DumpControlRequest)
-	if err := dec(in); err != nil {
-		return nil, err
+func (m *PortBlockOwnerRequest) Reset()         { *m = PortBlockOwnerRequest{} }
+func (m *PortBlockOwnerRequest) String() string { return proto.CompactTextString(m) }
+func (*PortBlockOwnerRequest) ProtoMessage()    {}
+func (*PortBlockOwnerRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_156a706a72c56418, []int{7}
+}
+
+func (m *PortBlockOwnerRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PortBlockOwnerRequest.Unmarshal(m, b)
+}
+func (m *PortBlockOwnerRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PortBlockOwnerRequest.Marshal(b, m, deterministic)
+}
+func (m *PortBlockOwnerRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PortBlockOwnerRequest.Merge(m, src)
+}
+func (m *PortBlockOwnerRequest) XXX_Size() int {
+	return xxx_messageInfo_PortBlockOwnerRequest.Size(m)
+}
+func (m *PortBlockOwnerRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PortBlockOwnerRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PortBlockOwnerRequest proto.InternalMessageInfo
+
+func (m *PortBlockOwnerRequest) GetInterfaceId() uint32 {
+	if m != nil {
+		return m.InterfaceId
 	}
-	if interceptor == nil {
-		return srv.(UpdaterServer).ControlDump(ctx, in)
+	return 0
+}
+
+func (m *PortBlockOwnerRequest) GetAddress() *IPAddress {
+	if m != nil {
+		return m.Address
 	}
-	info := &grpc.UnaryServerInfo{
-		Server:     srv,
-		FullMethod: "/updatecfg.Updater/ControlDump",
+	return nil
+}
+
+func (m *PortBlockOwnerRequest) GetPortNumber() uint32 {
+	if m != nil {
+		return m.PortNumber
 	}
-	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(UpdaterServer).ControlDump(ctx, req.(*DumpControlRequest))
+	return 0
+}
+
+type SessionLimitsChangeRequest struct {
+	InterfaceId          uint32   `protobuf:"varint,1,opt,name=interface_id,json=interfaceId,proto3" json:"interface_id,omitempty"`
+	Total                uint32   `protobuf:"varint,2,opt,name=total,proto3" json:"total,omitempty"`
+	Tcp                  uint32   `protobuf:"varint,3,opt,name=tcp,proto3" json:"tcp,omitempty"`
+	Udp                  uint32   `protobuf:"varint,4,opt,name=udp,proto3" json:"udp,omitempty"`
+	Icmp                 uint32   `protobuf:"varint,5,opt,name=icmp,proto3" json:"icmp,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SessionLimitsChangeRequest) Reset()         { *m = SessionLimitsChangeRequest{} }
+func (m *SessionLimitsChangeRequest) String() string { return proto.CompactTextString(m) }
+func (*SessionLimitsChangeRequest) ProtoMessage()    {}
+func (*SessionLimitsChangeRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_156a706a72c56418, []int{8}
+}
+
+func (m *SessionLimitsChangeRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SessionLimitsChangeRequest.Unmarshal(m, b)
+}
+func (m *SessionLimitsChangeRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SessionLimitsChangeRequest.Marshal(b, m, deterministic)
+}
+func (m *SessionLimitsChangeRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SessionLimitsChangeRequest.Merge(m, src)
+}
+func (m *SessionLimitsChangeRequest) XXX_Size() int {
+	return xxx_messageInfo_SessionLimitsChangeRequest.Size(m)
+}
+func (m *SessionLimitsChangeRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_SessionLimitsChangeRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SessionLimitsChangeRequest proto.InternalMessageInfo
+
+func (m *SessionLimitsChangeRequest) GetInterfaceId() uint32 {
+	if m != nil {
+		return m.InterfaceId
 	}
-	return interceptor(ctx, in, info, handler)
+	return 0
 }
 
-func _Updater_ChangeInterfaceAddress_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(InterfaceAddressChangeRequest)
-	if err := dec(in); err != nil {
-		return nil, err
+func (m *SessionLimitsChangeRequest) GetTotal() uint32 {
+	if m != nil {
+		return m.Total
 	}
-	if interceptor == nil {
-		return srv.(UpdaterServer).ChangeInterfaceAddress(ctx, in)
+	return 0
+}
+
+func (m *SessionLimitsChangeRequest) GetTcp() uint32 {
+	if m != nil {
+		return m.Tcp
 	}
-	info := &grpc.UnaryServerInfo{
-		Server:     srv,
-		FullMethod: "/updatecfg.Updater/ChangeInterfaceAddress",
+	return 0
+}
+
+func (m *SessionLimitsChangeRequest) GetUdp() uint32 {
+	if m != nil {
+		return m.Udp
 	}
-	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(UpdaterServer).ChangeInterfaceAddress(ctx, req.(*InterfaceAddressChangeRequest))
+	return 0
+}
+
+func (m *SessionLimitsChangeRequest) GetIcmp() uint32 {
+	if m != nil {
+		return m.Icmp
 	}
-	return interceptor(ctx, in, info, handler)
+	return 0
 }
 
-func _Updater_ChangePortForwarding_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(PortForwardingChangeRequest)
-	if err := dec(in); err != nil {
-		return nil, err
+type StaticMappingChangeRequest struct {
+	EnableMapping        bool       `protobuf:"varint,1,opt,name=enable_mapping,json=enableMapping,proto3" json:"enable_mapping,omitempty"`
+	InterfaceId          uint32     `protobuf:"varint,2,opt,name=interface_id,json=interfaceId,proto3" json:"interface_id,omitempty"`
+	PublicAddress        *IPAddress `protobuf:"bytes,3,opt,name=public_address,json=publicAddress,proto3" json:"public_address,omitempty"`
+	PrivateAddress       *IPAddress `protobuf:"bytes,4,opt,name=private_address,json=privateAddress,proto3" json:"private_address,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
+	XXX_unrecognized     []byte     `json:"-"`
+	XXX_sizecache        int32      `json:"-"`
+}
+
+func (m *StaticMappingChangeRequest) Reset()         { *m = StaticMappingChangeRequest{} }
+func (m *StaticMappingChangeRequest) String() string { return proto.CompactTextString(m) }
+func (*StaticMappingChangeRequest) ProtoMessage()    {}
+func (*StaticMappingChangeRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_156a706a72c56418, []int{9}
+}
+
+func (m *StaticMappingChangeRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_StaticMappingChangeRequest.Unmarshal(m, b)
+}
+func (m *StaticMappingChangeRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_StaticMappingChangeRequest.Marshal(b, m, deterministic)
+}
+func (m *StaticMappingChangeRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_StaticMappingChangeRequest.Merge(m, src)
+}
+func (m *StaticMappingChangeRequest) XXX_Size() int {
+	return xxx_messageInfo_StaticMappingChangeRequest.Size(m)
+}
+func (m *StaticMappingChangeRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_StaticMappingChangeRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_StaticMappingChangeRequest proto.InternalMessageInfo
+
+func (m *StaticMappingChangeRequest) GetEnableMapping() bool {
+	if m != nil {
+		return m.EnableMapping
 	}
-	if interceptor == nil {
-		return srv.(UpdaterServer).ChangePortForwarding(ctx, in)
+	return false
+}
+
+func (m *StaticMappingChangeRequest) GetInterfaceId() uint32 {
+	if m != nil {
+		return m.InterfaceId
 	}
-	info := &grpc.UnaryServerInfo{
-		Server:     srv,
-		FullMethod: "/updatecfg.Updater/ChangePortForwarding",
+	return 0
+}
+
+func (m *StaticMappingChangeRequest) GetPublicAddress() *IPAddress {
+	if m != nil {
+		return m.PublicAddress
 	}
-	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(UpdaterServer).ChangePortForwarding(ctx, req.(*PortForwardingChangeRequest))
+	return nil
+}
+
+func (m *StaticMappingChangeRequest) GetPrivateAddress() *IPAddress {
+	if m != nil {
+		return m.PrivateAddress
 	}
-	return interceptor(ctx, in, info, handler)
+	return nil
 }
 
-var _Updater_serviceDesc = grpc.ServiceDesc{
-	ServiceName: "updatecfg.Updater",
-	HandlerType: (*UpdaterServer)(nil),
-	Methods: []grpc.MethodDesc{
-		{
-			MethodName: "ControlDump",
-			Handler:    _Updater_ControlDump_Handler,
-		},
-		{
-			MethodName: "ChangeInterfaceAddress",
-			Handler:    _Updater_ChangeInterfaceAddress_Handler,
-		},
-		{
+type SessionsRequest struct {
+	MatchInterface       bool       `protobuf:"varint,1,opt,name=match_interface,json=matchInterface,proto3" json:"match_interface,omitempty"`
+	InterfaceId          uint32     `protobuf:"varint,2,opt,name=interface_id,json=interfaceId,proto3" json:"interface_id,omitempty"`
+	Protocol             uint32     `protobuf:"varint,3,opt,name=protocol,proto3" json:"protocol,omitempty"`
+	PrivateAddress       *IPAddress `protobuf:"bytes,4,opt,name=private_address,json=privateAddress,proto3" json:"private_address,omitempty"`
+	PublicAddress        *IPAddress `protobuf:"bytes,5,opt,name=public_address,json=publicAddress,proto3" json:"public_address,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
+	XXX_unrecognized     []byte     `json:"-"`
+	XXX_sizecache        int32      `json:"-"`
+}
+
+func (m *SessionsRequest) Reset()         { *m = SessionsRequest{} }
+func (m *SessionsRequest) String() string { return proto.CompactTextString(m) }
+func (*SessionsRequest) ProtoMessage()    {}
+func (*SessionsRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_156a706a72c56418, []int{10}
+}
+
+func (m *SessionsRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SessionsRequest.Unmarshal(m, b)
+}
+func (m *SessionsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SessionsRequest.Marshal(b, m, deterministic)
+}
+func (m *SessionsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SessionsRequest.Merge(m, src)
+}
+func (m *SessionsRequest) XXX_Size() int {
+	return xxx_messageInfo_SessionsRequest.Size(m)
+}
+func (m *SessionsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_SessionsRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SessionsRequest proto.InternalMessageInfo
+
+func (m *SessionsRequest) GetMatchInterface() bool {
+	if m != nil {
+		return m.MatchInterface
+	}
+	return false
+}
+
+func (m *SessionsRequest) GetInterfaceId() uint32 {
+	if m != nil {
+		return m.InterfaceId
+	}
+	return 0
+}
+
+func (m *SessionsRequest) GetProtocol() uint32 {
+	if m != nil {
+		return m.Protocol
+	}
+	return 0
+}
+
+func (m *SessionsRequest) GetPrivateAddress() *IPAddress {
+	if m != nil {
+		return m.PrivateAddress
+	}
+	return nil
+}
+
+func (m *SessionsRequest) GetPublicAddress() *IPAddress {
+	if m != nil {
+		return m.PublicAddress
+	}
+	return nil
+}
+
+type Session struct {
+	InterfaceId          uint32     `protobuf:"varint,1,opt,name=interface_id,json=interfaceId,proto3" json:"interface_id,omitempty"`
+	Protocol             uint32     `protobuf:"varint,2,opt,name=protocol,proto3" json:"protocol,omitempty"`
+	PrivateAddress       *IPAddress `protobuf:"bytes,3,opt,name=private_address,json=privateAddress,proto3" json:"private_address,omitempty"`
+	PrivatePort          uint32     `protobuf:"varint,4,opt,name=private_port,json=privatePort,proto3" json:"private_port,omitempty"`
+	PublicAddress        *IPAddress `protobuf:"bytes,5,opt,name=public_address,json=publicAddress,proto3" json:"public_address,omitempty"`
+	PublicPort           uint32     `protobuf:"varint,6,opt,name=public_port,json=publicPort,proto3" json:"public_port,omitempty"`
+	RemoteAddress        *IPAddress `protobuf:"bytes,7,opt,name=remote_address,json=remoteAddress,proto3" json:"remote_address,omitempty"`
+	RemotePort           uint32     `protobuf:"varint,8,opt,name=remote_port,json=remotePort,proto3" json:"remote_port,omitempty"`
+	AgeSeconds           uint64     `protobuf:"varint,9,opt,name=age_seconds,json=ageSeconds,proto3" json:"age_seconds,omitempty"`
+	LastUsedUnixNano     int64      `protobuf:"varint,10,opt,name=last_used_unix_nano,json=lastUsedUnixNano,proto3" json:"last_used_unix_nano,omitempty"`
+	Static               bool       `protobuf:"varint,11,opt,name=static,proto3" json:"static,omitempty"`
+	TcpState             string     `protobuf:"bytes,12,opt,name=tcp_state,json=tcpState,proto3" json:"tcp_state,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
+	XXX_unrecognized     []byte     `json:"-"`
+	XXX_sizecache        int32      `json:"-"`
+}
+
+func (m *Session) Reset()         { *m = Session{} }
+func (m *Session) String() string { return proto.CompactTextString(m) }
+func (*Session) ProtoMessage()    {}
+func (*Session) Descriptor() ([]byte, []int) {
+	return fileDescriptor_156a706a72c56418, []int{11}
+}
+
+func (m *Session) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Session.Unmarshal(m, b)
+}
+func (m *Session) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Session.Marshal(b, m, deterministic)
+}
+func (m *Session) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Session.Merge(m, src)
+}
+func (m *Session) XXX_Size() int {
+	return xxx_messageInfo_Session.Size(m)
+}
+func (m *Session) XXX_DiscardUnknown() {
+	xxx_messageInfo_Session.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Session proto.InternalMessageInfo
+
+func (m *Session) GetInterfaceId() uint32 {
+	if m != nil {
+		return m.InterfaceId
+	}
+	return 0
+}
+
+func (m *Session) GetProtocol() uint32 {
+	if m != nil {
+		return m.Protocol
+	}
+	return 0
+}
+
+func (m *Session) GetPrivateAddress() *IPAddress {
+	if m != nil {
+		return m.PrivateAddress
+	}
+	return nil
+}
+
+func (m *Session) GetPrivatePort() uint32 {
+	if m != nil {
+		return m.PrivatePort
+	}
+	return 0
+}
+
+func (m *Session) GetPublicAddress() *IPAddress {
+	if m != nil {
+		return m.PublicAddress
+	}
+	return nil
+}
+
+func (m *Session) GetPublicPort() uint32 {
+	if m != nil {
+		return m.PublicPort
+	}
+	return 0
+}
+
+func (m *Session) GetRemoteAddress() *IPAddress {
+	if m != nil {
+		return m.RemoteAddress
+	}
+	return nil
+}
+
+func (m *Session) GetRemotePort() uint32 {
+	if m != nil {
+		return m.RemotePort
+	}
+	return 0
+}
+
+func (m *Session) GetAgeSeconds() uint64 {
+	if m != nil {
+		return m.AgeSeconds
+	}
+	return 0
+}
+
+func (m *Session) GetLastUsedUnixNano() int64 {
+	if m != nil {
+		return m.LastUsedUnixNano
+	}
+	return 0
+}
+
+func (m *Session) GetStatic() bool {
+	if m != nil {
+		return m.Static
+	}
+	return false
+}
+
+func (m *Session) GetTcpState() string {
+	if m != nil {
+		return m.TcpState
+	}
+	return ""
+}
+
+type DropCountersRequest struct {
+	InterfaceId          uint32   `protobuf:"varint,1,opt,name=interface_id,json=interfaceId,proto3" json:"interface_id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DropCountersRequest) Reset()         { *m = DropCountersRequest{} }
+func (m *DropCountersRequest) String() string { return proto.CompactTextString(m) }
+func (*DropCountersRequest) ProtoMessage()    {}
+func (*DropCountersRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_156a706a72c56418, []int{12}
+}
+
+func (m *DropCountersRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DropCountersRequest.Unmarshal(m, b)
+}
+func (m *DropCountersRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DropCountersRequest.Marshal(b, m, deterministic)
+}
+func (m *DropCountersRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DropCountersRequest.Merge(m, src)
+}
+func (m *DropCountersRequest) XXX_Size() int {
+	return xxx_messageInfo_DropCountersRequest.Size(m)
+}
+func (m *DropCountersRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_DropCountersRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DropCountersRequest proto.InternalMessageInfo
+
+func (m *DropCountersRequest) GetInterfaceId() uint32 {
+	if m != nil {
+		return m.InterfaceId
+	}
+	return 0
+}
+
+type DropCounter struct {
+	Direction            string   `protobuf:"bytes,1,opt,name=direction,proto3" json:"direction,omitempty"`
+	Reason               string   `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+	Packets              uint64   `protobuf:"varint,3,opt,name=packets,proto3" json:"packets,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DropCounter) Reset()         { *m = DropCounter{} }
+func (m *DropCounter) String() string { return proto.CompactTextString(m) }
+func (*DropCounter) ProtoMessage()    {}
+func (*DropCounter) Descriptor() ([]byte, []int) {
+	return fileDescriptor_156a706a72c56418, []int{13}
+}
+
+func (m *DropCounter) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DropCounter.Unmarshal(m, b)
+}
+func (m *DropCounter) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DropCounter.Marshal(b, m, deterministic)
+}
+func (m *DropCounter) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DropCounter.Merge(m, src)
+}
+func (m *DropCounter) XXX_Size() int {
+	return xxx_messageInfo_DropCounter.Size(m)
+}
+func (m *DropCounter) XXX_DiscardUnknown() {
+	xxx_messageInfo_DropCounter.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DropCounter proto.InternalMessageInfo
+
+func (m *DropCounter) GetDirection() string {
+	if m != nil {
+		return m.Direction
+	}
+	return ""
+}
+
+func (m *DropCounter) GetReason() string {
+	if m != nil {
+		return m.Reason
+	}
+	return ""
+}
+
+func (m *DropCounter) GetPackets() uint64 {
+	if m != nil {
+		return m.Packets
+	}
+	return 0
+}
+
+type DropCountersReply struct {
+	Counters             []*DropCounter `protobuf:"bytes,1,rep,name=counters,proto3" json:"counters,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
+	XXX_unrecognized     []byte         `json:"-"`
+	XXX_sizecache        int32          `json:"-"`
+}
+
+func (m *DropCountersReply) Reset()         { *m = DropCountersReply{} }
+func (m *DropCountersReply) String() string { return proto.CompactTextString(m) }
+func (*DropCountersReply) ProtoMessage()    {}
+func (*DropCountersReply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_156a706a72c56418, []int{14}
+}
+
+func (m *DropCountersReply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DropCountersReply.Unmarshal(m, b)
+}
+func (m *DropCountersReply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DropCountersReply.Marshal(b, m, deterministic)
+}
+func (m *DropCountersReply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DropCountersReply.Merge(m, src)
+}
+func (m *DropCountersReply) XXX_Size() int {
+	return xxx_messageInfo_DropCountersReply.Size(m)
+}
+func (m *DropCountersReply) XXX_DiscardUnknown() {
+	xxx_messageInfo_DropCountersReply.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DropCountersReply proto.InternalMessageInfo
+
+func (m *DropCountersReply) GetCounters() []*DropCounter {
+	if m != nil {
+		return m.Counters
+	}
+	return nil
+}
+
+type SessionHistoryRequest struct {
+	PublicAddress        *IPAddress `protobuf:"bytes,1,opt,name=public_address,json=publicAddress,proto3" json:"public_address,omitempty"`
+	PublicPort           uint32     `protobuf:"varint,2,opt,name=public_port,json=publicPort,proto3" json:"public_port,omitempty"`
+	Protocol             uint32     `protobuf:"varint,3,opt,name=protocol,proto3" json:"protocol,omitempty"`
+	TimeUnixNano         int64      `protobuf:"varint,4,opt,name=time_unix_nano,json=timeUnixNano,proto3" json:"time_unix_nano,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
+	XXX_unrecognized     []byte     `json:"-"`
+	XXX_sizecache        int32      `json:"-"`
+}
+
+func (m *SessionHistoryRequest) Reset()         { *m = SessionHistoryRequest{} }
+func (m *SessionHistoryRequest) String() string { return proto.CompactTextString(m) }
+func (*SessionHistoryRequest) ProtoMessage()    {}
+func (*SessionHistoryRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_156a706a72c56418, []int{15}
+}
+
+func (m *SessionHistoryRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SessionHistoryRequest.Unmarshal(m, b)
+}
+func (m *SessionHistoryRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SessionHistoryRequest.Marshal(b, m, deterministic)
+}
+func (m *SessionHistoryRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SessionHistoryRequest.Merge(m, src)
+}
+func (m *SessionHistoryRequest) XXX_Size() int {
+	return xxx_messageInfo_SessionHistoryRequest.Size(m)
+}
+func (m *SessionHistoryRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_SessionHistoryRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SessionHistoryRequest proto.InternalMessageInfo
+
+func (m *SessionHistoryRequest) GetPublicAddress() *IPAddress {
+	if m != nil {
+		return m.PublicAddress
+	}
+	return nil
+}
+
+func (m *SessionHistoryRequest) GetPublicPort() uint32 {
+	if m != nil {
+		return m.PublicPort
+	}
+	return 0
+}
+
+func (m *SessionHistoryRequest) GetProtocol() uint32 {
+	if m != nil {
+		return m.Protocol
+	}
+	return 0
+}
+
+func (m *SessionHistoryRequest) GetTimeUnixNano() int64 {
+	if m != nil {
+		return m.TimeUnixNano
+	}
+	return 0
+}
+
+type SessionHistoryRecord struct {
+	InterfaceId          uint32     `protobuf:"varint,1,opt,name=interface_id,json=interfaceId,proto3" json:"interface_id,omitempty"`
+	Protocol             uint32     `protobuf:"varint,2,opt,name=protocol,proto3" json:"protocol,omitempty"`
+	PrivateAddress       *IPAddress `protobuf:"bytes,3,opt,name=private_address,json=privateAddress,proto3" json:"private_address,omitempty"`
+	PrivatePort          uint32     `protobuf:"varint,4,opt,name=private_port,json=privatePort,proto3" json:"private_port,omitempty"`
+	PublicAddress        *IPAddress `protobuf:"bytes,5,opt,name=public_address,json=publicAddress,proto3" json:"public_address,omitempty"`
+	PublicPort           uint32     `protobuf:"varint,6,opt,name=public_port,json=publicPort,proto3" json:"public_port,omitempty"`
+	RemoteAddress        *IPAddress `protobuf:"bytes,7,opt,name=remote_address,json=remoteAddress,proto3" json:"remote_address,omitempty"`
+	RemotePort           uint32     `protobuf:"varint,8,opt,name=remote_port,json=remotePort,proto3" json:"remote_port,omitempty"`
+	StartUnixNano        int64      `protobuf:"varint,9,opt,name=start_unix_nano,json=startUnixNano,proto3" json:"start_unix_nano,omitempty"`
+	EndUnixNano          int64      `protobuf:"varint,10,opt,name=end_unix_nano,json=endUnixNano,proto3" json:"end_unix_nano,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
+	XXX_unrecognized     []byte     `json:"-"`
+	XXX_sizecache        int32      `json:"-"`
+}
+
+func (m *SessionHistoryRecord) Reset()         { *m = SessionHistoryRecord{} }
+func (m *SessionHistoryRecord) String() string { return proto.CompactTextString(m) }
+func (*SessionHistoryRecord) ProtoMessage()    {}
+func (*SessionHistoryRecord) Descriptor() ([]byte, []int) {
+	return fileDescriptor_156a706a72c56418, []int{16}
+}
+
+func (m *SessionHistoryRecord) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SessionHistoryRecord.Unmarshal(m, b)
+}
+func (m *SessionHistoryRecord) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SessionHistoryRecord.Marshal(b, m, deterministic)
+}
+func (m *SessionHistoryRecord) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SessionHistoryRecord.Merge(m, src)
+}
+func (m *SessionHistoryRecord) XXX_Size() int {
+	return xxx_messageInfo_SessionHistoryRecord.Size(m)
+}
+func (m *SessionHistoryRecord) XXX_DiscardUnknown() {
+	xxx_messageInfo_SessionHistoryRecord.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SessionHistoryRecord proto.InternalMessageInfo
+
+func (m *SessionHistoryRecord) GetInterfaceId() uint32 {
+	if m != nil {
+		return m.InterfaceId
+	}
+	return 0
+}
+
+func (m *SessionHistoryRecord) GetProtocol() uint32 {
+	if m != nil {
+		return m.Protocol
+	}
+	return 0
+}
+
+func (m *SessionHistoryRecord) GetPrivateAddress() *IPAddress {
+	if m != nil {
+		return m.PrivateAddress
+	}
+	return nil
+}
+
+func (m *SessionHistoryRecord) GetPrivatePort() uint32 {
+	if m != nil {
+		return m.PrivatePort
+	}
+	return 0
+}
+
+func (m *SessionHistoryRecord) GetPublicAddress() *IPAddress {
+	if m != nil {
+		return m.PublicAddress
+	}
+	return nil
+}
+
+func (m *SessionHistoryRecord) GetPublicPort() uint32 {
+	if m != nil {
+		return m.PublicPort
+	}
+	return 0
+}
+
+func (m *SessionHistoryRecord) GetRemoteAddress() *IPAddress {
+	if m != nil {
+		return m.RemoteAddress
+	}
+	return nil
+}
+
+func (m *SessionHistoryRecord) GetRemotePort() uint32 {
+	if m != nil {
+		return m.RemotePort
+	}
+	return 0
+}
+
+func (m *SessionHistoryRecord) GetStartUnixNano() int64 {
+	if m != nil {
+		return m.StartUnixNano
+	}
+	return 0
+}
+
+func (m *SessionHistoryRecord) GetEndUnixNano() int64 {
+	if m != nil {
+		return m.EndUnixNano
+	}
+	return 0
+}
+
+type SessionHistoryReply struct {
+	Records              []*SessionHistoryRecord `protobuf:"bytes,1,rep,name=records,proto3" json:"records,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}                `json:"-"`
+	XXX_unrecognized     []byte                  `json:"-"`
+	XXX_sizecache        int32                   `json:"-"`
+}
+
+func (m *SessionHistoryReply) Reset()         { *m = SessionHistoryReply{} }
+func (m *SessionHistoryReply) String() string { return proto.CompactTextString(m) }
+func (*SessionHistoryReply) ProtoMessage()    {}
+func (*SessionHistoryReply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_156a706a72c56418, []int{17}
+}
+
+func (m *SessionHistoryReply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SessionHistoryReply.Unmarshal(m, b)
+}
+func (m *SessionHistoryReply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SessionHistoryReply.Marshal(b, m, deterministic)
+}
+func (m *SessionHistoryReply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SessionHistoryReply.Merge(m, src)
+}
+func (m *SessionHistoryReply) XXX_Size() int {
+	return xxx_messageInfo_SessionHistoryReply.Size(m)
+}
+func (m *SessionHistoryReply) XXX_DiscardUnknown() {
+	xxx_messageInfo_SessionHistoryReply.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SessionHistoryReply proto.InternalMessageInfo
+
+func (m *SessionHistoryReply) GetRecords() []*SessionHistoryRecord {
+	if m != nil {
+		return m.Records
+	}
+	return nil
+}
+
+type Reply struct {
+	Msg                  string   `protobuf:"bytes,2,opt,name=msg,proto3" json:"msg,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Reply) Reset()         { *m = Reply{} }
+func (m *Reply) String() string { return proto.CompactTextString(m) }
+func (*Reply) ProtoMessage()    {}
+func (*Reply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_156a706a72c56418, []int{18}
+}
+
+func (m *Reply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Reply.Unmarshal(m, b)
+}
+func (m *Reply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Reply.Marshal(b, m, deterministic)
+}
+func (m *Reply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Reply.Merge(m, src)
+}
+func (m *Reply) XXX_Size() int {
+	return xxx_messageInfo_Reply.Size(m)
+}
+func (m *Reply) XXX_DiscardUnknown() {
+	xxx_messageInfo_Reply.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Reply proto.InternalMessageInfo
+
+func (m *Reply) GetMsg() string {
+	if m != nil {
+		return m.Msg
+	}
+	return ""
+}
+
+func init() {
+	proto.RegisterEnum("updatecfg.TraceType", TraceType_name, TraceType_value)
+	proto.RegisterEnum("updatecfg.Protocol", Protocol_name, Protocol_value)
+	proto.RegisterType((*DumpControlRequest)(nil), "updatecfg.DumpControlRequest")
+	proto.RegisterType((*IPAddress)(nil), "updatecfg.IPAddress")
+	proto.RegisterType((*Subnet)(nil), "updatecfg.Subnet")
+	proto.RegisterType((*InterfaceAddressChangeRequest)(nil), "updatecfg.InterfaceAddressChangeRequest")
+	proto.RegisterType((*ForwardedPort)(nil), "updatecfg.ForwardedPort")
+	proto.RegisterType((*PortForwardingChangeRequest)(nil), "updatecfg.PortForwardingChangeRequest")
+	proto.RegisterType((*SessionTimeoutsChangeRequest)(nil), "updatecfg.SessionTimeoutsChangeRequest")
+	proto.RegisterType((*PortBlockOwnerRequest)(nil), "updatecfg.PortBlockOwnerRequest")
+	proto.RegisterType((*SessionLimitsChangeRequest)(nil), "updatecfg.SessionLimitsChangeRequest")
+	proto.RegisterType((*StaticMappingChangeRequest)(nil), "updatecfg.StaticMappingChangeRequest")
+	proto.RegisterType((*SessionsRequest)(nil), "updatecfg.SessionsRequest")
+	proto.RegisterType((*Session)(nil), "updatecfg.Session")
+	proto.RegisterType((*DropCountersRequest)(nil), "updatecfg.DropCountersRequest")
+	proto.RegisterType((*DropCounter)(nil), "updatecfg.DropCounter")
+	proto.RegisterType((*DropCountersReply)(nil), "updatecfg.DropCountersReply")
+	proto.RegisterType((*SessionHistoryRequest)(nil), "updatecfg.SessionHistoryRequest")
+	proto.RegisterType((*SessionHistoryRecord)(nil), "updatecfg.SessionHistoryRecord")
+	proto.RegisterType((*SessionHistoryReply)(nil), "updatecfg.SessionHistoryReply")
+	proto.RegisterType((*Reply)(nil), "updatecfg.Reply")
+}
+
+func init() { proto.RegisterFile("updatecfg.proto", fileDescriptor_156a706a72c56418) }
+
+var fileDescriptor_156a706a72c56418 = []byte{
+	// 1520 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xec, 0x57, 0xc9, 0x6f, 0xdb, 0x46,
+	0x17, 0x8f, 0x16, 0x5b, 0xd2, 0xa3, 0x16, 0x7a, 0xbc, 0xc4, 0x9f, 0xb3, 0xd8, 0x21, 0xbe, 0x24,
+	0x46, 0xbe, 0x7c, 0x49, 0xeb, 0xb4, 0x46, 0x8b, 0xb4, 0x41, 0xbd, 0xc4, 0x89, 0x11, 0x47, 0x16,
+	0x68, 0xb9, 0x39, 0x15, 0xc4, 0x88, 0x1c, 0x2b, 0x84, 0x29, 0x92, 0xe5, 0x0c, 0x9d, 0xf8, 0x96,
+	0x53, 0x2f, 0x45, 0x81, 0xf6, 0xdc, 0x7f, 0xa0, 0xff, 0x41, 0x8f, 0xfd, 0x3f, 0x7a, 0xec, 0xb5,
+	0xe7, 0xde, 0x8b, 0x59, 0x48, 0x91, 0x92, 0xa2, 0x3a, 0x0d, 0x7a, 0xeb, 0x6d, 0xe6, 0xbd, 0xdf,
+	0xdb, 0xe6, 0x2d, 0x33, 0x03, 0xad, 0x38, 0x74, 0x30, 0x23, 0xf6, 0x49, 0xff, 0x5e, 0x18, 0x05,
+	0x2c, 0x40, 0xb5, 0x94, 0x60, 0x78, 0x80, 0x76, 0xe3, 0x41, 0xb8, 0x13, 0xf8, 0x2c, 0x0a, 0x3c,
+	0x93, 0x7c, 0x1d, 0x13, 0xca, 0xd0, 0x0d, 0xa8, 0x13, 0x1f, 0xf7, 0x3c, 0x62, 0xb1, 0x08, 0xdb,
+	0x64, 0xb9, 0xb0, 0x56, 0x58, 0xaf, 0x9a, 0x9a, 0xa4, 0x75, 0x39, 0x09, 0x3d, 0x00, 0x10, 0x3c,
+	0x8b, 0x9d, 0x87, 0x64, 0xb9, 0xb8, 0x56, 0x58, 0x6f, 0x6e, 0x2c, 0xdc, 0x1b, 0x5a, 0x12, 0xa8,
+	0xee, 0x79, 0x48, 0xcc, 0x1a, 0x4b, 0x96, 0xc6, 0x4d, 0xa8, 0xed, 0x77, 0xb6, 0x1c, 0x27, 0x22,
+	0x94, 0xa2, 0x65, 0xa8, 0x60, 0xb9, 0x14, 0xfa, 0xeb, 0x66, 0xb2, 0x35, 0x7a, 0x30, 0x7b, 0x14,
+	0xf7, 0x7c, 0xc2, 0xd0, 0xbd, 0x3c, 0x46, 0xcb, 0x99, 0x48, 0x55, 0xa5, 0x92, 0x68, 0x1d, 0xf4,
+	0x01, 0xa6, 0xa7, 0x56, 0xcf, 0x65, 0xd4, 0xf2, 0xe3, 0x41, 0x8f, 0x44, 0xc2, 0xb7, 0x86, 0xd9,
+	0xe4, 0xf4, 0x6d, 0x97, 0xd1, 0xb6, 0xa0, 0x1a, 0x67, 0x70, 0x6d, 0xdf, 0x67, 0x24, 0x3a, 0xc1,
+	0x36, 0x51, 0x6a, 0x76, 0x5e, 0x62, 0xbf, 0x4f, 0x32, 0x67, 0xe0, 0x26, 0x00, 0xcb, 0x75, 0x84,
+	0xfd, 0x86, 0xa9, 0xa5, 0xb4, 0x7d, 0x07, 0x6d, 0x80, 0x16, 0x06, 0x11, 0xb3, 0xa8, 0x70, 0x56,
+	0x18, 0xd2, 0x36, 0xe6, 0x32, 0x1e, 0xca, 0x28, 0x4c, 0xe0, 0x28, 0xb9, 0x36, 0xbe, 0x2f, 0x42,
+	0x63, 0x2f, 0x88, 0x5e, 0xe1, 0xc8, 0x21, 0x4e, 0x27, 0x88, 0x18, 0xba, 0x0b, 0x88, 0x06, 0x71,
+	0x64, 0x13, 0x4b, 0x28, 0x53, 0x5e, 0x4b, 0x73, 0xba, 0xe4, 0x70, 0x9c, 0xf4, 0x1b, 0x3d, 0x84,
+	0x26, 0xc3, 0x51, 0x9f, 0x30, 0x2b, 0x39, 0x98, 0xe2, 0x94, 0x83, 0x69, 0x48, 0xac, 0xda, 0x72,
+	0x53, 0x4a, 0x38, 0x6b, 0xaa, 0x24, 0x4d, 0x49, 0x4e, 0xc6, 0xd4, 0x7d, 0xa8, 0x8a, 0x7a, 0xb1,
+	0x03, 0x6f, 0xb9, 0x2c, 0x12, 0x3c, 0x9f, 0x31, 0xd2, 0x51, 0x2c, 0x33, 0x05, 0xa1, 0x8f, 0xe1,
+	0xb2, 0x87, 0x29, 0xb3, 0x26, 0x84, 0x33, 0x23, 0x6c, 0x2c, 0x70, 0xf6, 0xd1, 0x48, 0x48, 0xc6,
+	0x8f, 0x05, 0xb8, 0xc2, 0xb7, 0xea, 0x58, 0x5c, 0xbf, 0x9f, 0xcf, 0xc4, 0xff, 0x60, 0x4e, 0x55,
+	0xe3, 0x49, 0x8a, 0x50, 0x25, 0xa9, 0x4b, 0xc6, 0x50, 0x72, 0x2c, 0x6d, 0xc5, 0xf1, 0xb4, 0xdd,
+	0x85, 0x32, 0x77, 0x4d, 0xc4, 0xad, 0x6d, 0x2c, 0x67, 0x62, 0xca, 0x25, 0xc6, 0x14, 0x28, 0xe3,
+	0xa7, 0x32, 0x5c, 0x3d, 0x22, 0x94, 0xba, 0x81, 0xdf, 0x75, 0x07, 0x24, 0x88, 0xd9, 0x48, 0xa1,
+	0x6c, 0xc2, 0x65, 0x66, 0x87, 0x16, 0xa1, 0x0c, 0xf7, 0x3c, 0x97, 0xbe, 0x24, 0x8e, 0x45, 0x89,
+	0x1d, 0xf8, 0x0e, 0x55, 0x49, 0x5c, 0x64, 0x76, 0xf8, 0x78, 0xc8, 0x3d, 0x92, 0x4c, 0xf4, 0x11,
+	0x2c, 0x71, 0x39, 0x16, 0x61, 0x9f, 0xba, 0x2c, 0x88, 0xce, 0x53, 0x31, 0xe9, 0xf3, 0x02, 0xb3,
+	0xc3, 0x6e, 0xca, 0x4c, 0xa4, 0x56, 0x41, 0x8b, 0x9d, 0x30, 0x85, 0xca, 0xdc, 0x41, 0xec, 0x84,
+	0x09, 0x80, 0x1f, 0x80, 0x3d, 0x18, 0x22, 0xca, 0xea, 0x00, 0xec, 0x41, 0x0a, 0xb9, 0x0f, 0x5c,
+	0xb7, 0x45, 0xcf, 0x7d, 0x8b, 0x12, 0x9f, 0xa5, 0x50, 0x99, 0xa4, 0x39, 0x66, 0x87, 0x47, 0xe7,
+	0xfe, 0x11, 0xf1, 0xd9, 0x04, 0x81, 0x88, 0xd8, 0x67, 0xa9, 0xc0, 0x6c, 0x56, 0xc0, 0x24, 0xf6,
+	0xd9, 0x88, 0xc0, 0x89, 0xeb, 0x5b, 0xaf, 0xb0, 0x3b, 0xb4, 0x50, 0x49, 0x05, 0xf6, 0x5c, 0xff,
+	0x05, 0x76, 0x53, 0x0b, 0x0f, 0xe4, 0x61, 0xd8, 0x5e, 0x40, 0x49, 0x5e, 0xa4, 0x2a, 0x44, 0xe6,
+	0x99, 0x1d, 0xee, 0x70, 0x66, 0x56, 0x48, 0x59, 0x11, 0x35, 0x87, 0xed, 0xd3, 0x54, 0xa4, 0x96,
+	0x5a, 0x39, 0xc0, 0x94, 0x6d, 0xd9, 0xa7, 0x89, 0xc0, 0x87, 0xb0, 0x28, 0x8e, 0xdc, 0x1d, 0x8c,
+	0x18, 0x01, 0x21, 0x81, 0xf8, 0x89, 0xbb, 0x83, 0x9c, 0x8d, 0x3b, 0x30, 0x37, 0x74, 0x2c, 0x81,
+	0x6b, 0x02, 0xde, 0x4a, 0x7c, 0x52, 0x58, 0xe3, 0xdb, 0x02, 0x2c, 0xf2, 0xca, 0xd9, 0xf6, 0x02,
+	0xfb, 0xf4, 0xf0, 0x95, 0x4f, 0xa2, 0x77, 0x18, 0x26, 0x99, 0x51, 0x57, 0xbc, 0xc8, 0xa8, 0x5b,
+	0x05, 0x2d, 0xdb, 0x60, 0xaa, 0x10, 0xc2, 0x61, 0x5b, 0x7d, 0x57, 0x80, 0x15, 0x55, 0xb8, 0x07,
+	0xee, 0xc0, 0x65, 0xef, 0x3e, 0xdf, 0x16, 0x60, 0x86, 0x05, 0x0c, 0x7b, 0xaa, 0x20, 0xe5, 0x06,
+	0xe9, 0x50, 0x62, 0x76, 0xa8, 0x0c, 0xf2, 0x25, 0xa7, 0xc4, 0x4e, 0xa8, 0x2a, 0x8d, 0x2f, 0x11,
+	0x82, 0x32, 0x2f, 0x38, 0x55, 0x51, 0x62, 0x6d, 0xfc, 0xc6, 0xfd, 0x61, 0x98, 0xb9, 0xf6, 0x73,
+	0x1c, 0x86, 0x63, 0x5d, 0x7e, 0x13, 0x9a, 0xaa, 0xcb, 0x07, 0x92, 0xad, 0x5a, 0xbc, 0x21, 0xa9,
+	0x4a, 0xe6, 0x22, 0xfd, 0xfd, 0x10, 0x9a, 0x61, 0xdc, 0xf3, 0x5c, 0x3b, 0x1d, 0x91, 0xa5, 0x69,
+	0x23, 0x52, 0x62, 0xd5, 0x16, 0x7d, 0x0e, 0xad, 0x30, 0x72, 0xcf, 0x30, 0x23, 0xa9, 0x74, 0x79,
+	0x8a, 0x74, 0x53, 0x81, 0xd5, 0xde, 0xf8, 0xa3, 0x00, 0x2d, 0x75, 0xe8, 0x34, 0x89, 0xec, 0x36,
+	0xb4, 0x06, 0x98, 0xd9, 0x2f, 0xad, 0xd4, 0x49, 0x15, 0x5a, 0x53, 0x90, 0xd3, 0x6b, 0xe8, 0x22,
+	0xb1, 0xad, 0x64, 0x66, 0xb2, 0xcc, 0x40, 0xba, 0x7f, 0x4f, 0xd7, 0x27, 0x1c, 0xdb, 0xcc, 0x85,
+	0x8f, 0xcd, 0xf8, 0xbd, 0x04, 0x15, 0x15, 0xf7, 0x45, 0x2a, 0x2b, 0x1b, 0x46, 0xf1, 0xaf, 0xc3,
+	0x28, 0xbd, 0x43, 0x18, 0x37, 0xa0, 0x9e, 0x88, 0x8b, 0x29, 0xaf, 0xe6, 0x9f, 0xa2, 0x89, 0x1b,
+	0xf7, 0x7d, 0x22, 0x15, 0x7d, 0x27, 0x85, 0x85, 0xfa, 0x59, 0xd5, 0x77, 0x82, 0x94, 0x68, 0x8f,
+	0xc8, 0x20, 0xc8, 0xb8, 0x5f, 0x99, 0xa6, 0x5d, 0x62, 0x33, 0xda, 0x95, 0xb0, 0xd0, 0x2e, 0x87,
+	0x1f, 0x48, 0x92, 0xd0, 0xbe, 0x0a, 0x1a, 0xee, 0x93, 0xdc, 0xa8, 0x2b, 0x9b, 0x80, 0xfb, 0xc9,
+	0x10, 0x42, 0xff, 0x87, 0x79, 0x31, 0x10, 0x63, 0x4a, 0x1c, 0x2b, 0xf6, 0xdd, 0xd7, 0x96, 0x8f,
+	0xfd, 0x40, 0x4c, 0xb8, 0x92, 0xa9, 0x73, 0xd6, 0x31, 0x25, 0xce, 0xb1, 0xef, 0xbe, 0x6e, 0x63,
+	0x3f, 0x40, 0x4b, 0x30, 0x4b, 0x45, 0x53, 0x8a, 0xa1, 0x56, 0x35, 0xd5, 0x0e, 0x5d, 0x81, 0x9a,
+	0x18, 0xf9, 0x0c, 0x33, 0xb2, 0x5c, 0x5f, 0x2b, 0xac, 0xd7, 0xcc, 0x2a, 0x9f, 0xf3, 0x7c, 0x6f,
+	0x7c, 0x02, 0xf3, 0xbb, 0x51, 0x10, 0xee, 0x04, 0x31, 0xcf, 0x29, 0xbd, 0xf8, 0x48, 0x31, 0xbe,
+	0x02, 0x2d, 0x23, 0x89, 0xae, 0x42, 0xcd, 0x71, 0x23, 0x62, 0x33, 0x37, 0xf0, 0x05, 0xbc, 0x66,
+	0x0e, 0x09, 0xdc, 0xb7, 0x88, 0x60, 0x1a, 0xf8, 0xa2, 0x46, 0x6a, 0xa6, 0xda, 0xf1, 0x97, 0x63,
+	0x88, 0xed, 0x53, 0xc2, 0x64, 0x65, 0x94, 0xcd, 0x64, 0x6b, 0x3c, 0x81, 0xb9, 0xbc, 0x63, 0xa1,
+	0x77, 0x8e, 0x36, 0xa0, 0x6a, 0x2b, 0xc2, 0x72, 0x61, 0xad, 0xb4, 0xae, 0x6d, 0x2c, 0x65, 0x52,
+	0x91, 0xc1, 0x9b, 0x29, 0xce, 0xf8, 0xb9, 0x00, 0x8b, 0xaa, 0x9e, 0x9f, 0xba, 0x94, 0x5f, 0xc0,
+	0x49, 0x90, 0xe3, 0xc5, 0x53, 0xf8, 0xdb, 0xc5, 0x53, 0x1c, 0x2b, 0x9e, 0x69, 0xfd, 0xfd, 0x5f,
+	0x68, 0x8a, 0x9b, 0x6b, 0x98, 0xd4, 0xb2, 0x48, 0x6a, 0x9d, 0x53, 0x93, 0x84, 0x1a, 0xbf, 0x94,
+	0x60, 0x61, 0xd4, 0x73, 0x3b, 0x88, 0x9c, 0x7f, 0xdb, 0xf2, 0x9f, 0x6c, 0xcb, 0x5b, 0xd0, 0xa2,
+	0x0c, 0x47, 0x2c, 0x93, 0x9c, 0x9a, 0x48, 0x4e, 0x43, 0x90, 0xd3, 0x76, 0x33, 0xa0, 0x41, 0xfc,
+	0xf1, 0xbe, 0xd4, 0x88, 0x9f, 0xb6, 0xa4, 0xd1, 0x81, 0xf9, 0xd1, 0x04, 0xf2, 0x32, 0xfe, 0x14,
+	0x2a, 0x91, 0xc8, 0x64, 0x52, 0xc5, 0xab, 0xd9, 0x9f, 0xc6, 0x84, 0x8c, 0x9b, 0x09, 0xde, 0xf8,
+	0x0f, 0xcc, 0x48, 0x1d, 0x3a, 0x94, 0x06, 0xb4, 0xaf, 0xda, 0x89, 0x2f, 0xef, 0x7c, 0x06, 0xb5,
+	0xf4, 0xab, 0x86, 0x1a, 0x50, 0xdb, 0x3d, 0x7e, 0xde, 0xb1, 0x76, 0xcd, 0xc3, 0x8e, 0x7e, 0x09,
+	0x21, 0x68, 0x8a, 0x6d, 0xd7, 0xdc, 0x6a, 0x1f, 0x1d, 0x6c, 0x75, 0x1f, 0xeb, 0x05, 0x54, 0x87,
+	0xaa, 0xa0, 0x3d, 0x6b, 0xef, 0xeb, 0xc5, 0x3b, 0x26, 0x54, 0x93, 0x7f, 0x00, 0xd2, 0xa0, 0x72,
+	0xdc, 0x7e, 0xd6, 0x3e, 0x7c, 0xd1, 0xd6, 0x2f, 0xa1, 0x0a, 0x94, 0xba, 0x3b, 0x1d, 0x7d, 0x96,
+	0x2f, 0x8e, 0x77, 0x3b, 0xfa, 0x1c, 0x6a, 0xf1, 0xbf, 0xdf, 0xd9, 0xa6, 0xb5, 0xe7, 0xe1, 0xbe,
+	0xfe, 0xe6, 0x4d, 0x19, 0x01, 0x94, 0xbb, 0x3b, 0x9d, 0x4d, 0xfd, 0x1b, 0xb9, 0x3e, 0xde, 0xed,
+	0x6c, 0xea, 0x3f, 0xbc, 0x29, 0x6f, 0xfc, 0x3a, 0x0b, 0x95, 0x63, 0x11, 0x58, 0x84, 0x1e, 0x81,
+	0xa6, 0xbe, 0xa6, 0xfc, 0x97, 0x8a, 0xae, 0x65, 0xfb, 0x76, 0xec, 0xdb, 0xba, 0xa2, 0x67, 0xd8,
+	0x32, 0xde, 0x2e, 0x2c, 0xc9, 0x57, 0xc6, 0xe8, 0x5f, 0x0f, 0xad, 0x67, 0xd3, 0x3e, 0xed, 0x23,
+	0x38, 0x41, 0x6b, 0x07, 0x16, 0x24, 0x24, 0xff, 0x6b, 0x41, 0xb7, 0xb2, 0xdf, 0xa3, 0xb7, 0x7f,
+	0x68, 0x26, 0x68, 0x34, 0x61, 0x51, 0x42, 0x46, 0x7e, 0x1a, 0xe8, 0xf6, 0x78, 0x8e, 0x27, 0xfe,
+	0x42, 0x26, 0xe8, 0x7c, 0x0a, 0x68, 0xcf, 0xf5, 0x9d, 0xfc, 0x83, 0x14, 0xad, 0x8d, 0xf8, 0x38,
+	0xf6, 0x56, 0x9d, 0xa0, 0xa9, 0x0d, 0xf3, 0x39, 0xef, 0xe4, 0x73, 0x12, 0xdd, 0x1c, 0xf7, 0x6d,
+	0xc2, 0x43, 0x73, 0xaa, 0xbe, 0xec, 0x73, 0x30, 0xaf, 0xef, 0xad, 0x0f, 0xc5, 0x09, 0xfa, 0xbe,
+	0x80, 0xfa, 0x81, 0x4b, 0x99, 0xf2, 0x81, 0xa2, 0x95, 0x71, 0xc7, 0x92, 0x3b, 0x6a, 0x05, 0x8d,
+	0xf3, 0x3e, 0x28, 0xa0, 0x47, 0xd0, 0xdc, 0x25, 0x1e, 0x61, 0xe4, 0x42, 0x3a, 0xc6, 0x3d, 0x38,
+	0x84, 0xd6, 0x13, 0xc2, 0xb2, 0x57, 0x0f, 0xba, 0x3e, 0xf9, 0x8e, 0x49, 0x95, 0x5c, 0x7d, 0x2b,
+	0x9f, 0x2b, 0xfc, 0x52, 0x26, 0x2f, 0xdf, 0xd6, 0xb9, 0xe4, 0x4d, 0xbc, 0x9d, 0x56, 0xae, 0x4f,
+	0x41, 0x84, 0xde, 0xf9, 0xb6, 0xbe, 0x5d, 0x97, 0xbd, 0xd5, 0xc6, 0x6c, 0xe7, 0xa4, 0xdf, 0x29,
+	0xf4, 0x66, 0xc5, 0x84, 0x7f, 0xf0, 0xe7, 0x00, 0xad, 0x0a, 0xdd, 0x4a, 0x26, 0x12, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// UpdaterClient is the client API for Updater service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type UpdaterClient interface {
+	ControlDump(ctx context.Context, in *DumpControlRequest, opts ...grpc.CallOption) (*Reply, error)
+	ChangeInterfaceAddress(ctx context.Context, in *InterfaceAddressChangeRequest, opts ...grpc.CallOption) (*Reply, error)
+	ChangePortForwarding(ctx context.Context, in *PortForwardingChangeRequest, opts ...grpc.CallOption) (*Reply, error)
+	ChangeSessionTimeouts(ctx context.Context, in *SessionTimeoutsChangeRequest, opts ...grpc.CallOption) (*Reply, error)
+	FindPortBlockOwner(ctx context.Context, in *PortBlockOwnerRequest, opts ...grpc.CallOption) (*Reply, error)
+	ChangeSessionLimits(ctx context.Context, in *SessionLimitsChangeRequest, opts ...grpc.CallOption) (*Reply, error)
+	ChangeStaticMapping(ctx context.Context, in *StaticMappingChangeRequest, opts ...grpc.CallOption) (*Reply, error)
+	ListSessions(ctx context.Context, in *SessionsRequest, opts ...grpc.CallOption) (Updater_ListSessionsClient, error)
+	DeleteSessions(ctx context.Context, in *SessionsRequest, opts ...grpc.CallOption) (*Reply, error)
+	GetDropCounters(ctx context.Context, in *DropCountersRequest, opts ...grpc.CallOption) (*DropCountersReply, error)
+	FindSessionHistory(ctx context.Context, in *SessionHistoryRequest, opts ...grpc.CallOption) (*SessionHistoryReply, error)
+}
+
+type updaterClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewUpdaterClient(cc *grpc.ClientConn) UpdaterClient {
+	return &updaterClient{cc}
+}
+
+func (c *updaterClient) ControlDump(ctx context.Context, in *DumpControlRequest, opts ...grpc.CallOption) (*Reply, error) {
+	out := new(Reply)
+	err := c.cc.Invoke(ctx, "/updatecfg.Updater/ControlDump", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *updaterClient) ChangeInterfaceAddress(ctx context.Context, in *InterfaceAddressChangeRequest, opts ...grpc.CallOption) (*Reply, error) {
+	out := new(Reply)
+	err := c.cc.Invoke(ctx, "/updatecfg.Updater/ChangeInterfaceAddress", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *updaterClient) ChangePortForwarding(ctx context.Context, in *PortForwardingChangeRequest, opts ...grpc.CallOption) (*Reply, error) {
+	out := new(Reply)
+	err := c.cc.Invoke(ctx, "/updatecfg.Updater/ChangePortForwarding", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *updaterClient) ChangeSessionTimeouts(ctx context.Context, in *SessionTimeoutsChangeRequest, opts ...grpc.CallOption) (*Reply, error) {
+	out := new(Reply)
+	err := c.cc.Invoke(ctx, "/updatecfg.Updater/ChangeSessionTimeouts", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *updaterClient) FindPortBlockOwner(ctx context.Context, in *PortBlockOwnerRequest, opts ...grpc.CallOption) (*Reply, error) {
+	out := new(Reply)
+	err := c.cc.Invoke(ctx, "/updatecfg.Updater/FindPortBlockOwner", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *updaterClient) ChangeSessionLimits(ctx context.Context, in *SessionLimitsChangeRequest, opts ...grpc.CallOption) (*Reply, error) {
+	out := new(Reply)
+	err := c.cc.Invoke(ctx, "/updatecfg.Updater/ChangeSessionLimits", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *updaterClient) ChangeStaticMapping(ctx context.Context, in *StaticMappingChangeRequest, opts ...grpc.CallOption) (*Reply, error) {
+	out := new(Reply)
+	err := c.cc.Invoke(ctx, "/updatecfg.Updater/ChangeStaticMapping", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *updaterClient) ListSessions(ctx context.Context, in *SessionsRequest, opts ...grpc.CallOption) (Updater_ListSessionsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Updater_serviceDesc.Streams[0], "/updatecfg.Updater/ListSessions", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &updaterListSessionsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Updater_ListSessionsClient interface {
+	Recv() (*Session, error)
+	grpc.ClientStream
+}
+
+type updaterListSessionsClient struct {
+	grpc.ClientStream
+}
+
+func (x *updaterListSessionsClient) Recv() (*Session, error) {
+	m := new(Session)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *updaterClient) DeleteSessions(ctx context.Context, in *SessionsRequest, opts ...grpc.CallOption) (*Reply, error) {
+	out := new(Reply)
+	err := c.cc.Invoke(ctx, "/updatecfg.Updater/DeleteSessions", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *updaterClient) GetDropCounters(ctx context.Context, in *DropCountersRequest, opts ...grpc.CallOption) (*DropCountersReply, error) {
+	out := new(DropCountersReply)
+	err := c.cc.Invoke(ctx, "/updatecfg.Updater/GetDropCounters", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *updaterClient) FindSessionHistory(ctx context.Context, in *SessionHistoryRequest, opts ...grpc.CallOption) (*SessionHistoryReply, error) {
+	out := new(SessionHistoryReply)
+	err := c.cc.Invoke(ctx, "/updatecfg.Updater/FindSessionHistory", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// UpdaterServer is the server API for Updater service.
+type UpdaterServer interface {
+	ControlDump(context.Context, *DumpControlRequest) (*Reply, error)
+	ChangeInterfaceAddress(context.Context, *InterfaceAddressChangeRequest) (*Reply, error)
+	ChangePortForwarding(context.Context, *PortForwardingChangeRequest) (*Reply, error)
+	ChangeSessionTimeouts(context.Context, *SessionTimeoutsChangeRequest) (*Reply, error)
+	FindPortBlockOwner(context.Context, *PortBlockOwnerRequest) (*Reply, error)
+	ChangeSessionLimits(context.Context, *SessionLimitsChangeRequest) (*Reply, error)
+	ChangeStaticMapping(context.Context, *StaticMappingChangeRequest) (*Reply, error)
+	ListSessions(*SessionsRequest, Updater_ListSessionsServer) error
+	DeleteSessions(context.Context, *SessionsRequest) (*Reply, error)
+	GetDropCounters(context.Context, *DropCountersRequest) (*DropCountersReply, error)
+	FindSessionHistory(context.Context, *SessionHistoryRequest) (*SessionHistoryReply, error)
+}
+
+func RegisterUpdaterServer(s *grpc.Server, srv UpdaterServer) {
+	s.RegisterService(&_Updater_serviceDesc, srv)
+}
+
+func _Updater_ControlDump_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DumpControlRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UpdaterServer).ControlDump(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/updatecfg.Updater/ControlDump",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UpdaterServer).ControlDump(ctx, req.(*DumpControlRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Updater_ChangeInterfaceAddress_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InterfaceAddressChangeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UpdaterServer).ChangeInterfaceAddress(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/updatecfg.Updater/ChangeInterfaceAddress",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UpdaterServer).ChangeInterfaceAddress(ctx, req.(*InterfaceAddressChangeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Updater_ChangePortForwarding_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PortForwardingChangeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UpdaterServer).ChangePortForwarding(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/updatecfg.Updater/ChangePortForwarding",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UpdaterServer).ChangePortForwarding(ctx, req.(*PortForwardingChangeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Updater_ChangeSessionTimeouts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SessionTimeoutsChangeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UpdaterServer).ChangeSessionTimeouts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/updatecfg.Updater/ChangeSessionTimeouts",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UpdaterServer).ChangeSessionTimeouts(ctx, req.(*SessionTimeoutsChangeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Updater_FindPortBlockOwner_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PortBlockOwnerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UpdaterServer).FindPortBlockOwner(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/updatecfg.Updater/FindPortBlockOwner",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UpdaterServer).FindPortBlockOwner(ctx, req.(*PortBlockOwnerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Updater_ChangeSessionLimits_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SessionLimitsChangeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UpdaterServer).ChangeSessionLimits(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/updatecfg.Updater/ChangeSessionLimits",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UpdaterServer).ChangeSessionLimits(ctx, req.(*SessionLimitsChangeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Updater_ChangeStaticMapping_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StaticMappingChangeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UpdaterServer).ChangeStaticMapping(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/updatecfg.Updater/ChangeStaticMapping",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UpdaterServer).ChangeStaticMapping(ctx, req.(*StaticMappingChangeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Updater_ListSessions_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SessionsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(UpdaterServer).ListSessions(m, &updaterListSessionsServer{stream})
+}
+
+type Updater_ListSessionsServer interface {
+	Send(*Session) error
+	grpc.ServerStream
+}
+
+type updaterListSessionsServer struct {
+	grpc.ServerStream
+}
+
+func (x *updaterListSessionsServer) Send(m *Session) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Updater_DeleteSessions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SessionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UpdaterServer).DeleteSessions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/updatecfg.Updater/DeleteSessions",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UpdaterServer).DeleteSessions(ctx, req.(*SessionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Updater_GetDropCounters_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DropCountersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UpdaterServer).GetDropCounters(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/updatecfg.Updater/GetDropCounters",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UpdaterServer).GetDropCounters(ctx, req.(*DropCountersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Updater_FindSessionHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SessionHistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UpdaterServer).FindSessionHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/updatecfg.Updater/FindSessionHistory",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UpdaterServer).FindSessionHistory(ctx, req.(*SessionHistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Updater_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "updatecfg.Updater",
+	HandlerType: (*UpdaterServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ControlDump",
+			Handler:    _Updater_ControlDump_Handler,
+		},
+		{
+			MethodName: "ChangeInterfaceAddress",
+			Handler:    _Updater_ChangeInterfaceAddress_Handler,
+		},
+		{
 			MethodName: "ChangePortForwarding",
 			Handler:    _Updater_ChangePortForwarding_Handler,
 		},
+		{
+			MethodName: "ChangeSessionTimeouts",
+			Handler:    _Updater_ChangeSessionTimeouts_Handler,
+		},
+		{
+			MethodName: "FindPortBlockOwner",
+			Handler:    _Updater_FindPortBlockOwner_Handler,
+		},
+		{
+			MethodName: "ChangeSessionLimits",
+			Handler:    _Updater_ChangeSessionLimits_Handler,
+		},
+		{
+			MethodName: "ChangeStaticMapping",
+			Handler:    _Updater_ChangeStaticMapping_Handler,
+		},
+		{
+			MethodName: "DeleteSessions",
+			Handler:    _Updater_DeleteSessions_Handler,
+		},
+		{
+			MethodName: "GetDropCounters",
+			Handler:    _Updater_GetDropCounters_Handler,
+		},
+		{
+			MethodName: "FindSessionHistory",
+			Handler:    _Updater_FindSessionHistory_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ListSessions",
+			Handler:       _Updater_ListSessions_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "updatecfg.proto",
 }
-
-func init() { proto.RegisterFile("updatecfg.proto", fileDescriptor_updatecfg_fa7d68b5067fd899) }
-
-var fileDescriptor_updatecfg_fa7d68b5067fd899 = []byte{
-	// 610 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x53, 0x41, 0x6f, 0xd3, 0x4c,
-	0x10, 0xad, 0xd3, 0x7c, 0x4d, 0x32, 0x4e, 0xd2, 0xed, 0x7c, 0x15, 0x0a, 0xa0, 0x4a, 0xc5, 0x12,
-	0x28, 0x2a, 0x55, 0x91, 0x52, 0xa9, 0x17, 0x38, 0xd0, 0x26, 0x54, 0x8a, 0x0a, 0xae, 0xb5, 0x4d,
-	0xe0, 0x68, 0xd9, 0xf1, 0xc6, 0x44, 0x38, 0xb6, 0x59, 0xaf, 0x8b, 0x7a, 0xeb, 0x89, 0x3b, 0x67,
-	0x7e, 0x19, 0xbf, 0x84, 0x2b, 0xf2, 0xae, 0xed, 0x3a, 0xa4, 0xf4, 0x36, 0x9e, 0x79, 0x3b, 0x6f,
-	0xe6, 0xf9, 0x0d, 0x6c, 0xa7, 0xb1, 0xe7, 0x08, 0x36, 0x9b, 0xfb, 0x47, 0x31, 0x8f, 0x44, 0x84,
-	0xad, 0x32, 0x61, 0x04, 0x80, 0xa3, 0x74, 0x19, 0x0f, 0xa3, 0x50, 0xf0, 0x28, 0xa0, 0xec, 0x6b,
-	0xca, 0x12, 0x81, 0xcf, 0xa0, 0xcd, 0x42, 0xc7, 0x0d, 0x98, 0x2d, 0xb8, 0x33, 0x63, 0x3d, 0x6d,
-	0x5f, 0xeb, 0x37, 0xa9, 0xae, 0x72, 0x93, 0x2c, 0x85, 0xc7, 0x00, 0xb2, 0x66, 0x8b, 0x9b, 0x98,
-	0xf5, 0x6a, 0xfb, 0x5a, 0xbf, 0x3b, 0xd8, 0x3d, 0xba, 0x63, 0x92, 0xa8, 0xc9, 0x4d, 0xcc, 0x68,
-	0x4b, 0x14, 0xa1, 0xf1, 0x1c, 0x5a, 0x63, 0xeb, 0xd4, 0xf3, 0x38, 0x4b, 0x12, 0xec, 0x41, 0xc3,
-	0x51, 0xa1, 0xec, 0xdf, 0xa6, 0xc5, 0xa7, 0xe1, 0xc2, 0xd6, 0x55, 0xea, 0x86, 0x4c, 0xe0, 0xd1,
-	0x2a, 0x46, 0x5f, 0xa1, 0x28, 0x5b, 0x95, 0x2f, 0xb1, 0x0f, 0x64, 0xe9, 0x24, 0x5f, 0x6c, 0x77,
-	0x21, 0x12, 0x3b, 0x4c, 0x97, 0x2e, 0xe3, 0x72, 0xb6, 0x0e, 0xed, 0x66, 0xf9, 0xb3, 0x85, 0x48,
-	0x4c, 0x99, 0x35, 0xae, 0x61, 0x6f, 0x1c, 0x0a, 0xc6, 0xe7, 0xce, 0x8c, 0xe5, 0x6d, 0x86, 0x9f,
-	0x9d, 0xd0, 0x67, 0x15, 0x0d, 0x16, 0x05, 0xc0, 0x5e, 0x78, 0x92, 0xbf, 0x43, 0xf5, 0x32, 0x37,
-	0xf6, 0x70, 0x00, 0x7a, 0x1c, 0x71, 0x61, 0x27, 0x72, 0x58, 0x49, 0xa4, 0x0f, 0x76, 0x2a, 0x13,
-	0xaa, 0x2d, 0x28, 0x64, 0x28, 0x15, 0x1b, 0xbf, 0x34, 0xe8, 0x9c, 0x47, 0xfc, 0x9b, 0xc3, 0x3d,
-	0xe6, 0x59, 0x11, 0x17, 0x78, 0x08, 0x98, 0x44, 0x29, 0x9f, 0x31, 0x5b, 0x36, 0xcb, 0xa7, 0x56,
-	0x74, 0x44, 0x55, 0x32, 0x9c, 0x9a, 0x1b, 0x5f, 0x43, 0x57, 0x38, 0xdc, 0x67, 0xc2, 0x2e, 0x84,
-	0xa9, 0x3d, 0x20, 0x4c, 0x47, 0x61, 0x0b, 0xc9, 0x0f, 0x01, 0xf3, 0xc7, 0x55, 0xaa, 0x4d, 0x45,
-	0xa5, 0x2a, 0x15, 0xaa, 0x57, 0xd0, 0x94, 0x7e, 0x99, 0x45, 0x41, 0xaf, 0x2e, 0x7f, 0xf0, 0xff,
-	0x15, 0x12, 0x2b, 0x2f, 0xd1, 0x12, 0x64, 0xfc, 0xd4, 0xe0, 0x69, 0xf6, 0x3e, 0xdf, 0x6f, 0x11,
-	0xfa, 0xab, 0x92, 0xbe, 0x84, 0x9d, 0xdc, 0x56, 0xf3, 0x12, 0x91, 0x7b, 0x8b, 0xa8, 0xc2, 0xdd,
-	0xcb, 0x35, 0xfd, 0x6b, 0xeb, 0xfa, 0x1f, 0x42, 0x3d, 0xdb, 0x43, 0x2e, 0xa0, 0x0f, 0x7a, 0x95,
-	0xe1, 0x56, 0x14, 0xa6, 0x12, 0x65, 0x3c, 0x86, 0xff, 0x28, 0x8b, 0x83, 0x1b, 0x24, 0xb0, 0xb9,
-	0x4c, 0x7c, 0xd9, 0xb0, 0x45, 0xb3, 0xf0, 0xe0, 0x0d, 0xb4, 0x4a, 0xbf, 0x62, 0x07, 0x5a, 0xa3,
-	0xe9, 0x07, 0xcb, 0x1e, 0xd1, 0x4b, 0x8b, 0x6c, 0x20, 0x42, 0x57, 0x7e, 0x4e, 0xe8, 0xa9, 0x79,
-	0xf5, 0xfe, 0x74, 0xf2, 0x8e, 0x68, 0xd8, 0x86, 0xa6, 0xcc, 0x5d, 0x98, 0x63, 0x52, 0x3b, 0xa0,
-	0xd0, 0x2c, 0xc4, 0x40, 0x1d, 0x1a, 0x53, 0xf3, 0xc2, 0xbc, 0xfc, 0x64, 0x92, 0x0d, 0x6c, 0xc0,
-	0xe6, 0x64, 0x68, 0x91, 0xad, 0x2c, 0x98, 0x8e, 0x2c, 0xb2, 0x83, 0xdb, 0xd9, 0x01, 0x5c, 0x9f,
-	0xd8, 0xe7, 0x81, 0xe3, 0x93, 0xdb, 0xdb, 0x3a, 0x02, 0xd4, 0x27, 0x43, 0xeb, 0x84, 0x7c, 0x57,
-	0xf1, 0x74, 0x64, 0x9d, 0x90, 0x1f, 0xb7, 0xf5, 0xc1, 0x6f, 0x0d, 0x1a, 0x53, 0xb9, 0x0e, 0xc7,
-	0xb7, 0xa0, 0xe7, 0xf7, 0x99, 0x9d, 0x2a, 0xee, 0x55, 0xf6, 0x5c, 0xbf, 0xdd, 0x27, 0xa4, 0x52,
-	0x96, 0xfb, 0x1a, 0x1b, 0xf8, 0x11, 0x1e, 0xa9, 0x3f, 0xf1, 0xb7, 0xe5, 0xb1, 0x5f, 0xb5, 0xcd,
-	0x43, 0xf7, 0x70, 0x6f, 0x5f, 0x0a, 0xbb, 0x0a, 0xb4, 0xfa, 0xd7, 0xf1, 0x45, 0xd5, 0x27, 0xff,
-	0x36, 0xc4, 0x7d, 0x3d, 0xcf, 0xc8, 0x59, 0x5b, 0x2d, 0x6e, 0x3a, 0x62, 0x38, 0xf7, 0x2d, 0xcd,
-	0xdd, 0x92, 0x06, 0x3b, 0xfe, 0x13, 0x00, 0x00, 0xff, 0xff, 0xb8, 0xe4, 0x11, 0xa6, 0xc8, 0x04,
-	0x00, 0x00,
-}